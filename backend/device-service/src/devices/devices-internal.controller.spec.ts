@@ -0,0 +1,61 @@
+import { Test, TestingModule } from '@nestjs/testing';
+import { NotFoundException } from '@nestjs/common';
+import { ServiceAuthGuard } from '@cloudphone/shared';
+import { DevicesInternalController } from './devices-internal.controller';
+import { DevicesService } from './devices.service';
+
+describe('DevicesInternalController', () => {
+  let controller: DevicesInternalController;
+
+  const mockDevicesService = {
+    findOne: jest.fn(),
+  };
+
+  beforeEach(async () => {
+    const module: TestingModule = await Test.createTestingModule({
+      controllers: [DevicesInternalController],
+      providers: [
+        {
+          provide: DevicesService,
+          useValue: mockDevicesService,
+        },
+      ],
+    })
+      .overrideGuard(ServiceAuthGuard)
+      .useValue({ canActivate: jest.fn(() => true) })
+      .compile();
+
+    controller = module.get<DevicesInternalController>(DevicesInternalController);
+  });
+
+  afterEach(() => {
+    jest.clearAllMocks();
+  });
+
+  describe('getOwner', () => {
+    it('should return only the device owner fields', async () => {
+      mockDevicesService.findOne.mockResolvedValue({
+        id: 'device-123',
+        userId: 'user-123',
+        tenantId: 'tenant-1',
+        name: 'test device',
+        adbPort: 5555,
+      });
+
+      const result = await controller.getOwner('device-123');
+
+      expect(result).toEqual({
+        id: 'device-123',
+        userId: 'user-123',
+        tenantId: 'tenant-1',
+      });
+      expect(mockDevicesService.findOne).toHaveBeenCalledWith('device-123');
+    });
+
+    it('should propagate not found errors', async () => {
+      mockDevicesService.findOne.mockRejectedValue(new NotFoundException('设备不存在'));
+
+      await expect(controller.getOwner('missing')).rejects.toThrow(NotFoundException);
+    });
+  });
+});
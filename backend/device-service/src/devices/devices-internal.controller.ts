@@ -0,0 +1,51 @@
+import { Controller, Get, Param, UseGuards, Logger } from '@nestjs/common';
+import { ApiTags, ApiOperation, ApiResponse, ApiHeader, ApiParam } from '@nestjs/swagger';
+import { ServiceAuthGuard } from '@cloudphone/shared';
+import { DevicesService } from './devices.service';
+
+/**
+ * 内部设备 API
+ *
+ * 仅供其他微服务调用，使用 Service Token 认证
+ *
+ * @route /internal/devices
+ * @auth ServiceAuthGuard (X-Service-Token header)
+ */
+@ApiTags('internal/devices')
+@ApiHeader({
+  name: 'X-Service-Token',
+  description: '服务间认证 Token',
+  required: true,
+})
+@Controller('internal/devices')
+@UseGuards(ServiceAuthGuard)
+export class DevicesInternalController {
+  private readonly logger = new Logger(DevicesInternalController.name);
+
+  constructor(private readonly devicesService: DevicesService) {}
+
+  /**
+   * 获取设备归属（内部调用）
+   *
+   * @description 供 media-service 在用户请求之外（如向 SFU 房间添加设备时）
+   * 按参与者的用户和租户判定观看权限，不需要转发用户的 JWT
+   */
+  @Get(':id/owner')
+  @ApiOperation({
+    summary: '获取设备归属（内部）',
+    description: '供其他服务调用，查询设备所属的用户和租户',
+  })
+  @ApiParam({ name: 'id', description: '设备 ID' })
+  @ApiResponse({ status: 200, description: '获取成功' })
+  @ApiResponse({ status: 404, description: '设备不存在' })
+  @ApiResponse({ status: 401, description: '服务 Token 无效' })
+  async getOwner(@Param('id') id: string) {
+    this.logger.debug(`[Internal] 获取设备归属 - deviceId: ${id}`);
+    const device = await this.devicesService.findOne(id);
+    return {
+      id: device.id,
+      userId: device.userId,
+      tenantId: device.tenantId,
+    };
+  }
+}
@@ -3,6 +3,7 @@ import { TypeOrmModule } from '@nestjs/typeorm';
 import { HttpModule } from '@nestjs/axios';
 import { DevicesService } from './devices.service';
 import { DevicesController } from './devices.controller';
+import { DevicesInternalController } from './devices-internal.controller';
 import { DevicesConsumer } from './devices.consumer'; // ✅ V2: 启用消费者 (现在 @RabbitSubscribe 可以工作)
 import { SmsEventsConsumer } from '../rabbitmq/consumers/sms-events.consumer'; // ✅ SMS 事件消费者
 import { BatchOperationsService } from './batch-operations.service';
@@ -41,7 +42,7 @@ import { MetricsModule } from '../metrics/metrics.module';
     MetricsModule, // ✅ Business Metrics for Prometheus
     // EventBusModule 是全局模块，已在 AppModule 中导入，无需重复导入
   ],
-  controllers: [DevicesController, BatchOperationsController],
+  controllers: [DevicesController, DevicesInternalController, BatchOperationsController],
   providers: [
     DevicesService,
     DevicesConsumer, // ✅ V2: 启用 RabbitMQ 消费者
@@ -12,6 +12,7 @@ require (
 	github.com/hashicorp/consul/api v1.29.4
 	github.com/pion/interceptor v0.1.29
 	github.com/pion/rtcp v1.2.14
+	github.com/pion/rtp v1.8.7
 	github.com/pion/webrtc/v3 v3.3.5
 	github.com/prometheus/client_golang v1.23.2
 	github.com/sirupsen/logrus v1.4.2
@@ -67,7 +68,6 @@ require (
 	github.com/pion/logging v0.2.2 // indirect
 	github.com/pion/mdns v0.0.12 // indirect
 	github.com/pion/randutil v0.1.0 // indirect
-	github.com/pion/rtp v1.8.7 // indirect
 	github.com/pion/sctp v1.8.19 // indirect
 	github.com/pion/sdp/v3 v3.0.9 // indirect
 	github.com/pion/srtp/v2 v2.0.20 // indirect
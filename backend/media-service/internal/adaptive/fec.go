@@ -0,0 +1,225 @@
+package adaptive
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// Video FEC is sent as ULPFEC (RFC 5109) inside RED (RFC 2198) on the media SSRC,
+// the scheme browsers negotiate with "red" and "ulpfec" video codecs
+const (
+	MimeTypeRED    = "video/red"
+	MimeTypeULPFEC = "video/ulpfec"
+
+	// Payload types offered by the server (answers to client offers keep the client's)
+	defaultREDPayloadType    = 116
+	defaultULPFECPayloadType = 117
+)
+
+const (
+	// fecGroupSize is the number of media packets protected by one FEC packet (~12% overhead);
+	// a group is also closed at the end of each frame
+	fecGroupSize = 8
+
+	rtpFixedHeaderSize = 12
+	ulpfecHeaderSize   = 10
+	ulpfecLevel0Size   = 4  // protection length + 16-bit mask (L = 0)
+	ulpfecMaxGroupSize = 16 // packets covered by a 16-bit mask
+)
+
+// RegisterFECCodecs registers the RED and ULPFEC video codecs so that FEC is negotiated in SDP
+func RegisterFECCodecs(mediaEngine *webrtc.MediaEngine) error {
+	codecs := []webrtc.RTPCodecParameters{
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: MimeTypeRED, ClockRate: 90000},
+			PayloadType:        defaultREDPayloadType,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: MimeTypeULPFEC, ClockRate: 90000},
+			PayloadType:        defaultULPFECPayloadType,
+		},
+	}
+	for _, codec := range codecs {
+		if err := mediaEngine.RegisterCodec(codec, webrtc.RTPCodecTypeVideo); err != nil {
+			return fmt.Errorf("failed to register %s codec: %w", codec.MimeType, err)
+		}
+	}
+	return nil
+}
+
+// negotiatedFECPayloadTypes returns the RED and ULPFEC payload types among negotiated codecs
+// ok is false when the remote did not accept both
+func negotiatedFECPayloadTypes(codecs []webrtc.RTPCodecParameters) (red, ulpfec uint8, ok bool) {
+	for _, codec := range codecs {
+		switch {
+		case strings.EqualFold(codec.MimeType, MimeTypeRED):
+			red = uint8(codec.PayloadType)
+		case strings.EqualFold(codec.MimeType, MimeTypeULPFEC):
+			ulpfec = uint8(codec.PayloadType)
+		}
+	}
+	return red, ulpfec, red != 0 && ulpfec != 0
+}
+
+// fecEncoder holds the FEC state of one peer connection, shared by its video streams
+// It is switched on and off by the RTCPCollector from the quality controller's decision.
+type fecEncoder struct {
+	enabled    atomic.Bool
+	redPT      atomic.Uint32
+	ulpfecPT   atomic.Uint32
+	fecPackets atomic.Uint64
+}
+
+// enable starts protecting video with the negotiated payload types
+func (e *fecEncoder) enable(red, ulpfec uint8) {
+	e.redPT.Store(uint32(red))
+	e.ulpfecPT.Store(uint32(ulpfec))
+	e.enabled.Store(true)
+}
+
+func (e *fecEncoder) disable() {
+	e.enabled.Store(false)
+}
+
+// fecInterceptor wraps video packets in RED and appends ULPFEC packets while FEC is enabled
+// Inserted FEC packets shift the sequence numbers of the stream, so it must be the outermost
+// interceptor (registered last): the NACK history then holds the packets as sent.
+type fecInterceptor struct {
+	interceptor.NoOp
+	encoder *fecEncoder
+}
+
+// fecInterceptorFactory creates the fecInterceptor of a single peer connection
+type fecInterceptorFactory struct {
+	encoder *fecEncoder
+}
+
+// NewInterceptor implements interceptor.Factory
+func (f *fecInterceptorFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return &fecInterceptor{encoder: f.encoder}, nil
+}
+
+// BindLocalStream protects video streams (audio uses Opus in-band FEC)
+func (i *fecInterceptor) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	if !strings.HasPrefix(strings.ToLower(info.MimeType), "video/") {
+		return writer
+	}
+
+	stream := &fecStream{encoder: i.encoder, writer: writer}
+	return interceptor.RTPWriterFunc(stream.write)
+}
+
+// fecStream is the FEC state of one video stream
+type fecStream struct {
+	encoder *fecEncoder
+	writer  interceptor.RTPWriter
+
+	mu     sync.Mutex
+	offset uint16   // FEC packets inserted so far, added to media sequence numbers
+	group  [][]byte // media packets (as recovered by the receiver) protected by the next FEC packet
+}
+
+func (s *fecStream) write(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	media := *header
+	media.SequenceNumber += s.offset
+
+	if !s.encoder.enabled.Load() {
+		s.group = s.group[:0]
+		return s.writer.Write(&media, payload, attributes)
+	}
+	redPT := uint8(s.encoder.redPT.Load())
+	ulpfecPT := uint8(s.encoder.ulpfecPT.Load())
+
+	// The receiver recovers the media packet without header extensions (they are added
+	// further down the chain and not protected)
+	protected := media
+	protected.Extension = false
+	protected.Extensions = nil
+	raw, err := (&rtp.Packet{Header: protected, Payload: payload}).Marshal()
+	if err != nil {
+		return 0, err
+	}
+	s.group = append(s.group, raw)
+
+	red := media
+	red.PayloadType = redPT
+	n, err := s.writer.Write(&red, append([]byte{media.PayloadType & 0x7f}, payload...), attributes)
+	if err != nil {
+		return n, err
+	}
+
+	if len(s.group) >= fecGroupSize || media.Marker {
+		fec := rtp.Header{
+			Version:        2,
+			PayloadType:    redPT,
+			SequenceNumber: media.SequenceNumber + 1,
+			Timestamp:      media.Timestamp,
+			SSRC:           media.SSRC,
+		}
+		fecPayload := append([]byte{ulpfecPT & 0x7f}, encodeULPFEC(s.group)...)
+		s.group = s.group[:0]
+		s.offset++
+
+		if _, err := s.writer.Write(&fec, fecPayload, attributes); err == nil {
+			s.encoder.fecPackets.Add(1)
+		}
+	}
+	return n, nil
+}
+
+// encodeULPFEC builds a ULPFEC payload (RFC 5109 section 7) with a single level-0 protection
+// covering media, marshaled RTP packets with consecutive sequence numbers (at most 16)
+func encodeULPFEC(media [][]byte) []byte {
+	if len(media) > ulpfecMaxGroupSize {
+		media = media[:ulpfecMaxGroupSize]
+	}
+
+	protectionLength := 0
+	for _, packet := range media {
+		if l := len(packet) - rtpFixedHeaderSize; l > protectionLength {
+			protectionLength = l
+		}
+	}
+
+	fec := make([]byte, ulpfecHeaderSize+ulpfecLevel0Size+protectionLength)
+	baseSeq := binary.BigEndian.Uint16(media[0][2:4])
+
+	var (
+		recovery       [8]byte // XOR of the first 8 bytes of the RTP headers
+		lengthRecovery uint16
+		mask           uint16
+	)
+	body := fec[ulpfecHeaderSize+ulpfecLevel0Size:]
+	for _, packet := range media {
+		for j := range recovery {
+			recovery[j] ^= packet[j]
+		}
+		lengthRecovery ^= uint16(len(packet) - rtpFixedHeaderSize)
+		for j, b := range packet[rtpFixedHeaderSize:] {
+			body[j] ^= b
+		}
+		mask |= 1 << (15 - (binary.BigEndian.Uint16(packet[2:4]) - baseSeq))
+	}
+
+	// FEC header: E = 0, L = 0, P/X/CC, M/PT, SN base, TS and length recovery
+	fec[0] = recovery[0] & 0x3f
+	fec[1] = recovery[1]
+	binary.BigEndian.PutUint16(fec[2:4], baseSeq)
+	copy(fec[4:8], recovery[4:8])
+	binary.BigEndian.PutUint16(fec[8:10], lengthRecovery)
+
+	// Level 0 header
+	binary.BigEndian.PutUint16(fec[10:12], uint16(protectionLength))
+	binary.BigEndian.PutUint16(fec[12:14], mask)
+	return fec
+}
@@ -2,6 +2,7 @@ package adaptive
 
 import (
 	"fmt"
+	"math"
 	"sync"
 	"time"
 
@@ -105,6 +106,10 @@ func (f BitrateAdjusterFunc) SetBitrate(bitrate int) error {
 	return f(bitrate)
 }
 
+// QualityChangeHandler is called after the current quality level changes
+// (adaptation or manual override). It runs asynchronously and must not block.
+type QualityChangeHandler func(previous, current QualitySettings)
+
 // QualityController manages adaptive quality adjustment
 type QualityController struct {
 	sessionID        string
@@ -127,9 +132,24 @@ type QualityController struct {
 	lastAdaptation     time.Time
 	cooldownPeriod     time.Duration
 
+	// Bitrate tracking within the current level
+	trackBandwidth    bool
+	levelBitrate      int // bitrate of the current level, upper bound for tracking
+	minBitrate        int
+	lastBitrateUpdate time.Time
+	bitrateInterval   time.Duration // minimum interval between tracked changes
+
+	// Video FEC, switched on when the recent loss reaches fecEnableLoss and off below fecDisableLoss
+	fecEnabled     bool
+	fecEnableLoss  float64
+	fecDisableLoss float64
+
 	// Bitrate adjustment callback
 	bitrateAdjuster BitrateAdjuster
 
+	// Quality change notification callback
+	qualityChangeHandler QualityChangeHandler
+
 	// Statistics
 	adaptationCount  uint64 // Number of quality adaptations
 	bitrateUpCount   uint64 // Number of bitrate increases
@@ -148,9 +168,26 @@ type QualityControllerOptions struct {
 	BandwidthMultiplier float64
 	AdaptationInterval  time.Duration
 	CooldownPeriod      time.Duration
-	Logger              *logrus.Logger
+	// TrackBandwidth makes the bitrate follow the bandwidth estimate within a level
+	// Only enable it when bitrate changes are cheap (e.g. scrcpy control socket)
+	TrackBandwidth bool
+	// MinBitrate is the lowest bitrate bandwidth tracking goes down to (default 150 Kbps)
+	MinBitrate int
+	// BitrateUpdateInterval is the minimum interval between tracked bitrate changes (default 1s)
+	BitrateUpdateInterval time.Duration
+	// FECEnableLoss is the recent packet loss at which video FEC is switched on (default 3%)
+	FECEnableLoss float64
+	// FECDisableLoss is the recent packet loss below which video FEC is switched off again (default 1%)
+	FECDisableLoss float64
+	Logger                *logrus.Logger
 }
 
+// bitrateTolerance is the relative change below which the tracked bitrate is left unchanged
+const bitrateTolerance = 0.1
+
+// fecLossSamples is the number of recent samples averaged for the FEC decision
+const fecLossSamples = 3
+
 // NewQualityController creates a new quality controller
 func NewQualityController(options QualityControllerOptions) *QualityController {
 	if options.MaxHistoryLength <= 0 {
@@ -177,6 +214,18 @@ func NewQualityController(options QualityControllerOptions) *QualityController {
 	if options.CooldownPeriod == 0 {
 		options.CooldownPeriod = 10 * time.Second
 	}
+	if options.MinBitrate == 0 {
+		options.MinBitrate = 150000
+	}
+	if options.BitrateUpdateInterval == 0 {
+		options.BitrateUpdateInterval = time.Second
+	}
+	if options.FECEnableLoss == 0 {
+		options.FECEnableLoss = 0.03 // 3%
+	}
+	if options.FECDisableLoss == 0 {
+		options.FECDisableLoss = 0.01 // 1%
+	}
 	if options.Logger == nil {
 		options.Logger = logrus.New()
 	}
@@ -200,6 +249,12 @@ func NewQualityController(options QualityControllerOptions) *QualityController {
 		adaptationInterval:  options.AdaptationInterval,
 		cooldownPeriod:      options.CooldownPeriod,
 		lastAdaptation:      time.Now(),
+		trackBandwidth:      options.TrackBandwidth,
+		levelBitrate:        options.InitialQuality.Bitrate,
+		minBitrate:          options.MinBitrate,
+		bitrateInterval:     options.BitrateUpdateInterval,
+		fecEnableLoss:       options.FECEnableLoss,
+		fecDisableLoss:      options.FECDisableLoss,
 		logger:              options.Logger,
 	}
 }
@@ -219,6 +274,8 @@ func (qc *QualityController) UpdateNetworkQuality(quality NetworkQuality) {
 		qc.networkHistory = qc.networkHistory[1:]
 	}
 
+	qc.updateFECLocked()
+
 	qc.logger.WithFields(logrus.Fields{
 		"session_id":  qc.sessionID,
 		"rtt":         quality.RTT,
@@ -227,6 +284,44 @@ func (qc *QualityController) UpdateNetworkQuality(quality NetworkQuality) {
 	}).Debug("Network quality updated")
 }
 
+// updateFECLocked switches video FEC on the average loss of the recent samples (must hold qc.mu)
+// Unlike quality levels it is not subject to the adaptation cooldown: FEC is cheap to toggle
+// and should kick in as soon as the link becomes lossy.
+func (qc *QualityController) updateFECLocked() {
+	recent := qc.networkHistory
+	if len(recent) > fecLossSamples {
+		recent = recent[len(recent)-fecLossSamples:]
+	}
+
+	var loss float64
+	for _, sample := range recent {
+		loss += sample.PacketLoss
+	}
+	loss /= float64(len(recent))
+
+	switch {
+	case !qc.fecEnabled && loss >= qc.fecEnableLoss:
+		qc.fecEnabled = true
+	case qc.fecEnabled && loss < qc.fecDisableLoss:
+		qc.fecEnabled = false
+	default:
+		return
+	}
+
+	qc.logger.WithFields(logrus.Fields{
+		"session_id":  qc.sessionID,
+		"fec_enabled": qc.fecEnabled,
+		"packet_loss": loss,
+	}).Info("Video FEC decision changed")
+}
+
+// FECEnabled reports whether video FEC should be sent
+func (qc *QualityController) FECEnabled() bool {
+	qc.mu.RLock()
+	defer qc.mu.RUnlock()
+	return qc.fecEnabled
+}
+
 // GetCurrentQuality returns the current quality settings
 func (qc *QualityController) GetCurrentQuality() QualitySettings {
 	qc.mu.RLock()
@@ -273,16 +368,23 @@ func (qc *QualityController) CalculateOptimalQuality() QualitySettings {
 	var avgLoss float64
 	var avgBandwidth uint64
 
+	// Bandwidth 0 means the receiver sent no estimate, only average known samples
+	var bandwidthSamples uint64
 	for _, nq := range qc.networkHistory {
 		avgRTT += nq.RTT
 		avgLoss += nq.PacketLoss
-		avgBandwidth += nq.Bandwidth
+		if nq.Bandwidth > 0 {
+			avgBandwidth += nq.Bandwidth
+			bandwidthSamples++
+		}
 	}
 
 	count := len(qc.networkHistory)
 	avgRTT /= time.Duration(count)
 	avgLoss /= float64(count)
-	avgBandwidth /= uint64(count)
+	if bandwidthSamples > 0 {
+		avgBandwidth /= bandwidthSamples
+	}
 
 	// Determine quality level based on metrics
 	score := qc.calculateQualityScore(avgRTT, avgLoss, avgBandwidth)
@@ -334,15 +436,17 @@ func (qc *QualityController) calculateQualityScore(rtt time.Duration, loss float
 		lossScore = 30.0 * (1.0 - ratio)
 	}
 
-	// Bandwidth score (0-30 points)
+	// Bandwidth score (0-30 points), unknown bandwidth (0) is not penalized
 	bandwidthScore := 30.0
-	requiredBandwidth := uint64(qc.currentQuality.Bitrate) / uint64(qc.bandwidthMultiplier)
-	if bandwidth < requiredBandwidth/4 {
-		bandwidthScore = 0
-	} else if bandwidth < requiredBandwidth {
-		// Linear interpolation
-		ratio := float64(bandwidth) / float64(requiredBandwidth)
-		bandwidthScore = 30.0 * ratio
+	requiredBandwidth := uint64(float64(qc.levelBitrate) / qc.bandwidthMultiplier)
+	if bandwidth > 0 {
+		if bandwidth < requiredBandwidth/4 {
+			bandwidthScore = 0
+		} else if bandwidth < requiredBandwidth {
+			// Linear interpolation
+			ratio := float64(bandwidth) / float64(requiredBandwidth)
+			bandwidthScore = 30.0 * ratio
+		}
 	}
 
 	return rttScore + lossScore + bandwidthScore
@@ -356,10 +460,30 @@ func (qc *QualityController) SetBitrateAdjuster(adjuster BitrateAdjuster) {
 	qc.bitrateAdjuster = adjuster
 }
 
+// SetQualityChangeHandler sets the callback invoked when the quality level changes
+func (qc *QualityController) SetQualityChangeHandler(handler QualityChangeHandler) {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+	qc.qualityChangeHandler = handler
+}
+
+// notifyQualityChange calls the quality change callback (must hold qc.mu)
+func (qc *QualityController) notifyQualityChange(previous, current QualitySettings) {
+	if qc.qualityChangeHandler != nil {
+		// Call callback in a goroutine so it can query the controller without deadlocking
+		go qc.qualityChangeHandler(previous, current)
+	}
+}
+
 // Adapt performs quality adaptation
+// The quality level follows the averaged network score (with cooldown); within the
+// level the bitrate follows the latest bandwidth estimate. changed reports level changes only.
 func (qc *QualityController) Adapt() (changed bool, newQuality QualitySettings) {
 	if !qc.ShouldAdapt() {
-		return false, qc.GetCurrentQuality()
+		qc.mu.Lock()
+		defer qc.mu.Unlock()
+		qc.trackBandwidthLocked()
+		return false, qc.currentQuality
 	}
 
 	qc.mu.Lock()
@@ -370,11 +494,17 @@ func (qc *QualityController) Adapt() (changed bool, newQuality QualitySettings)
 
 	// Check if we need to change
 	if optimal.Level == qc.currentQuality.Level {
+		qc.trackBandwidthLocked()
 		return false, qc.currentQuality
 	}
 
 	// Track direction of change for statistics
-	oldBitrate := qc.currentQuality.Bitrate
+	previous := qc.currentQuality
+	oldBitrate := previous.Bitrate
+
+	// The new level's bitrate is still capped by the bandwidth estimate
+	qc.levelBitrate = optimal.Bitrate
+	optimal.Bitrate = qc.targetBitrateLocked()
 
 	// Update quality
 	qc.currentQuality = optimal
@@ -390,17 +520,7 @@ func (qc *QualityController) Adapt() (changed bool, newQuality QualitySettings)
 	}
 
 	// Apply bitrate change via callback if available
-	if qc.bitrateAdjuster != nil {
-		if err := qc.bitrateAdjuster.SetBitrate(optimal.Bitrate); err != nil {
-			qc.logger.WithError(err).Warn("Failed to apply bitrate adjustment")
-		} else {
-			qc.logger.WithFields(logrus.Fields{
-				"session_id":  qc.sessionID,
-				"old_bitrate": oldBitrate,
-				"new_bitrate": optimal.Bitrate,
-			}).Debug("Bitrate adjustment applied via callback")
-		}
-	}
+	qc.applyBitrateLocked(oldBitrate, optimal.Bitrate)
 
 	qc.logger.WithFields(logrus.Fields{
 		"session_id": qc.sessionID,
@@ -410,9 +530,77 @@ func (qc *QualityController) Adapt() (changed bool, newQuality QualitySettings)
 		"resolution": fmt.Sprintf("%dx%d", optimal.Width, optimal.Height),
 	}).Info("Quality adapted")
 
+	qc.notifyQualityChange(previous, optimal)
+
 	return true, optimal
 }
 
+// targetBitrateLocked returns the bitrate for the latest bandwidth estimate,
+// capped by the level bitrate (must hold qc.mu)
+func (qc *QualityController) targetBitrateLocked() int {
+	target := qc.levelBitrate
+	if !qc.trackBandwidth {
+		return target
+	}
+	// Latest known estimate (intervals without one keep the previous estimate)
+	for i := len(qc.networkHistory) - 1; i >= 0; i-- {
+		if bandwidth := qc.networkHistory[i].Bandwidth; bandwidth > 0 {
+			if estimate := int(float64(bandwidth) * qc.bandwidthMultiplier); estimate < target {
+				target = estimate
+			}
+			break
+		}
+	}
+	if target < qc.minBitrate {
+		target = qc.minBitrate
+	}
+	return target
+}
+
+// trackBandwidthLocked moves the bitrate within the current level towards the
+// bandwidth estimate, ignoring small changes (must hold qc.mu)
+func (qc *QualityController) trackBandwidthLocked() {
+	if !qc.trackBandwidth {
+		return
+	}
+
+	current := qc.currentQuality.Bitrate
+	target := qc.targetBitrateLocked()
+	if current > 0 && math.Abs(float64(target-current)) < float64(current)*bitrateTolerance {
+		return
+	}
+	if time.Since(qc.lastBitrateUpdate) < qc.bitrateInterval {
+		return
+	}
+	if !qc.applyBitrateLocked(current, target) {
+		return
+	}
+
+	qc.currentQuality.Bitrate = target
+	qc.targetQuality.Bitrate = target
+}
+
+// applyBitrateLocked applies a bitrate via the adjuster callback (must hold qc.mu)
+// Returns false if the bitrate could not be applied
+func (qc *QualityController) applyBitrateLocked(oldBitrate, newBitrate int) bool {
+	qc.lastBitrateUpdate = time.Now()
+	if qc.bitrateAdjuster == nil {
+		return true
+	}
+
+	if err := qc.bitrateAdjuster.SetBitrate(newBitrate); err != nil {
+		qc.logger.WithError(err).Warn("Failed to apply bitrate adjustment")
+		return false
+	}
+
+	qc.logger.WithFields(logrus.Fields{
+		"session_id":  qc.sessionID,
+		"old_bitrate": oldBitrate,
+		"new_bitrate": newBitrate,
+	}).Debug("Bitrate adjustment applied via callback")
+	return true
+}
+
 // calculateOptimalQualityLocked is the locked version
 func (qc *QualityController) calculateOptimalQualityLocked() QualitySettings {
 	if len(qc.networkHistory) == 0 {
@@ -424,16 +612,23 @@ func (qc *QualityController) calculateOptimalQualityLocked() QualitySettings {
 	var avgLoss float64
 	var avgBandwidth uint64
 
+	// Bandwidth 0 means the receiver sent no estimate, only average known samples
+	var bandwidthSamples uint64
 	for _, nq := range qc.networkHistory {
 		avgRTT += nq.RTT
 		avgLoss += nq.PacketLoss
-		avgBandwidth += nq.Bandwidth
+		if nq.Bandwidth > 0 {
+			avgBandwidth += nq.Bandwidth
+			bandwidthSamples++
+		}
 	}
 
 	count := len(qc.networkHistory)
 	avgRTT /= time.Duration(count)
 	avgLoss /= float64(count)
-	avgBandwidth /= uint64(count)
+	if bandwidthSamples > 0 {
+		avgBandwidth /= bandwidthSamples
+	}
 
 	// Determine quality level based on metrics
 	score := qc.calculateQualityScore(avgRTT, avgLoss, avgBandwidth)
@@ -459,14 +654,20 @@ func (qc *QualityController) SetManualQuality(quality QualitySettings) {
 	qc.mu.Lock()
 	defer qc.mu.Unlock()
 
+	previous := qc.currentQuality
 	qc.currentQuality = quality
 	qc.targetQuality = quality
+	qc.levelBitrate = quality.Bitrate
 	qc.lastAdaptation = time.Now()
 
 	qc.logger.WithFields(logrus.Fields{
 		"session_id": qc.sessionID,
 		"level":      quality.Level.String(),
 	}).Info("Quality set manually")
+
+	if previous != quality {
+		qc.notifyQualityChange(previous, quality)
+	}
 }
 
 // GetNetworkHistory returns the network quality history
@@ -487,6 +688,7 @@ type QualityControllerStats struct {
 	BitrateDownCount uint64 `json:"bitrate_down_count"`
 	CurrentLevel     string `json:"current_level"`
 	CurrentBitrate   int    `json:"current_bitrate"`
+	FECEnabled       bool   `json:"fec_enabled"`
 }
 
 // GetStats returns quality controller statistics
@@ -500,6 +702,7 @@ func (qc *QualityController) GetStats() QualityControllerStats {
 		BitrateDownCount: qc.bitrateDownCount,
 		CurrentLevel:     qc.currentQuality.Level.String(),
 		CurrentBitrate:   qc.currentQuality.Bitrate,
+		FECEnabled:       qc.fecEnabled,
 	}
 }
 
@@ -509,6 +712,7 @@ func (qc *QualityController) Reset() {
 	defer qc.mu.Unlock()
 
 	qc.networkHistory = qc.networkHistory[:0]
+	qc.fecEnabled = false
 	qc.adaptationCount = 0
 	qc.bitrateUpCount = 0
 	qc.bitrateDownCount = 0
@@ -2,11 +2,19 @@ package adaptive
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/gcc"
+	"github.com/pion/interceptor/pkg/nack"
+	"github.com/pion/interceptor/pkg/twcc"
 	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
 	"github.com/sirupsen/logrus"
 )
@@ -14,44 +22,144 @@ import (
 // KeyframeRequestFunc is a callback type for requesting keyframes
 type KeyframeRequestFunc func() error
 
-// RTCPCollector collects RTCP statistics for quality adaptation
+// NetworkQualityFunc receives the network quality measured over one collection interval
+// It returns an error when the feedback cannot be applied (e.g. the pipeline is not running yet)
+type NetworkQualityFunc func(quality NetworkQuality) error
+
+// SessionFeedback receives the RTCP feedback of a session's video pipeline
+// (implemented by encoder.PipelineManager, looked up by session ID)
+type SessionFeedback interface {
+	// RequestKeyframe is called when the receiver sends PLI/FIR
+	RequestKeyframe(sessionID string) error
+	// UpdateNetworkQuality is called once per collection interval
+	UpdateNetworkQuality(sessionID string, quality NetworkQuality) error
+	// FECEnabled reports whether the quality controller wants video FEC (polled after each update)
+	FECEnabled(sessionID string) bool
+}
+
+// defaultCollectInterval is how often aggregated RTCP feedback is reported
+const defaultCollectInterval = 2 * time.Second
+
+// TransportCCURI is the transport-wide sequence number header extension (same as sdp.TransportCCURI)
+// Peers that negotiate it send TWCC feedback, which drives the send-side bandwidth estimator
+const TransportCCURI = "http://www.ietf.org/id/draft-holmer-rmcat-transport-wide-cc-extensions-01"
+
+// Send-side bandwidth estimator (GCC) limits, matching the range scrcpy accepts
+const (
+	bweInitialBitrate = 2000000  // 2 Mbps (QualityPresetHigh)
+	bweMinBitrate     = 100000   // 100 Kbps
+	bweMaxBitrate     = 20000000 // 20 Mbps
+)
+
+// Send-side packet history kept by the NACK responder
+const (
+	nackPacketSize     = 1200    // typical video RTP packet in bytes (MTU minus headers)
+	nackDefaultBitrate = 2000000 // used when the session bitrate is unknown
+	nackMinHistorySize = 256
+	nackMaxHistorySize = 8192
+)
+
+// NACKHistorySize returns the number of sent video packets kept for retransmission
+// at the given bitrate (bps): about one second of video, rounded up to the power
+// of two required by the NACK responder
+func NACKHistorySize(bitrate int) uint16 {
+	if bitrate <= 0 {
+		bitrate = nackDefaultBitrate
+	}
+
+	packets := bitrate / 8 / nackPacketSize
+	size := nackMinHistorySize
+	for size < packets && size < nackMaxHistorySize {
+		size <<= 1
+	}
+	return uint16(size)
+}
+
+// RTCPStats contains cumulative RTCP feedback counters
+type RTCPStats struct {
+	ReceiverReports      uint64 // RR/SR report blocks about our streams
+	REMBs                uint64
+	TWCCs                uint64
+	NACKedPackets        uint64 // packets the receiver asked to retransmit
+	PLIs                 uint64
+	FIRs                 uint64
+	KeyframeRequests     uint64
+	PacketsSent          uint64 // video RTP packets written, including retransmissions
+	RetransmittedPackets uint64 // video RTP packets resent in response to NACKs
+	FECPackets           uint64 // ULPFEC packets sent
+	FECEnabled           bool
+}
+
+// feedbackWindow aggregates the RTCP feedback received during one collection interval
+type feedbackWindow struct {
+	reports      int
+	fractionLost float64       // worst fraction lost among report blocks
+	rtt          time.Duration // worst RTT computed from LSR/DLSR
+	jitter       time.Duration // worst interarrival jitter
+	remb         uint64        // lowest REMB estimate in bps (0 = none)
+	twccReceived uint64
+	twccLost     uint64
+}
+
+func (w *feedbackWindow) empty() bool {
+	return w.reports == 0 && w.remb == 0 && w.twccReceived+w.twccLost == 0
+}
+
+// RTCPCollector aggregates the RTCP feedback received on one peer connection
+// (fed by RTCPInterceptor) and drives quality adaptation and keyframe requests:
+//   - RR fraction lost / TWCC loss, RTT (LSR/DLSR), jitter and the bandwidth
+//     estimate (GCC or REMB) are reported to the network quality handler once per interval
+//   - PLI/FIR request a keyframe (rate limited); NACKed packets are resent by the
+//     NACK responder from its packet history and only counted here
+//   - video FEC is switched on and off following the FEC source (the quality controller)
 type RTCPCollector struct {
-	sessionID      string
-	peerConnection *webrtc.PeerConnection
-	qualityController *QualityController
-	logger         *logrus.Logger
-
-	// Statistics
-	mu               sync.RWMutex
-	lastRTT          time.Duration
-	lastPacketLoss   float64
-	lastJitter       time.Duration
-	packetsReceived  uint64
-	packetsLost      uint64
-	bytesReceived    uint64
+	sessionID string
+	logger    *logrus.Logger
+	interval  time.Duration
+
+	mu             sync.RWMutex
+	qualityHandler NetworkQualityFunc
+	window         feedbackWindow
+	clockRates     map[uint32]uint32 // local stream SSRC -> RTP clock rate
+	stats          RTCPStats
+
+	// estimator is the GCC send-side bandwidth estimator of the peer connection
+	// Its estimate is only used once the remote sends TWCC feedback
+	estimator cc.BandwidthEstimator
+
+	// Last reported statistics
+	lastRTT        time.Duration
+	lastPacketLoss float64
+	lastJitter     time.Duration
+	lastBandwidth  uint64
+
+	// Video packets written to the network (counted on the RTP write path)
+	packetsSent          atomic.Uint64
+	retransmittedPackets atomic.Uint64
+
+	// Video FEC: fec is attached by NewInterceptorRegistry, fecSource decides whether it is on,
+	// and the payload types come from the codecs negotiated on videoSender
+	fec         *fecEncoder
+	fecSource   func() bool
+	videoSender *webrtc.RTPSender
 
 	// Keyframe request support
-	keyframeRequester    KeyframeRequestFunc
-	lastKeyframeRequest  time.Time
-	keyframeRequestCount uint64
-	keyframeCooldown     time.Duration // Minimum interval between keyframe requests
+	keyframeRequester   KeyframeRequestFunc
+	lastKeyframeRequest time.Time
+	keyframeCooldown    time.Duration // Minimum interval between keyframe requests
 
 	// Packet loss threshold for auto keyframe request
 	packetLossThreshold float64 // Default: 5% loss triggers keyframe
 
 	// Context and control
-	ctx    context.Context
-	cancel context.CancelFunc
-	wg     sync.WaitGroup
-}
-
-// NewRTCPCollector creates a new RTCP statistics collector
-func NewRTCPCollector(
-	sessionID string,
-	peerConnection *webrtc.PeerConnection,
-	qualityController *QualityController,
-	logger *logrus.Logger,
-) *RTCPCollector {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+}
+
+// NewRTCPCollector creates a new RTCP feedback collector
+func NewRTCPCollector(sessionID string, logger *logrus.Logger) *RTCPCollector {
 	if logger == nil {
 		logger = logrus.New()
 	}
@@ -60,9 +168,9 @@ func NewRTCPCollector(
 
 	return &RTCPCollector{
 		sessionID:           sessionID,
-		peerConnection:      peerConnection,
-		qualityController:   qualityController,
 		logger:              logger,
+		interval:            defaultCollectInterval,
+		clockRates:          make(map[uint32]uint32),
 		ctx:                 ctx,
 		cancel:              cancel,
 		keyframeCooldown:    2 * time.Second, // Default: max 1 keyframe request per 2 seconds
@@ -70,14 +178,63 @@ func NewRTCPCollector(
 	}
 }
 
+// NewSessionCollector creates a collector reporting to the video pipeline of sessionID
+// feedback may be nil, in which case RTCP is only counted
+func NewSessionCollector(sessionID string, feedback SessionFeedback, logger *logrus.Logger) *RTCPCollector {
+	collector := NewRTCPCollector(sessionID, logger)
+	if feedback != nil {
+		collector.keyframeRequester = func() error {
+			return feedback.RequestKeyframe(sessionID)
+		}
+		collector.qualityHandler = func(quality NetworkQuality) error {
+			return feedback.UpdateNetworkQuality(sessionID, quality)
+		}
+		collector.fecSource = func() bool {
+			return feedback.FECEnabled(sessionID)
+		}
+	}
+	return collector
+}
+
 // SetKeyframeRequester sets the callback for requesting keyframes
-// This should be called before Start() with a function that can request IDR frames from the encoder
+// The callback is invoked on the RTCP read goroutine and should not block for long
 func (rc *RTCPCollector) SetKeyframeRequester(requester KeyframeRequestFunc) {
 	rc.mu.Lock()
 	defer rc.mu.Unlock()
 	rc.keyframeRequester = requester
 }
 
+// SetNetworkQualityHandler sets the callback receiving per-interval network quality
+func (rc *RTCPCollector) SetNetworkQualityHandler(handler NetworkQualityFunc) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.qualityHandler = handler
+}
+
+// SetQualityController reports network quality directly to a quality controller
+func (rc *RTCPCollector) SetQualityController(qc *QualityController) {
+	rc.SetNetworkQualityHandler(func(quality NetworkQuality) error {
+		qc.UpdateNetworkQuality(quality)
+		qc.Adapt()
+		return nil
+	})
+}
+
+// SetFECSource sets the function deciding whether video FEC is sent, polled once per interval
+func (rc *RTCPCollector) SetFECSource(source func() bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.fecSource = source
+}
+
+// SetVideoSender sets the sender whose negotiated codecs provide the RED/ULPFEC payload types
+// FEC stays off when the remote did not negotiate both
+func (rc *RTCPCollector) SetVideoSender(sender *webrtc.RTPSender) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.videoSender = sender
+}
+
 // SetKeyframeCooldown sets the minimum interval between keyframe requests
 func (rc *RTCPCollector) SetKeyframeCooldown(cooldown time.Duration) {
 	rc.mu.Lock()
@@ -97,7 +254,7 @@ func (rc *RTCPCollector) SetPacketLossThreshold(threshold float64) {
 func (rc *RTCPCollector) GetKeyframeRequestCount() uint64 {
 	rc.mu.RLock()
 	defer rc.mu.RUnlock()
-	return rc.keyframeRequestCount
+	return rc.stats.KeyframeRequests
 }
 
 // RequestKeyframe manually triggers a keyframe request if cooldown has elapsed
@@ -135,38 +292,40 @@ func (rc *RTCPCollector) requestKeyframeLocked(reason string) bool {
 	}
 
 	rc.lastKeyframeRequest = time.Now()
-	rc.keyframeRequestCount++
+	rc.stats.KeyframeRequests++
 
 	rc.logger.WithFields(logrus.Fields{
 		"session_id": rc.sessionID,
 		"reason":     reason,
-		"count":      rc.keyframeRequestCount,
+		"count":      rc.stats.KeyframeRequests,
 	}).Info("Keyframe requested")
 
 	return true
 }
 
-// Start begins collecting RTCP statistics
+// Start begins reporting aggregated RTCP feedback
 func (rc *RTCPCollector) Start() {
 	rc.wg.Add(1)
 	go rc.collectLoop()
 
-	rc.logger.WithField("session_id", rc.sessionID).Info("RTCP collector started")
+	rc.logger.WithField("session_id", rc.sessionID).Debug("RTCP collector started")
 }
 
-// Stop stops collecting RTCP statistics
+// Stop stops the collector (safe to call more than once)
 func (rc *RTCPCollector) Stop() {
-	rc.cancel()
-	rc.wg.Wait()
+	rc.stopOnce.Do(func() {
+		rc.cancel()
+		rc.wg.Wait()
 
-	rc.logger.WithField("session_id", rc.sessionID).Info("RTCP collector stopped")
+		rc.logger.WithField("session_id", rc.sessionID).Debug("RTCP collector stopped")
+	})
 }
 
-// collectLoop periodically collects RTCP statistics
+// collectLoop periodically reports the aggregated feedback
 func (rc *RTCPCollector) collectLoop() {
 	defer rc.wg.Done()
 
-	ticker := time.NewTicker(2 * time.Second)
+	ticker := time.NewTicker(rc.interval)
 	defer ticker.Stop()
 
 	for {
@@ -179,113 +338,255 @@ func (rc *RTCPCollector) collectLoop() {
 	}
 }
 
-// collectStats collects current statistics from the peer connection
-func (rc *RTCPCollector) collectStats() {
-	// Get stats from peer connection
-	statsReport := rc.peerConnection.GetStats()
+// registerStream records the clock rate of a local stream (needed to convert RR jitter)
+func (rc *RTCPCollector) registerStream(ssrc, clockRate uint32) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.clockRates[ssrc] = clockRate
+}
 
-	var (
-		rtt          time.Duration
-		packetLoss   float64
-		jitter       time.Duration
-		bandwidth    uint64
-	)
+// setBandwidthEstimator attaches the peer connection's send-side bandwidth estimator
+func (rc *RTCPCollector) setBandwidthEstimator(estimator cc.BandwidthEstimator) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.estimator = estimator
+}
+
+// unregisterStream forgets a local stream
+func (rc *RTCPCollector) unregisterStream(ssrc uint32) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	delete(rc.clockRates, ssrc)
+}
+
+// HandleRTCP processes RTCP packets received from the remote peer
+func (rc *RTCPCollector) HandleRTCP(packets []rtcp.Packet) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
 
-	// Parse stats report
-	for _, stat := range statsReport {
-		switch s := stat.(type) {
-		case webrtc.InboundRTPStreamStats:
-			// Calculate packet loss
-			if s.PacketsReceived > 0 {
-				// PacketsReceived is uint32, PacketsLost is int32 - convert to common type
-				totalPackets := int64(s.PacketsReceived) + int64(s.PacketsLost)
-				if totalPackets > 0 {
-					packetLoss = float64(s.PacketsLost) / float64(totalPackets)
-				}
+	now := time.Now()
+
+	for _, packet := range packets {
+		switch p := packet.(type) {
+		case *rtcp.ReceiverReport:
+			rc.handleReportsLocked(p.Reports, now)
+
+		case *rtcp.SenderReport:
+			rc.handleReportsLocked(p.Reports, now)
+
+		case *rtcp.ReceiverEstimatedMaximumBitrate:
+			rc.stats.REMBs++
+			if bitrate := uint64(p.Bitrate); bitrate > 0 && (rc.window.remb == 0 || bitrate < rc.window.remb) {
+				rc.window.remb = bitrate
 			}
 
-			// Get jitter
-			jitter = time.Duration(s.Jitter * float64(time.Second))
-
-			// Update counters
-			rc.mu.Lock()
-			rc.packetsReceived = uint64(s.PacketsReceived)
-			rc.packetsLost = uint64(max(0, int64(s.PacketsLost))) // Convert int32 to uint64, handle negative
-			rc.bytesReceived = s.BytesReceived
-			rc.mu.Unlock()
-
-		case webrtc.RemoteInboundRTPStreamStats:
-			// Get RTT from remote inbound stats
-			// RoundTripTime is float64 (seconds) in newer Pion versions
-			if s.RoundTripTime > 0 {
-				rtt = time.Duration(s.RoundTripTime * float64(time.Second))
+		case *rtcp.TransportLayerCC:
+			rc.stats.TWCCs++
+			received, lost := countTWCCStatuses(p)
+			rc.window.twccReceived += received
+			rc.window.twccLost += lost
+
+		case *rtcp.TransportLayerNack:
+			var lost uint64
+			for _, pair := range p.Nacks {
+				lost += uint64(len(pair.PacketList()))
 			}
+			rc.stats.NACKedPackets += lost
+
+		case *rtcp.PictureLossIndication:
+			rc.stats.PLIs++
+			rc.requestKeyframeLocked("pli")
+
+		case *rtcp.FullIntraRequest:
+			rc.stats.FIRs++
+			rc.requestKeyframeLocked("fir")
 		}
 	}
+}
 
-	// Estimate bandwidth based on bytes received
-	// This is a simple estimation - in production, use more sophisticated methods
-	rc.mu.RLock()
-	bytesReceived := rc.bytesReceived
-	rc.mu.RUnlock()
+// handleReportsLocked aggregates report blocks about our local streams (must hold lock)
+func (rc *RTCPCollector) handleReportsLocked(reports []rtcp.ReceptionReport, now time.Time) {
+	for _, report := range reports {
+		clockRate, ok := rc.clockRates[report.SSRC]
+		if !ok {
+			continue
+		}
+
+		rc.stats.ReceiverReports++
+		rc.window.reports++
+
+		if lost := float64(report.FractionLost) / 256.0; lost > rc.window.fractionLost {
+			rc.window.fractionLost = lost
+		}
+
+		if clockRate > 0 {
+			jitter := time.Duration(float64(report.Jitter) / float64(clockRate) * float64(time.Second))
+			if jitter > rc.window.jitter {
+				rc.window.jitter = jitter
+			}
+		}
+
+		if rtt, ok := reportRTT(report, now); ok && rtt > rc.window.rtt {
+			rc.window.rtt = rtt
+		}
+	}
+}
+
+// reportRTT computes the round-trip time from a report block (RFC 3550 6.4.1)
+// Returns false when the remote has not received a sender report yet
+func reportRTT(report rtcp.ReceptionReport, now time.Time) (time.Duration, bool) {
+	if report.LastSenderReport == 0 {
+		return 0, false
+	}
+
+	// RTT = A - LSR - DLSR, all in 1/65536 seconds (middle 32 bits of NTP time)
+	rtt := int32(compactNTP(now) - report.LastSenderReport - report.Delay)
+	if rtt < 0 {
+		return 0, false
+	}
+	return time.Duration(int64(rtt) * int64(time.Second) >> 16), true
+}
+
+// compactNTP returns the middle 32 bits of the NTP timestamp for t
+func compactNTP(t time.Time) uint32 {
+	const ntpEpochOffset = 2208988800 // seconds between 1900-01-01 and 1970-01-01
 
-	// Estimate as bits per 2 seconds (collection interval)
-	bandwidth = bytesReceived * 8 / 2 // bps
+	seconds := uint64(t.Unix()) + ntpEpochOffset
+	fraction := (uint64(t.Nanosecond()) << 32) / uint64(time.Second)
+	return uint32(seconds<<16 | fraction>>16)
+}
+
+// countTWCCStatuses counts received and lost packets in a transport-wide CC feedback
+func countTWCCStatuses(p *rtcp.TransportLayerCC) (received, lost uint64) {
+	remaining := int(p.PacketStatusCount)
+
+	count := func(symbol uint16) {
+		if remaining <= 0 {
+			return
+		}
+		remaining--
+		if symbol == rtcp.TypeTCCPacketNotReceived {
+			lost++
+		} else {
+			received++
+		}
+	}
+
+	for _, chunk := range p.PacketChunks {
+		switch c := chunk.(type) {
+		case *rtcp.RunLengthChunk:
+			for i := uint16(0); i < c.RunLength; i++ {
+				count(c.PacketStatusSymbol)
+			}
+		case *rtcp.StatusVectorChunk:
+			for _, symbol := range c.SymbolList {
+				count(symbol)
+			}
+		}
+	}
+	return received, lost
+}
 
-	// Update statistics
+// collectStats reports the feedback aggregated since the previous interval
+func (rc *RTCPCollector) collectStats() {
 	rc.mu.Lock()
-	rc.lastRTT = rtt
-	rc.lastPacketLoss = packetLoss
-	rc.lastJitter = jitter
+	window := rc.window
+	rc.window = feedbackWindow{}
+
+	// No feedback in this interval (peer not connected yet or not sending RTCP):
+	// don't feed the quality controller with made-up values
+	if window.empty() {
+		rc.mu.Unlock()
+		return
+	}
 
-	// Check if packet loss exceeds threshold and request keyframe
-	if packetLoss > rc.packetLossThreshold && rc.keyframeRequester != nil {
-		if rc.requestKeyframeLocked("packet_loss") {
-			rc.logger.WithFields(logrus.Fields{
-				"session_id":  rc.sessionID,
-				"packet_loss": packetLoss,
-				"threshold":   rc.packetLossThreshold,
-			}).Info("Keyframe requested due to high packet loss")
+	// Prefer transport-wide loss (per packet) over the RR fraction lost (per report),
+	// and the GCC estimate (fed by the same TWCC feedback) over the receiver's REMB
+	packetLoss := window.fractionLost
+	bandwidth := window.remb // 0 = no estimate from the receiver
+	if total := window.twccReceived + window.twccLost; total > 0 {
+		packetLoss = float64(window.twccLost) / float64(total)
+		if rc.estimator != nil {
+			bandwidth = uint64(rc.estimator.GetTargetBitrate())
 		}
 	}
+
+	quality := NetworkQuality{
+		RTT:        window.rtt,
+		PacketLoss: packetLoss,
+		Jitter:     window.jitter,
+		Bandwidth:  bandwidth,
+		Timestamp:  time.Now(),
+	}
+
+	rc.lastRTT = quality.RTT
+	rc.lastPacketLoss = quality.PacketLoss
+	rc.lastJitter = quality.Jitter
+	rc.lastBandwidth = quality.Bandwidth
+
+	// Check if packet loss exceeds threshold and request keyframe
+	if packetLoss > rc.packetLossThreshold {
+		rc.requestKeyframeLocked("packet_loss")
+	}
+
+	handler := rc.qualityHandler
+	fecSource := rc.fecSource
 	rc.mu.Unlock()
 
-	// Update quality controller
-	if rc.qualityController != nil {
-		quality := NetworkQuality{
-			RTT:        rtt,
-			PacketLoss: packetLoss,
-			Jitter:     jitter,
-			Bandwidth:  bandwidth,
-			Timestamp:  time.Now(),
+	rc.logger.WithFields(logrus.Fields{
+		"session_id":  rc.sessionID,
+		"rtt":         quality.RTT,
+		"packet_loss": quality.PacketLoss,
+		"jitter":      quality.Jitter,
+		"bandwidth":   quality.Bandwidth,
+	}).Debug("RTCP feedback collected")
+
+	if handler != nil {
+		if err := handler(quality); err != nil {
+			rc.logger.WithError(err).WithField("session_id", rc.sessionID).Debug("Network quality not applied")
 		}
+	}
 
-		rc.qualityController.UpdateNetworkQuality(quality)
+	if fecSource != nil {
+		rc.applyFEC(fecSource())
+	}
+}
 
-		// Check if we should adapt
-		if changed, newQuality := rc.qualityController.Adapt(); changed {
-			rc.logger.WithFields(logrus.Fields{
-				"session_id": rc.sessionID,
-				"new_level":  newQuality.Level.String(),
-				"bitrate":    newQuality.Bitrate,
-				"fps":        newQuality.FrameRate,
-			}).Info("Quality adaptation triggered")
+// applyFEC switches video FEC on or off
+func (rc *RTCPCollector) applyFEC(enabled bool) {
+	rc.mu.RLock()
+	fec, sender, loss := rc.fec, rc.videoSender, rc.lastPacketLoss
+	rc.mu.RUnlock()
 
-			// Note: The actual adaptation (changing encoder settings) should be
-			// handled by the caller who has access to the encoder/pipeline
-		}
+	if fec == nil || enabled == fec.enabled.Load() {
+		return
+	}
+
+	if !enabled {
+		fec.disable()
+		rc.logger.WithField("session_id", rc.sessionID).Info("Video FEC disabled")
+		return
 	}
 
+	if sender == nil {
+		return
+	}
+	red, ulpfec, ok := negotiatedFECPayloadTypes(sender.GetParameters().Codecs)
+	if !ok {
+		rc.logger.WithField("session_id", rc.sessionID).Debug("Video FEC requested but not negotiated")
+		return
+	}
+	fec.enable(red, ulpfec)
+
 	rc.logger.WithFields(logrus.Fields{
 		"session_id":  rc.sessionID,
-		"rtt":         rtt,
-		"packet_loss": packetLoss,
-		"jitter":      jitter,
-		"bandwidth":   bandwidth,
-	}).Debug("RTCP stats collected")
+		"red_pt":      red,
+		"ulpfec_pt":   ulpfec,
+		"packet_loss": loss,
+	}).Info("Video FEC enabled")
 }
 
-// GetCurrentStats returns the current statistics
+// GetCurrentStats returns the statistics of the last reported interval
 func (rc *RTCPCollector) GetCurrentStats() (rtt time.Duration, loss float64, jitter time.Duration) {
 	rc.mu.RLock()
 	defer rc.mu.RUnlock()
@@ -293,23 +594,31 @@ func (rc *RTCPCollector) GetCurrentStats() (rtt time.Duration, loss float64, jit
 	return rc.lastRTT, rc.lastPacketLoss, rc.lastJitter
 }
 
-// GetPacketStats returns packet statistics
-func (rc *RTCPCollector) GetPacketStats() (received, lost uint64) {
+// GetEstimatedBandwidth returns the last bandwidth estimate in bps (GCC with TWCC feedback,
+// otherwise REMB; 0 if the receiver sends neither)
+func (rc *RTCPCollector) GetEstimatedBandwidth() uint64 {
 	rc.mu.RLock()
 	defer rc.mu.RUnlock()
 
-	return rc.packetsReceived, rc.packetsLost
+	return rc.lastBandwidth
 }
 
-// GetBytesReceived returns total bytes received
-func (rc *RTCPCollector) GetBytesReceived() uint64 {
+// GetStats returns cumulative RTCP feedback counters
+func (rc *RTCPCollector) GetStats() RTCPStats {
 	rc.mu.RLock()
-	defer rc.mu.RUnlock()
+	stats := rc.stats
+	rc.mu.RUnlock()
 
-	return rc.bytesReceived
+	stats.PacketsSent = rc.packetsSent.Load()
+	stats.RetransmittedPackets = rc.retransmittedPackets.Load()
+	if rc.fec != nil {
+		stats.FECPackets = rc.fec.fecPackets.Load()
+		stats.FECEnabled = rc.fec.enabled.Load()
+	}
+	return stats
 }
 
-// RTCPInterceptor is a custom interceptor for RTCP packets
+// RTCPInterceptor feeds the RTCP received on a peer connection to an RTCPCollector
 type RTCPInterceptor struct {
 	interceptor.NoOp
 	collector *RTCPCollector
@@ -336,76 +645,151 @@ func (i *RTCPInterceptor) BindRTCPReader(reader interceptor.RTCPReader) intercep
 			return n, attr, err
 		}
 
-		// Parse RTCP packet
-		packets, err := rtcp.Unmarshal(b[:n])
-		if err != nil {
-			return n, attr, err
+		if attr == nil {
+			attr = make(interceptor.Attributes)
 		}
 
-		// Process packets
-		for _, packet := range packets {
-			switch p := packet.(type) {
-			case *rtcp.ReceiverReport:
-				// Process receiver report
-				i.logger.WithFields(logrus.Fields{
-					"ssrc":   p.SSRC,
-					"reports": len(p.Reports),
-				}).Debug("Received RTCP Receiver Report")
-
-			case *rtcp.SenderReport:
-				// Process sender report
-				i.logger.WithFields(logrus.Fields{
-					"ssrc":         p.SSRC,
-					"ntp_time":     p.NTPTime,
-					"rtp_time":     p.RTPTime,
-					"packet_count": p.PacketCount,
-					"octet_count":  p.OctetCount,
-				}).Debug("Received RTCP Sender Report")
-
-			case *rtcp.TransportLayerNack:
-				// NACK indicates packet loss
-				i.logger.WithFields(logrus.Fields{
-					"ssrc":  p.MediaSSRC,
-					"nacks": len(p.Nacks),
-				}).Debug("Received RTCP NACK")
-
-				// Multiple NACKs may indicate significant packet loss - trigger keyframe
-				if len(p.Nacks) >= 3 && i.collector != nil {
-					i.collector.RequestKeyframe()
-				}
-
-			case *rtcp.PictureLossIndication:
-				// PLI - receiver is requesting a keyframe due to picture loss
-				i.logger.WithFields(logrus.Fields{
-					"sender_ssrc": p.SenderSSRC,
-					"media_ssrc":  p.MediaSSRC,
-				}).Info("Received RTCP PLI (Picture Loss Indication)")
-
-				// Forward PLI request to the encoder
-				if i.collector != nil {
-					i.collector.RequestKeyframe()
-				}
-
-			case *rtcp.FullIntraRequest:
-				// FIR - receiver explicitly requests a full intra frame
-				i.logger.WithFields(logrus.Fields{
-					"sender_ssrc": p.SenderSSRC,
-					"media_ssrc":  p.MediaSSRC,
-					"fir_entries": len(p.FIR),
-				}).Info("Received RTCP FIR (Full Intra Request)")
-
-				// Forward FIR request to the encoder
-				if i.collector != nil {
-					i.collector.RequestKeyframe()
-				}
-			}
+		// Malformed RTCP is dropped by pion later on, it must not break the read loop
+		packets, err := attr.GetRTCPPackets(b[:n])
+		if err != nil {
+			i.logger.WithError(err).Debug("Failed to unmarshal RTCP")
+			return n, attr, nil
 		}
 
+		i.collector.HandleRTCP(packets)
 		return n, attr, nil
 	})
 }
 
-// BindRTCPWriter binds an RTCP writer
-func (i *RTCPInterceptor) BindRTCPWriter(writer interceptor.RTCPWriter) interceptor.RTCPWriter {
-	return writer
+// BindLocalStream records the clock rate of video streams we send (audio reports are ignored)
+// and counts their packets. The interceptor is registered below the NACK responder, so a
+// packet whose sequence number is not newer than the highest one written is a retransmission.
+func (i *RTCPInterceptor) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	if !strings.HasPrefix(strings.ToLower(info.MimeType), "video/") {
+		return writer
+	}
+	i.collector.registerStream(info.SSRC, info.ClockRate)
+
+	var (
+		mu      sync.Mutex
+		started bool
+		highest uint16
+	)
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		mu.Lock()
+		retransmitted := started && int16(header.SequenceNumber-highest) <= 0
+		if !retransmitted {
+			started = true
+			highest = header.SequenceNumber
+		}
+		mu.Unlock()
+
+		i.collector.packetsSent.Add(1)
+		if retransmitted {
+			i.collector.retransmittedPackets.Add(1)
+		}
+		return writer.Write(header, payload, attributes)
+	})
+}
+
+// UnbindLocalStream forgets a local stream
+func (i *RTCPInterceptor) UnbindLocalStream(info *interceptor.StreamInfo) {
+	i.collector.unregisterStream(info.SSRC)
+}
+
+// Close stops the collector when the peer connection is closed
+func (i *RTCPInterceptor) Close() error {
+	i.collector.Stop()
+	return nil
+}
+
+// ReadSenderRTCP reads the RTCP of a sender until its peer connection is closed
+// pion only runs the interceptors' RTCP readers (and thus the collector) when the sender is read
+func ReadSenderRTCP(sender *webrtc.RTPSender) {
+	buf := make([]byte, 1500)
+	for {
+		if _, _, err := sender.Read(buf); err != nil {
+			return
+		}
+	}
+}
+
+// RTCPInterceptorFactory creates the RTCPInterceptor of a single peer connection
+type RTCPInterceptorFactory struct {
+	collector *RTCPCollector
+	logger    *logrus.Logger
+}
+
+// NewRTCPInterceptorFactory creates a factory bound to collector
+func NewRTCPInterceptorFactory(collector *RTCPCollector, logger *logrus.Logger) *RTCPInterceptorFactory {
+	return &RTCPInterceptorFactory{collector: collector, logger: logger}
+}
+
+// NewInterceptor implements interceptor.Factory
+func (f *RTCPInterceptorFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return NewRTCPInterceptor(f.collector, f.logger), nil
+}
+
+// NewInterceptorRegistry builds the interceptor registry of one peer connection:
+//   - RTCP sender/receiver reports (needed for RTT)
+//   - transport-wide sequence numbers and a GCC send-side bandwidth estimator
+//     (the TransportCCURI header extension must be registered on the MediaEngine)
+//   - an RTCPInterceptor feeding collector
+//   - a NACK responder resending video packets from a history sized for videoBitrate (bps, 0 = unknown)
+//   - a ULPFEC encoder, off until the collector's FEC source enables it (the RED/ULPFEC
+//     codecs must be registered on the MediaEngine with RegisterFECCodecs)
+//
+// Each peer connection needs its own API built from its own registry.
+func NewInterceptorRegistry(collector *RTCPCollector, videoBitrate int) (*interceptor.Registry, error) {
+	registry := &interceptor.Registry{}
+	if err := webrtc.ConfigureRTCPReports(registry); err != nil {
+		return nil, fmt.Errorf("failed to configure RTCP reports: %w", err)
+	}
+
+	// The device encoder already paces its output, packets are sent without an extra pacer
+	congestionController, err := cc.NewInterceptor(func() (cc.BandwidthEstimator, error) {
+		return gcc.NewSendSideBWE(
+			gcc.SendSideBWEInitialBitrate(bweInitialBitrate),
+			gcc.SendSideBWEMinBitrate(bweMinBitrate),
+			gcc.SendSideBWEMaxBitrate(bweMaxBitrate),
+			gcc.SendSideBWEPacer(gcc.NewNoOpPacer()),
+		)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create congestion controller: %w", err)
+	}
+	congestionController.OnNewPeerConnection(func(_ string, estimator cc.BandwidthEstimator) {
+		collector.setBandwidthEstimator(estimator)
+	})
+	registry.Add(congestionController)
+
+	headerExtension, err := twcc.NewHeaderExtensionInterceptor()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TWCC header extension interceptor: %w", err)
+	}
+	registry.Add(headerExtension)
+
+	registry.Add(NewRTCPInterceptorFactory(collector, collector.logger))
+
+	// Registered after the RTCP interceptor so that retransmissions are written through
+	// (and counted by) it. Only streams negotiating "nack" feedback keep a history.
+	responder, err := nack.NewResponderInterceptor(nack.ResponderSize(NACKHistorySize(videoBitrate)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create NACK responder: %w", err)
+	}
+	registry.Add(responder)
+
+	collector.fec = &fecEncoder{}
+	registry.Add(&fecInterceptorFactory{encoder: collector.fec})
+	return registry, nil
+}
+
+// RegisterTransportCC registers the transport-wide CC header extension for audio and video
+func RegisterTransportCC(mediaEngine *webrtc.MediaEngine) error {
+	for _, codecType := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
+		if err := mediaEngine.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: TransportCCURI}, codecType); err != nil {
+			return fmt.Errorf("failed to register transport-cc header extension: %w", err)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,443 @@
+package admission
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloudphone/media-service/internal/logger"
+	"go.uber.org/zap"
+)
+
+// 拒绝原因码（随 HTTP 响应 / 信令 error 返回给客户端）
+const (
+	// 配额：客户端应关闭已有会话后重试（HTTP 429）
+	CodeUserSessionLimit   = "user_session_limit"
+	CodeTenantSessionLimit = "tenant_session_limit"
+	CodeDeviceSessionLimit = "device_session_limit"
+
+	// 主机过载：稍后重试或由网关调度到其他节点（HTTP 503）
+	CodeHostCPUOverloaded = "host_cpu_overloaded"
+	CodeHostPipelineLimit = "host_pipeline_limit"
+	CodeICEPortsExhausted = "ice_ports_exhausted"
+)
+
+// RejectedError 准入被拒绝
+type RejectedError struct {
+	Code    string  // 拒绝原因码
+	Message string  // 可读描述
+	Limit   float64 // 触发拒绝的限制值
+	Current float64 // 当前使用量
+}
+
+func (e *RejectedError) Error() string {
+	return e.Message
+}
+
+// HostOverloaded 是否为主机级拒绝（与用户配额无关，换节点或稍后重试可能成功）
+func (e *RejectedError) HostOverloaded() bool {
+	switch e.Code {
+	case CodeHostCPUOverloaded, CodeHostPipelineLimit, CodeICEPortsExhausted:
+		return true
+	default:
+		return false
+	}
+}
+
+// Limits 准入限制（0 表示不限制）
+type Limits struct {
+	MaxSessionsPerUser   int     `json:"maxSessionsPerUser"`   // 单个用户的并发会话数
+	MaxSessionsPerTenant int     `json:"maxSessionsPerTenant"` // 单个租户的并发会话数
+	MaxSessionsPerDevice int     `json:"maxSessionsPerDevice"` // 单个设备的并发会话数
+	MaxCPUPercent        float64 `json:"maxCpuPercent"`        // 主机 CPU 使用率超过该值时拒绝新会话
+	MaxActivePipelines   int     `json:"maxActivePipelines"`   // 主机活跃视频管道（采集 + 编码进程）上限
+	MinFreeICEPorts      int     `json:"minFreeIcePorts"`      // ICE 端口范围内至少保留的空闲端口数
+}
+
+// Request 准入请求
+type Request struct {
+	UserID   string // 为空时不计入用户配额（级联中继的实例间连接）
+	TenantID string
+	DeviceID string // 为空时不计入设备配额（SFU 房间参与者的连接承载多个设备）
+}
+
+// Controller 会话准入控制器
+//
+// 创建会话前依次检查：
+//  1. 主机负载：CPU 使用率、活跃视频管道数、ICE 端口范围内的空闲端口
+//  2. 配额：用户、租户、设备的并发会话数
+//
+// 通过后返回 Reservation，会话创建成功后 Commit 绑定会话 ID，会话关闭时 Release；
+// 创建失败时 Cancel。预留期间的请求也计入配额和端口占用，避免并发请求同时通过检查。
+// 连接可能在会话创建后、Commit 之前关闭，此时 Release 记下会话 ID，随后的 Commit 直接释放预留。
+type Controller struct {
+	limits          Limits
+	portMin         uint16
+	portMax         uint16
+	pipelineCounter func() int // 活跃视频管道数
+	peerConnCounter func() int // 活跃 PeerConnection 数（无法读取 /proc/net/udp 时估算端口占用）
+	cpu             *cpuSampler
+
+	mu       sync.Mutex
+	users    map[string]int
+	tenants  map[string]int
+	devices  map[string]int
+	sessions map[string]Request // sessionID -> 已提交的会话
+	released map[string]bool    // 有预留未提交时关闭的会话 ID（Commit 时释放预留）
+	pending  int                // 已预留尚未提交的请求
+	admitted uint64
+	rejected map[string]uint64 // code -> 次数
+}
+
+// Option 配置选项
+type Option func(*Controller)
+
+// WithLimits 设置准入限制
+func WithLimits(limits Limits) Option {
+	return func(c *Controller) {
+		c.limits = limits
+	}
+}
+
+// WithICEPortRange 设置 ICE 端口范围（与 WebRTC / SFU 的 SettingEngine 一致）
+func WithICEPortRange(min, max uint16) Option {
+	return func(c *Controller) {
+		c.portMin = min
+		c.portMax = max
+	}
+}
+
+// WithPipelineCounter 设置活跃视频管道计数
+func WithPipelineCounter(counter func() int) Option {
+	return func(c *Controller) {
+		c.pipelineCounter = counter
+	}
+}
+
+// WithPeerConnectionCounter 设置活跃 PeerConnection 计数（用于估算 ICE 端口占用）
+func WithPeerConnectionCounter(counter func() int) Option {
+	return func(c *Controller) {
+		c.peerConnCounter = counter
+	}
+}
+
+// WithCPUSampleInterval 设置 CPU 使用率采样间隔
+func WithCPUSampleInterval(interval time.Duration) Option {
+	return func(c *Controller) {
+		c.cpu.interval = interval
+	}
+}
+
+// NewController 创建准入控制器
+func NewController(opts ...Option) *Controller {
+	c := &Controller{
+		cpu:      newCPUSampler(defaultCPUSampleInterval),
+		users:    make(map[string]int),
+		tenants:  make(map[string]int),
+		devices:  make(map[string]int),
+		sessions: make(map[string]Request),
+		released: make(map[string]bool),
+		rejected: make(map[string]uint64),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Start 启动 CPU 使用率采样（ctx 取消时停止）
+func (c *Controller) Start(ctx context.Context) {
+	go c.cpu.run(ctx)
+}
+
+// Limits 返回准入限制
+func (c *Controller) Limits() Limits {
+	return c.limits
+}
+
+// Reservation 准入预留
+type Reservation struct {
+	controller *Controller
+	request    Request
+	done       bool
+}
+
+// Commit 会话创建成功后绑定会话 ID（会话关闭时通过 Release 释放）
+// 会话已经提交过（例如复用了设备已有的 SFU 发布者）或在提交前已经关闭时释放预留
+// 未配置准入控制器时预留为 nil，Commit 和 Cancel 不做任何事
+func (r *Reservation) Commit(sessionID string) {
+	if r == nil {
+		return
+	}
+	c := r.controller
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if r.done {
+		return
+	}
+	r.done = true
+	defer c.settleLocked()
+	if _, ok := c.sessions[sessionID]; ok || c.released[sessionID] {
+		c.decrementLocked(r.request)
+		return
+	}
+	c.sessions[sessionID] = r.request
+}
+
+// Cancel 会话创建失败时释放预留
+func (r *Reservation) Cancel() {
+	if r == nil {
+		return
+	}
+	c := r.controller
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if r.done {
+		return
+	}
+	r.done = true
+	defer c.settleLocked()
+	c.decrementLocked(r.request)
+}
+
+// Admit 检查主机负载和配额，通过时预留一个会话名额
+func (c *Controller) Admit(req Request) (*Reservation, error) {
+	if err := c.checkHost(); err != nil {
+		c.mu.Lock()
+		c.rejectLocked(req, err)
+		c.mu.Unlock()
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.checkQuotaLocked(req); err != nil {
+		c.rejectLocked(req, err)
+		return nil, err
+	}
+
+	if req.UserID != "" {
+		c.users[req.UserID]++
+	}
+	if req.TenantID != "" {
+		c.tenants[req.TenantID]++
+	}
+	if req.DeviceID != "" {
+		c.devices[req.DeviceID]++
+	}
+	c.pending++
+	c.admitted++
+
+	return &Reservation{controller: c, request: req}, nil
+}
+
+// Release 会话关闭时释放名额（未经准入的会话忽略）
+// 有预留尚未提交时记下会话 ID：关闭可能发生在会话创建后、Commit 之前，
+// 也可能是预留复用的已有会话（例如设备的 SFU 发布者），Commit 时据此释放预留
+func (c *Controller) Release(sessionID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pending > 0 {
+		c.released[sessionID] = true
+	}
+
+	req, ok := c.sessions[sessionID]
+	if !ok {
+		return
+	}
+	delete(c.sessions, sessionID)
+	c.decrementLocked(req)
+}
+
+func (c *Controller) checkHost() *RejectedError {
+	if limit := c.limits.MaxCPUPercent; limit > 0 {
+		if usage, ok := c.cpu.usage(); ok && usage >= limit {
+			return &RejectedError{
+				Code:    CodeHostCPUOverloaded,
+				Message: fmt.Sprintf("host CPU usage %.0f%% exceeds %.0f%%", usage, limit),
+				Limit:   limit,
+				Current: usage,
+			}
+		}
+	}
+
+	if limit := c.limits.MaxActivePipelines; limit > 0 && c.pipelineCounter != nil {
+		if active := c.pipelineCounter(); active >= limit {
+			return &RejectedError{
+				Code:    CodeHostPipelineLimit,
+				Message: fmt.Sprintf("host has %d active video pipelines (max %d)", active, limit),
+				Limit:   float64(limit),
+				Current: float64(active),
+			}
+		}
+	}
+
+	if c.limits.MinFreeICEPorts > 0 {
+		if free, _, ok := c.freeICEPorts(); ok && free < c.limits.MinFreeICEPorts {
+			return &RejectedError{
+				Code:    CodeICEPortsExhausted,
+				Message: fmt.Sprintf("only %d free ICE ports left (min %d)", free, c.limits.MinFreeICEPorts),
+				Limit:   float64(c.limits.MinFreeICEPorts),
+				Current: float64(free),
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Controller) checkQuotaLocked(req Request) *RejectedError {
+	checks := []struct {
+		code    string
+		scope   string
+		current int
+		limit   int
+	}{
+		{CodeUserSessionLimit, "user", c.users[req.UserID], c.limits.MaxSessionsPerUser},
+		{CodeTenantSessionLimit, "tenant", c.tenants[req.TenantID], c.limits.MaxSessionsPerTenant},
+		{CodeDeviceSessionLimit, "device", c.devices[req.DeviceID], c.limits.MaxSessionsPerDevice},
+	}
+
+	for _, check := range checks {
+		if check.limit <= 0 || (check.scope == "user" && req.UserID == "") || (check.scope == "tenant" && req.TenantID == "") || (check.scope == "device" && req.DeviceID == "") {
+			continue
+		}
+		if check.current >= check.limit {
+			return &RejectedError{
+				Code:    check.code,
+				Message: fmt.Sprintf("%s has %d concurrent sessions (max %d)", check.scope, check.current, check.limit),
+				Limit:   float64(check.limit),
+				Current: float64(check.current),
+			}
+		}
+	}
+	return nil
+}
+
+// freeICEPorts 返回 ICE 端口范围内的空闲端口数和总端口数（未配置端口范围时 ok 为 false）
+// 已预留尚未创建 PeerConnection 的请求按每个占用一个端口计算
+func (c *Controller) freeICEPorts() (free, total int, ok bool) {
+	if c.portMax == 0 || c.portMax < c.portMin {
+		return 0, 0, false
+	}
+	total = int(c.portMax) - int(c.portMin) + 1
+
+	used, err := boundUDPPorts(c.portMin, c.portMax)
+	if err != nil {
+		// 非 Linux 或无法读取 /proc：按每个 PeerConnection 占用一个端口估算
+		if c.peerConnCounter == nil {
+			return 0, total, false
+		}
+		used = c.peerConnCounter()
+	}
+
+	c.mu.Lock()
+	pending := c.pending
+	c.mu.Unlock()
+
+	free = total - used - pending
+	if free < 0 {
+		free = 0
+	}
+	return free, total, true
+}
+
+// settleLocked 一个预留完成（提交或取消）
+// 没有预留时不会再有 Commit，清空关闭记录
+func (c *Controller) settleLocked() {
+	c.pending--
+	if c.pending == 0 && len(c.released) > 0 {
+		c.released = make(map[string]bool)
+	}
+}
+
+func (c *Controller) decrementLocked(req Request) {
+	if req.UserID != "" {
+		decrement(c.users, req.UserID)
+	}
+	if req.TenantID != "" {
+		decrement(c.tenants, req.TenantID)
+	}
+	if req.DeviceID != "" {
+		decrement(c.devices, req.DeviceID)
+	}
+}
+
+func (c *Controller) rejectLocked(req Request, err *RejectedError) {
+	c.rejected[err.Code]++
+	logger.Warn("admission_rejected",
+		zap.String("code", err.Code),
+		zap.String("user_id", req.UserID),
+		zap.String("tenant_id", req.TenantID),
+		zap.String("device_id", req.DeviceID),
+		zap.Float64("limit", err.Limit),
+		zap.Float64("current", err.Current),
+	)
+}
+
+func decrement(counts map[string]int, key string) {
+	if counts[key] <= 1 {
+		delete(counts, key)
+		return
+	}
+	counts[key]--
+}
+
+// Stats 当前利用率（/stats）
+type Stats struct {
+	Sessions        int               `json:"sessions"`        // 经准入的活跃会话
+	Pending         int               `json:"pending"`         // 已预留尚未创建的会话
+	Users           int               `json:"users"`           // 有会话的用户数
+	Tenants         int               `json:"tenants"`         // 有会话的租户数
+	Devices         int               `json:"devices"`         // 有会话的设备数
+	CPUPercent      *float64          `json:"cpuPercent"`      // 主机 CPU 使用率（尚未采样或无法读取时为 null）
+	ActivePipelines int               `json:"activePipelines"` // 活跃视频管道数
+	ICEPorts        *ICEPortStats     `json:"icePorts"`        // 未配置端口范围时为 null
+	Admitted        uint64            `json:"admitted"`        // 累计通过次数
+	Rejected        map[string]uint64 `json:"rejected"`        // 累计拒绝次数（按原因码）
+	Limits          Limits            `json:"limits"`          // 当前限制（0 = 不限制）
+}
+
+// ICEPortStats ICE 端口范围利用率
+type ICEPortStats struct {
+	Total int `json:"total"`
+	Free  int `json:"free"`
+}
+
+// Stats 返回当前利用率
+func (c *Controller) Stats() Stats {
+	stats := Stats{Limits: c.limits}
+
+	if usage, ok := c.cpu.usage(); ok {
+		stats.CPUPercent = &usage
+	}
+	if c.pipelineCounter != nil {
+		stats.ActivePipelines = c.pipelineCounter()
+	}
+	if free, total, ok := c.freeICEPorts(); ok {
+		stats.ICEPorts = &ICEPortStats{Total: total, Free: free}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats.Sessions = len(c.sessions)
+	stats.Pending = c.pending
+	stats.Users = len(c.users)
+	stats.Tenants = len(c.tenants)
+	stats.Devices = len(c.devices)
+	stats.Admitted = c.admitted
+	stats.Rejected = make(map[string]uint64, len(c.rejected))
+	for code, count := range c.rejected {
+		stats.Rejected[code] = count
+	}
+
+	return stats
+}
@@ -0,0 +1,174 @@
+package admission
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/cloudphone/media-service/internal/logger"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = zap.NewNop()
+	logger.Sugar = logger.Log.Sugar()
+	os.Exit(m.Run())
+}
+
+// admissionStep 准入测试的一步
+type admissionStep struct {
+	op        string // admit / commit / cancel / release
+	reserve   int    // admit/commit/cancel 使用的预留序号
+	sessionID string // commit/release 的会话 ID
+}
+
+func admit(reserve int) admissionStep { return admissionStep{op: "admit", reserve: reserve} }
+func commit(reserve int, sessionID string) admissionStep {
+	return admissionStep{op: "commit", reserve: reserve, sessionID: sessionID}
+}
+func cancel(reserve int) admissionStep { return admissionStep{op: "cancel", reserve: reserve} }
+func release(sessionID string) admissionStep {
+	return admissionStep{op: "release", sessionID: sessionID}
+}
+
+func TestAdmissionOrdering(t *testing.T) {
+	tests := []struct {
+		name         string
+		steps        []admissionStep
+		wantSessions int
+		wantPending  int
+		wantUsers    int
+	}{
+		{
+			name:        "reserved until committed",
+			steps:       []admissionStep{admit(0)},
+			wantPending: 1,
+			wantUsers:   1,
+		},
+		{
+			name:         "commit then release",
+			steps:        []admissionStep{admit(0), commit(0, "s1")},
+			wantSessions: 1,
+			wantUsers:    1,
+		},
+		{
+			name:  "release after commit frees the slot",
+			steps: []admissionStep{admit(0), commit(0, "s1"), release("s1")},
+		},
+		{
+			name:  "cancel frees the slot",
+			steps: []admissionStep{admit(0), cancel(0)},
+		},
+		{
+			name:  "release before commit frees the slot",
+			steps: []admissionStep{admit(0), release("s1"), commit(0, "s1")},
+		},
+		{
+			name: "release before commit does not affect other reservations",
+			steps: []admissionStep{
+				admit(0), admit(1),
+				release("s1"),
+				commit(0, "s1"), commit(1, "s2"),
+			},
+			wantSessions: 1,
+			wantUsers:    1,
+		},
+		{
+			name: "committing an existing session frees the new reservation",
+			steps: []admissionStep{
+				admit(0), commit(0, "s1"),
+				admit(1), commit(1, "s1"),
+			},
+			wantSessions: 1,
+			wantUsers:    1,
+		},
+		{
+			name: "reused session closed before commit",
+			steps: []admissionStep{
+				admit(0), commit(0, "s1"),
+				admit(1), release("s1"), commit(1, "s1"),
+			},
+		},
+		{
+			name:         "release of unknown session is ignored",
+			steps:        []admissionStep{release("unknown"), admit(0), commit(0, "unknown")},
+			wantSessions: 1,
+			wantUsers:    1,
+		},
+		{
+			name:  "commit after cancel is ignored",
+			steps: []admissionStep{admit(0), cancel(0), commit(0, "s1")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewController()
+			reservations := make(map[int]*Reservation)
+
+			for i, step := range tt.steps {
+				switch step.op {
+				case "admit":
+					r, err := c.Admit(Request{UserID: "user-1", TenantID: "tenant-1", DeviceID: "device-1"})
+					if err != nil {
+						t.Fatalf("step %d: admit failed: %v", i, err)
+					}
+					reservations[step.reserve] = r
+				case "commit":
+					reservations[step.reserve].Commit(step.sessionID)
+				case "cancel":
+					reservations[step.reserve].Cancel()
+				case "release":
+					c.Release(step.sessionID)
+				default:
+					t.Fatalf("step %d: unknown op %q", i, step.op)
+				}
+			}
+
+			stats := c.Stats()
+			if stats.Sessions != tt.wantSessions {
+				t.Errorf("sessions = %d, want %d", stats.Sessions, tt.wantSessions)
+			}
+			if stats.Pending != tt.wantPending {
+				t.Errorf("pending = %d, want %d", stats.Pending, tt.wantPending)
+			}
+			if stats.Users != tt.wantUsers || stats.Tenants != tt.wantUsers || stats.Devices != tt.wantUsers {
+				t.Errorf("users/tenants/devices = %d/%d/%d, want %d", stats.Users, stats.Tenants, stats.Devices, tt.wantUsers)
+			}
+			if tt.wantPending == 0 && len(c.released) != 0 {
+				t.Errorf("released = %v, want empty once no reservation is pending", c.released)
+			}
+		})
+	}
+}
+
+func TestReleaseBeforeCommitKeepsQuota(t *testing.T) {
+	c := NewController(WithLimits(Limits{MaxSessionsPerUser: 1}))
+	req := Request{UserID: "user-1"}
+
+	r, err := c.Admit(req)
+	if err != nil {
+		t.Fatalf("admit failed: %v", err)
+	}
+	// 连接在会话创建后、Commit 之前关闭
+	c.Release("s1")
+	r.Commit("s1")
+
+	if _, err := c.Admit(req); err != nil {
+		t.Fatalf("admit after closed session failed: %v", err)
+	}
+	if _, err := c.Admit(req); err == nil {
+		t.Fatal("admit over quota succeeded")
+	} else {
+		var rejected *RejectedError
+		if !errors.As(err, &rejected) || rejected.Code != CodeUserSessionLimit {
+			t.Fatalf("admit over quota error = %v, want %s", err, CodeUserSessionLimit)
+		}
+	}
+}
+
+func TestNilReservation(t *testing.T) {
+	var r *Reservation
+	r.Commit("s1")
+	r.Cancel()
+}
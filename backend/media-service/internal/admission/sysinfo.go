@@ -0,0 +1,153 @@
+package admission
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudphone/media-service/internal/metrics"
+)
+
+const defaultCPUSampleInterval = 2 * time.Second
+
+// cpuSampler 定期读取 /proc/stat 计算主机 CPU 使用率
+// 准入检查在请求路径上，只读取最近一次采样结果
+type cpuSampler struct {
+	interval time.Duration
+	percent  atomic.Uint64 // math.Float64bits，采样前为 noSample
+}
+
+// noSample 尚未采样或无法读取 /proc/stat
+const noSample = math.MaxUint64
+
+func newCPUSampler(interval time.Duration) *cpuSampler {
+	s := &cpuSampler{interval: interval}
+	s.percent.Store(noSample)
+	return s
+}
+
+// usage 返回最近一次采样的 CPU 使用率（0-100）
+func (s *cpuSampler) usage() (float64, bool) {
+	bits := s.percent.Load()
+	if bits == noSample {
+		return 0, false
+	}
+	return math.Float64frombits(bits), true
+}
+
+func (s *cpuSampler) run(ctx context.Context) {
+	if s.interval <= 0 {
+		return
+	}
+
+	prevBusy, prevTotal, err := readCPUTimes()
+	if err != nil {
+		// 非 Linux：不做 CPU 检查
+		return
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		busy, total, err := readCPUTimes()
+		if err != nil || total <= prevTotal {
+			continue
+		}
+		percent := float64(busy-prevBusy) / float64(total-prevTotal) * 100
+		prevBusy, prevTotal = busy, total
+
+		s.percent.Store(math.Float64bits(percent))
+		metrics.CPUUsage.Set(percent / 100)
+	}
+}
+
+// readCPUTimes 读取 /proc/stat 的总 CPU 时间和非空闲时间（jiffies）
+func readCPUTimes() (busy, total uint64, err error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return 0, 0, fmt.Errorf("empty /proc/stat")
+	}
+	fields := strings.Fields(scanner.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0, fmt.Errorf("unexpected /proc/stat format")
+	}
+
+	var idle uint64
+	for i, field := range fields[1:] {
+		v, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid /proc/stat value %q: %w", field, err)
+		}
+		total += v
+		// idle 和 iowait
+		if i == 3 || i == 4 {
+			idle += v
+		}
+	}
+	return total - idle, total, nil
+}
+
+// boundUDPPorts 统计端口范围内已绑定的 UDP 端口数（读取 /proc/net/udp 和 udp6）
+func boundUDPPorts(min, max uint16) (int, error) {
+	ports := make(map[uint16]struct{})
+	read := 0
+	for _, path := range []string{"/proc/net/udp", "/proc/net/udp6"} {
+		if err := collectUDPPorts(path, min, max, ports); err != nil {
+			continue
+		}
+		read++
+	}
+	if read == 0 {
+		return 0, fmt.Errorf("cannot read /proc/net/udp")
+	}
+	return len(ports), nil
+}
+
+func collectUDPPorts(path string, min, max uint16, ports map[uint16]struct{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // 表头
+	for scanner.Scan() {
+		// "  sl  local_address rem_address ..."，local_address 为 "IP:PORT"（十六进制）
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		i := strings.LastIndexByte(fields[1], ':')
+		if i < 0 {
+			continue
+		}
+		port, err := strconv.ParseUint(fields[1][i+1:], 16, 16)
+		if err != nil {
+			continue
+		}
+		if p := uint16(port); p >= min && p <= max {
+			ports[p] = struct{}{}
+		}
+	}
+	return scanner.Err()
+}
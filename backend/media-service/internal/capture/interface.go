@@ -119,6 +119,39 @@ type KeyframeRequester interface {
 	RequestKeyframe() error
 }
 
+// DeviceEventType represents the type of a device event reported by a capture
+type DeviceEventType string
+
+const (
+	// DeviceEventDisconnected the device stream was lost (Reconnecting reports whether a reconnect follows)
+	DeviceEventDisconnected DeviceEventType = "device_disconnected"
+	// DeviceEventReconnectAttempt a reconnection attempt finished (Success reports the outcome)
+	DeviceEventReconnectAttempt DeviceEventType = "reconnect_attempt"
+	// DeviceEventClipboard the device clipboard changed (Text holds the new content)
+	DeviceEventClipboard DeviceEventType = "clipboard"
+)
+
+// DeviceEvent is a device state change observed by the capture
+type DeviceEvent struct {
+	Type         DeviceEventType
+	Attempt      uint32 // Reconnection attempt number (reconnect_attempt)
+	MaxAttempts  uint32 // Maximum reconnection attempts, 0 = unlimited
+	Success      bool   // Whether the reconnection attempt succeeded
+	Reconnecting bool   // Whether a reconnection will be attempted (device_disconnected)
+	Text         string // Clipboard content (clipboard)
+	Timestamp    time.Time
+}
+
+// DeviceEventEmitter extends ScreenCapture with device event notifications
+// This interface is optional - use type assertion to check if capture supports it
+type DeviceEventEmitter interface {
+	ScreenCapture
+
+	// SetDeviceEventHandler sets the callback for device events
+	// Call this before Start(); the handler is invoked asynchronously
+	SetDeviceEventHandler(handler func(DeviceEvent))
+}
+
 // CaptureStats contains statistics about the capture process
 type CaptureStats struct {
 	FramesCaptured  uint64        // Total frames captured
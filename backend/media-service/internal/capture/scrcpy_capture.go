@@ -1,6 +1,7 @@
 package capture
 
 import (
+	"bufio"
 	"context"
 	"encoding/binary"
 	"fmt"
@@ -62,6 +63,9 @@ type ScrcpyCapture struct {
 	maxReconnects     uint32        // Maximum reconnection attempts (0 = unlimited)
 	reconnectDelay    time.Duration // Base delay between reconnects (with exponential backoff)
 	onReconnect       func(success bool, attempt uint32) // Optional callback on reconnection attempts
+
+	// Device events (disconnect, reconnection, clipboard)
+	onDeviceEvent func(DeviceEvent) // Optional callback, see SetDeviceEventHandler
 }
 
 // scrcpy control message types (v2.x+ protocol)
@@ -84,6 +88,17 @@ const (
 	scrcpyControlRequestKeyframe      = 0x0E // Request IDR frame (scrcpy v2.6+)
 )
 
+// scrcpy device message types (server -> client on the control socket)
+// Reference: https://github.com/Genymobile/scrcpy/blob/master/server/src/main/java/com/genymobile/scrcpy/control/DeviceMessage.java
+const (
+	scrcpyDeviceMsgClipboard    = 0x00
+	scrcpyDeviceMsgAckClipboard = 0x01
+	scrcpyDeviceMsgUhidOutput   = 0x02
+
+	// scrcpyMaxClipboardLength matches the server-side limit for clipboard messages
+	scrcpyMaxClipboardLength = 1 << 18
+)
+
 // ScrcpyOptions contains scrcpy-specific options
 type ScrcpyOptions struct {
 	MaxSize       int  // Max dimension (width or height), 0 = native
@@ -197,6 +212,7 @@ func (c *ScrcpyCapture) Start(ctx context.Context, options CaptureOptions) error
 
 	// Step 5: Start reading H.264 stream (with reconnection support)
 	go c.readH264StreamWithReconnect(captureCtx)
+	c.startDeviceMessageReader()
 
 	c.logger.WithFields(logrus.Fields{
 		"device_id":        options.DeviceID,
@@ -1486,6 +1502,7 @@ func (c *ScrcpyCapture) extractSPSPPS(data []byte) {
 		case 7: // SPS
 			c.sps = make([]byte, len(nal))
 			copy(c.sps, nal)
+			c.parseResolutionFromSPS(c.sps)
 			c.logger.WithField("sps_size", len(nal)).Debug("SPS NAL extracted")
 		case 8: // PPS
 			c.pps = make([]byte, len(nal))
@@ -1526,10 +1543,23 @@ func splitNALUnits(data []byte) [][]byte {
 	return nalUnits
 }
 
-// parseResolutionFromSPS extracts resolution from H.264 SPS NAL unit (unused - we get resolution from scrcpy header)
+// parseResolutionFromSPS updates the resolution from a new H.264 SPS NAL unit
+// The initial resolution comes from the scrcpy header; scrcpy restarts the encoder
+// with a new SPS when the device rotates, so later frames carry the rotated size.
+// Caller must hold c.mu.
 func (c *ScrcpyCapture) parseResolutionFromSPS(sps []byte) {
-	// Resolution is now obtained from scrcpy protocol header
-	// This function is kept for potential future use with direct H.264 streams
+	oldWidth, oldHeight := c.width, c.height
+	if err := c.parseResolutionFromSPSData(sps); err != nil {
+		c.logger.WithError(err).Debug("Failed to parse resolution from SPS, keeping current resolution")
+		return
+	}
+	if oldWidth != 0 && (c.width != oldWidth || c.height != oldHeight) {
+		c.logger.WithFields(logrus.Fields{
+			"device_id":      c.deviceID,
+			"old_resolution": fmt.Sprintf("%dx%d", oldWidth, oldHeight),
+			"new_resolution": fmt.Sprintf("%dx%d", c.width, c.height),
+		}).Info("Capture resolution changed")
+	}
 }
 
 // trimNull removes null bytes from a byte slice and returns as string
@@ -1682,8 +1712,14 @@ func (c *ScrcpyCapture) readH264StreamWithReconnect(ctx context.Context) {
 		// Run the actual H.264 stream reading
 		c.readH264Stream(ctx)
 
+		// Stream ended without Stop(): the device went away
+		reconnect := c.shouldReconnect(ctx)
+		if ctx.Err() == nil {
+			c.emitDeviceEvent(DeviceEvent{Type: DeviceEventDisconnected, Reconnecting: reconnect})
+		}
+
 		// Check if we should attempt reconnection
-		if !c.shouldReconnect(ctx) {
+		if !reconnect {
 			c.logger.Debug("Reconnection not enabled or context cancelled, exiting stream reader")
 			return
 		}
@@ -1695,6 +1731,9 @@ func (c *ScrcpyCapture) readH264StreamWithReconnect(ctx context.Context) {
 				"attempts":         c.reconnectAttempts,
 				"max_reconnects":   c.maxReconnects,
 			}).Warn("All reconnection attempts failed, giving up")
+			if ctx.Err() == nil {
+				c.emitDeviceEvent(DeviceEvent{Type: DeviceEventDisconnected, Reconnecting: false})
+			}
 			return
 		}
 
@@ -1807,6 +1846,7 @@ func (c *ScrcpyCapture) attemptReconnect(ctx context.Context) bool {
 	// Reconnection successful
 	c.running.Store(true)
 	c.stats.LastFrameTime = time.Now()
+	c.startDeviceMessageReader()
 
 	// Reset attempt counter on success
 	atomic.StoreUint32(&c.reconnectAttempts, 0)
@@ -1843,4 +1883,101 @@ func (c *ScrcpyCapture) notifyReconnect(success bool, attempt uint32) {
 		// Call callback in a goroutine to avoid blocking
 		go c.onReconnect(success, attempt)
 	}
+	c.emitDeviceEvent(DeviceEvent{
+		Type:        DeviceEventReconnectAttempt,
+		Attempt:     attempt,
+		MaxAttempts: c.maxReconnects,
+		Success:     success,
+	})
+}
+
+// ==================== Device Events ====================
+
+// SetDeviceEventHandler sets the callback for device events (disconnect, reconnection, clipboard)
+// Call this before Start()
+func (c *ScrcpyCapture) SetDeviceEventHandler(handler func(DeviceEvent)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onDeviceEvent = handler
+}
+
+// emitDeviceEvent calls the device event callback if configured
+func (c *ScrcpyCapture) emitDeviceEvent(event DeviceEvent) {
+	c.mu.RLock()
+	handler := c.onDeviceEvent
+	c.mu.RUnlock()
+
+	if handler == nil {
+		return
+	}
+	event.Timestamp = time.Now()
+	// Call callback in a goroutine to avoid blocking the stream reader
+	go handler(event)
+}
+
+// startDeviceMessageReader reads device messages from the control socket
+// The socket is owned by the reader only for reads; writes are serialized by controlMu
+func (c *ScrcpyCapture) startDeviceMessageReader() {
+	c.mu.RLock()
+	conn := c.controlConn
+	c.mu.RUnlock()
+
+	if conn != nil {
+		go c.readDeviceMessages(conn)
+	}
+}
+
+// readDeviceMessages parses scrcpy device messages until the control socket is closed
+//
+// Message formats:
+//   - CLIPBOARD:     [type(1)] + [length(4)] + [UTF-8 text]
+//   - ACK_CLIPBOARD: [type(1)] + [sequence(8)]
+//   - UHID_OUTPUT:   [type(1)] + [id(2)] + [size(2)] + [data]
+func (c *ScrcpyCapture) readDeviceMessages(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	header := make([]byte, 8)
+
+	for {
+		msgType, err := reader.ReadByte()
+		if err != nil {
+			c.logger.WithError(err).WithField("device_id", c.deviceID).Debug("Device message reader exited")
+			return
+		}
+
+		switch msgType {
+		case scrcpyDeviceMsgClipboard:
+			if _, err := io.ReadFull(reader, header[:4]); err != nil {
+				return
+			}
+			length := binary.BigEndian.Uint32(header[:4])
+			if length > scrcpyMaxClipboardLength {
+				c.logger.WithField("length", length).Warn("Clipboard message too large, stopping device message reader")
+				return
+			}
+			text := make([]byte, length)
+			if _, err := io.ReadFull(reader, text); err != nil {
+				return
+			}
+			c.emitDeviceEvent(DeviceEvent{Type: DeviceEventClipboard, Text: string(text)})
+
+		case scrcpyDeviceMsgAckClipboard:
+			if _, err := io.ReadFull(reader, header[:8]); err != nil {
+				return
+			}
+
+		case scrcpyDeviceMsgUhidOutput:
+			if _, err := io.ReadFull(reader, header[:4]); err != nil {
+				return
+			}
+			size := binary.BigEndian.Uint16(header[2:4])
+			if _, err := reader.Discard(int(size)); err != nil {
+				return
+			}
+
+		default:
+			// Unknown message: the stream can no longer be framed
+			c.logger.WithField("type", fmt.Sprintf("0x%02x", msgType)).Warn("Unknown device message type, stopping device message reader")
+			return
+		}
+	}
 }
@@ -524,8 +524,16 @@ func (c *AndroidScreenRecordCapture) GetSPSPPS() (sps, pps []byte) {
 }
 
 // readH264Stream reads H.264 NAL units from the stream
+// screenrecord 输出的是连续 Annex-B 字节流，按起始码切分为完整 NAL 后再发送，
+// 避免 NAL 被 64KB 读缓冲截断导致 WebRTC 打包错误
 func (c *AndroidScreenRecordCapture) readH264Stream(ctx context.Context, reader io.Reader) {
 	buffer := make([]byte, 65536) // 64KB buffer
+	nalBuf := make([]byte, 0, 256*1024)
+
+	frameDuration := time.Second / 30
+	if c.options.FrameRate > 0 {
+		frameDuration = time.Second / time.Duration(c.options.FrameRate)
+	}
 
 	for {
 		select {
@@ -541,25 +549,54 @@ func (c *AndroidScreenRecordCapture) readH264Stream(ctx context.Context, reader
 				return
 			}
 
-			if n > 0 {
-				// Create frame with H.264 data
-				frame := &Frame{
-					Data:      make([]byte, n),
-					Timestamp: time.Now(),
-					Format:    FrameFormatH264,
-				}
-				copy(frame.Data, buffer[:n])
-
-				// Try to send frame
-				select {
-				case c.frameChannel <- frame:
-					atomic.AddUint64(&c.stats.FramesCaptured, 1)
-					atomic.AddUint64(&c.stats.BytesCaptured, uint64(n))
-					c.stats.LastFrameTime = time.Now()
-				default:
-					atomic.AddUint64(&c.stats.FramesDropped, 1)
-				}
+			nalBuf = append(nalBuf, buffer[:n]...)
+			nalUnits := splitNALUnits(nalBuf)
+			if len(nalUnits) < 2 {
+				continue
 			}
+
+			// 最后一个 NAL 可能不完整，保留到下次读取
+			for _, nal := range nalUnits[:len(nalUnits)-1] {
+				c.sendNAL(nal, frameDuration)
+			}
+			last := nalUnits[len(nalUnits)-1]
+			nalBuf = append(nalBuf[:0], last...)
 		}
 	}
 }
+
+// sendNAL sends a complete NAL unit as a frame
+func (c *AndroidScreenRecordCapture) sendNAL(nal []byte, frameDuration time.Duration) {
+	nalTypeIdx := 4
+	if len(nal) > 2 && nal[2] == 1 {
+		nalTypeIdx = 3 // 3-byte start code
+	}
+	if nalTypeIdx >= len(nal) {
+		return
+	}
+	nalType := nal[nalTypeIdx] & 0x1F
+
+	frame := &Frame{
+		Data:      make([]byte, len(nal)),
+		Timestamp: time.Now(),
+		Format:    FrameFormatH264,
+		Keyframe:  nalType == 5,
+	}
+	copy(frame.Data, nal)
+
+	// 仅图像 slice 推进时间戳，SPS/PPS/SEI 与其后的 slice 共享时间戳
+	if nalType == 1 || nalType == 5 {
+		frame.Duration = frameDuration
+	}
+
+	select {
+	case c.frameChannel <- frame:
+		atomic.AddUint64(&c.stats.FramesCaptured, 1)
+		atomic.AddUint64(&c.stats.BytesCaptured, uint64(len(nal)))
+		c.mu.Lock()
+		c.stats.LastFrameTime = time.Now()
+		c.mu.Unlock()
+	default:
+		atomic.AddUint64(&c.stats.FramesDropped, 1)
+	}
+}
@@ -24,8 +24,20 @@ type Config struct {
 	ICEPortMax  uint16
 	NAT1To1IPs  []string // NAT 1:1 映射 IP（用于跨 NAT/Docker 网络的 ICE 候选）
 
+	// 会话恢复配置
+	SessionResumeGraceSeconds int // ICE 失败后保留会话（采集、录像）等待客户端 ICE restart 的秒数（0 = 立即关闭）
+
+	// 准入控制配置（0 = 不限制）
+	MaxSessionsPerUser       int     // 单个用户的并发会话数
+	MaxSessionsPerTenant     int     // 单个租户的并发会话数
+	MaxSessionsPerDevice     int     // 单个设备的并发会话数
+	AdmissionMaxCPUPercent   float64 // 主机 CPU 使用率（%）超过该值时拒绝新会话
+	AdmissionMaxPipelines    int     // 主机活跃视频管道上限
+	AdmissionMinFreeICEPorts int     // ICE 端口范围内至少保留的空闲端口数
+
 	// 设备服务配置
-	DeviceServiceURL string
+	DeviceServiceURL            string
+	DeviceAccessCacheTTLSeconds int // 设备访问权限查询结果的缓存时间（秒）
 
 	// 媒体配置
 	VideoCodec    string
@@ -36,8 +48,30 @@ type Config struct {
 	VideoHeight   int
 
 	// 采集配置 (新增)
-	CaptureMode     string // "screencap" (PNG) or "screenrecord" (H.264)
-	VideoEncoderType string // "passthrough", "vp8", "vp8-simple", "h264"
+	CaptureMode           string // "auto", "scrcpy", "screenrecord" (H.264) or "screencap" (PNG)
+	VideoEncoderType      string // "auto", "passthrough", "vp8", "vp8-simple", "h264"
+	PipelineOverridesFile string // 租户/设备级管道规格覆盖（JSON 文件，可选）
+
+	// 编码进程配置
+	MaxEncoderProcesses int // 单机常驻 FFmpeg 编码进程上限
+	EncoderWorkers      int // 原始帧会话的并行编码工作协程数（0 = 串行编码）
+	EncoderMaxWorkers   int // 按队列深度扩容的工作协程上限
+
+	// 视频 FEC：协商 RED/ULPFEC，丢包率超过阈值时由质量控制器开启
+	VideoFECEnabled bool
+
+	// SFU 转码配置
+	SFUSimulcastTranscode bool   // 将设备端 H.264 转码为多个层，订阅者按网络状况选择
+	SFUSimulcastLayers    string // 层配置 "rid:width:bitrate,..."（空 = 720/480/240 三层）
+
+	// SFU 级联：通过 Consul 登记和发现设备发布者，订阅其他实例上的设备时在本实例中继（需要启用 Consul）
+	SFUCascadeEnabled bool
+
+	// SFU 发布者生命周期：按需采集（默认开启，false 时协商完成即采集）时第一个订阅者连接才开始设备采集，
+	// 订阅者数量降为 0 时暂停采集；没有订阅者超过 SFUPublisherIdleSeconds 后关闭发布者（0 = 不关闭）
+	SFULazyCapture          bool
+	SFUPausedCaptureFPS     int // 暂停时保持采集的帧率（0 = 停止采集）
+	SFUPublisherIdleSeconds int
 
 	// Consul 配置
 	ConsulHost    string
@@ -69,6 +103,8 @@ func Load() *Config {
 
 		DeviceServiceURL: getEnv("DEVICE_SERVICE_URL", "http://localhost:30002"),
 
+		DeviceAccessCacheTTLSeconds: getEnvInt("DEVICE_ACCESS_CACHE_TTL_SECONDS", 30),
+
 		VideoCodec:   getEnv("VIDEO_CODEC", "VP8"),
 		AudioCodec:   getEnv("AUDIO_CODEC", "opus"),
 		MaxBitrate:   getEnvInt("MAX_BITRATE", 2000000), // 2 Mbps
@@ -76,14 +112,40 @@ func Load() *Config {
 		VideoWidth:   getEnvInt("VIDEO_WIDTH", 1280),
 		VideoHeight:  getEnvInt("VIDEO_HEIGHT", 720),
 
-		// 采集配置: 默认使用 screenrecord (H.264 硬件编码)
-		CaptureMode:     getEnv("CAPTURE_MODE", "screenrecord"), // screenrecord (推荐) | screencap
-		VideoEncoderType: getEnv("VIDEO_ENCODER_TYPE", "passthrough"), // 自动根据 CaptureMode 选择
+		// 采集配置: 默认按可用性选择 (scrcpy → screenrecord → screencap)
+		CaptureMode:           getEnv("CAPTURE_MODE", "auto"),       // auto | scrcpy | screenrecord | screencap
+		VideoEncoderType:      getEnv("VIDEO_ENCODER_TYPE", "auto"), // 自动根据 CaptureMode 选择
+		PipelineOverridesFile: getEnv("PIPELINE_OVERRIDES_FILE", ""),
+
+		MaxEncoderProcesses: getEnvInt("MAX_ENCODER_PROCESSES", 8),
+		EncoderWorkers:      getEnvInt("ENCODER_WORKERS", 0),
+		EncoderMaxWorkers:   getEnvInt("ENCODER_MAX_WORKERS", 4),
+
+		VideoFECEnabled: getEnvBool("VIDEO_FEC_ENABLED", false),
+
+		SFUSimulcastTranscode: getEnvBool("SFU_SIMULCAST_TRANSCODE", false),
+		SFUSimulcastLayers:    getEnv("SFU_SIMULCAST_LAYERS", ""),
+
+		SFUCascadeEnabled: getEnvBool("SFU_CASCADE_ENABLED", false),
+
+		SFULazyCapture:          getEnvBool("SFU_LAZY_CAPTURE", true),
+		SFUPausedCaptureFPS:     getEnvInt("SFU_PAUSED_CAPTURE_FPS", 0),
+		SFUPublisherIdleSeconds: getEnvInt("SFU_PUBLISHER_IDLE_SECONDS", 300),
 
 		ICEPortMin: uint16(getEnvInt("ICE_PORT_MIN", 50000)),
 		ICEPortMax: uint16(getEnvInt("ICE_PORT_MAX", 50100)),
 		NAT1To1IPs: getEnvStringSlice("NAT_1TO1_IPS", []string{}), // 可选：指定公网/LAN IP
 
+		SessionResumeGraceSeconds: getEnvInt("SESSION_RESUME_GRACE_SECONDS", 30),
+
+		// 准入控制配置
+		MaxSessionsPerUser:       getEnvInt("MAX_SESSIONS_PER_USER", 10),
+		MaxSessionsPerTenant:     getEnvInt("MAX_SESSIONS_PER_TENANT", 0),
+		MaxSessionsPerDevice:     getEnvInt("MAX_SESSIONS_PER_DEVICE", 10),
+		AdmissionMaxCPUPercent:   getEnvFloat("ADMISSION_MAX_CPU_PERCENT", 90),
+		AdmissionMaxPipelines:    getEnvInt("ADMISSION_MAX_PIPELINES", 0),
+		AdmissionMinFreeICEPorts: getEnvInt("ADMISSION_MIN_FREE_ICE_PORTS", 4),
+
 		// Consul 配置
 		ConsulHost:    getEnv("CONSUL_HOST", "localhost"),
 		ConsulPort:    getEnvInt("CONSUL_PORT", 8500),
@@ -122,10 +184,29 @@ func Load() *Config {
 		zap.Uint16("ice_port_min", cfg.ICEPortMin),
 		zap.Uint16("ice_port_max", cfg.ICEPortMax),
 		zap.Strings("nat_1to1_ips", cfg.NAT1To1IPs),
+		zap.Int("session_resume_grace_seconds", cfg.SessionResumeGraceSeconds),
+		zap.Int("max_sessions_per_user", cfg.MaxSessionsPerUser),
+		zap.Int("max_sessions_per_tenant", cfg.MaxSessionsPerTenant),
+		zap.Int("max_sessions_per_device", cfg.MaxSessionsPerDevice),
+		zap.Float64("admission_max_cpu_percent", cfg.AdmissionMaxCPUPercent),
+		zap.Int("admission_max_pipelines", cfg.AdmissionMaxPipelines),
+		zap.Int("admission_min_free_ice_ports", cfg.AdmissionMinFreeICEPorts),
+		zap.String("device_service_url", cfg.DeviceServiceURL),
+		zap.Int("device_access_cache_ttl_seconds", cfg.DeviceAccessCacheTTLSeconds),
 		zap.String("video_codec", cfg.VideoCodec),
 		zap.Int("max_bitrate", cfg.MaxBitrate),
 		zap.String("capture_mode", cfg.CaptureMode),
 		zap.String("video_encoder_type", cfg.VideoEncoderType),
+		zap.String("pipeline_overrides_file", cfg.PipelineOverridesFile),
+		zap.Int("max_encoder_processes", cfg.MaxEncoderProcesses),
+		zap.Int("encoder_workers", cfg.EncoderWorkers),
+		zap.Int("encoder_max_workers", cfg.EncoderMaxWorkers),
+		zap.Bool("video_fec_enabled", cfg.VideoFECEnabled),
+		zap.Bool("sfu_simulcast_transcode", cfg.SFUSimulcastTranscode),
+		zap.Bool("sfu_cascade_enabled", cfg.SFUCascadeEnabled),
+		zap.Bool("sfu_lazy_capture", cfg.SFULazyCapture),
+		zap.Int("sfu_paused_capture_fps", cfg.SFUPausedCaptureFPS),
+		zap.Int("sfu_publisher_idle_seconds", cfg.SFUPublisherIdleSeconds),
 	)
 
 	return cfg
@@ -147,6 +228,15 @@ func getEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
 func getEnvBool(key string, defaultValue bool) bool {
 	if value := os.Getenv(key); value != "" {
 		if boolValue, err := strconv.ParseBool(value); err == nil {
@@ -32,6 +32,18 @@ func NewClient(cfg *config.Config) (*Client, error) {
 	}, nil
 }
 
+// Instance is a healthy media-service instance discovered through Consul
+type Instance struct {
+	ID      string
+	Address string
+	Port    int
+}
+
+// BaseURL returns the instance's HTTP address
+func (i Instance) BaseURL() string {
+	return fmt.Sprintf("http://%s:%d", i.Address, i.Port)
+}
+
 // RegisterService registers the media service with Consul
 func (c *Client) RegisterService() error {
 	// Service port as integer
@@ -40,7 +52,7 @@ func (c *Client) RegisterService() error {
 		return fmt.Errorf("invalid port: %w", err)
 	}
 
-	serviceID := fmt.Sprintf("%s-%s-%d", c.config.ServiceName, c.config.ServiceHost, port)
+	serviceID := c.serviceID(port)
 	c.checkID = fmt.Sprintf("service:%s", serviceID)
 
 	registration := &consulapi.AgentServiceRegistration{
@@ -80,7 +92,7 @@ func (c *Client) DeregisterService() error {
 		return fmt.Errorf("invalid port: %w", err)
 	}
 
-	serviceID := fmt.Sprintf("%s-%s-%d", c.config.ServiceName, c.config.ServiceHost, port)
+	serviceID := c.serviceID(port)
 
 	err = c.client.Agent().ServiceDeregister(serviceID)
 	if err != nil {
@@ -94,6 +106,42 @@ func (c *Client) DeregisterService() error {
 	return nil
 }
 
+// ServiceID returns the ID this instance registers with
+func (c *Client) ServiceID() (string, error) {
+	port, err := parsePort(c.config.Port)
+	if err != nil {
+		return "", fmt.Errorf("invalid port: %w", err)
+	}
+	return c.serviceID(port), nil
+}
+
+// HealthyInstances lists the instances of this service that pass their health checks
+func (c *Client) HealthyInstances() ([]Instance, error) {
+	entries, _, err := c.client.Health().Service(c.config.ServiceName, "", true, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query healthy instances: %w", err)
+	}
+
+	instances := make([]Instance, 0, len(entries))
+	for _, entry := range entries {
+		address := entry.Service.Address
+		if address == "" {
+			// Services registered without an address use the node address
+			address = entry.Node.Address
+		}
+		instances = append(instances, Instance{
+			ID:      entry.Service.ID,
+			Address: address,
+			Port:    entry.Service.Port,
+		})
+	}
+	return instances, nil
+}
+
+func (c *Client) serviceID(port int) string {
+	return fmt.Sprintf("%s-%s-%d", c.config.ServiceName, c.config.ServiceHost, port)
+}
+
 // parsePort converts string port to int
 func parsePort(portStr string) (int, error) {
 	var port int
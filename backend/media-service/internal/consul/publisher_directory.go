@@ -0,0 +1,253 @@
+package consul
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloudphone/media-service/internal/logger"
+	"github.com/cloudphone/media-service/internal/sfu"
+	consulapi "github.com/hashicorp/consul/api"
+	"go.uber.org/zap"
+)
+
+const (
+	// publisherKeyPrefix is the KV prefix of device publisher records
+	publisherKeyPrefix = "cloudphone/media-service/sfu/publishers/"
+	// directorySessionTTL is how long records survive an instance that stops renewing its session
+	directorySessionTTL = "30s"
+	// sessionRetryInterval is the delay between attempts to recreate a lost session
+	sessionRetryInterval = 5 * time.Second
+)
+
+// publisherEntry is the KV value of a device publisher record
+type publisherEntry struct {
+	sfu.PublisherRecord
+	InstanceID string `json:"instanceId"`
+}
+
+// PublisherDirectory records which instance owns each device publisher in Consul KV,
+// so other instances can relay the device instead of capturing it again (cascading SFU).
+// Records are held by a session of this instance: when the instance dies the session
+// expires and Consul deletes its records.
+type PublisherDirectory struct {
+	client     *Client
+	instanceID string
+
+	mu        sync.Mutex
+	sessionID string
+	records   map[string]sfu.PublisherRecord // deviceID -> record held by this instance
+	done      chan struct{}
+	stopped   chan struct{}
+}
+
+// NewPublisherDirectory creates a publisher directory for this instance
+func NewPublisherDirectory(client *Client) (*PublisherDirectory, error) {
+	instanceID, err := client.ServiceID()
+	if err != nil {
+		return nil, err
+	}
+
+	return &PublisherDirectory{
+		client:     client,
+		instanceID: instanceID,
+		records:    make(map[string]sfu.PublisherRecord),
+	}, nil
+}
+
+// Start creates the session that holds this instance's records and keeps it alive
+func (d *PublisherDirectory) Start() error {
+	sessionID, err := d.createSession()
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.sessionID = sessionID
+	d.done = make(chan struct{})
+	d.stopped = make(chan struct{})
+	d.mu.Unlock()
+
+	go d.renew(sessionID)
+
+	logger.Info("sfu_publisher_directory_started",
+		zap.String("instance_id", d.instanceID),
+		zap.String("session_id", sessionID),
+	)
+	return nil
+}
+
+// Stop destroys the session, which deletes all records of this instance
+func (d *PublisherDirectory) Stop() {
+	d.mu.Lock()
+	done, stopped := d.done, d.stopped
+	d.done = nil
+	d.mu.Unlock()
+
+	if done == nil {
+		return
+	}
+	close(done)
+	<-stopped
+
+	logger.Info("sfu_publisher_directory_stopped",
+		zap.String("instance_id", d.instanceID),
+	)
+}
+
+// RegisterPublisher records that this instance owns the device publisher
+func (d *PublisherDirectory) RegisterPublisher(record sfu.PublisherRecord) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.acquire(d.sessionID, record); err != nil {
+		return err
+	}
+	d.records[record.DeviceID] = record
+	return nil
+}
+
+// DeregisterPublisher removes the device publisher record if it still belongs to the publisher
+func (d *PublisherDirectory) DeregisterPublisher(record sfu.PublisherRecord) error {
+	d.mu.Lock()
+	if current, ok := d.records[record.DeviceID]; ok && current.PublisherID == record.PublisherID {
+		delete(d.records, record.DeviceID)
+	}
+	d.mu.Unlock()
+
+	kv := d.client.client.KV()
+	pair, _, err := kv.Get(publisherKeyPrefix+record.DeviceID, nil)
+	if err != nil {
+		return fmt.Errorf("failed to read publisher record: %w", err)
+	}
+	if pair == nil {
+		return nil
+	}
+
+	var entry publisherEntry
+	if err := json.Unmarshal(pair.Value, &entry); err != nil || entry.InstanceID != d.instanceID || entry.PublisherID != record.PublisherID {
+		// The device has been published again, here or on another instance
+		return nil
+	}
+	if _, _, err := kv.DeleteCAS(pair, nil); err != nil {
+		return fmt.Errorf("failed to delete publisher record: %w", err)
+	}
+	return nil
+}
+
+// LookupPublisher finds the device publisher on another healthy instance
+func (d *PublisherDirectory) LookupPublisher(deviceID string) (*sfu.RemotePublisher, error) {
+	pair, _, err := d.client.client.KV().Get(publisherKeyPrefix+deviceID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read publisher record: %w", err)
+	}
+	if pair == nil || pair.Session == "" {
+		return nil, sfu.ErrNoRemotePublisher
+	}
+
+	var entry publisherEntry
+	if err := json.Unmarshal(pair.Value, &entry); err != nil {
+		return nil, fmt.Errorf("invalid publisher record for device %s: %w", deviceID, err)
+	}
+	if entry.InstanceID == d.instanceID {
+		return nil, sfu.ErrNoRemotePublisher
+	}
+
+	instances, err := d.client.HealthyInstances()
+	if err != nil {
+		return nil, err
+	}
+	for _, instance := range instances {
+		if instance.ID == entry.InstanceID {
+			return &sfu.RemotePublisher{
+				PublisherRecord: entry.PublisherRecord,
+				InstanceID:      instance.ID,
+				BaseURL:         instance.BaseURL(),
+			}, nil
+		}
+	}
+	return nil, sfu.ErrNoRemotePublisher
+}
+
+// createSession creates a TTL session whose records are deleted when it is invalidated
+func (d *PublisherDirectory) createSession() (string, error) {
+	sessionID, _, err := d.client.client.Session().Create(&consulapi.SessionEntry{
+		Name:     d.instanceID + "-sfu-publishers",
+		TTL:      directorySessionTTL,
+		Behavior: consulapi.SessionBehaviorDelete,
+	}, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create consul session: %w", err)
+	}
+	return sessionID, nil
+}
+
+// acquire writes the record held by the session
+func (d *PublisherDirectory) acquire(sessionID string, record sfu.PublisherRecord) error {
+	value, err := json.Marshal(publisherEntry{PublisherRecord: record, InstanceID: d.instanceID})
+	if err != nil {
+		return fmt.Errorf("failed to encode publisher record: %w", err)
+	}
+
+	acquired, _, err := d.client.client.KV().Acquire(&consulapi.KVPair{
+		Key:     publisherKeyPrefix + record.DeviceID,
+		Value:   value,
+		Session: sessionID,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to write publisher record: %w", err)
+	}
+	if !acquired {
+		return fmt.Errorf("device %s is already published by another instance", record.DeviceID)
+	}
+	return nil
+}
+
+// renew keeps the session alive until Stop; if the session is lost (e.g. Consul was
+// unreachable longer than the TTL) a new one is created and the records are written again
+func (d *PublisherDirectory) renew(sessionID string) {
+	d.mu.Lock()
+	done, stopped := d.done, d.stopped
+	d.mu.Unlock()
+	defer close(stopped)
+
+	for {
+		// RenewPeriodic destroys the session when done is closed
+		err := d.client.client.Session().RenewPeriodic(directorySessionTTL, sessionID, nil, done)
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		logger.Warn("sfu_publisher_directory_session_lost",
+			zap.String("session_id", sessionID),
+			zap.Error(err),
+		)
+
+		newSessionID, err := d.createSession()
+		for err != nil {
+			logger.Warn("sfu_publisher_directory_session_create_failed", zap.Error(err))
+			select {
+			case <-done:
+				return
+			case <-time.After(sessionRetryInterval):
+			}
+			newSessionID, err = d.createSession()
+		}
+		sessionID = newSessionID
+
+		d.mu.Lock()
+		d.sessionID = sessionID
+		for _, record := range d.records {
+			if err := d.acquire(sessionID, record); err != nil {
+				logger.Warn("sfu_publisher_record_restore_failed",
+					zap.String("device_id", record.DeviceID),
+					zap.Error(err),
+				)
+			}
+		}
+		d.mu.Unlock()
+	}
+}
@@ -0,0 +1,308 @@
+package deviceaccess
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudphone/media-service/internal/httpclient"
+	"github.com/cloudphone/media-service/internal/logger"
+	"github.com/cloudphone/media-service/internal/middleware"
+	"go.uber.org/zap"
+)
+
+// Level 用户对设备的访问级别
+type Level int
+
+const (
+	// LevelNone 无权访问
+	LevelNone Level = iota
+	// LevelView 只能观看画面（SFU 订阅者）
+	LevelView
+	// LevelControl 可以观看并控制设备（会话、发布者、WHIP 推流）
+	LevelControl
+)
+
+// String 返回访问级别名称
+func (l Level) String() string {
+	switch l {
+	case LevelView:
+		return "view"
+	case LevelControl:
+		return "control"
+	default:
+		return "none"
+	}
+}
+
+// Allows 判断当前级别是否满足 required
+func (l Level) Allows(required Level) bool {
+	return l >= required
+}
+
+var (
+	// ErrDeviceNotFound device-service 中不存在该设备
+	ErrDeviceNotFound = errors.New("device not found")
+	// ErrUnavailable device-service 无法确认访问权限（网络错误、5xx 等，结果不缓存）
+	ErrUnavailable = errors.New("device access check unavailable")
+)
+
+const (
+	defaultCacheTTL = 30 * time.Second
+	// maxCacheEntries 缓存条目数超过该值时清理过期条目
+	maxCacheEntries = 10000
+)
+
+// deviceInfo device-service 设备详情中用于授权的字段
+type deviceInfo struct {
+	ID       string `json:"id"`
+	UserID   string `json:"userId"`
+	TenantID string `json:"tenantId"`
+}
+
+// deviceResponse device-service 统一响应格式
+type deviceResponse struct {
+	Success bool        `json:"success"`
+	Data    *deviceInfo `json:"data"`
+}
+
+// cacheEntry 缓存的访问级别（设备不存在时 notFound 为 true）
+type cacheEntry struct {
+	level     Level
+	notFound  bool
+	expiresAt time.Time
+}
+
+// Checker 通过 device-service 查询 JWT 用户对设备的访问级别
+//
+// 以用户自己的 JWT 调用 GET /devices/:id（device-service 校验 device.read 权限），
+// 或者在用户请求之外（CheckIdentity）以服务令牌调用 GET /internal/devices/:id/owner，
+// 再按设备的归属判定级别：
+//   - 设备所有者、媒体管理员（见 UserContext.CanAccess）: LevelControl
+//   - 同租户的其他用户: LevelView
+//   - 其他: LevelNone
+//
+// 结果按 用户 + 设备 缓存 TTL 时间（包括拒绝和设备不存在），device-service 不可用时不缓存
+type Checker struct {
+	baseURL           string
+	client            *httpclient.Client
+	ttl               time.Duration
+	serviceCredential ServiceCredential
+
+	mu    sync.RWMutex
+	cache map[string]cacheEntry
+}
+
+// Option 配置选项
+type Option func(*Checker)
+
+// ServiceCredential 生成调用 device-service 内部接口的服务令牌
+type ServiceCredential func() (string, error)
+
+// WithCacheTTL 设置缓存时间（<= 0 时不缓存）
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(c *Checker) {
+		c.ttl = ttl
+	}
+}
+
+// WithServiceCredential 设置服务令牌（CheckIdentity 使用，未设置时 CheckIdentity 返回 ErrUnavailable）
+func WithServiceCredential(credential ServiceCredential) Option {
+	return func(c *Checker) {
+		c.serviceCredential = credential
+	}
+}
+
+// WithHTTPClient 设置 HTTP 客户端
+func WithHTTPClient(client *httpclient.Client) Option {
+	return func(c *Checker) {
+		c.client = client
+	}
+}
+
+// NewChecker 创建设备访问检查器
+func NewChecker(deviceServiceURL string, opts ...Option) *Checker {
+	c := &Checker{
+		baseURL: strings.TrimRight(deviceServiceURL, "/"),
+		ttl:     defaultCacheTTL,
+		cache:   make(map[string]cacheEntry),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.client == nil {
+		client := httpclient.New()
+		// 授权查询在创建会话的请求路径上，不能使用默认的 30 秒超时
+		client.Timeout = 5 * time.Second
+		c.client = client
+	}
+
+	return c
+}
+
+// Check 查询用户对设备的访问级别
+func (c *Checker) Check(ctx context.Context, user *middleware.UserContext, deviceID string) (Level, error) {
+	return c.check(ctx, user, deviceID, c.fetchDevice)
+}
+
+// CheckIdentity 按用户的身份（用户、租户和权限）查询访问级别，不使用用户的 JWT
+// 用于用户请求之外的校验（例如向房间添加设备时的其他参与者），JWT 过期不影响结果
+func (c *Checker) CheckIdentity(ctx context.Context, user *middleware.UserContext, deviceID string) (Level, error) {
+	return c.check(ctx, user, deviceID, func(ctx context.Context, _ *middleware.UserContext, deviceID string) (*deviceInfo, error) {
+		return c.fetchOwner(ctx, deviceID)
+	})
+}
+
+// check 查询访问级别，缓存未命中时通过 fetch 获取设备归属
+func (c *Checker) check(ctx context.Context, user *middleware.UserContext, deviceID string,
+	fetch func(ctx context.Context, user *middleware.UserContext, deviceID string) (*deviceInfo, error)) (Level, error) {
+	key := user.UserID + "|" + user.TenantID + "|" + deviceID
+
+	if entry, ok := c.cached(key); ok {
+		if entry.notFound {
+			return LevelNone, ErrDeviceNotFound
+		}
+		return entry.level, nil
+	}
+
+	device, err := fetch(ctx, user, deviceID)
+	switch {
+	case errors.Is(err, ErrDeviceNotFound):
+		c.store(key, cacheEntry{notFound: true})
+		return LevelNone, err
+	case err != nil:
+		logger.Warn("device_access_check_failed",
+			zap.String("user_id", user.UserID),
+			zap.String("device_id", deviceID),
+			zap.Error(err),
+		)
+		return LevelNone, err
+	}
+
+	level := LevelNone
+	if device != nil {
+		level = accessLevel(user, device)
+	}
+	c.store(key, cacheEntry{level: level})
+
+	logger.Debug("device_access_checked",
+		zap.String("user_id", user.UserID),
+		zap.String("device_id", deviceID),
+		zap.String("level", level.String()),
+	)
+
+	return level, nil
+}
+
+// accessLevel 按设备归属判定访问级别
+func accessLevel(user *middleware.UserContext, device *deviceInfo) Level {
+	if user.CanAccess(device.UserID, device.TenantID) {
+		return LevelControl
+	}
+	if device.TenantID != "" && device.TenantID == user.TenantID {
+		return LevelView
+	}
+	return LevelNone
+}
+
+// fetchDevice 以用户身份查询设备详情
+// 返回 nil 设备表示 device-service 拒绝了该用户（401/403）
+func (c *Checker) fetchDevice(ctx context.Context, user *middleware.UserContext, deviceID string) (*deviceInfo, error) {
+	device, status, err := c.getDevice(ctx, "/devices/"+url.PathEscape(deviceID), "Authorization", "Bearer "+user.Token)
+	if status == http.StatusUnauthorized || status == http.StatusForbidden {
+		return nil, nil
+	}
+	return device, err
+}
+
+// fetchOwner 以服务令牌查询设备归属（服务令牌被拒绝视为不可用）
+func (c *Checker) fetchOwner(ctx context.Context, deviceID string) (*deviceInfo, error) {
+	if c.serviceCredential == nil {
+		return nil, fmt.Errorf("%w: service credential not configured", ErrUnavailable)
+	}
+	token, err := c.serviceCredential()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to create service token: %v", ErrUnavailable, err)
+	}
+
+	device, _, err := c.getDevice(ctx, "/internal/devices/"+url.PathEscape(deviceID)+"/owner", middleware.ServiceTokenHeader, token)
+	return device, err
+}
+
+// getDevice 请求 device-service 并解析设备，同时返回 HTTP 状态码
+// 401/403 返回 ErrUnavailable，由调用方决定是否视为拒绝
+func (c *Checker) getDevice(ctx context.Context, path, authHeader, authValue string) (*deviceInfo, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set(authHeader, authValue)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, resp.StatusCode, ErrDeviceNotFound
+	default:
+		io.Copy(io.Discard, resp.Body)
+		return nil, resp.StatusCode, fmt.Errorf("%w: device-service returned %d", ErrUnavailable, resp.StatusCode)
+	}
+
+	var body deviceResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("%w: invalid device response: %v", ErrUnavailable, err)
+	}
+	if body.Data == nil {
+		return nil, resp.StatusCode, ErrDeviceNotFound
+	}
+
+	return body.Data, resp.StatusCode, nil
+}
+
+// cached 读取未过期的缓存结果
+func (c *Checker) cached(key string) (cacheEntry, bool) {
+	c.mu.RLock()
+	entry, ok := c.cache[key]
+	c.mu.RUnlock()
+
+	if !ok || time.Now().After(entry.expiresAt) {
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+// store 缓存结果，条目过多时清理过期条目
+func (c *Checker) store(key string, entry cacheEntry) {
+	if c.ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	entry.expiresAt = now.Add(c.ttl)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.cache) >= maxCacheEntries {
+		for k, e := range c.cache {
+			if now.After(e.expiresAt) {
+				delete(c.cache, k)
+			}
+		}
+	}
+	c.cache[key] = entry
+}
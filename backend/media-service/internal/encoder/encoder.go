@@ -2,6 +2,7 @@ package encoder
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/cloudphone/media-service/internal/capture"
 )
@@ -21,6 +22,42 @@ type VideoEncoder interface {
 	Close() error
 }
 
+// AsyncVideoEncoder is implemented by encoders whose output is decoupled from input,
+// such as a long-lived FFmpeg process whose stdout is parsed incrementally.
+// Frames passed to Submit produce encoded frames on Output in submission order.
+type AsyncVideoEncoder interface {
+	VideoEncoder
+
+	// Submit queues a frame for encoding without waiting for output
+	Submit(frame *capture.Frame) error
+
+	// Output returns the channel of encoded frames
+	Output() <-chan *EncodedFrame
+}
+
+// PreparingVideoEncoder is implemented by stateful encoders whose per-frame
+// preprocessing (decode, scale, color conversion) does not depend on encoder
+// state. Prepare may run concurrently on many goroutines; SubmitPrepared must
+// be called in presentation order because all frames share one encoder context.
+type PreparingVideoEncoder interface {
+	AsyncVideoEncoder
+
+	// Prepare converts a captured frame into encoder input
+	Prepare(frame *capture.Frame) (*PreparedFrame, error)
+
+	// SubmitPrepared feeds a prepared frame to the shared encoder context
+	SubmitPrepared(prepared *PreparedFrame) error
+}
+
+// PreparedFrame is a frame converted to raw encoder input (I420)
+type PreparedFrame struct {
+	Data      []byte
+	Width     int
+	Height    int
+	Timestamp time.Time
+	Duration  time.Duration
+}
+
 // AudioEncoder defines the interface for audio encoding
 type AudioEncoder interface {
 	// EncodeAudio encodes a captured audio frame
@@ -33,6 +70,15 @@ type AudioEncoder interface {
 	Close() error
 }
 
+// LossAwareAudioEncoder is implemented by audio encoders that add in-band FEC
+// tuned to the expected packet loss, such as libopus with useinbandfec=1.
+type LossAwareAudioEncoder interface {
+	AudioEncoder
+
+	// SetPacketLoss sets the expected packet loss in percent
+	SetPacketLoss(percent int) error
+}
+
 // PassThroughEncoder is a no-op encoder that passes frames unchanged
 type PassThroughEncoder struct{}
 
@@ -24,13 +24,14 @@ const (
 
 // VideoEncoderConfig contains configuration for video encoders
 type VideoEncoderConfig struct {
-	Type      EncoderType
-	Width     int
-	Height    int
-	Bitrate   int
-	FrameRate int
-	Quality   int
-	Logger    *logrus.Logger
+	Type        EncoderType
+	Width       int // 0 = follow captured frame size (vp8/h264)
+	Height      int // 0 = follow captured frame size (vp8/h264)
+	Bitrate     int
+	FrameRate   int
+	Quality     int
+	ProcessPool *EncoderProcessPool // nil = package-wide pool
+	Logger      *logrus.Logger
 }
 
 // AudioEncoderConfig contains configuration for audio encoders
@@ -77,6 +78,18 @@ func (f *EncoderFactory) CreateVideoEncoder(config VideoEncoderConfig) (VideoEnc
 			"framerate": config.FrameRate,
 		}).Info("Creating VP8 encoder (FFmpeg streaming)")
 
+		if config.Width == 0 || config.Height == 0 {
+			// 输出尺寸跟随采集帧（管道已按目标分辨率缩放）
+			return NewStreamingEncoder(StreamingEncoderOptions{
+				Codec:       StreamCodecVP8,
+				Bitrate:     config.Bitrate,
+				FrameRate:   config.FrameRate,
+				Quality:     config.Quality,
+				ProcessPool: config.ProcessPool,
+				Logger:      config.Logger,
+			})
+		}
+
 		return NewVP8EncoderFFmpeg(VP8EncoderOptions{
 			Width:     config.Width,
 			Height:    config.Height,
@@ -111,13 +124,14 @@ func (f *EncoderFactory) CreateVideoEncoder(config VideoEncoderConfig) (VideoEnc
 		}).Info("Creating H.264 encoder (FFmpeg with hardware acceleration)")
 
 		return NewH264EncoderFFmpeg(H264EncoderOptions{
-			Width:     config.Width,
-			Height:    config.Height,
-			Bitrate:   config.Bitrate,
-			FrameRate: config.FrameRate,
-			Preset:    "faster",
-			HWAccel:   H264EncoderAuto, // Auto-detect hardware
-			Logger:    config.Logger,
+			Width:       config.Width,
+			Height:      config.Height,
+			Bitrate:     config.Bitrate,
+			FrameRate:   config.FrameRate,
+			Preset:      "faster",
+			HWAccel:     H264EncoderAuto, // Auto-detect hardware
+			ProcessPool: config.ProcessPool,
+			Logger:      config.Logger,
 		})
 
 	default:
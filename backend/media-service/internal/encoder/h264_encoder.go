@@ -4,9 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"os/exec"
-	"sync"
 
-	"github.com/cloudphone/media-service/internal/capture"
 	"github.com/sirupsen/logrus"
 )
 
@@ -21,33 +19,28 @@ const (
 	H264EncoderAuto      H264EncoderType = "auto"       // Auto-detect
 )
 
-// H264EncoderFFmpeg implements H.264 encoding using FFmpeg with hardware acceleration
+// H264EncoderFFmpeg implements H.264 encoding using FFmpeg with hardware acceleration.
+// Frames are streamed into a single long-lived FFmpeg process (see StreamingEncoder).
 type H264EncoderFFmpeg struct {
-	width      int
-	height     int
-	bitrate    int
-	frameRate  int
-	preset     string
-	hwAccel    H264EncoderType
-	logger     *logrus.Logger
-	converter  *ImageConverter
-	mu         sync.Mutex
+	*StreamingEncoder
+	hwAccel H264EncoderType
 }
 
 // H264EncoderOptions contains configuration for H.264 encoder
 type H264EncoderOptions struct {
-	Width     int
-	Height    int
-	Bitrate   int
-	FrameRate int
-	Preset    string          // ultrafast, superfast, veryfast, faster, fast, medium
-	HWAccel   H264EncoderType // Hardware acceleration type
-	Logger    *logrus.Logger
+	Width       int // 0 = derive from first frame
+	Height      int // 0 = derive from first frame
+	Bitrate     int
+	FrameRate   int
+	Preset      string          // ultrafast, superfast, veryfast, faster, fast, medium
+	HWAccel     H264EncoderType // Hardware acceleration type
+	ProcessPool *EncoderProcessPool
+	Logger      *logrus.Logger
 }
 
 // NewH264EncoderFFmpeg creates a new H.264 encoder with hardware acceleration
 func NewH264EncoderFFmpeg(options H264EncoderOptions) (*H264EncoderFFmpeg, error) {
-	if options.Width <= 0 || options.Height <= 0 {
+	if options.Width < 0 || options.Height < 0 {
 		return nil, fmt.Errorf("invalid dimensions: %dx%d", options.Width, options.Height)
 	}
 	if options.Bitrate <= 0 {
@@ -67,18 +60,27 @@ func NewH264EncoderFFmpeg(options H264EncoderOptions) (*H264EncoderFFmpeg, error
 		options.Logger = logrus.New()
 	}
 
+	stream, err := NewStreamingEncoder(StreamingEncoderOptions{
+		Codec:       StreamCodecH264,
+		Width:       options.Width,
+		Height:      options.Height,
+		Bitrate:     options.Bitrate,
+		FrameRate:   options.FrameRate,
+		Preset:      options.Preset,
+		HWAccel:     options.HWAccel,
+		ProcessPool: options.ProcessPool,
+		Logger:      options.Logger,
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	encoder := &H264EncoderFFmpeg{
-		width:     options.Width,
-		height:    options.Height,
-		bitrate:   options.Bitrate,
-		frameRate: options.FrameRate,
-		preset:    options.Preset,
-		hwAccel:   options.HWAccel,
-		logger:    options.Logger,
-		converter: NewImageConverter(),
+		StreamingEncoder: stream,
+		hwAccel:          options.HWAccel,
 	}
 
-	encoder.logger.WithFields(logrus.Fields{
+	options.Logger.WithFields(logrus.Fields{
 		"width":     options.Width,
 		"height":    options.Height,
 		"bitrate":   options.Bitrate,
@@ -90,154 +92,71 @@ func NewH264EncoderFFmpeg(options H264EncoderOptions) (*H264EncoderFFmpeg, error
 	return encoder, nil
 }
 
-// Encode encodes a frame to H.264
-func (e *H264EncoderFFmpeg) Encode(frame *capture.Frame) ([]byte, error) {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-
-	// Build FFmpeg command based on hardware acceleration
-	cmd := e.buildFFmpegCommand(frame)
-
-	// Set input
-	cmd.Stdin = bytes.NewReader(frame.Data)
-
-	// Capture output
-	var stdout bytes.Buffer
-	cmd.Stdout = &stdout
-
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	// Execute encoding
-	if err := cmd.Run(); err != nil {
-		e.logger.WithError(err).WithField("stderr", stderr.String()).Error("FFmpeg encoding failed")
-		return nil, fmt.Errorf("h264 encoding failed: %w", err)
-	}
-
-	return stdout.Bytes(), nil
-}
-
-// buildFFmpegCommand builds the FFmpeg command based on hardware acceleration
-func (e *H264EncoderFFmpeg) buildFFmpegCommand(frame *capture.Frame) *exec.Cmd {
+// h264CodecArgs builds the FFmpeg encoder arguments based on hardware acceleration
+func h264CodecArgs(hwAccel H264EncoderType, preset string, bitrate, gop int) []string {
 	var args []string
 
-	// Input format detection
-	inputFormat := "image2pipe"
-	if frame.Format == capture.FrameFormatPNG {
-		inputFormat = "image2pipe"
-	} else if frame.Format == capture.FrameFormatJPEG {
-		inputFormat = "image2pipe"
-	}
-
-	// Common input arguments
-	args = append(args,
-		"-f", inputFormat,
-		"-i", "pipe:0",
-	)
-
-	// Hardware acceleration specific arguments
-	switch e.hwAccel {
+	switch hwAccel {
 	case H264EncoderNVENC:
 		// NVIDIA NVENC
 		args = append(args,
 			"-c:v", "h264_nvenc",
-			"-preset", e.preset,
-			"-b:v", fmt.Sprintf("%d", e.bitrate),
-			"-maxrate", fmt.Sprintf("%d", e.bitrate*2),
-			"-bufsize", fmt.Sprintf("%d", e.bitrate),
+			"-preset", preset,
+			"-b:v", fmt.Sprintf("%d", bitrate),
+			"-maxrate", fmt.Sprintf("%d", bitrate*2),
+			"-bufsize", fmt.Sprintf("%d", bitrate),
 			"-profile:v", "baseline",
 			"-level", "3.1",
+			"-bf", "0",
+			"-g", fmt.Sprintf("%d", gop),
 		)
 
 	case H264EncoderQSV:
 		// Intel QuickSync
 		args = append(args,
 			"-c:v", "h264_qsv",
-			"-preset", e.preset,
-			"-b:v", fmt.Sprintf("%d", e.bitrate),
-			"-maxrate", fmt.Sprintf("%d", e.bitrate*2),
-			"-bufsize", fmt.Sprintf("%d", e.bitrate),
+			"-preset", preset,
+			"-b:v", fmt.Sprintf("%d", bitrate),
+			"-maxrate", fmt.Sprintf("%d", bitrate*2),
+			"-bufsize", fmt.Sprintf("%d", bitrate),
 			"-profile:v", "baseline",
 			"-level", "3.1",
+			"-bf", "0",
+			"-g", fmt.Sprintf("%d", gop),
 		)
 
 	case H264EncoderVAAPI:
 		// VA-API (AMD/Intel)
 		args = append(args,
 			"-vaapi_device", "/dev/dri/renderD128",
+			"-vf", "format=nv12,hwupload",
 			"-c:v", "h264_vaapi",
-			"-b:v", fmt.Sprintf("%d", e.bitrate),
-			"-maxrate", fmt.Sprintf("%d", e.bitrate*2),
-			"-bufsize", fmt.Sprintf("%d", e.bitrate),
+			"-b:v", fmt.Sprintf("%d", bitrate),
+			"-maxrate", fmt.Sprintf("%d", bitrate*2),
+			"-bufsize", fmt.Sprintf("%d", bitrate),
 			"-profile:v", "578", // Baseline profile
+			"-bf", "0",
+			"-g", fmt.Sprintf("%d", gop),
 		)
 
 	default:
 		// Software fallback (libx264)
+		// repeat-headers: 每个 IDR 前重复 SPS/PPS，便于中途加入的观看端解码
 		args = append(args,
 			"-c:v", "libx264",
-			"-preset", e.preset,
+			"-preset", preset,
 			"-tune", "zerolatency",
-			"-b:v", fmt.Sprintf("%d", e.bitrate),
-			"-maxrate", fmt.Sprintf("%d", e.bitrate*2),
-			"-bufsize", fmt.Sprintf("%d", e.bitrate),
+			"-b:v", fmt.Sprintf("%d", bitrate),
+			"-maxrate", fmt.Sprintf("%d", bitrate*2),
+			"-bufsize", fmt.Sprintf("%d", bitrate),
 			"-profile:v", "baseline",
 			"-level", "3.1",
-			"-x264-params", "keyint=60:min-keyint=60:scenecut=0",
+			"-pix_fmt", "yuv420p",
+			"-x264-params", fmt.Sprintf("keyint=%d:min-keyint=%d:scenecut=0:repeat-headers=1", gop, gop),
 		)
 	}
 
-	// Common output arguments
-	args = append(args,
-		"-r", fmt.Sprintf("%d", e.frameRate),
-		"-pix_fmt", "yuv420p",
-		"-frames:v", "1",
-		"-f", "h264",
-		"pipe:1",
-	)
-
-	return exec.Command("ffmpeg", args...)
-}
-
-// Close releases encoder resources
-func (e *H264EncoderFFmpeg) Close() error {
-	e.logger.Info("H.264 encoder closed")
-	return nil
-}
-
-// SetBitrate dynamically adjusts the bitrate
-func (e *H264EncoderFFmpeg) SetBitrate(bitrate int) error {
-	if bitrate <= 0 || bitrate > 50000000 {
-		return fmt.Errorf("invalid bitrate: %d", bitrate)
-	}
-
-	e.mu.Lock()
-	e.bitrate = bitrate
-	e.mu.Unlock()
-
-	e.logger.WithField("new_bitrate", bitrate).Info("H.264 bitrate adjusted")
-	return nil
-}
-
-// GetBitrate returns the current bitrate
-func (e *H264EncoderFFmpeg) GetBitrate() int {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-	return e.bitrate
-}
-
-// SetFrameRate dynamically adjusts the frame rate
-func (e *H264EncoderFFmpeg) SetFrameRate(fps int) error {
-	if fps <= 0 || fps > 60 {
-		return fmt.Errorf("invalid frame rate: %d (must be 1-60)", fps)
-	}
-
-	e.mu.Lock()
-	e.frameRate = fps
-	e.mu.Unlock()
-
-	e.logger.WithField("new_framerate", fps).Info("H.264 frame rate adjusted")
-	return nil
+	return args
 }
 
 // detectHardwareEncoder detects available hardware encoders
@@ -296,14 +215,10 @@ func detectHardwareEncoder() H264EncoderType {
 
 // IsHardwareAccelerated returns true if using hardware acceleration
 func (e *H264EncoderFFmpeg) IsHardwareAccelerated() bool {
-	e.mu.Lock()
-	defer e.mu.Unlock()
 	return e.hwAccel != H264EncoderX264
 }
 
 // GetHardwareType returns the hardware acceleration type
 func (e *H264EncoderFFmpeg) GetHardwareType() H264EncoderType {
-	e.mu.Lock()
-	defer e.mu.Unlock()
 	return e.hwAccel
 }
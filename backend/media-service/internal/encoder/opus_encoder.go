@@ -10,11 +10,29 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// defaultOpusPacketLoss is the expected packet loss (percent) the encoder is tuned for
+// libopus only adds in-band FEC (LBRR) when it expects loss, matching the
+// useinbandfec=1 advertised in SDP. Measured loss above it raises the redundancy.
+const defaultOpusPacketLoss = 5
+
+// opusPacketLoss clamps an expected packet loss to the range libopus accepts,
+// never going below defaultOpusPacketLoss so FEC stays on
+func opusPacketLoss(percent int) int {
+	if percent < defaultOpusPacketLoss {
+		return defaultOpusPacketLoss
+	}
+	if percent > 100 {
+		return 100
+	}
+	return percent
+}
+
 // OpusEncoderFFmpeg implements Opus audio encoding using FFmpeg
 type OpusEncoderFFmpeg struct {
 	sampleRate int
 	channels   int
 	bitrate    int
+	packetLoss int // expected packet loss in percent, drives in-band FEC
 	logger     *logrus.Logger
 	mu         sync.Mutex
 }
@@ -24,6 +42,7 @@ type OpusEncoderOptions struct {
 	SampleRate int // Sample rate in Hz (default 48000)
 	Channels   int // Number of channels (1=mono, 2=stereo)
 	Bitrate    int // Bitrate in bps (default 64000)
+	PacketLoss int // Expected packet loss in percent for in-band FEC (default 5)
 	Logger     *logrus.Logger
 }
 
@@ -38,6 +57,7 @@ func NewOpusEncoderFFmpeg(options OpusEncoderOptions) (AudioEncoder, error) {
 	if options.Bitrate <= 0 {
 		options.Bitrate = 64000 // Default 64 kbps
 	}
+	options.PacketLoss = opusPacketLoss(options.PacketLoss)
 	if options.Logger == nil {
 		options.Logger = logrus.New()
 	}
@@ -46,6 +66,7 @@ func NewOpusEncoderFFmpeg(options OpusEncoderOptions) (AudioEncoder, error) {
 		sampleRate: options.SampleRate,
 		channels:   options.Channels,
 		bitrate:    options.Bitrate,
+		packetLoss: options.PacketLoss,
 		logger:     options.Logger,
 	}
 
@@ -53,6 +74,7 @@ func NewOpusEncoderFFmpeg(options OpusEncoderOptions) (AudioEncoder, error) {
 		"sample_rate": encoder.sampleRate,
 		"channels":    encoder.channels,
 		"bitrate":     encoder.bitrate,
+		"packet_loss": encoder.packetLoss,
 	}).Info("Opus encoder initialized")
 
 	return encoder, nil
@@ -75,6 +97,8 @@ func (e *OpusEncoderFFmpeg) EncodeAudio(frame *capture.AudioFrame) ([]byte, erro
 		"-compression_level", "10", // Max compression
 		"-frame_duration", "20", // 20ms frames
 		"-application", "voip", // Optimize for voice/real-time
+		"-fec", "1", // In-band FEC (useinbandfec=1 in SDP)
+		"-packet_loss", fmt.Sprintf("%d", e.packetLoss), // Expected loss, FEC is only added above 0
 		"-f", "opus",
 		"pipe:1",
 	)
@@ -103,6 +127,15 @@ func (e *OpusEncoderFFmpeg) SetBitrate(bitrate int) error {
 	return nil
 }
 
+// SetPacketLoss adjusts the expected packet loss that drives in-band FEC
+func (e *OpusEncoderFFmpeg) SetPacketLoss(percent int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.packetLoss = opusPacketLoss(percent)
+	return nil
+}
+
 // Close releases encoder resources
 func (e *OpusEncoderFFmpeg) Close() error {
 	e.logger.Info("Opus encoder closed")
@@ -114,6 +147,7 @@ type StreamingOpusEncoder struct {
 	sampleRate int
 	channels   int
 	bitrate    int
+	packetLoss int
 	cmd        *exec.Cmd
 	stdin      *bytes.Buffer
 	running    bool
@@ -131,6 +165,7 @@ func NewStreamingOpusEncoder(sampleRate, channels, bitrate int, logger *logrus.L
 		sampleRate: sampleRate,
 		channels:   channels,
 		bitrate:    bitrate,
+		packetLoss: defaultOpusPacketLoss,
 		logger:     logger,
 	}
 }
@@ -140,12 +175,15 @@ func (e *StreamingOpusEncoder) EncodeAudio(frame *capture.AudioFrame) ([]byte, e
 	// For now, delegate to one-shot encoder
 	// A full streaming implementation would maintain the FFmpeg process
 	// and use pipes for continuous encoding
+	e.mu.Lock()
 	oneShot := &OpusEncoderFFmpeg{
 		sampleRate: e.sampleRate,
 		channels:   e.channels,
 		bitrate:    e.bitrate,
+		packetLoss: e.packetLoss,
 		logger:     e.logger,
 	}
+	e.mu.Unlock()
 
 	return oneShot.EncodeAudio(frame)
 }
@@ -158,6 +196,14 @@ func (e *StreamingOpusEncoder) SetBitrate(bitrate int) error {
 	return nil
 }
 
+// SetPacketLoss updates the expected packet loss
+func (e *StreamingOpusEncoder) SetPacketLoss(percent int) error {
+	e.mu.Lock()
+	e.packetLoss = opusPacketLoss(percent)
+	e.mu.Unlock()
+	return nil
+}
+
 // Close stops the encoder
 func (e *StreamingOpusEncoder) Close() error {
 	e.mu.Lock()
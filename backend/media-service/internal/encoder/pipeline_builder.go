@@ -0,0 +1,340 @@
+package encoder
+
+import (
+	"fmt"
+
+	"github.com/cloudphone/media-service/internal/capture"
+	"github.com/sirupsen/logrus"
+)
+
+// 视频源默认参数（规格未指定时使用）
+const (
+	defaultTargetWidth = 720 // WiFi ADB 优化: 降低到 720 宽度可将帧率提升 2-3 倍
+)
+
+// PipelineBuilder turns a declarative PipelineSpec into capture, encoder and writer.
+//
+// 规格解析顺序: 全局配置（CAPTURE_MODE / VIDEO_ENCODER_TYPE）< 租户覆盖 < 设备覆盖。
+// 解析后的规格通过 Plan 展开为按优先级排列的视频源（回退链），
+// 再由 Build 逐个实例化为屏幕捕获和编码器（编码器通过 EncoderFactory 创建）。
+type PipelineBuilder struct {
+	base             PipelineSpec
+	overrides        PipelineOverrides
+	factory          *EncoderFactory
+	processPool      *EncoderProcessPool
+	adbPath          string
+	scrcpyServerPath string
+	h264Fallback     bool
+	logger           *logrus.Logger
+}
+
+// PipelineBuilderOption 配置选项
+type PipelineBuilderOption func(*PipelineBuilder)
+
+// WithBaseSpec 设置全局默认规格（通常来自配置）
+func WithBaseSpec(spec PipelineSpec) PipelineBuilderOption {
+	return func(b *PipelineBuilder) {
+		b.base = spec
+	}
+}
+
+// WithPipelineOverrides 设置租户/设备级覆盖规则
+func WithPipelineOverrides(overrides *PipelineOverrides) PipelineBuilderOption {
+	return func(b *PipelineBuilder) {
+		if overrides != nil {
+			b.overrides = *overrides
+		}
+	}
+}
+
+// WithBuilderScrcpyServer 设置 scrcpy-server 路径（为空时跳过 scrcpy 视频源）
+func WithBuilderScrcpyServer(path string) PipelineBuilderOption {
+	return func(b *PipelineBuilder) {
+		b.scrcpyServerPath = path
+	}
+}
+
+// WithBuilderH264Fallback 设置是否启用 H.264 回退链
+// 启用后视频源失败时依次回退: scrcpy → screenrecord → screencap + 服务端 H.264 编码
+func WithBuilderH264Fallback(enabled bool) PipelineBuilderOption {
+	return func(b *PipelineBuilder) {
+		b.h264Fallback = enabled
+	}
+}
+
+// WithBuilderEncoderFactory 设置编码器工厂
+func WithBuilderEncoderFactory(factory *EncoderFactory) PipelineBuilderOption {
+	return func(b *PipelineBuilder) {
+		if factory != nil {
+			b.factory = factory
+		}
+	}
+}
+
+// WithBuilderProcessPool 设置服务端编码器使用的进程池
+func WithBuilderProcessPool(pool *EncoderProcessPool) PipelineBuilderOption {
+	return func(b *PipelineBuilder) {
+		b.processPool = pool
+	}
+}
+
+// NewPipelineBuilder creates a pipeline builder
+func NewPipelineBuilder(adbPath string, logger *logrus.Logger, opts ...PipelineBuilderOption) *PipelineBuilder {
+	if logger == nil {
+		logger = logrus.New()
+	}
+
+	b := &PipelineBuilder{
+		base: PipelineSpec{
+			CaptureMode: CaptureModeAuto,
+			EncoderType: EncoderTypeAuto,
+		},
+		adbPath: adbPath,
+		logger:  logger,
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	if b.factory == nil {
+		b.factory = NewEncoderFactory(logger)
+	}
+
+	return b
+}
+
+// Resolve 解析租户和设备对应的规格
+func (b *PipelineBuilder) Resolve(tenantID, deviceID string) PipelineSpec {
+	spec := b.base
+	if tenantID != "" {
+		if override, ok := b.overrides.Tenants[tenantID]; ok {
+			spec = spec.Merge(override)
+		}
+	}
+	if deviceID != "" {
+		if override, ok := b.overrides.Devices[deviceID]; ok {
+			spec = spec.Merge(override)
+		}
+	}
+	return spec
+}
+
+// PipelinePlan 规格展开后的视频源回退链
+type PipelinePlan struct {
+	Codec StreamCodec    // WebRTC 轨道编码，回退链中每一环的输出都与之一致
+	Steps []PipelineSpec // 按优先级排列，每一环的字段均已补全
+}
+
+// CaptureModes 返回回退链中的采集模式（用于日志）
+func (p *PipelinePlan) CaptureModes() []string {
+	modes := make([]string, len(p.Steps))
+	for i, step := range p.Steps {
+		modes[i] = string(step.CaptureMode)
+	}
+	return modes
+}
+
+// MaxBitrate 返回回退链中的最高码率 (bps)
+func (p *PipelinePlan) MaxBitrate() int {
+	var bitrate int
+	for _, step := range p.Steps {
+		if step.Bitrate > bitrate {
+			bitrate = step.Bitrate
+		}
+	}
+	return bitrate
+}
+
+// Plan 将规格展开为回退链
+// codec 为空时自动选择轨道编码：回退链包含设备端 H.264 视频源时使用 H.264，
+// 否则根据编码器类型选择；codec 非空时（如客户端指定）只保留能输出该编码的视频源。
+func (b *PipelineBuilder) Plan(spec PipelineSpec, codec StreamCodec) *PipelinePlan {
+	modes := b.captureChain(spec.CaptureMode)
+
+	if codec == "" {
+		codec = StreamCodecVP8
+		if spec.EncoderType == EncoderTypeH264 {
+			codec = StreamCodecH264
+		}
+		for _, mode := range modes {
+			if mode.IsDeviceEncoded() {
+				codec = StreamCodecH264
+				break
+			}
+		}
+	}
+
+	plan := &PipelinePlan{Codec: codec}
+	for _, mode := range modes {
+		// 设备端 H.264 只能配合 H.264 轨道使用
+		if mode.IsDeviceEncoded() && codec != StreamCodecH264 {
+			continue
+		}
+		plan.Steps = append(plan.Steps, b.stepForMode(spec, mode, codec))
+	}
+
+	// 没有可用视频源时至少保留 screencap（服务端编码可输出任意轨道编码）
+	if len(plan.Steps) == 0 {
+		plan.Steps = append(plan.Steps, b.stepForMode(spec, CaptureModeScreencap, codec))
+	}
+
+	return plan
+}
+
+// captureChain 返回从指定采集模式开始的回退链
+func (b *PipelineBuilder) captureChain(mode CaptureMode) []CaptureMode {
+	var chain []CaptureMode
+
+	switch mode {
+	case CaptureModeScreencap:
+		return []CaptureMode{CaptureModeScreencap}
+	case CaptureModeScreenrecord:
+		chain = append(chain, CaptureModeScreenrecord)
+		if b.h264Fallback {
+			chain = append(chain, CaptureModeScreencap)
+		}
+		return chain
+	case CaptureModeScrcpy:
+		if b.scrcpyServerPath != "" {
+			chain = append(chain, CaptureModeScrcpy)
+		} else {
+			b.logger.Warn("scrcpy capture mode requested but scrcpy-server is not configured")
+		}
+	default: // auto
+		if b.scrcpyServerPath != "" {
+			chain = append(chain, CaptureModeScrcpy)
+		}
+	}
+
+	if b.h264Fallback {
+		return append(chain, CaptureModeScreenrecord, CaptureModeScreencap)
+	}
+	if len(chain) == 0 {
+		chain = append(chain, CaptureModeScreencap)
+	}
+	return chain
+}
+
+// stepForMode 补全单个视频源的规格
+func (b *PipelineBuilder) stepForMode(spec PipelineSpec, mode CaptureMode, codec StreamCodec) PipelineSpec {
+	step := spec
+	step.CaptureMode = mode
+
+	// 视频源默认参数
+	var fps, bitrate int
+	switch mode {
+	case CaptureModeScrcpy:
+		// scrcpy 可以轻松达到 30 FPS，4 Mbps 适合 WiFi 传输
+		fps, bitrate = 30, 4000000
+	case CaptureModeScreenrecord:
+		// screenrecord 码率在设备端固定为 2 Mbps
+		fps, bitrate = 30, 2000000
+	default:
+		// screencap 模式需要更保守的参数
+		fps, bitrate = 15, 2000000
+	}
+	if step.FPS == 0 {
+		step.FPS = fps
+	}
+	if step.Bitrate == 0 {
+		step.Bitrate = bitrate
+	}
+	if step.Width == 0 {
+		step.Width = defaultTargetWidth
+	}
+
+	// 编码器必须与视频源和轨道编码匹配
+	if mode.IsDeviceEncoded() {
+		step.EncoderType = EncoderTypePassThrough
+		return step
+	}
+
+	switch {
+	case codec == StreamCodecH264:
+		step.EncoderType = EncoderTypeH264
+	case step.EncoderType == EncoderTypeVP8Simple:
+		// 显式指定的 VP8 编码器变体保持不变
+	default:
+		step.EncoderType = EncoderTypeVP8
+	}
+
+	// passthrough 只约束设备端 H.264 视频源，对 screencap 等同于 auto
+	switch spec.EncoderType {
+	case "", EncoderTypeAuto, EncoderTypePassThrough, step.EncoderType:
+	default:
+		b.logger.WithFields(logrus.Fields{
+			"requested": spec.EncoderType,
+			"selected":  step.EncoderType,
+			"capture":   mode,
+			"codec":     codec,
+		}).Warn("Configured encoder type does not match track codec, overriding")
+	}
+
+	return step
+}
+
+// BuiltPipeline 由规格实例化的管道组件
+type BuiltPipeline struct {
+	Spec        PipelineSpec
+	Capture     capture.ScreenCapture
+	Encoder     VideoEncoder
+	EncoderName string
+	Writer      FrameWriter
+}
+
+// Options 返回创建管道时使用的选项
+func (p *BuiltPipeline) Options() CreateVideoPipelineOptions {
+	return CreateVideoPipelineOptions{
+		UseH264Passthrough: p.Spec.EncoderType == EncoderTypePassThrough,
+		Encoder:            p.Encoder,
+		EncoderName:        p.EncoderName,
+	}
+}
+
+// Build 实例化回退链中的一环
+// 编码器由调用方（PipelineManager）接管，创建管道失败时由其负责关闭
+func (b *PipelineBuilder) Build(step PipelineSpec, writer FrameWriter) (*BuiltPipeline, error) {
+	if err := step.Validate(); err != nil {
+		return nil, err
+	}
+	if writer == nil {
+		return nil, fmt.Errorf("frame writer is required")
+	}
+
+	var screenCapture capture.ScreenCapture
+	switch step.CaptureMode {
+	case CaptureModeScrcpy:
+		screenCapture = capture.NewScrcpyCapture(b.adbPath, b.scrcpyServerPath, b.logger)
+	case CaptureModeScreenrecord:
+		screenCapture = capture.NewAndroidScreenRecordCapture(b.adbPath, b.logger)
+	case CaptureModeScreencap:
+		screenCapture = capture.NewAndroidScreenCapture(b.adbPath, b.logger)
+	default:
+		return nil, fmt.Errorf("capture mode must be resolved before build: %s", step.CaptureMode)
+	}
+
+	if !step.CaptureMode.IsDeviceEncoded() && step.EncoderType == EncoderTypePassThrough {
+		return nil, fmt.Errorf("capture mode %s requires a host encoder", step.CaptureMode)
+	}
+
+	// 尺寸为 0：编码器跟随采集帧尺寸（管道已按 Width/Height 缩放）
+	videoEncoder, err := b.factory.CreateVideoEncoder(VideoEncoderConfig{
+		Type:        step.EncoderType,
+		Bitrate:     step.Bitrate,
+		FrameRate:   step.FPS,
+		ProcessPool: b.processPool,
+		Logger:      b.logger,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s encoder: %w", step.EncoderType, err)
+	}
+
+	return &BuiltPipeline{
+		Spec:        step,
+		Capture:     screenCapture,
+		Encoder:     videoEncoder,
+		EncoderName: string(step.EncoderType),
+		Writer:      writer,
+	}, nil
+}
@@ -0,0 +1,125 @@
+package encoder
+
+import (
+	"time"
+
+	"github.com/cloudphone/media-service/internal/adaptive"
+	"github.com/cloudphone/media-service/internal/capture"
+)
+
+// PipelineEventType 管道事件类型
+type PipelineEventType string
+
+const (
+	// PipelineEventCaptureState 采集帧尺寸首次确定或发生变化（设备旋转、分辨率切换）
+	PipelineEventCaptureState PipelineEventType = "capture_state"
+	// PipelineEventQualityChanged 自适应质量控制器切换了质量级别
+	PipelineEventQualityChanged PipelineEventType = "quality_changed"
+	// PipelineEventDevice 采集报告的设备事件（断开、重连、剪贴板）
+	PipelineEventDevice PipelineEventType = "device"
+)
+
+// PipelineEvent 视频管道事件
+type PipelineEvent struct {
+	Type      PipelineEventType
+	SessionID string
+	DeviceID  string
+	Timestamp time.Time
+
+	// capture_state
+	Width    int
+	Height   int
+	Rotation int // 相对管道启动时方向的旋转角度：0 或 90（H.264 流只能反映横竖屏变化）
+
+	// quality_changed
+	PreviousQuality *adaptive.QualitySettings
+	Quality         *adaptive.QualitySettings
+
+	// device
+	Device *capture.DeviceEvent
+}
+
+// PipelineEventHandler 管道事件回调（在管道或采集的协程中调用，不能阻塞）
+type PipelineEventHandler func(event PipelineEvent)
+
+// OnPipelineEvent 注册视频管道事件监听器（对之后创建的管道生效）
+func (pm *PipelineManager) OnPipelineEvent(handler PipelineEventHandler) {
+	pm.handlersMu.Lock()
+	defer pm.handlersMu.Unlock()
+	pm.eventHandlers = append(pm.eventHandlers, handler)
+}
+
+// notifyPipelineEvent 通知所有管道事件监听器
+func (pm *PipelineManager) notifyPipelineEvent(event PipelineEvent) {
+	pm.handlersMu.RLock()
+	handlers := make([]PipelineEventHandler, len(pm.eventHandlers))
+	copy(handlers, pm.eventHandlers)
+	pm.handlersMu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// setupEvents 把采集的设备事件和质量控制器的级别变化转发为管道事件
+func (p *VideoPipeline) setupEvents() {
+	if p.eventHandler == nil {
+		return
+	}
+
+	if emitter, ok := p.capture.(capture.DeviceEventEmitter); ok {
+		emitter.SetDeviceEventHandler(func(event capture.DeviceEvent) {
+			p.emitEvent(PipelineEvent{Type: PipelineEventDevice, Device: &event})
+		})
+	}
+
+	if p.qualityController != nil {
+		p.qualityController.SetQualityChangeHandler(func(previous, current adaptive.QualitySettings) {
+			p.emitEvent(PipelineEvent{
+				Type:            PipelineEventQualityChanged,
+				PreviousQuality: &previous,
+				Quality:         &current,
+			})
+		})
+	}
+}
+
+// emitEvent 填充会话信息后回调管道事件
+func (p *VideoPipeline) emitEvent(event PipelineEvent) {
+	if p.eventHandler == nil {
+		return
+	}
+	event.SessionID = p.sessionID
+	event.DeviceID = p.deviceID
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	p.eventHandler(event)
+}
+
+// frameGeometry 跟踪采集帧尺寸，首帧和尺寸变化时产生 capture_state 事件
+// 只在 processingLoop 协程中使用
+type frameGeometry struct {
+	width, height int
+	landscape     bool // 首帧方向
+}
+
+// observe 记录帧尺寸，返回是否发生变化
+func (g *frameGeometry) observe(width, height int) bool {
+	if width <= 0 || height <= 0 || (width == g.width && height == g.height) {
+		return false
+	}
+	if g.width == 0 {
+		g.landscape = width > height
+	}
+	g.width, g.height = width, height
+	return true
+}
+
+// rotation 相对首帧方向的旋转角度
+func (g *frameGeometry) rotation() int {
+	if (g.width > g.height) != g.landscape {
+		return 90
+	}
+	return 0
+}
@@ -6,6 +6,7 @@ import (
 	"hash/fnv"
 	"sync"
 
+	"github.com/cloudphone/media-service/internal/adaptive"
 	"github.com/cloudphone/media-service/internal/capture"
 	"github.com/sirupsen/logrus"
 )
@@ -24,9 +25,15 @@ type pipelineShard struct {
 //   - numShards=1: 单锁模式，适合小规模部署
 //   - numShards=16: 分片模式，适合高并发场景（默认）
 type PipelineManager struct {
-	shards    []pipelineShard
-	numShards uint32
-	logger    *logrus.Logger
+	shards      []pipelineShard
+	numShards   uint32
+	encoderPool *EncoderProcessPool // 单机编码进程上限
+	workerPool  *WorkerPoolOptions  // 原始帧并行编码配置（nil = 串行编码）
+	logger      *logrus.Logger
+
+	// 管道事件监听器（采集状态、质量变化、设备事件）
+	handlersMu    sync.RWMutex
+	eventHandlers []PipelineEventHandler
 }
 
 // PipelineManagerOption 配置选项
@@ -42,6 +49,31 @@ func WithNumShards(n int) PipelineManagerOption {
 	}
 }
 
+// WithEncoderProcessPool 设置编码进程池（限制单机 FFmpeg 进程数）
+func WithEncoderProcessPool(pool *EncoderProcessPool) PipelineManagerOption {
+	return func(pm *PipelineManager) {
+		if pool != nil {
+			pm.encoderPool = pool
+		}
+	}
+}
+
+// WithEncoderWorkers 为原始帧（screencap 等）会话启用并行编码工作池
+// minWorkers 为初始及最少工作协程数，maxWorkers 为按队列深度扩容的上限；minWorkers <= 0 时不启用
+func WithEncoderWorkers(minWorkers, maxWorkers int) PipelineManagerOption {
+	return func(pm *PipelineManager) {
+		if minWorkers <= 0 {
+			pm.workerPool = nil
+			return
+		}
+		pm.workerPool = &WorkerPoolOptions{
+			Workers:    minWorkers,
+			MinWorkers: minWorkers,
+			MaxWorkers: maxWorkers,
+		}
+	}
+}
+
 // NewPipelineManager creates a new pipeline manager
 func NewPipelineManager(logger *logrus.Logger, opts ...PipelineManagerOption) *PipelineManager {
 	if logger == nil {
@@ -49,8 +81,9 @@ func NewPipelineManager(logger *logrus.Logger, opts ...PipelineManagerOption) *P
 	}
 
 	pm := &PipelineManager{
-		numShards: defaultNumShards,
-		logger:    logger,
+		numShards:   defaultNumShards,
+		encoderPool: DefaultEncoderProcessPool(),
+		logger:      logger,
 	}
 
 	// 应用配置选项
@@ -65,7 +98,11 @@ func NewPipelineManager(logger *logrus.Logger, opts ...PipelineManagerOption) *P
 		pm.shards[i].audioPipelines = make(map[string]*AudioPipeline)
 	}
 
-	logger.WithField("num_shards", pm.numShards).Info("Pipeline manager initialized with sharded locks")
+	fields := logrus.Fields{"num_shards": pm.numShards}
+	if pm.workerPool != nil {
+		fields["encoder_workers"] = fmt.Sprintf("%d-%d", pm.workerPool.MinWorkers, pm.workerPool.MaxWorkers)
+	}
+	logger.WithFields(fields).Info("Pipeline manager initialized with sharded locks")
 
 	return pm
 }
@@ -87,7 +124,31 @@ func (pm *PipelineManager) getShardIndex(sessionID string) uint32 {
 
 // CreateVideoPipelineOptions contains options for creating a video pipeline
 type CreateVideoPipelineOptions struct {
-	UseH264Passthrough bool // If true, use PassThroughEncoder for pre-encoded H.264 (e.g., from scrcpy)
+	UseH264Passthrough bool         // If true, use PassThroughEncoder for pre-encoded H.264 (e.g., from scrcpy)
+	HostEncoderCodec   StreamCodec  // Codec for host-side encoding of raw frames (default VP8)
+	Encoder            VideoEncoder // Pre-built encoder (e.g., from PipelineBuilder); the manager takes ownership
+	EncoderName        string       // Encoder name for logging when Encoder is set
+}
+
+// processReserver 常驻进程编码器在创建管道时预占进程槽位
+type processReserver interface {
+	Reserve() error
+}
+
+// CreateBuiltVideoPipeline creates and starts a video pipeline from PipelineBuilder output
+func (pm *PipelineManager) CreateBuiltVideoPipeline(ctx context.Context, sessionID, deviceID string, built *BuiltPipeline) error {
+	return pm.CreateVideoPipeline(
+		ctx,
+		sessionID,
+		deviceID,
+		built.Capture,
+		built.Writer,
+		built.Spec.FPS,
+		built.Spec.Bitrate,
+		built.Spec.Width,
+		built.Spec.Height,
+		built.Options(),
+	)
 }
 
 // CreateVideoPipeline creates and starts a video pipeline for a session
@@ -104,6 +165,12 @@ func (pm *PipelineManager) CreateVideoPipeline(
 	targetHeight int,
 	opts ...CreateVideoPipelineOptions,
 ) error {
+	// Parse options
+	var pipelineOpts CreateVideoPipelineOptions
+	if len(opts) > 0 {
+		pipelineOpts = opts[0]
+	}
+
 	// 获取对应分片
 	shard := pm.getShard(sessionID)
 
@@ -112,30 +179,75 @@ func (pm *PipelineManager) CreateVideoPipeline(
 
 	// Check if pipeline already exists
 	if _, exists := shard.videoPipelines[sessionID]; exists {
+		if pipelineOpts.Encoder != nil {
+			pipelineOpts.Encoder.Close()
+		}
 		return fmt.Errorf("video pipeline already exists for session %s", sessionID)
 	}
 
-	// Parse options
-	var pipelineOpts CreateVideoPipelineOptions
-	if len(opts) > 0 {
-		pipelineOpts = opts[0]
-	}
-
 	// Select encoder based on capture mode
+	// - pre-built encoder (PipelineBuilder / EncoderFactory) → use as is
 	// - scrcpy outputs pre-encoded H.264 NAL units → use PassThroughEncoder (zero-copy)
-	// - screencap outputs raw PNG frames → use VP8FrameEncoder (decode + encode)
+	// - screencap outputs raw PNG frames → use StreamingEncoder (one long-lived FFmpeg per session)
 	var encoder VideoEncoder
 	var encoderName string
 
-	if pipelineOpts.UseH264Passthrough {
+	switch {
+	case pipelineOpts.Encoder != nil:
+		encoder = pipelineOpts.Encoder
+		encoderName = pipelineOpts.EncoderName
+		if encoderName == "" {
+			encoderName = fmt.Sprintf("%T", encoder)
+		}
+		// 创建会话时即占用进程槽位，达到单机上限时直接拒绝
+		if reserver, ok := encoder.(processReserver); ok {
+			if err := reserver.Reserve(); err != nil {
+				encoder.Close()
+				return fmt.Errorf("failed to reserve encoder process: %w", err)
+			}
+		}
+	case pipelineOpts.UseH264Passthrough:
 		// scrcpy mode: H.264 直通，无需二次编码
 		// 性能提升: 避免 H.264 → 解码 → VP8 编码的开销
 		encoder = NewPassThroughEncoder()
 		encoderName = "PassThroughEncoder (H.264)"
-	} else {
-		// screencap mode: PNG → VP8 编码
-		encoder = NewVP8FrameEncoder(targetBitrate, targetFPS, pm.logger)
-		encoderName = "VP8FrameEncoder"
+	default:
+		// screencap mode: PNG → VP8/H.264 编码（常驻 FFmpeg 进程）
+		// 输出尺寸跟随采集帧（采集端已按 targetWidth/targetHeight 缩放）
+		codec := pipelineOpts.HostEncoderCodec
+		if codec == "" {
+			codec = StreamCodecVP8
+		}
+		stream, err := NewStreamingEncoder(StreamingEncoderOptions{
+			Codec:       codec,
+			Bitrate:     targetBitrate,
+			FrameRate:   targetFPS,
+			ProcessPool: pm.encoderPool,
+			Logger:      pm.logger,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create streaming encoder: %w", err)
+		}
+		// 创建会话时即占用进程槽位，达到单机上限时直接拒绝
+		if err := stream.Reserve(); err != nil {
+			stream.Close()
+			return fmt.Errorf("failed to reserve encoder process: %w", err)
+		}
+		encoder = stream
+		encoderName = fmt.Sprintf("StreamingEncoder (%s)", codec)
+	}
+
+	_, passthrough := encoder.(*PassThroughEncoder)
+
+	// 直通模式没有服务端编码器，只有采集支持动态码率（scrcpy 控制通道）时才启用自适应质量
+	_, adaptiveCapture := screenCapture.(capture.AdaptiveBitrateCapture)
+	adaptiveMode := !passthrough || adaptiveCapture
+
+	// 原始帧会话使用工作池并行预处理/编码；设备端 H.264 直通无需编码
+	var workerPool *WorkerPoolOptions
+	if pm.workerPool != nil && !passthrough {
+		poolOptions := *pm.workerPool
+		workerPool = &poolOptions
 	}
 
 	pm.logger.WithFields(logrus.Fields{
@@ -146,7 +258,8 @@ func (pm *PipelineManager) CreateVideoPipeline(
 		"target_width":     targetWidth,
 		"target_height":    targetHeight,
 		"encoder":          encoderName,
-		"h264_passthrough": pipelineOpts.UseH264Passthrough,
+		"h264_passthrough": passthrough,
+		"worker_pool":      workerPool != nil,
 		"shard":            fmt.Sprintf("%d/%d", pm.getShardIndex(sessionID), pm.numShards),
 	}).Info("Creating video pipeline")
 
@@ -155,21 +268,25 @@ func (pm *PipelineManager) CreateVideoPipeline(
 		SessionID:     sessionID,
 		DeviceID:      deviceID,
 		Capture:       screenCapture,
-		Encoder:       encoder, // VP8FrameEncoder for screencap, PassThroughEncoder for scrcpy H.264
+		Encoder:       encoder, // StreamingEncoder for screencap, PassThroughEncoder for scrcpy H.264
 		FrameWriter:   frameWriter,
 		TargetFPS:     targetFPS,
 		TargetBitrate: targetBitrate,
-		AdaptiveMode:  !pipelineOpts.UseH264Passthrough, // Disable adaptive mode for H.264 passthrough
+		AdaptiveMode:  adaptiveMode, // RTCP 反馈驱动码率（直通模式需采集支持动态码率）
 		Logger:        pm.logger,
 		TargetWidth:   targetWidth,  // WiFi ADB optimization
 		TargetHeight:  targetHeight,
+		WorkerPool:    workerPool,
+		EventHandler:  pm.notifyPipelineEvent,
 	})
 	if err != nil {
+		encoder.Close()
 		return fmt.Errorf("failed to create video pipeline: %w", err)
 	}
 
 	// Start pipeline
 	if err := pipeline.Start(ctx); err != nil {
+		encoder.Close()
 		return fmt.Errorf("failed to start video pipeline: %w", err)
 	}
 
@@ -202,21 +319,32 @@ func (pm *PipelineManager) CreateAudioPipeline(
 		return fmt.Errorf("audio pipeline already exists for session %s", sessionID)
 	}
 
+	// Opus with in-band FEC, matching the useinbandfec=1 advertised in SDP;
+	// the expected packet loss follows UpdateNetworkQuality
+	audioEncoder, err := NewOpusEncoderFFmpeg(OpusEncoderOptions{
+		Logger: pm.logger,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create audio encoder: %w", err)
+	}
+
 	// Create pipeline
 	pipeline, err := NewAudioPipeline(AudioPipelineOptions{
 		SessionID:   sessionID,
 		DeviceID:    deviceID,
 		Capture:     audioCapture,
-		Encoder:     NewPassThroughAudioEncoder(), // Use pass-through for now
+		Encoder:     audioEncoder,
 		FrameWriter: frameWriter,
 		Logger:      pm.logger,
 	})
 	if err != nil {
+		audioEncoder.Close()
 		return fmt.Errorf("failed to create audio pipeline: %w", err)
 	}
 
 	// Start pipeline
 	if err := pipeline.Start(ctx); err != nil {
+		audioEncoder.Close()
 		return fmt.Errorf("failed to start audio pipeline: %w", err)
 	}
 
@@ -494,6 +622,11 @@ func (pm *PipelineManager) GetShardStats() []ShardStats {
 	return stats
 }
 
+// GetEncoderProcessStats 返回编码进程池统计信息
+func (pm *PipelineManager) GetEncoderProcessStats() EncoderProcessPoolStats {
+	return pm.encoderPool.Stats()
+}
+
 // ShardStats 分片统计信息
 type ShardStats struct {
 	ShardIndex     uint32 `json:"shard_index"`
@@ -517,6 +650,43 @@ func (pm *PipelineManager) RequestKeyframe(sessionID string) error {
 	return pipeline.RequestKeyframe()
 }
 
+// UpdateNetworkQuality feeds network quality measured from RTCP feedback to a session's pipelines
+// This is called by the session's RTCPCollector once per collection interval; the audio
+// pipeline only takes the packet loss, which sets the Opus in-band FEC redundancy
+func (pm *PipelineManager) UpdateNetworkQuality(sessionID string, quality adaptive.NetworkQuality) error {
+	shard := pm.getShard(sessionID)
+
+	shard.mu.RLock()
+	pipeline, exists := shard.videoPipelines[sessionID]
+	audioPipeline, audioExists := shard.audioPipelines[sessionID]
+	shard.mu.RUnlock()
+
+	if audioExists {
+		audioPipeline.UpdatePacketLoss(quality.PacketLoss)
+	}
+	if !exists {
+		if audioExists {
+			return nil
+		}
+		return fmt.Errorf("video pipeline not found for session %s", sessionID)
+	}
+
+	pipeline.UpdateNetworkQuality(quality.RTT, quality.PacketLoss, quality.Bandwidth)
+	return nil
+}
+
+// FECEnabled reports whether the session's quality controller wants video FEC
+// This is polled by the session's RTCPCollector after each network quality update
+func (pm *PipelineManager) FECEnabled(sessionID string) bool {
+	shard := pm.getShard(sessionID)
+
+	shard.mu.RLock()
+	pipeline, exists := shard.videoPipelines[sessionID]
+	shard.mu.RUnlock()
+
+	return exists && pipeline.FECEnabled()
+}
+
 // GetKeyframeRequester returns a function that can be used as RTCPCollector's keyframe requester
 // This creates a closure that captures the sessionID for the keyframe request
 func (pm *PipelineManager) GetKeyframeRequester(sessionID string) func() error {
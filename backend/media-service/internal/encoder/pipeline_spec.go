@@ -0,0 +1,149 @@
+package encoder
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// CaptureMode 采集模式（视频源）
+type CaptureMode string
+
+const (
+	CaptureModeAuto         CaptureMode = "auto"         // 按可用性选择: scrcpy → screenrecord → screencap
+	CaptureModeScrcpy       CaptureMode = "scrcpy"       // scrcpy-server，设备端 MediaCodec H.264
+	CaptureModeScreenrecord CaptureMode = "screenrecord" // screenrecord，设备端 H.264
+	CaptureModeScreencap    CaptureMode = "screencap"    // screencap PNG + 服务端编码
+)
+
+// EncoderTypeAuto 根据采集模式和轨道编码自动选择编码器
+// 设备端 H.264 使用 passthrough，screencap 使用与轨道编码一致的服务端编码器
+const EncoderTypeAuto EncoderType = "auto"
+
+// IsDeviceEncoded 采集模式是否输出设备端编码的 H.264
+func (m CaptureMode) IsDeviceEncoded() bool {
+	return m == CaptureModeScrcpy || m == CaptureModeScreenrecord
+}
+
+// PipelineSpec 声明式视频管道规格
+// 零值字段表示"未指定"，由更低优先级的规格或视频源默认值补全
+type PipelineSpec struct {
+	CaptureMode CaptureMode `json:"captureMode,omitempty"`
+	EncoderType EncoderType `json:"encoderType,omitempty"`
+	Width       int         `json:"width,omitempty"`  // 目标宽度（0 = 视频源默认）
+	Height      int         `json:"height,omitempty"` // 目标高度（0 = 按宽高比自动计算）
+	FPS         int         `json:"fps,omitempty"`
+	Bitrate     int         `json:"bitrate,omitempty"` // bits per second
+}
+
+// Merge 用 override 中已指定的字段覆盖当前规格
+func (s PipelineSpec) Merge(override PipelineSpec) PipelineSpec {
+	if override.CaptureMode != "" {
+		s.CaptureMode = override.CaptureMode
+	}
+	if override.EncoderType != "" {
+		s.EncoderType = override.EncoderType
+	}
+	if override.Width > 0 {
+		s.Width = override.Width
+	}
+	if override.Height > 0 {
+		s.Height = override.Height
+	}
+	if override.FPS > 0 {
+		s.FPS = override.FPS
+	}
+	if override.Bitrate > 0 {
+		s.Bitrate = override.Bitrate
+	}
+	return s
+}
+
+// Validate 校验规格中已指定的字段
+func (s PipelineSpec) Validate() error {
+	switch s.CaptureMode {
+	case "", CaptureModeAuto, CaptureModeScrcpy, CaptureModeScreenrecord, CaptureModeScreencap:
+	default:
+		return fmt.Errorf("unsupported capture mode: %s", s.CaptureMode)
+	}
+
+	switch s.EncoderType {
+	case "", EncoderTypeAuto, EncoderTypePassThrough, EncoderTypeVP8, EncoderTypeVP8Simple, EncoderTypeH264:
+	default:
+		return fmt.Errorf("unsupported video encoder type: %s", s.EncoderType)
+	}
+
+	if s.Width < 0 || s.Width > 3840 || s.Width%2 != 0 {
+		return fmt.Errorf("invalid width: %d (must be even, 0-3840)", s.Width)
+	}
+	if s.Height < 0 || s.Height > 2160 || s.Height%2 != 0 {
+		return fmt.Errorf("invalid height: %d (must be even, 0-2160)", s.Height)
+	}
+	if s.FPS < 0 || s.FPS > 60 {
+		return fmt.Errorf("invalid framerate: %d (must be 0-60)", s.FPS)
+	}
+	if s.Bitrate < 0 || s.Bitrate > 50000000 {
+		return fmt.Errorf("invalid bitrate: %d (must be 0-50000000)", s.Bitrate)
+	}
+
+	return nil
+}
+
+// PipelineOverrides 按租户和设备覆盖管道规格
+// 优先级: 全局配置 < 租户 < 设备
+type PipelineOverrides struct {
+	Tenants map[string]PipelineSpec `json:"tenants,omitempty"`
+	Devices map[string]PipelineSpec `json:"devices,omitempty"`
+}
+
+// LoadPipelineOverrides 从 JSON 文件加载覆盖规则
+//
+// 文件格式:
+//
+//	{
+//	  "tenants": {"tenant-a": {"captureMode": "screencap", "encoderType": "vp8"}},
+//	  "devices": {"device-1": {"fps": 20, "bitrate": 1500000}}
+//	}
+func LoadPipelineOverrides(path string) (*PipelineOverrides, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pipeline overrides: %w", err)
+	}
+
+	var overrides PipelineOverrides
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("failed to parse pipeline overrides: %w", err)
+	}
+
+	for tenantID, spec := range overrides.Tenants {
+		if err := spec.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid override for tenant %s: %w", tenantID, err)
+		}
+	}
+	for deviceID, spec := range overrides.Devices {
+		if err := spec.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid override for device %s: %w", deviceID, err)
+		}
+	}
+
+	return &overrides, nil
+}
+
+// ParseCaptureMode 解析配置中的采集模式（大小写不敏感，空值为 auto）
+func ParseCaptureMode(value string) CaptureMode {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return CaptureModeAuto
+	}
+	return CaptureMode(value)
+}
+
+// ParseEncoderType 解析配置中的编码器类型（大小写不敏感，空值为 auto）
+func ParseEncoderType(value string) EncoderType {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return EncoderTypeAuto
+	}
+	return EncoderType(value)
+}
@@ -0,0 +1,119 @@
+package encoder
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// DefaultMaxEncoderProcesses 单机默认允许的 FFmpeg 编码进程上限
+// 每个进程在 720p 软件编码时大约占用 0.5-1 个 CPU 核心
+const DefaultMaxEncoderProcesses = 8
+
+// ErrEncoderProcessLimit is returned when the host-wide encoder process cap is reached
+var ErrEncoderProcessLimit = errors.New("encoder process limit reached")
+
+// EncoderProcessPool limits the number of long-lived FFmpeg encoder processes on a host.
+// 每个 StreamingEncoder 在首次启动进程时占用一个槽位，崩溃重启和码率重启复用同一槽位，
+// 直到 Close 时才释放。
+type EncoderProcessPool struct {
+	mu           sync.Mutex
+	maxProcesses int
+	active       int
+
+	// 统计信息
+	acquired uint64 // 成功占用次数
+	rejected uint64 // 因达到上限被拒绝次数
+	restarts uint64 // 进程重启次数（崩溃恢复 + 参数变更）
+}
+
+// EncoderProcessPoolStats contains encoder process pool statistics
+type EncoderProcessPoolStats struct {
+	MaxProcesses    int    `json:"max_processes"`
+	ActiveProcesses int    `json:"active_processes"`
+	Acquired        uint64 `json:"acquired"`
+	Rejected        uint64 `json:"rejected"`
+	Restarts        uint64 `json:"restarts"`
+}
+
+// defaultProcessPool 未显式指定进程池的编码器共享此全局池
+var defaultProcessPool = NewEncoderProcessPool(DefaultMaxEncoderProcesses)
+
+// DefaultEncoderProcessPool returns the package-wide encoder process pool
+func DefaultEncoderProcessPool() *EncoderProcessPool {
+	return defaultProcessPool
+}
+
+// NewEncoderProcessPool creates a pool allowing at most maxProcesses encoder processes
+func NewEncoderProcessPool(maxProcesses int) *EncoderProcessPool {
+	if maxProcesses <= 0 {
+		maxProcesses = DefaultMaxEncoderProcesses
+	}
+	return &EncoderProcessPool{
+		maxProcesses: maxProcesses,
+	}
+}
+
+// Acquire reserves a process slot, returning ErrEncoderProcessLimit when the pool is full
+func (p *EncoderProcessPool) Acquire() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.active >= p.maxProcesses {
+		p.rejected++
+		return fmt.Errorf("%w (%d/%d)", ErrEncoderProcessLimit, p.active, p.maxProcesses)
+	}
+
+	p.active++
+	p.acquired++
+	return nil
+}
+
+// Release frees a previously acquired process slot
+func (p *EncoderProcessPool) Release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.active > 0 {
+		p.active--
+	}
+}
+
+// Available returns the number of free process slots
+func (p *EncoderProcessPool) Available() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.maxProcesses - p.active
+}
+
+// SetMaxProcesses adjusts the process cap; running processes above the new cap are not killed
+func (p *EncoderProcessPool) SetMaxProcesses(maxProcesses int) {
+	if maxProcesses <= 0 {
+		return
+	}
+
+	p.mu.Lock()
+	p.maxProcesses = maxProcesses
+	p.mu.Unlock()
+}
+
+// recordRestart 记录一次进程重启
+func (p *EncoderProcessPool) recordRestart() {
+	p.mu.Lock()
+	p.restarts++
+	p.mu.Unlock()
+}
+
+// Stats returns pool statistics
+func (p *EncoderProcessPool) Stats() EncoderProcessPoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return EncoderProcessPoolStats{
+		MaxProcesses:    p.maxProcesses,
+		ActiveProcesses: p.active,
+		Acquired:        p.acquired,
+		Rejected:        p.rejected,
+		Restarts:        p.restarts,
+	}
+}
@@ -0,0 +1,721 @@
+package encoder
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// =============================================================================
+// SimulcastTranscoder - 将设备端 H.264 转码为多个分辨率/码率层
+// =============================================================================
+//
+// SFU 默认把设备输出的 H.264（scrcpy 约 4 Mbps）原样转发给所有观看端，弱网观看端无法承受。
+// 转码器使用单个常驻 FFmpeg 进程解码一次，split 后缩放并分别编码为多个层，
+// 每个层通过独立的管道（pipe:3、pipe:4 ...）以 Annex-B 格式输出，由订阅者按网络状况选择。
+//
+// 层切换只能发生在目标层的关键帧上，因此各层使用较短的 GOP（默认 1 秒）。
+
+const (
+	// transcoderInputBuffer 输入队列缓冲帧数，队列满时丢帧并等待下一个关键帧
+	transcoderInputBuffer = 8
+
+	// maxSimulcastLayers 单个转码器允许的最大层数
+	maxSimulcastLayers = 4
+)
+
+// SimulcastLayer 转码输出层
+type SimulcastLayer struct {
+	RID     string `json:"rid"`     // 层标识（如 f/h/q）
+	Width   int    `json:"width"`   // 输出宽度，高度按宽高比计算；源画面更窄时不放大
+	Bitrate int    `json:"bitrate"` // bits per second
+}
+
+// DefaultSimulcastLayers 默认三层: 720p/2 Mbps、480p/800 kbps、240p/250 kbps
+func DefaultSimulcastLayers() []SimulcastLayer {
+	return []SimulcastLayer{
+		{RID: "f", Width: 720, Bitrate: 2000000},
+		{RID: "h", Width: 480, Bitrate: 800000},
+		{RID: "q", Width: 240, Bitrate: 250000},
+	}
+}
+
+// ParseSimulcastLayers 解析层配置，格式为 "rid:width:bitrate"，多个层以逗号分隔
+// 例如 "f:720:2000000,h:480:800000,q:240:250000"；空值返回默认层。
+// 返回的层按码率从高到低排列。
+func ParseSimulcastLayers(value string) ([]SimulcastLayer, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return DefaultSimulcastLayers(), nil
+	}
+
+	var layers []SimulcastLayer
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid simulcast layer %q (expected rid:width:bitrate)", item)
+		}
+
+		width, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid width in simulcast layer %q: %w", item, err)
+		}
+		bitrate, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid bitrate in simulcast layer %q: %w", item, err)
+		}
+
+		layer := SimulcastLayer{RID: parts[0], Width: width, Bitrate: bitrate}
+		if err := layer.Validate(); err != nil {
+			return nil, err
+		}
+		if seen[layer.RID] {
+			return nil, fmt.Errorf("duplicate simulcast layer rid: %s", layer.RID)
+		}
+		seen[layer.RID] = true
+		layers = append(layers, layer)
+	}
+
+	if len(layers) > maxSimulcastLayers {
+		return nil, fmt.Errorf("too many simulcast layers: %d (max %d)", len(layers), maxSimulcastLayers)
+	}
+
+	sort.SliceStable(layers, func(i, j int) bool {
+		return layers[i].Bitrate > layers[j].Bitrate
+	})
+
+	return layers, nil
+}
+
+// Validate 校验层参数
+func (l SimulcastLayer) Validate() error {
+	if l.RID == "" || len(l.RID) > 16 {
+		return fmt.Errorf("invalid simulcast layer rid: %q", l.RID)
+	}
+	if l.Width < 64 || l.Width > 3840 || l.Width%2 != 0 {
+		return fmt.Errorf("invalid simulcast layer width: %d (must be even, 64-3840)", l.Width)
+	}
+	if l.Bitrate < 50000 || l.Bitrate > 50000000 {
+		return fmt.Errorf("invalid simulcast layer bitrate: %d (must be 50000-50000000)", l.Bitrate)
+	}
+	return nil
+}
+
+// SimulcastSampleHandler 接收某一层输出的完整访问单元
+type SimulcastSampleHandler func(layer SimulcastLayer, au []byte, duration time.Duration, keyframe bool)
+
+// SimulcastTranscoderOptions contains options for a simulcast transcoder
+type SimulcastTranscoderOptions struct {
+	Layers           []SimulcastLayer
+	FrameRate        int    // 输入帧率（用于码控和首帧时长）
+	KeyframeInterval int    // 各层 GOP 帧数（0 = 1 秒），决定层切换的最大等待时间
+	Preset           string // libx264 preset
+	OnSample         SimulcastSampleHandler
+	ProcessPool      *EncoderProcessPool
+	Logger           *logrus.Logger
+}
+
+// SimulcastTranscoderStats contains statistics about a simulcast transcoder
+type SimulcastTranscoderStats struct {
+	Running       bool              `json:"running"`
+	FramesIn      uint64            `json:"frames_in"`
+	DroppedInputs uint64            `json:"dropped_inputs"`
+	FramesOut     map[string]uint64 `json:"frames_out"`
+	Restarts      uint64            `json:"restarts"`
+	Crashes       uint64            `json:"crashes"`
+}
+
+// transcoderProcess 单个 FFmpeg 转码进程
+type transcoderProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr *tailBuffer
+	done   chan struct{} // 所有层的读取协程退出且进程已回收后关闭
+	err    error
+}
+
+// SimulcastTranscoder decodes an H.264 elementary stream once and re-encodes it
+// into several scaled layers with a single FFmpeg process.
+type SimulcastTranscoder struct {
+	layers           []SimulcastLayer
+	frameRate        int
+	keyframeInterval int
+	preset           string
+	onSample         SimulcastSampleHandler
+	pool             *EncoderProcessPool
+	logger           *logrus.Logger
+
+	input chan []byte
+	stop  chan struct{}
+	wg    sync.WaitGroup
+
+	mu           sync.Mutex
+	closed       bool
+	failed       error
+	slotHeld     bool
+	waitKeyframe bool   // 丢帧后需要等待关键帧才能继续喂给解码器
+	paramSets    []byte // 最近一次的 SPS/PPS，进程重启后补在首个关键帧前
+
+	// 以下字段仅由 run 协程访问
+	proc       *transcoderProcess
+	crashTimes []time.Time
+
+	statsMu sync.Mutex
+	stats   SimulcastTranscoderStats
+}
+
+// NewSimulcastTranscoder creates a simulcast transcoder; the FFmpeg process is
+// started by Start and restarted automatically if it crashes.
+func NewSimulcastTranscoder(options SimulcastTranscoderOptions) (*SimulcastTranscoder, error) {
+	if len(options.Layers) == 0 {
+		return nil, fmt.Errorf("at least one simulcast layer is required")
+	}
+	if len(options.Layers) > maxSimulcastLayers {
+		return nil, fmt.Errorf("too many simulcast layers: %d (max %d)", len(options.Layers), maxSimulcastLayers)
+	}
+	for _, layer := range options.Layers {
+		if err := layer.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if options.OnSample == nil {
+		return nil, fmt.Errorf("sample handler is required")
+	}
+	if options.FrameRate <= 0 {
+		options.FrameRate = 30
+	}
+	if options.KeyframeInterval <= 0 {
+		options.KeyframeInterval = options.FrameRate
+	}
+	if options.Preset == "" {
+		options.Preset = "veryfast"
+	}
+	if options.ProcessPool == nil {
+		options.ProcessPool = defaultProcessPool
+	}
+	if options.Logger == nil {
+		options.Logger = logrus.New()
+	}
+
+	layers := make([]SimulcastLayer, len(options.Layers))
+	copy(layers, options.Layers)
+
+	t := &SimulcastTranscoder{
+		layers:           layers,
+		frameRate:        options.FrameRate,
+		keyframeInterval: options.KeyframeInterval,
+		preset:           options.Preset,
+		onSample:         options.OnSample,
+		pool:             options.ProcessPool,
+		logger:           options.Logger,
+		input:            make(chan []byte, transcoderInputBuffer),
+		stop:             make(chan struct{}),
+		waitKeyframe:     true,
+	}
+	t.stats.FramesOut = make(map[string]uint64, len(layers))
+
+	return t, nil
+}
+
+// Layers 返回转码输出层（按配置顺序）
+func (t *SimulcastTranscoder) Layers() []SimulcastLayer {
+	layers := make([]SimulcastLayer, len(t.layers))
+	copy(layers, t.layers)
+	return layers
+}
+
+// Start 占用进程槽位并启动转码协程，进程在收到第一个关键帧时启动
+func (t *SimulcastTranscoder) Start() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return fmt.Errorf("transcoder is closed")
+	}
+	if t.slotHeld {
+		return nil
+	}
+	if err := t.pool.Acquire(); err != nil {
+		return err
+	}
+	t.slotHeld = true
+
+	t.wg.Add(1)
+	go t.run()
+
+	return nil
+}
+
+// Write 提交一个 Annex-B 访问单元，不阻塞调用方
+// 队列满时丢弃该帧，并丢弃后续非关键帧直到下一个关键帧（避免解码器花屏）
+func (t *SimulcastTranscoder) Write(au []byte) error {
+	if len(au) == 0 {
+		return nil
+	}
+
+	keyframe, params := scanH264AccessUnit(au)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.failed != nil {
+		return t.failed
+	}
+	if t.closed || !t.slotHeld {
+		return fmt.Errorf("transcoder is not running")
+	}
+
+	if params != nil {
+		t.paramSets = params
+	}
+
+	if t.waitKeyframe {
+		if !keyframe {
+			t.recordDrop()
+			return nil
+		}
+		// 设备端可能只在流开始时发送一次 SPS/PPS，重新同步时补上缓存的参数集
+		if params == nil && t.paramSets != nil {
+			au = append(append([]byte(nil), t.paramSets...), au...)
+		}
+	}
+
+	// 访问单元由调用方复用，入队前复制
+	data := make([]byte, len(au))
+	copy(data, au)
+
+	select {
+	case t.input <- data:
+		t.waitKeyframe = false
+		t.statsMu.Lock()
+		t.stats.FramesIn++
+		t.statsMu.Unlock()
+	default:
+		t.waitKeyframe = true
+		t.recordDrop()
+	}
+
+	return nil
+}
+
+// recordDrop 记录丢弃的输入帧
+func (t *SimulcastTranscoder) recordDrop() {
+	t.statsMu.Lock()
+	t.stats.DroppedInputs++
+	t.statsMu.Unlock()
+}
+
+// run 将输入写入 FFmpeg stdin，进程崩溃时重启
+func (t *SimulcastTranscoder) run() {
+	defer t.wg.Done()
+	defer t.stopProcess()
+
+	for {
+		select {
+		case <-t.stop:
+			return
+		case au := <-t.input:
+			if t.proc != nil && t.procExited() {
+				if err := t.recordCrash(); err != nil {
+					t.fail(err)
+					return
+				}
+				t.stopProcess()
+				t.resync()
+				continue
+			}
+
+			if t.proc == nil {
+				if err := t.startProcess(); err != nil {
+					t.fail(err)
+					return
+				}
+			}
+
+			if _, err := t.proc.stdin.Write(au); err != nil {
+				// 进程已退出，下一帧时记录崩溃并重启
+				t.logger.WithError(err).Debug("Failed to write to transcoder stdin")
+			}
+		}
+	}
+}
+
+// procExited reports whether the current process has terminated
+func (t *SimulcastTranscoder) procExited() bool {
+	select {
+	case <-t.proc.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// resync 丢弃队列中的帧，等待下一个关键帧后再喂给新进程
+func (t *SimulcastTranscoder) resync() {
+	t.mu.Lock()
+	t.waitKeyframe = true
+	t.mu.Unlock()
+
+	for {
+		select {
+		case <-t.input:
+			t.recordDrop()
+		default:
+			return
+		}
+	}
+}
+
+// fail 停止接收输入，Write 之后返回该错误
+func (t *SimulcastTranscoder) fail(err error) {
+	t.mu.Lock()
+	t.failed = err
+	t.mu.Unlock()
+
+	t.logger.WithError(err).Error("Simulcast transcoder stopped")
+}
+
+// Err 返回导致转码器停止的错误（正常运行时为 nil）
+func (t *SimulcastTranscoder) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.failed
+}
+
+// recordCrash 记录崩溃并检查重启频率
+func (t *SimulcastTranscoder) recordCrash() error {
+	now := time.Now()
+	recent := t.crashTimes[:0]
+	for _, ts := range t.crashTimes {
+		if now.Sub(ts) < crashRestartWindow {
+			recent = append(recent, ts)
+		}
+	}
+	t.crashTimes = recent
+
+	t.statsMu.Lock()
+	t.stats.Crashes++
+	t.statsMu.Unlock()
+
+	fields := logrus.Fields{
+		"recent_crashes": len(recent),
+		"stderr":         t.proc.stderr.String(),
+	}
+	if t.proc.err != nil {
+		fields["exit_error"] = t.proc.err.Error()
+	}
+
+	if len(recent) >= maxCrashRestarts {
+		t.logger.WithFields(fields).Error("Simulcast transcoder crashing repeatedly, giving up")
+		return fmt.Errorf("transcoder crashed %d times within %s", len(recent), crashRestartWindow)
+	}
+
+	t.crashTimes = append(t.crashTimes, now)
+	t.logger.WithFields(fields).Warn("Simulcast transcoder process exited unexpectedly, restarting")
+	return nil
+}
+
+// startProcess 启动 FFmpeg 进程，每个层一个输出管道
+func (t *SimulcastTranscoder) startProcess() error {
+	cmd := exec.Command("ffmpeg", t.buildArgs()...)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+
+	readers := make([]*os.File, 0, len(t.layers))
+	closeAll := func(files []*os.File) {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	for range t.layers {
+		r, w, err := os.Pipe()
+		if err != nil {
+			stdin.Close()
+			closeAll(readers)
+			closeAll(cmd.ExtraFiles)
+			return fmt.Errorf("failed to create layer pipe: %w", err)
+		}
+		readers = append(readers, r)
+		// ExtraFiles[i] 在子进程中为 fd 3+i
+		cmd.ExtraFiles = append(cmd.ExtraFiles, w)
+	}
+
+	stderr := &tailBuffer{limit: 4096}
+	cmd.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		stdin.Close()
+		closeAll(readers)
+		closeAll(cmd.ExtraFiles)
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	// 写端由子进程持有，父进程关闭后子进程退出时读端才能收到 EOF
+	closeAll(cmd.ExtraFiles)
+
+	proc := &transcoderProcess{
+		cmd:    cmd,
+		stdin:  stdin,
+		stderr: stderr,
+		done:   make(chan struct{}),
+	}
+	t.proc = proc
+
+	var readWG sync.WaitGroup
+	for i, r := range readers {
+		readWG.Add(1)
+		go func(layer SimulcastLayer, r *os.File) {
+			defer readWG.Done()
+			defer r.Close()
+			t.readLayer(layer, r)
+		}(t.layers[i], r)
+	}
+	go func() {
+		readWG.Wait()
+		proc.err = cmd.Wait()
+		close(proc.done)
+	}()
+
+	t.statsMu.Lock()
+	if t.stats.Crashes > 0 {
+		t.stats.Restarts++
+		t.pool.recordRestart()
+	}
+	t.stats.Running = true
+	t.statsMu.Unlock()
+
+	rids := make([]string, len(t.layers))
+	for i, layer := range t.layers {
+		rids[i] = layer.RID
+	}
+	t.logger.WithFields(logrus.Fields{
+		"layers":    rids,
+		"framerate": t.frameRate,
+		"gop":       t.keyframeInterval,
+		"preset":    t.preset,
+		"pid":       cmd.Process.Pid,
+	}).Info("Simulcast transcoder process started")
+
+	return nil
+}
+
+// stopProcess 关闭 stdin 让 FFmpeg 退出，超时后强制结束
+func (t *SimulcastTranscoder) stopProcess() {
+	proc := t.proc
+	if proc == nil {
+		return
+	}
+	t.proc = nil
+
+	proc.stdin.Close()
+
+	select {
+	case <-proc.done:
+	case <-time.After(processStopTimeout):
+		if proc.cmd.Process != nil {
+			proc.cmd.Process.Kill()
+		}
+		<-proc.done
+	}
+
+	t.statsMu.Lock()
+	t.stats.Running = false
+	t.statsMu.Unlock()
+}
+
+// readLayer 解析单个层的输出流
+func (t *SimulcastTranscoder) readLayer(layer SimulcastLayer, r io.Reader) {
+	frameInterval := time.Second / time.Duration(t.frameRate)
+	var last time.Time
+
+	err := parseAnnexBStream(r, func(au []byte) {
+		// 原始 H.264 输出不携带时间戳，按输出间隔估算样本时长
+		now := time.Now()
+		duration := frameInterval
+		if !last.IsZero() {
+			duration = now.Sub(last)
+			if duration < time.Millisecond {
+				duration = time.Millisecond
+			} else if duration > time.Second {
+				duration = frameInterval
+			}
+		}
+		last = now
+
+		keyframe, _ := scanH264AccessUnit(au)
+
+		t.statsMu.Lock()
+		t.stats.FramesOut[layer.RID]++
+		t.statsMu.Unlock()
+
+		t.onSample(layer, au, duration, keyframe)
+	})
+	if err != nil {
+		t.logger.WithError(err).WithField("rid", layer.RID).Warn("Failed to parse transcoder output")
+	}
+}
+
+// buildArgs 构建 FFmpeg 参数: 解码一次 → split → 每层 scale + libx264
+func (t *SimulcastTranscoder) buildArgs() []string {
+	args := []string{
+		"-hide_banner",
+		"-loglevel", "error",
+		"-vsync", "passthrough", // 每个输入帧对应每层一个输出帧，不补帧/丢帧
+		"-fflags", "nobuffer",
+		"-flags", "low_delay",
+		"-probesize", "32",
+		"-analyzeduration", "0",
+		"-f", "h264",
+		"-framerate", fmt.Sprintf("%d", t.frameRate),
+		"-i", "pipe:0",
+	}
+
+	var graph strings.Builder
+	fmt.Fprintf(&graph, "[0:v]split=%d", len(t.layers))
+	for i := range t.layers {
+		fmt.Fprintf(&graph, "[s%d]", i)
+	}
+	for i, layer := range t.layers {
+		// 源画面比目标层窄时保持原宽度，高度按宽高比取偶数
+		fmt.Fprintf(&graph, ";[s%d]scale=w=min(%d\\,iw):h=-2[o%d]", i, layer.Width, i)
+	}
+	args = append(args, "-filter_complex", graph.String())
+
+	for i, layer := range t.layers {
+		args = append(args, "-map", fmt.Sprintf("[o%d]", i))
+		args = append(args, h264CodecArgs(H264EncoderX264, t.preset, layer.Bitrate, t.keyframeInterval)...)
+		args = append(args,
+			"-flush_packets", "1",
+			"-f", "h264",
+			fmt.Sprintf("pipe:%d", 3+i),
+		)
+	}
+
+	return args
+}
+
+// GetStats returns transcoder statistics
+func (t *SimulcastTranscoder) GetStats() SimulcastTranscoderStats {
+	t.statsMu.Lock()
+	defer t.statsMu.Unlock()
+
+	stats := t.stats
+	stats.FramesOut = make(map[string]uint64, len(t.stats.FramesOut))
+	for rid, n := range t.stats.FramesOut {
+		stats.FramesOut[rid] = n
+	}
+	return stats
+}
+
+// Close 停止转码进程并释放进程槽位
+func (t *SimulcastTranscoder) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	slotHeld := t.slotHeld
+	t.slotHeld = false
+	t.mu.Unlock()
+
+	if !slotHeld {
+		return nil
+	}
+
+	close(t.stop)
+	t.wg.Wait()
+	t.pool.Release()
+
+	return nil
+}
+
+// IsH264Keyframe 判断 Annex-B 访问单元是否包含 IDR slice
+func IsH264Keyframe(au []byte) bool {
+	keyframe, _ := scanH264AccessUnit(au)
+	return keyframe
+}
+
+// H264TemporalLayer 返回访问单元所属的时间层（0 为基础层）
+// 带 SVC 前缀 NAL（类型 14/20）时取其 temporal_id；否则 slice 的 nal_ref_idc 均为 0
+// （不被其他帧参考，丢弃不影响解码）时为 1，其余为 0
+func H264TemporalLayer(au []byte) int {
+	var (
+		slices    bool
+		reference bool
+	)
+	temporalID := -1
+	forEachH264NAL(au, func(nal []byte) {
+		switch nal[0] & 0x1F {
+		case 1, 5:
+			slices = true
+			if nal[0]&0x60 != 0 {
+				reference = true
+			}
+		case 14, 20:
+			// nal_unit_header_svc_extension 第三个字节的高 3 位为 temporal_id
+			if len(nal) >= 4 && temporalID < 0 {
+				temporalID = int(nal[3] >> 5)
+			}
+		}
+	})
+
+	switch {
+	case temporalID >= 0:
+		return temporalID
+	case slices && !reference:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// scanH264AccessUnit 扫描访问单元中的 NAL
+// 返回是否包含 IDR slice，以及其中的 SPS/PPS（带起始码，不存在时为 nil）
+func scanH264AccessUnit(au []byte) (keyframe bool, params []byte) {
+	forEachH264NAL(au, func(nal []byte) {
+		switch nal[0] & 0x1F {
+		case 5:
+			keyframe = true
+		case 7, 8:
+			params = append(params, 0x00, 0x00, 0x00, 0x01)
+			params = append(params, nal...)
+		}
+	})
+	return keyframe, params
+}
+
+// forEachH264NAL 依次处理 Annex-B 访问单元中的非空 NAL（不含起始码）
+func forEachH264NAL(au []byte, fn func(nal []byte)) {
+	rest := au
+	for {
+		idx := bytes.Index(rest, annexBStartCode)
+		if idx < 0 {
+			return
+		}
+		rest = rest[idx+len(annexBStartCode):]
+
+		end := bytes.Index(rest, annexBStartCode)
+		nal := rest
+		if end >= 0 {
+			nal = rest[:end]
+		}
+		nal = bytes.TrimRight(nal, "\x00")
+		if len(nal) == 0 {
+			continue
+		}
+		fn(nal)
+	}
+}
@@ -0,0 +1,941 @@
+package encoder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/cloudphone/media-service/internal/capture"
+	"github.com/sirupsen/logrus"
+)
+
+// =============================================================================
+// StreamingEncoder - 每个会话一个常驻 FFmpeg 进程
+// =============================================================================
+//
+// 与逐帧启动 FFmpeg 的方式相比（每帧 ~200ms 进程启动 + 编码器初始化，且每帧都是关键帧），
+// 常驻进程只在会话开始时初始化一次编码器，帧间预测正常工作，码率显著降低。
+//
+// 工作原理:
+// 1. 解码 PNG/JPEG 帧并转换为 I420，写入 FFmpeg stdin (rawvideo)
+// 2. FFmpeg 以 IVF (VP8) 或 Annex-B (H.264) 格式持续输出到 stdout
+// 3. 读取协程增量解析输出流，按帧切分后投递到输出通道
+// 4. 进程崩溃时自动重启；码率/帧率变更通过重启进程实现，FrameID 与 PTS 保持连续
+
+// StreamCodec identifies the output codec of a StreamingEncoder
+type StreamCodec string
+
+const (
+	StreamCodecVP8  StreamCodec = "vp8"
+	StreamCodecH264 StreamCodec = "h264"
+)
+
+const (
+	// streamOutputBuffer 输出通道缓冲帧数
+	streamOutputBuffer = 8
+
+	// maxEncodedFrameSize 单个编码帧的最大字节数，超过视为输出流损坏
+	maxEncodedFrameSize = 8 * 1024 * 1024
+
+	// maxCrashRestarts 在 crashRestartWindow 内允许的最大崩溃重启次数
+	maxCrashRestarts   = 5
+	crashRestartWindow = time.Minute
+
+	// processStopTimeout 关闭 stdin 后等待进程退出的时间
+	processStopTimeout = 2 * time.Second
+)
+
+// StreamingEncoderOptions contains options for a streaming encoder
+type StreamingEncoderOptions struct {
+	Codec            StreamCodec
+	Width            int             // Output width (0 = derive from first frame)
+	Height           int             // Output height (0 = derive from first frame)
+	Bitrate          int             // bits per second
+	FrameRate        int             // frames per second
+	KeyframeInterval int             // GOP size in frames (0 = 2 seconds)
+	Quality          int             // VP8 only: CRF 4-63, 0 = pure bitrate control
+	HWAccel          H264EncoderType // H.264 only
+	Preset           string          // H.264 only
+	OutputTimeout    time.Duration   // Max wait for output in synchronous Encode
+	ProcessPool      *EncoderProcessPool
+	Logger           *logrus.Logger
+}
+
+// StreamingEncoderStats contains statistics about a streaming encoder
+type StreamingEncoderStats struct {
+	Codec          StreamCodec `json:"codec"`
+	Width          int         `json:"width"`
+	Height         int         `json:"height"`
+	Bitrate        int         `json:"bitrate"`
+	FrameRate      int         `json:"frame_rate"`
+	Running        bool        `json:"running"`
+	FramesIn       uint64      `json:"frames_in"`
+	FramesOut      uint64      `json:"frames_out"`
+	BytesOut       uint64      `json:"bytes_out"`
+	DroppedOutputs uint64      `json:"dropped_outputs"`
+	Restarts       uint64      `json:"restarts"`
+	Crashes        uint64      `json:"crashes"`
+	LastPTS        int64       `json:"last_pts"`
+}
+
+// frameMeta 输入帧元数据，按提交顺序与输出帧一一对应
+type frameMeta struct {
+	timestamp time.Time
+	duration  time.Duration
+}
+
+// encoderProcess 单个 FFmpeg 进程
+type encoderProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr *tailBuffer
+	done   chan struct{} // 读取协程退出且进程已回收后关闭
+	err    error         // 进程退出原因，done 关闭后可读
+}
+
+// exited reports whether the process has terminated
+func (p *encoderProcess) exited() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// StreamingEncoder encodes frames with a single long-lived FFmpeg process.
+// It implements both VideoEncoder (synchronous Encode) and AsyncVideoEncoder (Submit + Output).
+type StreamingEncoder struct {
+	codec            StreamCodec
+	fixedWidth       int
+	fixedHeight      int
+	bitrate          int
+	frameRate        int
+	keyframeInterval int
+	quality          int
+	hwAccel          H264EncoderType
+	preset           string
+	outputTimeout    time.Duration
+	pool             *EncoderProcessPool
+	converter        *ImageConverter
+	logger           *logrus.Logger
+
+	mu            sync.Mutex
+	proc          *encoderProcess
+	width         int // 当前进程的输出尺寸
+	height        int
+	slotHeld      bool
+	closed        bool
+	restartReason string // 非空表示下一帧前需要重启进程
+	crashTimes    []time.Time
+
+	output chan *EncodedFrame
+
+	// 输出侧状态（读取协程与 Submit 共享）
+	metaMu  sync.Mutex
+	pending []frameMeta
+	nextID  uint64
+	ptsBase int64
+	lastPTS int64
+	statsMu sync.Mutex
+	stats   StreamingEncoderStats
+}
+
+// NewStreamingEncoder creates a streaming encoder.
+// The FFmpeg process is started lazily on the first frame so that the output
+// resolution can follow the captured frame size.
+func NewStreamingEncoder(options StreamingEncoderOptions) (*StreamingEncoder, error) {
+	switch options.Codec {
+	case StreamCodecVP8, StreamCodecH264:
+	case "":
+		options.Codec = StreamCodecVP8
+	default:
+		return nil, fmt.Errorf("unsupported stream codec: %s", options.Codec)
+	}
+	if options.Width < 0 || options.Height < 0 {
+		return nil, fmt.Errorf("invalid dimensions: %dx%d", options.Width, options.Height)
+	}
+	if options.Bitrate <= 0 {
+		options.Bitrate = 1000000 // 1 Mbps default
+	}
+	if options.FrameRate <= 0 {
+		options.FrameRate = 15
+	}
+	if options.KeyframeInterval <= 0 {
+		options.KeyframeInterval = options.FrameRate * 2
+	}
+	if options.Codec == StreamCodecH264 {
+		if options.Preset == "" {
+			options.Preset = "faster"
+		}
+		if options.HWAccel == "" || options.HWAccel == H264EncoderAuto {
+			options.HWAccel = detectHardwareEncoder()
+		}
+	}
+	if options.OutputTimeout <= 0 {
+		options.OutputTimeout = 200 * time.Millisecond
+	}
+	if options.ProcessPool == nil {
+		options.ProcessPool = DefaultEncoderProcessPool()
+	}
+	if options.Logger == nil {
+		options.Logger = logrus.New()
+	}
+
+	e := &StreamingEncoder{
+		codec:            options.Codec,
+		fixedWidth:       options.Width &^ 1,
+		fixedHeight:      options.Height &^ 1,
+		bitrate:          options.Bitrate,
+		frameRate:        options.FrameRate,
+		keyframeInterval: options.KeyframeInterval,
+		quality:          options.Quality,
+		hwAccel:          options.HWAccel,
+		preset:           options.Preset,
+		outputTimeout:    options.OutputTimeout,
+		pool:             options.ProcessPool,
+		converter:        NewImageConverter(),
+		logger:           options.Logger,
+		output:           make(chan *EncodedFrame, streamOutputBuffer),
+	}
+	e.stats.Codec = options.Codec
+
+	return e, nil
+}
+
+// Reserve acquires the encoder's process slot up front so that admission
+// failures surface when the session is created rather than on the first frame
+func (e *StreamingEncoder) Reserve() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return fmt.Errorf("encoder closed")
+	}
+	return e.acquireSlotLocked()
+}
+
+// acquireSlotLocked 占用进程池槽位（已占用则直接返回）
+func (e *StreamingEncoder) acquireSlotLocked() error {
+	if e.slotHeld {
+		return nil
+	}
+	if err := e.pool.Acquire(); err != nil {
+		return err
+	}
+	e.slotHeld = true
+	return nil
+}
+
+// Encode submits a frame and waits up to OutputTimeout for the next encoded frame.
+// Output is returned in submission order; nil data with nil error means the
+// encoder has not produced output yet (e.g. during process startup).
+func (e *StreamingEncoder) Encode(frame *capture.Frame) ([]byte, error) {
+	if err := e.Submit(frame); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(e.outputTimeout)
+	defer timer.Stop()
+
+	select {
+	case encoded := <-e.output:
+		return encoded.Data, encoded.Error
+	case <-timer.C:
+		return nil, nil
+	}
+}
+
+// Submit writes a frame to the encoder without waiting for output.
+// Encoded frames are delivered on Output().
+func (e *StreamingEncoder) Submit(frame *capture.Frame) error {
+	prepared, err := e.Prepare(frame)
+	if err != nil {
+		return err
+	}
+	return e.SubmitPrepared(prepared)
+}
+
+// Prepare decodes, scales and converts a frame to I420.
+// It does not touch the FFmpeg process and is safe for concurrent use.
+func (e *StreamingEncoder) Prepare(frame *capture.Frame) (*PreparedFrame, error) {
+	if frame == nil || len(frame.Data) == 0 {
+		return nil, fmt.Errorf("empty frame")
+	}
+
+	img, err := e.converter.DecodeFrame(frame)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode frame: %w", err)
+	}
+
+	width, height := e.outputSize(img.Bounds())
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid frame size: %dx%d", img.Bounds().Dx(), img.Bounds().Dy())
+	}
+
+	// 缩放到编码尺寸并转换为 I420
+	bounds := img.Bounds()
+	if bounds.Dx() != width || bounds.Dy() != height {
+		img = e.converter.ResizeImage(img, width, height)
+	}
+	i420, err := e.converter.ImageToI420(img)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert to I420: %w", err)
+	}
+
+	return &PreparedFrame{
+		Data:      i420,
+		Width:     width,
+		Height:    height,
+		Timestamp: frame.Timestamp,
+		Duration:  frame.Duration, // 为 0 时提交时按当前帧率补全
+	}, nil
+}
+
+// SubmitPrepared writes a prepared frame to the FFmpeg process.
+// Frames must be submitted in presentation order.
+func (e *StreamingEncoder) SubmitPrepared(prepared *PreparedFrame) error {
+	if prepared == nil || len(prepared.Data) == 0 {
+		return fmt.Errorf("empty frame")
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return fmt.Errorf("encoder closed")
+	}
+
+	if err := e.ensureProcessLocked(prepared.Width, prepared.Height); err != nil {
+		return err
+	}
+
+	duration := prepared.Duration
+	if duration <= 0 {
+		duration = time.Second / time.Duration(e.frameRate)
+	}
+
+	// 先登记元数据再写入，保证读取协程取到的元数据与输出帧对应
+	e.metaMu.Lock()
+	e.pending = append(e.pending, frameMeta{timestamp: prepared.Timestamp, duration: duration})
+	e.metaMu.Unlock()
+
+	if _, err := e.proc.stdin.Write(prepared.Data); err != nil {
+		e.metaMu.Lock()
+		if n := len(e.pending); n > 0 {
+			e.pending = e.pending[:n-1]
+		}
+		e.metaMu.Unlock()
+
+		e.restartReason = "crash"
+		return fmt.Errorf("failed to write frame to encoder: %w", err)
+	}
+
+	e.statsMu.Lock()
+	e.stats.FramesIn++
+	e.statsMu.Unlock()
+
+	return nil
+}
+
+// Output returns the channel of encoded frames in submission order
+func (e *StreamingEncoder) Output() <-chan *EncodedFrame {
+	return e.output
+}
+
+// outputSize 计算编码输出尺寸（YUV420 要求宽高为偶数）
+func (e *StreamingEncoder) outputSize(bounds image.Rectangle) (int, int) {
+	width, height := e.fixedWidth, e.fixedHeight
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	switch {
+	case width > 0 && height > 0:
+	case width > 0 && srcWidth > 0:
+		height = srcHeight * width / srcWidth
+	case height > 0 && srcHeight > 0:
+		width = srcWidth * height / srcHeight
+	default:
+		width, height = srcWidth, srcHeight
+	}
+
+	return width &^ 1, height &^ 1
+}
+
+// ensureProcessLocked 确保有可用的编码进程，必要时启动或重启
+func (e *StreamingEncoder) ensureProcessLocked(width, height int) error {
+	reason := e.restartReason
+
+	switch {
+	case e.proc == nil:
+		reason = "start"
+	case e.proc.exited():
+		reason = "crash"
+	case width != e.width || height != e.height:
+		reason = "resolution"
+	}
+
+	if reason == "" {
+		return nil
+	}
+
+	if reason == "crash" {
+		if err := e.recordCrashLocked(); err != nil {
+			return err
+		}
+	}
+
+	if e.proc != nil {
+		e.stopProcessLocked(reason != "crash")
+		e.pool.recordRestart()
+
+		e.statsMu.Lock()
+		e.stats.Restarts++
+		e.statsMu.Unlock()
+	}
+
+	e.restartReason = ""
+	e.width, e.height = width, height
+	return e.startProcessLocked(reason)
+}
+
+// recordCrashLocked 记录崩溃并检查重启频率
+func (e *StreamingEncoder) recordCrashLocked() error {
+	now := time.Now()
+	recent := e.crashTimes[:0]
+	for _, t := range e.crashTimes {
+		if now.Sub(t) < crashRestartWindow {
+			recent = append(recent, t)
+		}
+	}
+	e.crashTimes = recent
+
+	e.statsMu.Lock()
+	e.stats.Crashes++
+	e.statsMu.Unlock()
+
+	fields := logrus.Fields{
+		"codec":          e.codec,
+		"recent_crashes": len(recent),
+	}
+	if e.proc != nil {
+		fields["stderr"] = e.proc.stderr.String()
+		if e.proc.err != nil {
+			fields["exit_error"] = e.proc.err.Error()
+		}
+	}
+
+	if len(recent) >= maxCrashRestarts {
+		e.logger.WithFields(fields).Error("Streaming encoder crashing repeatedly, backing off")
+		return fmt.Errorf("encoder crashed %d times within %s", len(recent), crashRestartWindow)
+	}
+
+	e.crashTimes = append(e.crashTimes, now)
+	e.logger.WithFields(fields).Warn("Streaming encoder process exited unexpectedly, restarting")
+	return nil
+}
+
+// startProcessLocked 启动 FFmpeg 进程及输出读取协程
+func (e *StreamingEncoder) startProcessLocked(reason string) error {
+	if err := e.acquireSlotLocked(); err != nil {
+		return err
+	}
+
+	cmd := exec.Command("ffmpeg", e.buildArgs()...)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		stdin.Close()
+		return fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+	stderr := &tailBuffer{limit: 4096}
+	cmd.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		stdin.Close()
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+
+	proc := &encoderProcess{
+		cmd:    cmd,
+		stdin:  stdin,
+		stderr: stderr,
+		done:   make(chan struct{}),
+	}
+	e.proc = proc
+
+	// 崩溃后未输出的帧不会再有结果，丢弃其元数据；PTS 从上一进程的最后一帧之后继续
+	e.metaMu.Lock()
+	e.pending = e.pending[:0]
+	if e.nextID > 0 {
+		e.ptsBase = e.lastPTS + 1
+	}
+	e.metaMu.Unlock()
+
+	go e.readLoop(proc, stdout)
+
+	e.statsMu.Lock()
+	e.stats.Width, e.stats.Height = e.width, e.height
+	e.stats.Bitrate, e.stats.FrameRate = e.bitrate, e.frameRate
+	e.stats.Running = true
+	e.statsMu.Unlock()
+
+	e.logger.WithFields(logrus.Fields{
+		"codec":     e.codec,
+		"width":     e.width,
+		"height":    e.height,
+		"bitrate":   e.bitrate,
+		"framerate": e.frameRate,
+		"gop":       e.keyframeInterval,
+		"hwaccel":   e.hwAccel,
+		"reason":    reason,
+		"pid":       cmd.Process.Pid,
+	}).Info("Streaming encoder process started")
+
+	return nil
+}
+
+// stopProcessLocked 停止当前进程
+// graceful=true 时关闭 stdin 让 FFmpeg 输出剩余帧，超时后强制结束
+func (e *StreamingEncoder) stopProcessLocked(graceful bool) {
+	proc := e.proc
+	if proc == nil {
+		return
+	}
+	e.proc = nil
+
+	proc.stdin.Close()
+
+	if !proc.exited() {
+		var timeout <-chan time.Time
+		if graceful {
+			timeout = time.After(processStopTimeout)
+		} else {
+			timeout = time.After(0)
+		}
+
+		select {
+		case <-proc.done:
+		case <-timeout:
+			if err := proc.cmd.Process.Kill(); err != nil {
+				e.logger.WithError(err).Warn("Failed to kill FFmpeg process")
+			}
+			<-proc.done
+		}
+	}
+
+	e.statsMu.Lock()
+	e.stats.Running = false
+	e.statsMu.Unlock()
+}
+
+// readLoop 增量解析 FFmpeg 输出，直到进程退出
+func (e *StreamingEncoder) readLoop(proc *encoderProcess, stdout io.Reader) {
+	var err error
+	switch e.codec {
+	case StreamCodecH264:
+		err = parseAnnexBStream(stdout, func(au []byte) {
+			e.emit(au, -1)
+		})
+	default:
+		err = parseIVFStream(stdout, func(frame []byte, pts int64) {
+			e.emit(frame, pts)
+		})
+	}
+
+	// 读取结束后回收进程（Wait 会关闭 stdout）
+	waitErr := proc.cmd.Wait()
+	if err == nil {
+		err = waitErr
+	}
+	proc.err = err
+	close(proc.done)
+}
+
+// emit 将编码帧与对应输入帧的元数据关联后投递到输出通道
+// pts < 0 表示输出格式不携带 PTS（Annex-B），按帧序号递增
+func (e *StreamingEncoder) emit(data []byte, pts int64) {
+	e.metaMu.Lock()
+	meta := frameMeta{timestamp: time.Now(), duration: time.Second / time.Duration(e.frameRate)}
+	if len(e.pending) > 0 {
+		meta = e.pending[0]
+		e.pending = e.pending[1:]
+	}
+	if pts < 0 {
+		pts = e.lastPTS - e.ptsBase + 1
+		if e.nextID == 0 {
+			pts = 0
+		}
+	}
+	e.lastPTS = e.ptsBase + pts
+	frameID := e.nextID
+	e.nextID++
+	e.metaMu.Unlock()
+
+	encoded := &EncodedFrame{
+		Data:      data,
+		Timestamp: meta.timestamp,
+		Duration:  meta.duration,
+		FrameID:   frameID,
+	}
+
+	e.statsMu.Lock()
+	e.stats.FramesOut++
+	e.stats.BytesOut += uint64(len(data))
+	e.stats.LastPTS = e.lastPTS
+	e.statsMu.Unlock()
+
+	select {
+	case e.output <- encoded:
+	default:
+		// 消费端停滞：丢弃最旧的帧，保留最新输出
+		select {
+		case <-e.output:
+		default:
+		}
+		e.output <- encoded
+
+		e.statsMu.Lock()
+		e.stats.DroppedOutputs++
+		e.statsMu.Unlock()
+	}
+}
+
+// buildArgs 构建 FFmpeg 参数：rawvideo I420 输入 → IVF/Annex-B 输出
+func (e *StreamingEncoder) buildArgs() []string {
+	args := []string{
+		"-hide_banner",
+		"-loglevel", "error",
+		"-f", "rawvideo",
+		"-pix_fmt", "yuv420p",
+		"-s", fmt.Sprintf("%dx%d", e.width, e.height),
+		"-r", fmt.Sprintf("%d", e.frameRate),
+		"-i", "pipe:0",
+	}
+
+	switch e.codec {
+	case StreamCodecH264:
+		args = append(args, h264CodecArgs(e.hwAccel, e.preset, e.bitrate, e.keyframeInterval)...)
+		args = append(args,
+			"-flush_packets", "1", // 每个 packet 立即写出，降低延迟
+			"-f", "h264",
+			"pipe:1",
+		)
+
+	default:
+		args = append(args,
+			"-c:v", "libvpx",
+			"-b:v", fmt.Sprintf("%d", e.bitrate),
+			"-maxrate", fmt.Sprintf("%d", e.bitrate),
+			"-bufsize", fmt.Sprintf("%d", e.bitrate/2),
+		)
+		if e.quality > 0 {
+			args = append(args, "-crf", fmt.Sprintf("%d", e.quality))
+		}
+		args = append(args,
+			"-quality", "realtime",
+			"-cpu-used", "16",
+			"-deadline", "realtime",
+			"-keyint_min", fmt.Sprintf("%d", e.keyframeInterval),
+			"-g", fmt.Sprintf("%d", e.keyframeInterval),
+			"-error-resilient", "1",
+			"-auto-alt-ref", "0",
+			"-lag-in-frames", "0",
+			"-flush_packets", "1",
+			"-f", "ivf",
+			"pipe:1",
+		)
+	}
+
+	return args
+}
+
+// SetBitrate updates the bitrate; the process is restarted before the next frame
+func (e *StreamingEncoder) SetBitrate(bitrate int) error {
+	if bitrate <= 0 || bitrate > 50000000 {
+		return fmt.Errorf("invalid bitrate: %d", bitrate)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if bitrate == e.bitrate {
+		return nil
+	}
+
+	oldBitrate := e.bitrate
+	e.bitrate = bitrate
+	if e.proc != nil && e.restartReason == "" {
+		e.restartReason = "bitrate"
+	}
+
+	e.logger.WithFields(logrus.Fields{
+		"codec":       e.codec,
+		"old_bitrate": oldBitrate,
+		"new_bitrate": bitrate,
+	}).Info("Streaming encoder bitrate updated, restarting on next frame")
+
+	return nil
+}
+
+// GetBitrate returns the current target bitrate
+func (e *StreamingEncoder) GetBitrate() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.bitrate
+}
+
+// SetFrameRate updates the frame rate; the process is restarted before the next frame
+func (e *StreamingEncoder) SetFrameRate(fps int) error {
+	if fps <= 0 || fps > 60 {
+		return fmt.Errorf("invalid frame rate: %d (must be 1-60)", fps)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if fps == e.frameRate {
+		return nil
+	}
+
+	e.frameRate = fps
+	e.keyframeInterval = fps * 2
+	if e.proc != nil && e.restartReason == "" {
+		e.restartReason = "framerate"
+	}
+
+	e.logger.WithFields(logrus.Fields{
+		"codec":   e.codec,
+		"new_fps": fps,
+	}).Info("Streaming encoder frame rate updated, restarting on next frame")
+
+	return nil
+}
+
+// RequestKeyframe forces the next frame to be a keyframe.
+// FFmpeg cannot be asked for an IDR over a raw stdin pipe, so the process is
+// restarted; a fresh encoder always starts with a keyframe.
+func (e *StreamingEncoder) RequestKeyframe() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return fmt.Errorf("encoder closed")
+	}
+	if e.proc != nil && e.restartReason == "" {
+		e.restartReason = "keyframe"
+	}
+	return nil
+}
+
+// GetStats returns encoder statistics
+func (e *StreamingEncoder) GetStats() StreamingEncoderStats {
+	e.statsMu.Lock()
+	defer e.statsMu.Unlock()
+	return e.stats
+}
+
+// Close stops the FFmpeg process and releases the process slot. It is safe to call more than once.
+func (e *StreamingEncoder) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+
+	e.stopProcessLocked(true)
+
+	if e.slotHeld {
+		e.pool.Release()
+		e.slotHeld = false
+	}
+
+	stats := e.GetStats()
+	e.logger.WithFields(logrus.Fields{
+		"codec":      e.codec,
+		"frames_in":  stats.FramesIn,
+		"frames_out": stats.FramesOut,
+		"restarts":   stats.Restarts,
+		"crashes":    stats.Crashes,
+	}).Info("Streaming encoder closed")
+
+	return nil
+}
+
+// =============================================================================
+// 输出流解析
+// =============================================================================
+
+// parseIVFStream incrementally parses an IVF stream and calls emit for every frame.
+// IVF format:
+// - 32 byte file header ("DKIF", version, header length, fourcc, ...)
+// - For each frame:
+//   - 4 bytes: frame size (little-endian)
+//   - 8 bytes: timestamp (little-endian)
+//   - N bytes: frame data
+func parseIVFStream(r io.Reader, emit func(frame []byte, pts int64)) error {
+	br := bufio.NewReaderSize(r, 64*1024)
+
+	header := make([]byte, 32)
+	if _, err := io.ReadFull(br, header); err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return fmt.Errorf("failed to read IVF header: %w", err)
+	}
+	if string(header[0:4]) != "DKIF" {
+		return fmt.Errorf("invalid IVF signature: %q", header[0:4])
+	}
+	if headerLen := int(binary.LittleEndian.Uint16(header[6:8])); headerLen > len(header) {
+		if _, err := br.Discard(headerLen - len(header)); err != nil {
+			return fmt.Errorf("failed to skip IVF header: %w", err)
+		}
+	}
+
+	frameHeader := make([]byte, 12)
+	for {
+		if _, err := io.ReadFull(br, frameHeader); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("failed to read IVF frame header: %w", err)
+		}
+
+		size := binary.LittleEndian.Uint32(frameHeader[0:4])
+		pts := int64(binary.LittleEndian.Uint64(frameHeader[4:12]))
+		if size == 0 || size > maxEncodedFrameSize {
+			return fmt.Errorf("invalid IVF frame size: %d", size)
+		}
+
+		frame := make([]byte, size)
+		if _, err := io.ReadFull(br, frame); err != nil {
+			return fmt.Errorf("IVF frame truncated: %w", err)
+		}
+
+		emit(frame, pts)
+	}
+}
+
+// annexBStartCode H.264 Annex-B 起始码
+var annexBStartCode = []byte{0x00, 0x00, 0x01}
+
+// parseAnnexBStream incrementally splits an H.264 Annex-B stream into access units.
+// A new access unit starts at an AUD/SPS/PPS/SEI NAL or a slice with
+// first_mb_in_slice == 0 following a slice of the previous picture, so each
+// access unit is emitted once the first NAL of the next one has arrived.
+func parseAnnexBStream(r io.Reader, emit func(au []byte)) error {
+	var (
+		buf    []byte
+		au     []byte
+		hasVCL bool
+		chunk  = make([]byte, 64*1024)
+	)
+
+	addNAL := func(nal []byte) {
+		// 去掉 4 字节起始码带来的尾随 0（trailing_zero_8bits）
+		nal = bytes.TrimRight(nal, "\x00")
+		if len(nal) == 0 {
+			return
+		}
+
+		nalType := nal[0] & 0x1F
+		isVCL := nalType == 1 || nalType == 5
+
+		startsAU := false
+		switch nalType {
+		case 6, 7, 8, 9: // SEI, SPS, PPS, AUD
+			startsAU = hasVCL
+		case 1, 5: // 非 IDR / IDR slice
+			startsAU = hasVCL && len(nal) > 1 && nal[1]&0x80 != 0
+		}
+
+		if startsAU && len(au) > 0 {
+			emit(au)
+			au = nil
+			hasVCL = false
+		}
+
+		au = append(au, 0x00, 0x00, 0x00, 0x01)
+		au = append(au, nal...)
+		if isVCL {
+			hasVCL = true
+		}
+	}
+
+	for {
+		n, err := r.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+
+		// 提取所有完整的 NAL（两个起始码之间的数据）
+		consumed := 0
+		for {
+			first := bytes.Index(buf[consumed:], annexBStartCode)
+			if first < 0 {
+				break
+			}
+			start := consumed + first + len(annexBStartCode)
+			next := bytes.Index(buf[start:], annexBStartCode)
+			if next < 0 {
+				consumed += first
+				break
+			}
+			addNAL(buf[start : start+next])
+			consumed = start + next
+		}
+		if consumed > 0 {
+			buf = append(buf[:0], buf[consumed:]...)
+		}
+		if len(buf) > maxEncodedFrameSize {
+			return fmt.Errorf("annex-b NAL exceeds %d bytes", maxEncodedFrameSize)
+		}
+
+		if err != nil {
+			// 流结束：最后一个 NAL 没有后续起始码
+			if first := bytes.Index(buf, annexBStartCode); first >= 0 {
+				addNAL(buf[first+len(annexBStartCode):])
+			}
+			if len(au) > 0 {
+				emit(au)
+			}
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// tailBuffer 仅保留最后 limit 字节的 stderr 输出，用于崩溃诊断
+type tailBuffer struct {
+	mu    sync.Mutex
+	buf   []byte
+	limit int
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.limit {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-t.limit:]...)
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.buf)
+}
@@ -3,6 +3,7 @@ package encoder
 import (
 	"context"
 	"fmt"
+	"math"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -38,6 +39,12 @@ type VideoPipeline struct {
 
 	// Adaptive quality control
 	qualityController *adaptive.QualityController
+
+	// Parallel frame preparation/encoding for raw capture (nil = serial)
+	workerPool *WorkerPool
+
+	// Pipeline events (capture state, quality changes, device events)
+	eventHandler PipelineEventHandler
 }
 
 // FrameWriter is an interface for writing encoded frames
@@ -55,6 +62,8 @@ type PipelineStats struct {
 	EncodingErrors    uint64
 	WritingErrors     uint64
 	EncodingTimeouts  uint64  // 新增: 编码超时次数
+	EncoderWorkers    int     // 工作池当前工作协程数（0 = 串行编码）
+	FramesReordered   uint64  // 工作池中乱序完成后重排的帧数
 	AverageFPS        float64
 	AverageBitrate    float64
 	Uptime            time.Duration
@@ -76,6 +85,14 @@ type PipelineOptions struct {
 	TargetWidth  int // Target width (0 = native resolution)
 	TargetHeight int // Target height (0 = native resolution)
 	Quality      int // JPEG quality (1-100, 0 = default 70)
+
+	// WorkerPool enables parallel encoding of raw frames (nil = serial).
+	// If neither SharedEncoder nor EncoderFactory is set, Encoder is shared
+	// by all workers when it implements PreparingVideoEncoder.
+	WorkerPool *WorkerPoolOptions
+
+	// EventHandler receives capture state, quality and device events (nil = disabled)
+	EventHandler PipelineEventHandler
 }
 
 // NewVideoPipeline creates a new video processing pipeline
@@ -127,6 +144,31 @@ func NewVideoPipeline(options PipelineOptions) (*VideoPipeline, error) {
 		targetWidth:   options.TargetWidth,
 		targetHeight:  options.TargetHeight,
 		quality:       quality,
+		eventHandler:  options.EventHandler,
+	}
+
+	if options.WorkerPool != nil {
+		poolOptions := *options.WorkerPool
+		if poolOptions.SharedEncoder == nil && poolOptions.EncoderFactory == nil {
+			// 帧间预测编码器不能拆成多个实例，所有工作协程共享同一个编码上下文
+			if preparing, ok := options.Encoder.(PreparingVideoEncoder); ok {
+				poolOptions.SharedEncoder = preparing
+			}
+		}
+		if poolOptions.Logger == nil {
+			poolOptions.Logger = options.Logger
+		}
+
+		if poolOptions.SharedEncoder != nil || poolOptions.EncoderFactory != nil {
+			pool, err := NewWorkerPool(poolOptions)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create worker pool: %w", err)
+			}
+			pipeline.workerPool = pool
+		} else {
+			options.Logger.WithField("session_id", options.SessionID).
+				Warn("Encoder does not support parallel preparation, worker pool disabled")
+		}
 	}
 
 	// Setup adaptive quality control if enabled
@@ -134,6 +176,9 @@ func NewVideoPipeline(options PipelineOptions) (*VideoPipeline, error) {
 		pipeline.setupAdaptiveQuality()
 	}
 
+	// Forward capture and quality events (must run before the capture starts)
+	pipeline.setupEvents()
+
 	return pipeline, nil
 }
 
@@ -172,6 +217,19 @@ func (p *VideoPipeline) Start(ctx context.Context) error {
 
 	p.running.Store(true)
 
+	// 工作池模式: 输出已按序号重排（共享编码器模式下为编码器的有序输出）
+	if p.workerPool != nil {
+		if err := p.workerPool.Start(pipelineCtx); err != nil {
+			cancel()
+			p.running.Store(false)
+			return fmt.Errorf("failed to start worker pool: %w", err)
+		}
+		go p.outputLoop(pipelineCtx, p.workerPool.GetOutputQueue())
+	} else if async, ok := p.encoder.(AsyncVideoEncoder); ok {
+		// 异步编码器（常驻 FFmpeg 进程）的输出由独立协程写入 WebRTC
+		go p.outputLoop(pipelineCtx, async.Output())
+	}
+
 	// Start pipeline processing goroutine
 	go p.processingLoop(pipelineCtx)
 
@@ -195,6 +253,20 @@ func (p *VideoPipeline) Stop() error {
 		p.cancel()
 	}
 
+	// 先停止工作池，避免向已关闭的编码器提交帧
+	if p.workerPool != nil {
+		if err := p.workerPool.Stop(); err != nil {
+			p.logger.WithError(err).Warn("Failed to stop worker pool")
+		}
+	}
+
+	// 释放编码器资源（常驻 FFmpeg 进程及进程池槽位）
+	if p.encoder != nil {
+		if err := p.encoder.Close(); err != nil {
+			p.logger.WithError(err).Warn("Failed to close video encoder")
+		}
+	}
+
 	p.logger.WithFields(logrus.Fields{
 		"session_id":       p.sessionID,
 		"frames_processed": p.stats.FramesProcessed,
@@ -213,8 +285,15 @@ func (p *VideoPipeline) IsRunning() bool {
 // GetStats returns pipeline statistics
 func (p *VideoPipeline) GetStats() PipelineStats {
 	p.mu.RLock()
-	defer p.mu.RUnlock()
-	return p.stats
+	stats := p.stats
+	p.mu.RUnlock()
+
+	if p.workerPool != nil {
+		poolStats := p.workerPool.GetStats()
+		stats.EncoderWorkers = poolStats.ActiveWorkers
+		stats.FramesReordered = poolStats.FramesReordered
+	}
+	return stats
 }
 
 // SetTargetFPS adjusts target frame rate
@@ -265,6 +344,7 @@ func (p *VideoPipeline) processingLoop(ctx context.Context) {
 
 	var framesInLastSecond uint64
 	var bytesInLastSecond uint64
+	var geometry frameGeometry
 
 	for {
 		select {
@@ -285,12 +365,33 @@ func (p *VideoPipeline) processingLoop(ctx context.Context) {
 			// Capture frame size before processing (frame.Data may be released)
 			frameSize := uint64(len(frame.Data))
 
-			// Process frame
-			if err := p.processFrame(frame); err != nil {
-				p.logger.WithError(err).Warn("Failed to process frame")
-				atomic.AddUint64(&p.stats.EncodingErrors, 1)
-				frame.Release() // Return buffer to pool on error
-				continue
+			// Report the capture state on the first frame and whenever the size changes
+			if p.eventHandler != nil && geometry.observe(frame.Width, frame.Height) {
+				p.emitEvent(PipelineEvent{
+					Type:     PipelineEventCaptureState,
+					Width:    geometry.width,
+					Height:   geometry.height,
+					Rotation: geometry.rotation(),
+				})
+			}
+
+			if p.workerPool != nil {
+				// 帧的所有权交给工作池，处理完成后由工作协程释放
+				if err := p.workerPool.SubmitFrame(frame); err != nil {
+					atomic.AddUint64(&p.stats.FramesDropped, 1)
+					continue
+				}
+			} else {
+				// Process frame
+				if err := p.processFrame(frame); err != nil {
+					p.logger.WithError(err).Warn("Failed to process frame")
+					atomic.AddUint64(&p.stats.EncodingErrors, 1)
+					frame.Release() // Return buffer to pool on error
+					continue
+				}
+
+				// Release frame buffer back to pool
+				frame.Release()
 			}
 
 			// Update counters
@@ -299,9 +400,6 @@ func (p *VideoPipeline) processingLoop(ctx context.Context) {
 			framesInLastSecond++
 			bytesInLastSecond += frameSize
 
-			// Release frame buffer back to pool
-			frame.Release()
-
 			// Update FPS and bitrate stats every second
 			now := time.Now()
 			if now.Sub(lastStatsTime) >= time.Second {
@@ -327,6 +425,14 @@ func (p *VideoPipeline) processFrame(frame *capture.Frame) error {
 	var encodedData []byte
 	var err error
 
+	if async, ok := p.encoder.(AsyncVideoEncoder); ok {
+		// 异步编码器：仅提交帧，编码结果由 outputLoop 写出
+		if err := async.Submit(frame); err != nil {
+			return fmt.Errorf("encoding failed: %w", err)
+		}
+		return nil
+	}
+
 	if p.encoder != nil {
 		// 创建带超时的编码
 		// 500ms timeout to accommodate VP8 encoding via FFmpeg (~235ms typical)
@@ -368,13 +474,48 @@ func (p *VideoPipeline) processFrame(frame *capture.Frame) error {
 		encodedData = frame.Data
 	}
 
-	// Write to WebRTC track with timeout
+	// 编码器尚未产生输出（例如进程启动中），跳过写入
+	if len(encodedData) == 0 {
+		return nil
+	}
+
+	return p.writeFrame(encodedData, frame.Duration)
+}
+
+// outputLoop forwards frames produced by an asynchronous encoder or the worker pool to the frame writer
+func (p *VideoPipeline) outputLoop(ctx context.Context, output <-chan *EncodedFrame) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case encoded, ok := <-output:
+			if !ok {
+				return
+			}
+			if encoded.Error != nil {
+				atomic.AddUint64(&p.stats.EncodingErrors, 1)
+				continue
+			}
+
+			atomic.AddUint64(&p.stats.FramesEncoded, 1)
+			atomic.AddUint64(&p.stats.BytesEncoded, uint64(len(encoded.Data)))
+
+			if err := p.writeFrame(encoded.Data, encoded.Duration); err != nil {
+				p.logger.WithError(err).Warn("Failed to write encoded frame")
+			}
+		}
+	}
+}
+
+// writeFrame writes an encoded frame to the WebRTC track with timeout
+func (p *VideoPipeline) writeFrame(encodedData []byte, duration time.Duration) error {
 	writeCtx, writeCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
 	defer writeCancel()
 
 	writeDone := make(chan error, 1)
 	go func() {
-		writeDone <- p.frameWriter.WriteVideoFrame(p.sessionID, encodedData, frame.Duration)
+		writeDone <- p.frameWriter.WriteVideoFrame(p.sessionID, encodedData, duration)
 	}()
 
 	select {
@@ -537,6 +678,17 @@ func (p *AudioPipeline) GetStats() AudioPipelineStats {
 	return p.stats
 }
 
+// UpdatePacketLoss feeds the measured packet loss (0-1) to encoders that tune in-band FEC for it
+func (p *AudioPipeline) UpdatePacketLoss(packetLoss float64) {
+	encoder, ok := p.encoder.(LossAwareAudioEncoder)
+	if !ok {
+		return
+	}
+	if err := encoder.SetPacketLoss(int(math.Ceil(packetLoss * 100))); err != nil {
+		p.logger.WithError(err).Warn("Failed to update audio encoder packet loss")
+	}
+}
+
 // processingLoop is the main audio processing loop
 func (p *AudioPipeline) processingLoop(ctx context.Context) {
 	audioChannel := p.capture.GetAudioChannel()
@@ -606,7 +758,11 @@ func (p *AudioPipeline) processAudioFrame(frame *capture.AudioFrame) error {
 
 // setupAdaptiveQuality initializes the quality controller and wires it to the capture
 func (p *VideoPipeline) setupAdaptiveQuality() {
+	abc, adaptiveCapture := p.capture.(capture.AdaptiveBitrateCapture)
+
 	// Create quality controller
+	// scrcpy changes bitrate in place, so it follows the bandwidth estimate (GCC/REMB) continuously;
+	// the streaming encoder restarts on every change and only switches on level changes
 	p.qualityController = adaptive.NewQualityController(adaptive.QualityControllerOptions{
 		SessionID: p.sessionID,
 		InitialQuality: adaptive.QualitySettings{
@@ -616,13 +772,18 @@ func (p *VideoPipeline) setupAdaptiveQuality() {
 			Width:     p.targetWidth,
 			Height:    p.targetHeight,
 		},
-		Logger: p.logger,
+		TrackBandwidth: adaptiveCapture,
+		Logger:         p.logger,
 	})
 
 	// Wire quality controller to capture if it supports adaptive bitrate
-	if abc, ok := p.capture.(capture.AdaptiveBitrateCapture); ok {
+	if adaptiveCapture {
 		p.qualityController.SetBitrateAdjuster(adaptive.BitrateAdjusterFunc(abc.SetBitrate))
 		p.logger.WithField("session_id", p.sessionID).Info("Adaptive bitrate control enabled via scrcpy control socket")
+	} else if _, ok := p.encoder.(AsyncVideoEncoder); ok {
+		// 服务端编码：码率变更时重启常驻编码进程
+		p.qualityController.SetBitrateAdjuster(adaptive.BitrateAdjusterFunc(p.encoder.SetBitrate))
+		p.logger.WithField("session_id", p.sessionID).Info("Adaptive bitrate control enabled via streaming encoder")
 	} else {
 		p.logger.WithField("session_id", p.sessionID).Warn("Capture does not support adaptive bitrate, quality controller is read-only")
 	}
@@ -651,6 +812,14 @@ func (p *VideoPipeline) UpdateNetworkQuality(rtt time.Duration, packetLoss float
 	}
 }
 
+// FECEnabled reports whether the quality controller wants video FEC (false without adaptive quality)
+func (p *VideoPipeline) FECEnabled() bool {
+	if p.qualityController == nil {
+		return false
+	}
+	return p.qualityController.FECEnabled()
+}
+
 // GetQualityController returns the quality controller for external monitoring
 func (p *VideoPipeline) GetQualityController() *adaptive.QualityController {
 	return p.qualityController
@@ -662,5 +831,9 @@ func (p *VideoPipeline) RequestKeyframe() error {
 	if kr, ok := p.capture.(capture.KeyframeRequester); ok {
 		return kr.RequestKeyframe()
 	}
+	// 原始帧采集（screencap）时由服务端编码器产生关键帧
+	if kr, ok := p.encoder.(capture.KeyframeRequester); ok {
+		return kr.RequestKeyframe()
+	}
 	return fmt.Errorf("capture does not support keyframe requests")
 }
@@ -12,17 +12,39 @@ import (
 )
 
 // WorkerPool manages a pool of encoding workers for concurrent frame processing
+//
+// 两种工作模式:
+//   - 独立编码器: 每个工作协程通过 EncoderFactory 创建自己的编码器，适用于无状态编码（如 passthrough）
+//   - 共享编码器: 工作协程只做解码/缩放/I420 转换（Prepare），按序号顺序提交给同一个
+//     PreparingVideoEncoder，适用于帧间预测的 VP8/H.264，保证所有帧处于同一编码上下文
+//
+// 无论哪种模式，输出都按提交序号重排后再投递到输出队列。
+// 工作协程数量在 MinWorkers 和 MaxWorkers 之间根据输入队列深度自动伸缩。
 type WorkerPool struct {
-	workers       int
-	inputQueue    chan *capture.Frame
-	outputQueue   chan *EncodedFrame
+	workers        int // 初始工作协程数
+	minWorkers     int
+	maxWorkers     int
+	scaleInterval  time.Duration
+	inputQueue     chan *workerJob
+	results        chan *workerResult
+	outputQueue    chan *EncodedFrame
 	encoderFactory func() (VideoEncoder, error)
-	running       atomic.Bool
-	cancel        context.CancelFunc
-	wg            sync.WaitGroup
-	logger        *logrus.Logger
-	stats         WorkerPoolStats
-	mu            sync.RWMutex
+	sharedEncoder  PreparingVideoEncoder
+	running        atomic.Bool
+	cancel         context.CancelFunc
+	wg             sync.WaitGroup // 工作协程
+	stageWg        sync.WaitGroup // 重排和转发协程
+	retire         chan struct{}  // 缩容信号，收到的工作协程退出
+	scaleMu        sync.Mutex     // 保证 Stop 之后不再扩容
+	activeWorkers  atomic.Int32
+	logger         *logrus.Logger
+	stats          WorkerPoolStats
+	mu             sync.RWMutex
+
+	// 提交侧: 序号分配与入队必须原子，否则丢弃的帧会在重排缓冲区留下永久空洞
+	submitMu sync.Mutex
+	nextSeq  uint64
+	closed   bool
 }
 
 // EncodedFrame represents an encoded frame with metadata
@@ -42,30 +64,70 @@ type WorkerPoolStats struct {
 	TotalBytesEncoded    uint64
 	AverageEncodingTime  time.Duration
 	WorkerUtilization    float64 // Percentage of time workers are busy
+	ActiveWorkers        int     // 当前工作协程数
+	QueueDepth           int     // 输入队列深度
+	FramesReordered      uint64  // 乱序完成、在重排缓冲区等待过的帧数
+	ScaleUps             uint64
+	ScaleDowns           uint64
 }
 
 // WorkerPoolOptions contains configuration for worker pool
 type WorkerPoolOptions struct {
-	Workers        int                           // Number of worker goroutines
-	InputBuffer    int                           // Input queue buffer size
-	OutputBuffer   int                           // Output queue buffer size
-	EncoderFactory func() (VideoEncoder, error)  // Factory function to create encoders
+	Workers        int                          // Initial number of worker goroutines
+	MinWorkers     int                          // Lower bound for adaptive scaling (default: Workers)
+	MaxWorkers     int                          // Upper bound for adaptive scaling (default: Workers)
+	InputBuffer    int                          // Input queue buffer size
+	OutputBuffer   int                          // Output queue buffer size
+	ScaleInterval  time.Duration                // Queue depth sampling interval (default 500ms)
+	EncoderFactory func() (VideoEncoder, error) // Factory function to create per-worker encoders
+	SharedEncoder  PreparingVideoEncoder        // Single encoder context shared by all workers (takes precedence over EncoderFactory)
 	Logger         *logrus.Logger
 }
 
+// workerJob 带序号的待处理帧
+type workerJob struct {
+	seq   uint64
+	frame *capture.Frame
+}
+
+// workerResult 工作协程的处理结果，按 seq 重排
+type workerResult struct {
+	seq      uint64
+	encoded  *EncodedFrame  // 独立编码器模式
+	prepared *PreparedFrame // 共享编码器模式
+	err      error
+}
+
+const maxPoolWorkers = 16
+
 // NewWorkerPool creates a new worker pool for concurrent encoding
 func NewWorkerPool(options WorkerPoolOptions) (*WorkerPool, error) {
 	if options.Workers <= 0 {
 		options.Workers = 4 // Default 4 workers
 	}
+	if options.MinWorkers <= 0 || options.MinWorkers > options.Workers {
+		options.MinWorkers = options.Workers
+	}
+	if options.MaxWorkers < options.Workers {
+		options.MaxWorkers = options.Workers
+	}
+	if options.MaxWorkers > maxPoolWorkers {
+		options.MaxWorkers = maxPoolWorkers
+	}
+	if options.Workers > options.MaxWorkers {
+		options.Workers = options.MaxWorkers
+	}
 	if options.InputBuffer <= 0 {
 		options.InputBuffer = 10
 	}
 	if options.OutputBuffer <= 0 {
 		options.OutputBuffer = 20
 	}
-	if options.EncoderFactory == nil {
-		return nil, fmt.Errorf("encoder factory is required")
+	if options.ScaleInterval <= 0 {
+		options.ScaleInterval = 500 * time.Millisecond
+	}
+	if options.EncoderFactory == nil && options.SharedEncoder == nil {
+		return nil, fmt.Errorf("encoder factory or shared encoder is required")
 	}
 	if options.Logger == nil {
 		options.Logger = logrus.New()
@@ -73,9 +135,15 @@ func NewWorkerPool(options WorkerPoolOptions) (*WorkerPool, error) {
 
 	return &WorkerPool{
 		workers:        options.Workers,
-		inputQueue:     make(chan *capture.Frame, options.InputBuffer),
+		minWorkers:     options.MinWorkers,
+		maxWorkers:     options.MaxWorkers,
+		scaleInterval:  options.ScaleInterval,
+		inputQueue:     make(chan *workerJob, options.InputBuffer),
+		results:        make(chan *workerResult, options.MaxWorkers*2),
 		outputQueue:    make(chan *EncodedFrame, options.OutputBuffer),
 		encoderFactory: options.EncoderFactory,
+		sharedEncoder:  options.SharedEncoder,
+		retire:         make(chan struct{}),
 		logger:         options.Logger,
 	}, nil
 }
@@ -92,11 +160,29 @@ func (wp *WorkerPool) Start(ctx context.Context) error {
 
 	// Start worker goroutines
 	for i := 0; i < wp.workers; i++ {
-		wp.wg.Add(1)
-		go wp.worker(poolCtx, i)
+		wp.spawnWorker(poolCtx)
+	}
+
+	// 重排协程: 按序号输出；共享编码器模式下还负责按序提交给编码器
+	wp.stageWg.Add(1)
+	go wp.sequencer(poolCtx)
+
+	// 共享编码器的输出本身有序，直接转发到输出队列
+	if wp.sharedEncoder != nil {
+		wp.stageWg.Add(1)
+		go wp.forwardSharedOutput(poolCtx)
+	}
+
+	if wp.maxWorkers > wp.minWorkers {
+		go wp.autoscale(poolCtx)
 	}
 
-	wp.logger.WithField("workers", wp.workers).Info("Worker pool started")
+	wp.logger.WithFields(logrus.Fields{
+		"workers":        wp.workers,
+		"min_workers":    wp.minWorkers,
+		"max_workers":    wp.maxWorkers,
+		"shared_encoder": wp.sharedEncoder != nil,
+	}).Info("Worker pool started")
 
 	return nil
 }
@@ -107,46 +193,67 @@ func (wp *WorkerPool) Stop() error {
 		return fmt.Errorf("worker pool not running")
 	}
 
+	wp.scaleMu.Lock()
 	wp.running.Store(false)
-	if wp.cancel != nil {
-		wp.cancel()
-	}
+	wp.scaleMu.Unlock()
 
 	// Close input queue to signal workers to stop
+	wp.submitMu.Lock()
+	wp.closed = true
 	close(wp.inputQueue)
+	wp.submitMu.Unlock()
 
-	// Wait for all workers to finish
+	// Wait for all workers to finish, then let the sequencer drain
 	wp.wg.Wait()
+	close(wp.results)
+
+	if wp.cancel != nil {
+		wp.cancel()
+	}
+	wp.stageWg.Wait()
 
 	// Close output queue
 	close(wp.outputQueue)
 
+	stats := wp.GetStats()
 	wp.logger.WithFields(logrus.Fields{
-		"frames_processed": wp.stats.TotalFramesProcessed,
-		"frames_encoded":   wp.stats.TotalFramesEncoded,
-		"frames_failed":    wp.stats.TotalFramesFailed,
+		"frames_processed": stats.TotalFramesProcessed,
+		"frames_encoded":   stats.TotalFramesEncoded,
+		"frames_failed":    stats.TotalFramesFailed,
+		"frames_reordered": stats.FramesReordered,
 	}).Info("Worker pool stopped")
 
 	return nil
 }
 
-// SubmitFrame submits a frame for encoding (non-blocking)
+// SubmitFrame submits a frame for encoding (non-blocking).
+// The pool takes ownership of the frame and releases it after processing.
 func (wp *WorkerPool) SubmitFrame(frame *capture.Frame) error {
-	if !wp.running.Load() {
+	if frame == nil {
+		return fmt.Errorf("empty frame")
+	}
+
+	wp.submitMu.Lock()
+	defer wp.submitMu.Unlock()
+
+	if !wp.running.Load() || wp.closed {
+		frame.Release()
 		return fmt.Errorf("worker pool not running")
 	}
 
 	select {
-	case wp.inputQueue <- frame:
+	case wp.inputQueue <- &workerJob{seq: wp.nextSeq, frame: frame}:
+		wp.nextSeq++
 		return nil
 	default:
 		// Queue full, drop frame
 		atomic.AddUint64(&wp.stats.TotalFramesFailed, 1)
+		frame.Release()
 		return fmt.Errorf("input queue full, frame dropped")
 	}
 }
 
-// GetOutputQueue returns the output queue for reading encoded frames
+// GetOutputQueue returns the output queue for reading encoded frames (in submission order)
 func (wp *WorkerPool) GetOutputQueue() <-chan *EncodedFrame {
 	return wp.outputQueue
 }
@@ -154,8 +261,17 @@ func (wp *WorkerPool) GetOutputQueue() <-chan *EncodedFrame {
 // GetStats returns worker pool statistics
 func (wp *WorkerPool) GetStats() WorkerPoolStats {
 	wp.mu.RLock()
-	defer wp.mu.RUnlock()
-	return wp.stats
+	stats := wp.stats
+	wp.mu.RUnlock()
+
+	stats.TotalFramesProcessed = atomic.LoadUint64(&wp.stats.TotalFramesProcessed)
+	stats.TotalFramesEncoded = atomic.LoadUint64(&wp.stats.TotalFramesEncoded)
+	stats.TotalFramesFailed = atomic.LoadUint64(&wp.stats.TotalFramesFailed)
+	stats.TotalBytesEncoded = atomic.LoadUint64(&wp.stats.TotalBytesEncoded)
+	stats.FramesReordered = atomic.LoadUint64(&wp.stats.FramesReordered)
+	stats.ActiveWorkers = int(wp.activeWorkers.Load())
+	stats.QueueDepth = len(wp.inputQueue)
+	return stats
 }
 
 // IsRunning returns true if worker pool is active
@@ -163,17 +279,29 @@ func (wp *WorkerPool) IsRunning() bool {
 	return wp.running.Load()
 }
 
+// spawnWorker 启动一个工作协程
+func (wp *WorkerPool) spawnWorker(ctx context.Context) {
+	workerID := int(wp.activeWorkers.Add(1))
+	wp.wg.Add(1)
+	go wp.worker(ctx, workerID)
+}
+
 // worker is the main worker goroutine that processes frames
 func (wp *WorkerPool) worker(ctx context.Context, workerID int) {
 	defer wp.wg.Done()
-
-	// Create encoder for this worker
-	encoder, err := wp.encoderFactory()
-	if err != nil {
-		wp.logger.WithError(err).WithField("worker_id", workerID).Error("Failed to create encoder")
-		return
+	defer wp.activeWorkers.Add(-1)
+
+	// 独立编码器模式: 为该工作协程创建编码器
+	var encoder VideoEncoder
+	if wp.sharedEncoder == nil {
+		var err error
+		encoder, err = wp.encoderFactory()
+		if err != nil {
+			wp.logger.WithError(err).WithField("worker_id", workerID).Error("Failed to create encoder")
+			return
+		}
+		defer encoder.Close()
 	}
-	defer encoder.Close()
 
 	wp.logger.WithField("worker_id", workerID).Debug("Worker started")
 
@@ -181,81 +309,256 @@ func (wp *WorkerPool) worker(ctx context.Context, workerID int) {
 		select {
 		case <-ctx.Done():
 			wp.logger.WithField("worker_id", workerID).Debug("Worker stopped by context")
+			wp.drainJobs()
+			return
+
+		case <-wp.retire:
+			wp.logger.WithField("worker_id", workerID).Debug("Worker retired by autoscaler")
 			return
 
-		case frame, ok := <-wp.inputQueue:
+		case job, ok := <-wp.inputQueue:
 			if !ok {
 				// Input queue closed
 				wp.logger.WithField("worker_id", workerID).Debug("Worker stopped: input queue closed")
 				return
 			}
 
-			if frame == nil {
-				continue
-			}
-
-			// Process frame
-			encodedFrame := wp.processFrame(frame, encoder, workerID)
+			// 每个序号都必须产生结果，否则重排协程会一直等待
+			wp.results <- wp.processJob(job, encoder, workerID)
+		}
+	}
+}
 
-			// Send to output queue (non-blocking)
-			select {
-			case wp.outputQueue <- encodedFrame:
-				// Successfully sent
-			default:
-				// Output queue full, drop frame
-				atomic.AddUint64(&wp.stats.TotalFramesFailed, 1)
-				wp.logger.WithField("worker_id", workerID).Warn("Output queue full, dropping encoded frame")
+// drainJobs 上下文取消后清空输入队列，为每个序号补一个错误结果
+func (wp *WorkerPool) drainJobs() {
+	for {
+		select {
+		case job, ok := <-wp.inputQueue:
+			if !ok {
+				return
 			}
+			job.frame.Release()
+			wp.results <- &workerResult{seq: job.seq, err: context.Canceled}
+		default:
+			return
 		}
 	}
 }
 
-// processFrame encodes a single frame
-func (wp *WorkerPool) processFrame(frame *capture.Frame, encoder VideoEncoder, workerID int) *EncodedFrame {
+// processJob 处理单个帧：共享模式只做预处理，独立模式完整编码
+func (wp *WorkerPool) processJob(job *workerJob, encoder VideoEncoder, workerID int) *workerResult {
+	frame := job.frame
+	defer frame.Release()
+
 	atomic.AddUint64(&wp.stats.TotalFramesProcessed, 1)
 
 	startTime := time.Now()
 
+	if wp.sharedEncoder != nil {
+		prepared, err := wp.sharedEncoder.Prepare(frame)
+		wp.recordEncodingTime(time.Since(startTime))
+		if err != nil {
+			atomic.AddUint64(&wp.stats.TotalFramesFailed, 1)
+			wp.logger.WithError(err).WithField("worker_id", workerID).Warn("Frame preparation failed")
+		}
+		return &workerResult{seq: job.seq, prepared: prepared, err: err}
+	}
+
 	// Encode frame
 	data, err := encoder.Encode(frame)
-	encodingTime := time.Since(startTime)
+	wp.recordEncodingTime(time.Since(startTime))
+
+	if err != nil {
+		atomic.AddUint64(&wp.stats.TotalFramesFailed, 1)
+		wp.logger.WithError(err).WithField("worker_id", workerID).Warn("Frame encoding failed")
+		return &workerResult{
+			seq: job.seq,
+			encoded: &EncodedFrame{
+				Timestamp: frame.Timestamp,
+				Duration:  frame.Duration,
+				FrameID:   job.seq,
+				Error:     err,
+			},
+			err: err,
+		}
+	}
+
+	atomic.AddUint64(&wp.stats.TotalFramesEncoded, 1)
+	atomic.AddUint64(&wp.stats.TotalBytesEncoded, uint64(len(data)))
+
+	return &workerResult{
+		seq: job.seq,
+		encoded: &EncodedFrame{
+			Data:      data,
+			Timestamp: frame.Timestamp,
+			Duration:  frame.Duration,
+			FrameID:   job.seq,
+		},
+	}
+}
 
-	// Update average encoding time
+// recordEncodingTime updates the average encoding time
+func (wp *WorkerPool) recordEncodingTime(encodingTime time.Duration) {
 	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
 	if wp.stats.AverageEncodingTime == 0 {
 		wp.stats.AverageEncodingTime = encodingTime
 	} else {
 		// Exponential moving average
 		wp.stats.AverageEncodingTime = (wp.stats.AverageEncodingTime*9 + encodingTime) / 10
 	}
-	wp.mu.Unlock()
+}
 
-	if err != nil {
+// sequencer 按提交序号重排工作协程的结果
+func (wp *WorkerPool) sequencer(ctx context.Context) {
+	defer wp.stageWg.Done()
+
+	pending := make(map[uint64]*workerResult)
+	var next uint64
+
+	for result := range wp.results {
+		if result.seq != next {
+			atomic.AddUint64(&wp.stats.FramesReordered, 1)
+		}
+		pending[result.seq] = result
+
+		for {
+			ready, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			wp.emit(ctx, ready)
+		}
+	}
+}
+
+// emit 输出一个已按序排列的结果
+func (wp *WorkerPool) emit(ctx context.Context, result *workerResult) {
+	if wp.sharedEncoder != nil {
+		if result.err != nil || result.prepared == nil {
+			return
+		}
+		// 所有帧按序进入同一个编码上下文，编码输出由 forwardSharedOutput 转发
+		if err := wp.sharedEncoder.SubmitPrepared(result.prepared); err != nil {
+			atomic.AddUint64(&wp.stats.TotalFramesFailed, 1)
+			wp.logger.WithError(err).Warn("Failed to submit prepared frame to shared encoder")
+		}
+		return
+	}
+
+	if result.encoded == nil || (len(result.encoded.Data) == 0 && result.encoded.Error == nil) {
+		return
+	}
+
+	// Send to output queue (non-blocking)
+	select {
+	case wp.outputQueue <- result.encoded:
+	case <-ctx.Done():
+	default:
+		// Output queue full, drop frame
 		atomic.AddUint64(&wp.stats.TotalFramesFailed, 1)
-		wp.logger.WithError(err).WithField("worker_id", workerID).Warn("Frame encoding failed")
-		return &EncodedFrame{
-			Timestamp: frame.Timestamp,
-			Duration:  frame.Duration,
-			Error:     err,
+		wp.logger.Warn("Output queue full, dropping encoded frame")
+	}
+}
+
+// forwardSharedOutput 转发共享编码器的输出
+func (wp *WorkerPool) forwardSharedOutput(ctx context.Context) {
+	defer wp.stageWg.Done()
+
+	output := wp.sharedEncoder.Output()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case encoded, ok := <-output:
+			if !ok {
+				return
+			}
+			if encoded.Error == nil {
+				atomic.AddUint64(&wp.stats.TotalFramesEncoded, 1)
+				atomic.AddUint64(&wp.stats.TotalBytesEncoded, uint64(len(encoded.Data)))
+			}
+
+			select {
+			case wp.outputQueue <- encoded:
+			case <-ctx.Done():
+				return
+			}
 		}
 	}
+}
 
-	atomic.AddUint64(&wp.stats.TotalFramesEncoded, 1)
-	atomic.AddUint64(&wp.stats.TotalBytesEncoded, uint64(len(data)))
+// autoscale 根据输入队列深度调整工作协程数量
+// 队列积压超过当前工作协程数时扩容；连续空闲时缩容
+func (wp *WorkerPool) autoscale(ctx context.Context) {
+	ticker := time.NewTicker(wp.scaleInterval)
+	defer ticker.Stop()
 
-	return &EncodedFrame{
-		Data:      data,
-		Timestamp: frame.Timestamp,
-		Duration:  frame.Duration,
-		FrameID:   wp.stats.TotalFramesEncoded,
-		Error:     nil,
+	const idleTicksBeforeScaleDown = 4
+	idleTicks := 0
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !wp.running.Load() {
+				return
+			}
+
+			depth := len(wp.inputQueue)
+			active := int(wp.activeWorkers.Load())
+
+			switch {
+			case depth > active && active < wp.maxWorkers:
+				idleTicks = 0
+				wp.scaleMu.Lock()
+				if !wp.running.Load() {
+					wp.scaleMu.Unlock()
+					return
+				}
+				wp.spawnWorker(ctx)
+				wp.scaleMu.Unlock()
+				wp.mu.Lock()
+				wp.stats.ScaleUps++
+				wp.mu.Unlock()
+				wp.logger.WithFields(logrus.Fields{
+					"queue_depth": depth,
+					"workers":     active + 1,
+				}).Debug("Worker pool scaled up")
+
+			case depth == 0 && active > wp.minWorkers:
+				idleTicks++
+				if idleTicks < idleTicksBeforeScaleDown {
+					continue
+				}
+				idleTicks = 0
+				select {
+				case wp.retire <- struct{}{}:
+					wp.mu.Lock()
+					wp.stats.ScaleDowns++
+					wp.mu.Unlock()
+					wp.logger.WithFields(logrus.Fields{
+						"workers": active - 1,
+					}).Debug("Worker pool scaled down")
+				default:
+					// 所有工作协程都在忙，下次再缩容
+				}
+
+			default:
+				idleTicks = 0
+			}
+		}
 	}
 }
 
 // SetWorkerCount dynamically adjusts the number of workers (requires restart)
 func (wp *WorkerPool) SetWorkerCount(count int) error {
-	if count <= 0 || count > 16 {
-		return fmt.Errorf("invalid worker count: %d (must be 1-16)", count)
+	if count <= 0 || count > maxPoolWorkers {
+		return fmt.Errorf("invalid worker count: %d (must be 1-%d)", count, maxPoolWorkers)
 	}
 
 	if wp.running.Load() {
@@ -263,10 +566,19 @@ func (wp *WorkerPool) SetWorkerCount(count int) error {
 	}
 
 	wp.workers = count
+	if wp.minWorkers > count {
+		wp.minWorkers = count
+	}
+	if wp.maxWorkers < count {
+		wp.maxWorkers = count
+	}
 	return nil
 }
 
 // GetWorkerCount returns the current number of workers
 func (wp *WorkerPool) GetWorkerCount() int {
+	if wp.running.Load() {
+		return int(wp.activeWorkers.Load())
+	}
 	return wp.workers
 }
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/cloudphone/media-service/internal/deviceaccess"
+	"github.com/cloudphone/media-service/internal/logger"
+	"github.com/cloudphone/media-service/internal/middleware"
+	"github.com/cloudphone/media-service/internal/models"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// =============================================================================
+// 资源归属校验
+// =============================================================================
+//
+// 会话、发布者、订阅者和录像都记录创建者的用户 ID 和租户 ID（来自 JWT），
+// 所有按 ID 操作资源的接口都要校验当前用户可以访问该资源：
+// 只有资源所有者和拥有 middleware.PermissionMediaAdmin 的管理员可以访问（见 UserContext.CanAccess）。
+// 列表接口只返回当前用户可以访问的资源。
+//
+// 创建资源前还要通过 device-service 校验用户对设备的访问级别（见 deviceaccess.Checker）：
+// 发布者和 WHIP 推流需要控制权限，订阅者只需要观看权限；
+// 会话只需要观看权限，只有观看权限的用户创建 viewer 会话（不能注入输入，见 models.SessionRole）。
+
+// currentUser 获取当前 JWT 用户，未认证时返回 401
+func currentUser(c *gin.Context) (*middleware.UserContext, bool) {
+	userCtx, ok := middleware.GetUserContext(c)
+	if !ok || userCtx.UserID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error":   "Unauthorized",
+			"message": "未授权访问",
+		})
+		return nil, false
+	}
+	return userCtx, true
+}
+
+// requestUser 确定请求代表的用户
+// 请求体中的 userId 已废弃（以 JWT 为准），仍提供时必须与 JWT 用户一致，管理员可以代其他用户创建
+func requestUser(c *gin.Context, bodyUserID string) (userID, tenantID string, ok bool) {
+	userCtx, ok := currentUser(c)
+	if !ok {
+		return "", "", false
+	}
+
+	if bodyUserID == "" || bodyUserID == userCtx.UserID {
+		return userCtx.UserID, userCtx.TenantID, true
+	}
+	if userCtx.HasPermission(middleware.PermissionMediaAdmin) {
+		return bodyUserID, userCtx.TenantID, true
+	}
+
+	logger.Warn("user_impersonation_denied",
+		zap.String("user_id", userCtx.UserID),
+		zap.String("requested_user_id", bodyUserID),
+		zap.String("path", c.Request.URL.Path),
+	)
+	c.JSON(http.StatusForbidden, gin.H{
+		"error":   "Forbidden",
+		"message": "userId does not match the authenticated user",
+	})
+	return "", "", false
+}
+
+// authorizeResource 校验当前用户可以访问资源，否则返回 403
+func authorizeResource(c *gin.Context, kind, resourceID, ownerUserID, ownerTenantID string) bool {
+	userCtx, ok := currentUser(c)
+	if !ok {
+		return false
+	}
+	if userCtx.CanAccess(ownerUserID, ownerTenantID) {
+		return true
+	}
+
+	logger.Warn("resource_access_denied",
+		zap.String("user_id", userCtx.UserID),
+		zap.String("tenant_id", userCtx.TenantID),
+		zap.String("resource", kind),
+		zap.String("resource_id", resourceID),
+		zap.String("path", c.Request.URL.Path),
+	)
+	c.JSON(http.StatusForbidden, gin.H{
+		"error":   "Forbidden",
+		"message": kind + " belongs to another user",
+	})
+	return false
+}
+
+// canAccess 列表过滤：当前用户能否看到该资源（不写响应）
+func canAccess(c *gin.Context, ownerUserID, ownerTenantID string) bool {
+	userCtx, ok := middleware.GetUserContext(c)
+	return ok && userCtx.CanAccess(ownerUserID, ownerTenantID)
+}
+
+// errDeviceAccessDenied 用户对设备的访问级别不足
+var errDeviceAccessDenied = errors.New("device access denied")
+
+// checkDeviceAccess 通过 device-service 校验用户对设备的访问级别，返回用户的实际级别
+// 未配置检查器时不校验，视为拥有控制权限
+func checkDeviceAccess(ctx context.Context, checker *deviceaccess.Checker, user *middleware.UserContext, deviceID string, required deviceaccess.Level) (deviceaccess.Level, error) {
+	if checker == nil {
+		return deviceaccess.LevelControl, nil
+	}
+
+	level, err := checker.Check(ctx, user, deviceID)
+	if err != nil {
+		return deviceaccess.LevelNone, err
+	}
+	if !level.Allows(required) {
+		logger.Warn("device_access_denied",
+			zap.String("user_id", user.UserID),
+			zap.String("tenant_id", user.TenantID),
+			zap.String("device_id", deviceID),
+			zap.String("required", required.String()),
+			zap.String("level", level.String()),
+		)
+		return level, errDeviceAccessDenied
+	}
+	return level, nil
+}
+
+// authorizeDevice 校验当前用户对设备的访问级别：设备不存在返回 404，级别不足返回 403，
+// device-service 不可用返回 503
+func authorizeDevice(c *gin.Context, checker *deviceaccess.Checker, deviceID string, required deviceaccess.Level) bool {
+	_, ok := authorizeDeviceLevel(c, checker, deviceID, required)
+	return ok
+}
+
+// authorizeDeviceLevel 同 authorizeDevice，并返回用户对设备的实际访问级别（决定会话角色）
+func authorizeDeviceLevel(c *gin.Context, checker *deviceaccess.Checker, deviceID string, required deviceaccess.Level) (deviceaccess.Level, bool) {
+	if checker == nil {
+		return deviceaccess.LevelControl, true
+	}
+	userCtx, ok := currentUser(c)
+	if !ok {
+		return deviceaccess.LevelNone, false
+	}
+
+	level, err := checkDeviceAccess(c.Request.Context(), checker, userCtx, deviceID, required)
+	switch {
+	case err == nil:
+		return level, true
+	case errors.Is(err, deviceaccess.ErrDeviceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+	case errors.Is(err, errDeviceAccessDenied):
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":   "Forbidden",
+			"message": "no " + required.String() + " access to device",
+		})
+	default:
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Device access check unavailable"})
+	}
+	return deviceaccess.LevelNone, false
+}
+
+// sessionRoleFor 按设备访问级别确定会话角色：有控制权限为 owner，只有观看权限为 viewer
+func sessionRoleFor(level deviceaccess.Level) models.SessionRole {
+	if level.Allows(deviceaccess.LevelControl) {
+		return models.SessionRoleOwner
+	}
+	return models.SessionRoleViewer
+}
@@ -0,0 +1,512 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cloudphone/media-service/internal/config"
+	"github.com/cloudphone/media-service/internal/logger"
+	"github.com/cloudphone/media-service/internal/middleware"
+	"github.com/cloudphone/media-service/internal/models"
+	"github.com/cloudphone/media-service/internal/recording"
+	"github.com/cloudphone/media-service/internal/sfu"
+	"github.com/cloudphone/media-service/internal/webrtc"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	logger.Log = zap.NewNop()
+	logger.Sugar = logger.Log.Sugar()
+	os.Exit(m.Run())
+}
+
+var (
+	alice = &middleware.UserContext{UserID: "alice", TenantID: "tenant-a", Permissions: []string{"media:stream-view"}}
+	bob   = &middleware.UserContext{UserID: "bob", TenantID: "tenant-a", Permissions: []string{"media:stream-view"}}
+	admin = &middleware.UserContext{UserID: "admin", TenantID: "tenant-a", Permissions: []string{middleware.PermissionMediaAdmin}}
+	// otherAdmin 其他租户的管理员
+	otherAdmin = &middleware.UserContext{UserID: "admin-b", TenantID: "tenant-b", Permissions: []string{middleware.PermissionMediaAdmin}}
+	// platformAdmin 没有租户的平台管理员
+	platformAdmin = &middleware.UserContext{UserID: "platform-admin", Permissions: []string{middleware.PermissionMediaAdmin}}
+	// superAdmin 拥有通配权限的用户
+	superAdmin = &middleware.UserContext{UserID: "root", TenantID: "tenant-b", Permissions: []string{middleware.PermissionAll}}
+)
+
+// newTestHandler 创建使用真实 WebRTC 管理器的处理器（不启动视频管道）
+func newTestHandler(t *testing.T) (*Handler, *webrtc.Manager) {
+	t.Helper()
+	mgr := webrtc.NewManager(&config.Config{ICEPortMin: 50000, ICEPortMax: 50100}, webrtc.WithTURNService(nil))
+	return New(mgr, nil, nil, ""), mgr
+}
+
+// newTestSession 创建属于 owner 的会话
+func newTestSession(t *testing.T, mgr *webrtc.Manager, owner *middleware.UserContext) *models.Session {
+	t.Helper()
+	session, err := mgr.CreateSessionWithOptions("device-1", owner.UserID, webrtc.SessionOptions{
+		VideoCodec: webrtc.VideoCodecVP8,
+		TenantID:   owner.TenantID,
+	})
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	t.Cleanup(func() { mgr.CloseSession(session.ID) })
+	return session
+}
+
+// newTestRouter 注册会话路由，以 user 身份处理所有请求（替代 JWTMiddleware）
+func newTestRouter(h *Handler, user *middleware.UserContext) *gin.Engine {
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set(middleware.UserContextKey, user)
+		c.Next()
+	})
+	router.POST("/sessions", h.HandleCreateSession)
+	router.POST("/sessions/answer", h.HandleSetAnswer)
+	router.POST("/sessions/ice-candidate", h.HandleAddICECandidate)
+	router.GET("/sessions/:id", h.HandleGetSession)
+	router.DELETE("/sessions/:id", h.HandleCloseSession)
+	router.POST("/sessions/:id/ice-restart", h.HandleICERestart)
+	router.POST("/sessions/:id/resume", h.HandleResumeSession)
+	router.GET("/sessions", h.HandleListSessions)
+	return router
+}
+
+func serve(router *gin.Engine, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestSessionAccessDeniedForOtherUser(t *testing.T) {
+	h, mgr := newTestHandler(t)
+	session := newTestSession(t, mgr, alice)
+	router := newTestRouter(h, bob)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"get", http.MethodGet, "/sessions/" + session.ID, ""},
+		{"close", http.MethodDelete, "/sessions/" + session.ID, ""},
+		{"answer", http.MethodPost, "/sessions/answer", `{"sessionId":"` + session.ID + `","answer":{"type":"answer","sdp":"v=0"}}`},
+		{"ice candidate", http.MethodPost, "/sessions/ice-candidate", `{"sessionId":"` + session.ID + `","candidate":{"candidate":"candidate:1 1 udp 1 127.0.0.1 9 typ host"}}`},
+		{"ice restart", http.MethodPost, "/sessions/" + session.ID + "/ice-restart", ""},
+		{"resume", http.MethodPost, "/sessions/" + session.ID + "/resume", `{"resumeToken":"` + session.GetResumeToken() + `"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(router, tt.method, tt.path, tt.body)
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("expected 403, got %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+
+	// 被拒绝的请求不能影响会话
+	if _, err := mgr.GetSession(session.ID); err != nil {
+		t.Fatalf("session should still exist: %v", err)
+	}
+}
+
+func TestSessionAccessAllowedForOwnerAndAdmin(t *testing.T) {
+	h, mgr := newTestHandler(t)
+	session := newTestSession(t, mgr, alice)
+
+	for _, user := range []*middleware.UserContext{alice, admin} {
+		w := serve(newTestRouter(h, user), http.MethodGet, "/sessions/"+session.ID, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", user.UserID, w.Code, w.Body.String())
+		}
+	}
+
+	// 其他租户的管理员不能访问
+	w := serve(newTestRouter(h, otherAdmin), http.MethodGet, "/sessions/"+session.ID, "")
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("other tenant admin: expected 403, got %d", w.Code)
+	}
+
+	w = serve(newTestRouter(h, alice), http.MethodDelete, "/sessions/"+session.ID, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("owner close: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestTenantlessSessionAccessRestrictedToPlatformAdmins(t *testing.T) {
+	h, mgr := newTestHandler(t)
+	// 没有租户的会话（旧会话或没有租户的调用方）
+	legacy := &middleware.UserContext{UserID: "legacy", Permissions: []string{"media:stream-view"}}
+	session := newTestSession(t, mgr, legacy)
+
+	tests := []struct {
+		name string
+		user *middleware.UserContext
+		want int
+	}{
+		{"owner", legacy, http.StatusOK},
+		{"tenant admin", admin, http.StatusForbidden},
+		{"other tenant admin", otherAdmin, http.StatusForbidden},
+		{"platform admin", platformAdmin, http.StatusOK},
+		{"wildcard permission", superAdmin, http.StatusOK},
+		{"other user", bob, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(newTestRouter(h, tt.user), http.MethodGet, "/sessions/"+session.ID, "")
+			if w.Code != tt.want {
+				t.Fatalf("expected %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCrossTenantAdminAccessDenied(t *testing.T) {
+	h, mgr := newTestHandler(t)
+	session := newTestSession(t, mgr, alice)
+
+	tests := []struct {
+		name string
+		user *middleware.UserContext
+		want int
+	}{
+		{"same tenant admin", admin, http.StatusOK},
+		{"other tenant admin", otherAdmin, http.StatusForbidden},
+		{"platform admin", platformAdmin, http.StatusOK},
+		{"wildcard permission in other tenant", superAdmin, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(newTestRouter(h, tt.user), http.MethodGet, "/sessions/"+session.ID, "")
+			if w.Code != tt.want {
+				t.Fatalf("expected %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRequirePermissionWildcard(t *testing.T) {
+	statsUser := &middleware.UserContext{UserID: "dashboard", TenantID: "tenant-a", Permissions: []string{middleware.PermissionMediaStats}}
+
+	tests := []struct {
+		name string
+		user *middleware.UserContext
+		want int
+	}{
+		{"required permission", statsUser, http.StatusOK},
+		{"wildcard matches any permission", superAdmin, http.StatusOK},
+		{"media admin without stats permission", admin, http.StatusForbidden},
+		{"no permission", alice, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(func(c *gin.Context) {
+				c.Set(middleware.UserContextKey, tt.user)
+				c.Next()
+			})
+			router.GET("/stats", middleware.RequirePermission(middleware.PermissionMediaStats), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := serve(router, http.MethodGet, "/stats", "")
+			if w.Code != tt.want {
+				t.Fatalf("expected %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestListSessionsFiltersOtherUsers(t *testing.T) {
+	h, mgr := newTestHandler(t)
+	aliceSession := newTestSession(t, mgr, alice)
+	bobSession := newTestSession(t, mgr, bob)
+
+	listIDs := func(user *middleware.UserContext) map[string]bool {
+		w := serve(newTestRouter(h, user), http.MethodGet, "/sessions", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("list: expected 200, got %d", w.Code)
+		}
+		var resp struct {
+			Sessions []struct {
+				SessionID string `json:"sessionId"`
+			} `json:"sessions"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+		ids := make(map[string]bool)
+		for _, s := range resp.Sessions {
+			ids[s.SessionID] = true
+		}
+		return ids
+	}
+
+	if ids := listIDs(alice); !ids[aliceSession.ID] || ids[bobSession.ID] {
+		t.Fatalf("alice should only see her own session, got %v", ids)
+	}
+	if ids := listIDs(admin); !ids[aliceSession.ID] || !ids[bobSession.ID] {
+		t.Fatalf("admin should see all tenant sessions, got %v", ids)
+	}
+	if ids := listIDs(otherAdmin); len(ids) != 0 {
+		t.Fatalf("other tenant admin should see no sessions, got %v", ids)
+	}
+}
+
+func TestCreateSessionRejectsImpersonation(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	w := serve(newTestRouter(h, bob), http.MethodPost, "/sessions", `{"deviceId":"device-1","userId":"alice"}`)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRecordingAccessDeniedForOtherUser(t *testing.T) {
+	recMgr, err := recording.NewManager(recording.WithStoragePath(t.TempDir()))
+	if err != nil {
+		t.Fatalf("failed to create recording manager: %v", err)
+	}
+	rec, err := recMgr.StartRecording(context.Background(), recording.StartRecordingRequest{
+		SessionID: "session-1",
+		DeviceID:  "device-1",
+		UserID:    alice.UserID,
+		TenantID:  alice.TenantID,
+	}, 640, 480)
+	if err != nil {
+		t.Fatalf("failed to start recording: %v", err)
+	}
+	h := NewRecordingHandler(recMgr, nil)
+
+	newRouter := func(user *middleware.UserContext) *gin.Engine {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set(middleware.UserContextKey, user)
+			c.Next()
+		})
+		router.POST("/recordings/:id/stop", h.HandleStopRecording)
+		router.GET("/recordings/:id", h.HandleGetRecording)
+		router.GET("/recordings/:id/download", h.HandleDownloadRecording)
+		router.DELETE("/recordings/:id", h.HandleDeleteRecording)
+		return router
+	}
+
+	// 活跃录像
+	for _, path := range []string{"/recordings/" + rec.ID, "/recordings/" + rec.ID + "/stop"} {
+		method := http.MethodGet
+		if strings.HasSuffix(path, "/stop") {
+			method = http.MethodPost
+		}
+		if w := serve(newRouter(bob), method, path, ""); w.Code != http.StatusForbidden {
+			t.Fatalf("%s %s: expected 403, got %d: %s", method, path, w.Code, w.Body.String())
+		}
+	}
+
+	if w := serve(newRouter(alice), http.MethodPost, "/recordings/"+rec.ID+"/stop", ""); w.Code != http.StatusOK {
+		t.Fatalf("owner stop: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// 已完成的录像从元数据文件校验归属
+	if w := serve(newRouter(bob), http.MethodGet, "/recordings/"+rec.ID+"/download", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("download: expected 403, got %d", w.Code)
+	}
+	if w := serve(newRouter(bob), http.MethodDelete, "/recordings/"+rec.ID, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("delete: expected 403, got %d", w.Code)
+	}
+	if w := serve(newRouter(admin), http.MethodGet, "/recordings/"+rec.ID+"/download", ""); w.Code != http.StatusOK {
+		t.Fatalf("admin download: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serve(newRouter(alice), http.MethodDelete, "/recordings/"+rec.ID, ""); w.Code != http.StatusOK {
+		t.Fatalf("owner delete: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(recording.MetadataPath(rec.FilePath)); !os.IsNotExist(err) {
+		t.Fatalf("metadata file should be deleted with the recording, stat err: %v", err)
+	}
+}
+
+// newTestSFUHandler 创建使用真实 SFU 管理器的处理器（不启动设备采集）
+func newTestSFUHandler(t *testing.T) (*SFUHandler, *sfu.Manager) {
+	t.Helper()
+	mgr := sfu.NewManager(&config.Config{ICEPortMin: 50000, ICEPortMax: 50100})
+	return NewSFUHandler(mgr, nil, ""), mgr
+}
+
+// newTestSFURouter 注册 SFU 路由，以 user 身份处理所有请求（替代 JWTMiddleware）
+func newTestSFURouter(h *SFUHandler, user *middleware.UserContext) *gin.Engine {
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set(middleware.UserContextKey, user)
+		c.Next()
+	})
+	router.POST("/sfu/publishers/answer", h.HandleSetPublisherAnswer)
+	router.POST("/sfu/publishers/ice-candidate", h.HandleAddPublisherICECandidate)
+	router.GET("/sfu/publishers/:id", h.HandleGetPublisher)
+	router.DELETE("/sfu/publishers/:id", h.HandleClosePublisher)
+	router.POST("/sfu/subscribers", h.HandleCreateSubscriber)
+	router.POST("/sfu/subscribers/answer", h.HandleSetSubscriberAnswer)
+	router.POST("/sfu/subscribers/ice-candidate", h.HandleAddSubscriberICECandidate)
+	router.GET("/sfu/subscribers/:id", h.HandleGetSubscriber)
+	router.DELETE("/sfu/subscribers/:id", h.HandleCloseSubscriber)
+	router.POST("/sfu/subscribers/:id/control/grant", h.HandleGrantSubscriberControl)
+	router.POST("/sfu/subscribers/:id/control/revoke", h.HandleRevokeSubscriberControl)
+	router.GET("/sfu/publishers/:id/control/audit", h.HandleGetControlAudit)
+	router.GET("/sfu/rooms/:id", h.HandleGetRoom)
+	router.DELETE("/sfu/rooms/:id", h.HandleCloseRoom)
+	router.POST("/sfu/rooms/:id/devices", h.HandleAddRoomDevice)
+	router.DELETE("/sfu/rooms/:id/devices/:deviceId", h.HandleRemoveRoomDevice)
+	router.POST("/sfu/rooms/:id/join", h.HandleJoinRoom)
+	router.POST("/sfu/rooms/answer", h.HandleSetRoomAnswer)
+	router.POST("/sfu/rooms/ice-candidate", h.HandleAddRoomICECandidate)
+	router.DELETE("/sfu/rooms/participants/:id", h.HandleLeaveRoom)
+	return router
+}
+
+func TestSFUAccessDeniedForOtherUser(t *testing.T) {
+	h, mgr := newTestSFUHandler(t)
+	publisher, err := mgr.CreatePublisher("device-1", alice.UserID, "VP8")
+	if err != nil {
+		t.Fatalf("failed to create publisher: %v", err)
+	}
+	publisher.TenantID = alice.TenantID
+	t.Cleanup(func() { mgr.ClosePublisher(publisher.ID) })
+
+	subscriber, err := mgr.CreateSubscriber(publisher.ID, alice.UserID, sfu.WithSubscriberDataChannel())
+	if err != nil {
+		t.Fatalf("failed to create subscriber: %v", err)
+	}
+	subscriber.TenantID = alice.TenantID
+
+	router := newTestSFURouter(h, bob)
+	answer := `{"type":"answer","sdp":"v=0"}`
+	candidate := `{"candidate":"candidate:1 1 udp 1 127.0.0.1 9 typ host"}`
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"publisher get", http.MethodGet, "/sfu/publishers/" + publisher.ID, ""},
+		{"publisher close", http.MethodDelete, "/sfu/publishers/" + publisher.ID, ""},
+		{"publisher answer", http.MethodPost, "/sfu/publishers/answer", `{"publisherId":"` + publisher.ID + `","answer":` + answer + `}`},
+		{"publisher ice candidate", http.MethodPost, "/sfu/publishers/ice-candidate", `{"publisherId":"` + publisher.ID + `","candidate":` + candidate + `}`},
+		{"subscribe", http.MethodPost, "/sfu/subscribers", `{"publisherId":"` + publisher.ID + `"}`},
+		{"subscriber get", http.MethodGet, "/sfu/subscribers/" + subscriber.ID, ""},
+		{"subscriber close", http.MethodDelete, "/sfu/subscribers/" + subscriber.ID, ""},
+		{"subscriber answer", http.MethodPost, "/sfu/subscribers/answer", `{"subscriberId":"` + subscriber.ID + `","answer":` + answer + `}`},
+		{"subscriber ice candidate", http.MethodPost, "/sfu/subscribers/ice-candidate", `{"subscriberId":"` + subscriber.ID + `","candidate":` + candidate + `}`},
+		{"control grant", http.MethodPost, "/sfu/subscribers/" + subscriber.ID + "/control/grant", `{}`},
+		{"control revoke", http.MethodPost, "/sfu/subscribers/" + subscriber.ID + "/control/revoke", ""},
+		{"control audit", http.MethodGet, "/sfu/publishers/" + publisher.ID + "/control/audit", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(router, tt.method, tt.path, tt.body)
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("expected 403, got %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+
+	// 被拒绝的请求不能影响发布者和订阅者
+	if _, err := mgr.GetPublisher(publisher.ID); err != nil {
+		t.Fatalf("publisher should still exist: %v", err)
+	}
+	if _, err := mgr.GetSubscriber(subscriber.ID); err != nil {
+		t.Fatalf("subscriber should still exist: %v", err)
+	}
+
+	if w := serve(newTestSFURouter(h, admin), http.MethodGet, "/sfu/subscribers/"+subscriber.ID, ""); w.Code != http.StatusOK {
+		t.Fatalf("admin get: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSFURoomAccessDeniedForOtherUser(t *testing.T) {
+	h, mgr := newTestSFUHandler(t)
+	room := mgr.CreateRoom("room", alice.UserID, alice.TenantID)
+	t.Cleanup(func() { mgr.CloseRoom(room.ID) })
+
+	participant, _, err := mgr.JoinRoom(room.ID, alice.UserID, alice.TenantID, alice.Permissions)
+	if err != nil {
+		t.Fatalf("failed to join room: %v", err)
+	}
+
+	router := newTestSFURouter(h, bob)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"get", http.MethodGet, "/sfu/rooms/" + room.ID, ""},
+		{"close", http.MethodDelete, "/sfu/rooms/" + room.ID, ""},
+		{"add device", http.MethodPost, "/sfu/rooms/" + room.ID + "/devices", `{"deviceId":"device-1"}`},
+		{"remove device", http.MethodDelete, "/sfu/rooms/" + room.ID + "/devices/device-1", ""},
+		// 空房间只有创建者和管理员可以加入
+		{"join empty room", http.MethodPost, "/sfu/rooms/" + room.ID + "/join", ""},
+		{"answer", http.MethodPost, "/sfu/rooms/answer", `{"participantId":"` + participant.ID + `","answer":{"type":"answer","sdp":"v=0"}}`},
+		{"ice candidate", http.MethodPost, "/sfu/rooms/ice-candidate", `{"participantId":"` + participant.ID + `","candidate":{"candidate":"candidate:1 1 udp 1 127.0.0.1 9 typ host"}}`},
+		{"leave", http.MethodDelete, "/sfu/rooms/participants/" + participant.ID, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(router, tt.method, tt.path, tt.body)
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("expected 403, got %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+
+	if _, err := mgr.GetRoomParticipant(participant.ID); err != nil {
+		t.Fatalf("participant should still be in the room: %v", err)
+	}
+}
+
+func TestSessionControlDeniedForOtherUser(t *testing.T) {
+	h, mgr := newTestHandler(t)
+	session := newTestSession(t, mgr, alice)
+	target := newTestSession(t, mgr, alice)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set(middleware.UserContextKey, bob)
+		c.Next()
+	})
+	router.GET("/sessions/:id/participants", h.HandleListParticipants)
+	router.POST("/sessions/:id/control/grant", h.HandleGrantControl)
+	router.POST("/sessions/:id/control/revoke", h.HandleRevokeControl)
+	router.POST("/sessions/:id/control/request", h.HandleRequestControl)
+
+	body := `{"targetSessionId":"` + target.ID + `"}`
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"participants", http.MethodGet, "/sessions/" + session.ID + "/participants", ""},
+		{"grant", http.MethodPost, "/sessions/" + session.ID + "/control/grant", body},
+		{"revoke", http.MethodPost, "/sessions/" + session.ID + "/control/revoke", body},
+		{"request", http.MethodPost, "/sessions/" + session.ID + "/control/request", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(router, tt.method, tt.path, tt.body)
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("expected 403, got %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
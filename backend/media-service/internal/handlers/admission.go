@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/cloudphone/media-service/internal/admission"
+	"github.com/gin-gonic/gin"
+)
+
+// =============================================================================
+// 准入控制
+// =============================================================================
+//
+// 创建会话前由 admission.Controller 检查主机负载（CPU、活跃视频管道、空闲 ICE 端口）
+// 和用户 / 租户 / 设备的并发会话配额。SFU 发布者、订阅者、WHIP / WHEP 和房间参与者各占一个 PeerConnection，
+// 与 1:1 会话一样计入配额（房间参与者不计入设备配额），连接关闭时释放（sfu.Manager.OnConnectionClosed）。
+// 拒绝时返回结构化错误码：配额超限 429，主机过载 503（带 Retry-After）：
+//
+//	{"error":"user has 10 concurrent sessions (max 10)","code":"user_session_limit","limit":10,"current":10}
+//
+// WebSocket 信令的 error 消息使用相同的错误码。
+
+// admissionRetryAfterSeconds 主机过载时建议的重试间隔
+const admissionRetryAfterSeconds = 5
+
+// writeAdmissionRejected 返回准入拒绝响应，err 不是准入拒绝时返回 false
+func writeAdmissionRejected(c *gin.Context, err error) bool {
+	var rejected *admission.RejectedError
+	if !errors.As(err, &rejected) {
+		return false
+	}
+
+	status := http.StatusTooManyRequests
+	if rejected.HostOverloaded() {
+		status = http.StatusServiceUnavailable
+		c.Header("Retry-After", strconv.Itoa(admissionRetryAfterSeconds))
+	}
+	c.JSON(status, gin.H{
+		"error":   rejected.Message,
+		"code":    rejected.Code,
+		"limit":   rejected.Limit,
+		"current": rejected.Current,
+	})
+	return true
+}
+
+// admitSession 检查主机负载和配额并预留名额（未配置准入控制器时返回 nil 预留，Commit / Cancel 不做任何事）
+// 被拒绝时已写入响应
+func admitSession(c *gin.Context, controller *admission.Controller, req admission.Request) (*admission.Reservation, bool) {
+	if controller == nil {
+		return nil, true
+	}
+	reservation, err := controller.Admit(req)
+	if err != nil {
+		writeAdmissionRejected(c, err)
+		return nil, false
+	}
+	return reservation, true
+}
+
+// onConnectionClosed SFU 连接（发布者、订阅者、房间参与者）关闭时释放其准入名额
+func (h *SFUHandler) onConnectionClosed(connectionID string) {
+	if h.admission != nil {
+		h.admission.Release(connectionID)
+	}
+}
@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cloudphone/media-service/internal/logger"
+	"github.com/cloudphone/media-service/internal/models"
+	"github.com/cloudphone/media-service/internal/webrtc"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.uber.org/zap"
+)
+
+// =============================================================================
+// 会话角色与控制权
+// =============================================================================
+//
+// 同一设备可以有多个会话（客服协助、演示）：有设备控制权限的用户创建 owner 会话，
+// 只有观看权限的用户创建 viewer 会话。数据通道上的触摸、按键和文本输入只接受
+// owner 和 controller 会话，viewer 的输入被丢弃。
+//
+//	GET  /sessions/:id/participants                            同设备的所有会话及角色
+//	POST /sessions/:id/control/grant   {"targetSessionId"}     owner 授予控制权（原 controller 降为 viewer）
+//	POST /sessions/:id/control/revoke  {"targetSessionId"}     owner 收回控制权
+//	POST /sessions/:id/control/request                         viewer 请求控制权
+//
+// 数据通道和 WebSocket 信令提供相同的操作：
+//
+//	数据通道  {"type":"control","deviceId":"d1","action":"grant|revoke|request","targetSessionId":"s2"}
+//	信令      {"type":"grant_control|revoke_control|request_control","id":"1","sessionId":"s1","targetSessionId":"s2"}
+//
+// 角色变化推送 role_changed 给目标会话，控制权请求推送 control_requested 给设备的 owner 和 controller，
+// 会话绑定了信令连接时通过信令推送，否则通过该用户在该设备上的 WebSocket 连接推送。
+
+// ControlChangeRequest 授予/收回控制权请求
+type ControlChangeRequest struct {
+	TargetSessionID string `json:"targetSessionId" binding:"required"`
+}
+
+// ParticipantInfo 同设备会话的参与者信息
+type ParticipantInfo struct {
+	SessionID string              `json:"sessionId"`
+	UserID    string              `json:"userId"`
+	Role      models.SessionRole  `json:"role"`
+	State     models.SessionState `json:"state"`
+}
+
+// HandleGrantControl owner 把控制权授予同设备的另一个会话
+func (h *Handler) HandleGrantControl(c *gin.Context) {
+	h.handleControlChange(c, "webrtc.grant_control", h.webrtcManager.GrantControl)
+}
+
+// HandleRevokeControl owner 收回另一个会话的控制权
+func (h *Handler) HandleRevokeControl(c *gin.Context) {
+	h.handleControlChange(c, "webrtc.revoke_control", h.webrtcManager.RevokeControl)
+}
+
+// handleControlChange 校验 owner 会话归属后执行授予/收回
+func (h *Handler) handleControlChange(c *gin.Context, spanName string, change func(ownerSessionID, targetSessionID string) error) {
+	ctx := c.Request.Context()
+	_, span := tracer.Start(ctx, spanName)
+	defer span.End()
+
+	sessionID := c.Param("id")
+	span.SetAttributes(attribute.String("session.id", sessionID))
+
+	var req ControlChangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid request")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	span.SetAttributes(attribute.String("target.session.id", req.TargetSessionID))
+
+	if _, ok := h.authorizedSession(c, sessionID); !ok {
+		span.SetStatus(codes.Error, "session not accessible")
+		return
+	}
+
+	if err := change(sessionID, req.TargetSessionID); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "control change rejected")
+		logger.Warn("control_change_failed",
+			zap.String("session_id", sessionID),
+			zap.String("target_session_id", req.TargetSessionID),
+			zap.Error(err),
+		)
+		c.JSON(controlErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	span.SetStatus(codes.Ok, "control changed")
+	c.JSON(http.StatusOK, gin.H{"participants": h.participants(sessionID)})
+}
+
+// HandleRequestControl viewer 请求控制权（通知设备的 owner 和 controller）
+func (h *Handler) HandleRequestControl(c *gin.Context) {
+	sessionID := c.Param("id")
+
+	if _, ok := h.authorizedSession(c, sessionID); !ok {
+		return
+	}
+
+	if err := h.webrtcManager.RequestControl(sessionID); err != nil {
+		c.JSON(controlErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "requested"})
+}
+
+// HandleListParticipants 列出同设备的所有会话及角色
+func (h *Handler) HandleListParticipants(c *gin.Context) {
+	sessionID := c.Param("id")
+
+	session, ok := h.authorizedSession(c, sessionID)
+	if !ok {
+		return
+	}
+
+	participants := h.participants(sessionID)
+	c.JSON(http.StatusOK, gin.H{
+		"deviceId":     session.DeviceID,
+		"participants": participants,
+		"total":        len(participants),
+	})
+}
+
+// participants 返回会话所在设备的所有会话
+func (h *Handler) participants(sessionID string) []ParticipantInfo {
+	session, err := h.webrtcManager.GetSession(sessionID)
+	if err != nil {
+		return nil
+	}
+
+	var result []ParticipantInfo
+	for _, s := range h.webrtcManager.GetDeviceSessions(session.DeviceID) {
+		result = append(result, ParticipantInfo{
+			SessionID: s.ID,
+			UserID:    s.UserID,
+			Role:      s.GetRole(),
+			State:     s.GetState(),
+		})
+	}
+	return result
+}
+
+// controlErrorStatus 控制权操作错误对应的 HTTP 状态码
+func controlErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, webrtc.ErrNotSessionOwner):
+		return http.StatusForbidden
+	case errors.Is(err, webrtc.ErrDifferentDevice):
+		return http.StatusBadRequest
+	case errors.Is(err, webrtc.ErrInvalidRoleChange):
+		return http.StatusConflict
+	default:
+		// 会话在校验后已关闭，或目标会话不存在
+		return http.StatusNotFound
+	}
+}
+
+// signalGrantControl owner 通过信令授予控制权
+func (h *Handler) signalGrantControl(sc *signalingConn, msg *models.SignalingMessage) error {
+	return h.signalControlChange(sc, msg, h.webrtcManager.GrantControl)
+}
+
+// signalRevokeControl owner 通过信令收回控制权
+func (h *Handler) signalRevokeControl(sc *signalingConn, msg *models.SignalingMessage) error {
+	return h.signalControlChange(sc, msg, h.webrtcManager.RevokeControl)
+}
+
+// signalControlChange 校验 owner 会话归属后执行授予/收回，回复 ack
+func (h *Handler) signalControlChange(sc *signalingConn, msg *models.SignalingMessage, change func(ownerSessionID, targetSessionID string) error) error {
+	if msg.TargetSessionID == "" {
+		return newSignalingError(models.SignalingErrBadRequest, "targetSessionId is required")
+	}
+	session, err := h.signalingSession(sc, msg.SessionID)
+	if err != nil {
+		return err
+	}
+
+	if err := change(session.ID, msg.TargetSessionID); err != nil {
+		return controlSignalingError(err)
+	}
+
+	sc.send(&models.SignalingMessage{Type: models.SignalingAck, ID: msg.ID, SessionID: session.ID})
+	return nil
+}
+
+// signalRequestControl viewer 通过信令请求控制权
+func (h *Handler) signalRequestControl(sc *signalingConn, msg *models.SignalingMessage) error {
+	session, err := h.signalingSession(sc, msg.SessionID)
+	if err != nil {
+		return err
+	}
+
+	if err := h.webrtcManager.RequestControl(session.ID); err != nil {
+		return controlSignalingError(err)
+	}
+
+	sc.send(&models.SignalingMessage{Type: models.SignalingAck, ID: msg.ID, SessionID: session.ID})
+	return nil
+}
+
+// controlSignalingError 控制权操作错误对应的信令错误
+func controlSignalingError(err error) error {
+	switch controlErrorStatus(err) {
+	case http.StatusForbidden:
+		return newSignalingError(models.SignalingErrForbidden, err.Error())
+	case http.StatusNotFound:
+		return newSignalingError(models.SignalingErrNotFound, "session not found")
+	default:
+		return newSignalingError(models.SignalingErrBadRequest, err.Error())
+	}
+}
+
+// onSessionRoleChanged 通知会话的客户端角色已变化
+func (h *Handler) onSessionRoleChanged(sessionID string, role models.SessionRole) {
+	session, err := h.webrtcManager.GetSession(sessionID)
+	if err != nil {
+		return
+	}
+
+	logger.Info("session_role_changed",
+		zap.String("session_id", sessionID),
+		zap.String("device_id", session.DeviceID),
+		zap.String("role", string(role)),
+	)
+
+	h.notifySession(session, &models.SignalingMessage{
+		Type:      models.SignalingRoleChanged,
+		SessionID: sessionID,
+		DeviceID:  session.DeviceID,
+		Role:      role,
+	})
+}
+
+// onControlRequested 通知设备的 owner 和 controller 有 viewer 请求控制权
+func (h *Handler) onControlRequested(requester *models.Session) {
+	notified := 0
+	for _, session := range h.webrtcManager.GetDeviceSessions(requester.DeviceID) {
+		if session.ID == requester.ID || !session.CanControl() {
+			continue
+		}
+		h.notifySession(session, &models.SignalingMessage{
+			Type:            models.SignalingControlRequested,
+			SessionID:       session.ID,
+			DeviceID:        session.DeviceID,
+			UserID:          requester.UserID,
+			TargetSessionID: requester.ID,
+		})
+		notified++
+	}
+
+	logger.Info("control_requested",
+		zap.String("session_id", requester.ID),
+		zap.String("device_id", requester.DeviceID),
+		zap.String("user_id", requester.UserID),
+		zap.Int("notified_sessions", notified),
+	)
+}
+
+// notifySession 推送消息给会话的客户端：优先使用绑定的信令连接，
+// 否则发送到该用户在该设备上的 WebSocket 连接（REST 信令的客户端）
+func (h *Handler) notifySession(session *models.Session, msg *models.SignalingMessage) {
+	if sc := h.signaling.lookup(session.ID); sc != nil {
+		sc.send(msg)
+		return
+	}
+	if h.wsHub == nil {
+		return
+	}
+	if err := h.wsHub.SendToClient(session.UserID, session.DeviceID, msg); err != nil {
+		logger.Debug("session_notify_failed",
+			zap.String("session_id", session.ID),
+			zap.String("type", msg.Type),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cloudphone/media-service/internal/capture"
+	"github.com/cloudphone/media-service/internal/encoder"
+	"github.com/cloudphone/media-service/internal/logger"
+	"github.com/cloudphone/media-service/internal/models"
+	"github.com/cloudphone/media-service/internal/webrtc"
+	pionWebRTC "github.com/pion/webrtc/v3"
+	"go.uber.org/zap"
+)
+
+// =============================================================================
+// 服务端事件
+// =============================================================================
+//
+// 服务端通过数据通道 "events"（子协议 cloudphone-events.v1）向客户端推送 JSON 事件
+// （models.SessionEvent）：
+//
+//	capture_state        {"capture":{"width":720,"height":1280,"rotation":0,"codec":"H264","source":"scrcpy","encoder":"passthrough"}}
+//	quality_changed      {"quality":{"level":"Medium","previousLevel":"High","bitrate":1000000,"frameRate":24,"width":854,"height":480}}
+//	recording_started    {"recording":{"recordingId":"rec-1"}}
+//	recording_stopped    {"recording":{"recordingId":"rec-1","durationMs":60000,"fileSize":1048576}}
+//	device_disconnected  {"device":{"reconnecting":true}}
+//	device_reconnecting  {"device":{"attempt":1,"maxAttempts":5}}
+//	device_reconnected   {"device":{"attempt":2}}
+//	clipboard            {"clipboard":{"text":"..."}}
+//
+// clipboard 事件（常含密码、验证码）只推送给可以控制设备的会话（owner / controller），viewer 收不到。
+//
+// 每个事件都带有 type、v（schema 版本）、sessionId、deviceId 和 timestamp（Unix 毫秒）。
+// 事件通道未打开时（REST 信令的客户端未创建该通道、通道尚未建立或已关闭），
+// 事件通过 WebSocket 推送：{"type":"session_event","sessionId":"s1","event":{...}}。
+
+// SessionEventPublisher 推送服务端事件（录像处理器等不持有信令连接的组件使用）
+type SessionEventPublisher interface {
+	PublishSessionEvent(sessionID string, event *models.SessionEvent)
+}
+
+// PublishSessionEvent 推送服务端事件：优先使用数据通道事件通道，否则回退到 WebSocket
+// 会话不存在时丢弃事件；剪贴板事件只推送给可以控制设备的会话
+func (h *Handler) PublishSessionEvent(sessionID string, event *models.SessionEvent) {
+	session, err := h.webrtcManager.GetSession(sessionID)
+	if err != nil {
+		return
+	}
+	if event.Type == models.SessionEventClipboard && !session.CanControl() {
+		return
+	}
+
+	event.Version = models.SessionEventVersion
+	event.SessionID = session.ID
+	event.DeviceID = session.DeviceID
+	if event.Timestamp == 0 {
+		event.Timestamp = time.Now().UnixMilli()
+	}
+
+	err = h.webrtcManager.SendSessionEvent(sessionID, event)
+	if err == nil {
+		return
+	}
+	if !errors.Is(err, webrtc.ErrEventChannelUnavailable) {
+		logger.Debug("session_event_channel_send_failed",
+			zap.String("session_id", sessionID),
+			zap.String("event", event.Type),
+			zap.Error(err),
+		)
+	}
+
+	h.notifySession(session, &models.SignalingMessage{
+		Type:      models.SignalingSessionEvent,
+		SessionID: session.ID,
+		DeviceID:  session.DeviceID,
+		Event:     event,
+	})
+}
+
+// onPipelineEvent 把视频管道事件转换为服务端事件（SFU 发布者的管道不对应 1:1 会话，忽略）
+func (h *Handler) onPipelineEvent(pe encoder.PipelineEvent) {
+	session, err := h.webrtcManager.GetSession(pe.SessionID)
+	if err != nil {
+		return
+	}
+
+	event := &models.SessionEvent{Timestamp: pe.Timestamp.UnixMilli()}
+
+	switch pe.Type {
+	case encoder.PipelineEventCaptureState:
+		state := &models.CaptureStateEvent{
+			Width:    pe.Width,
+			Height:   pe.Height,
+			Rotation: pe.Rotation,
+			Codec:    string(sessionVideoCodec(session)),
+		}
+		if info := session.GetVideoPipelineInfo(); info != nil {
+			state.Source = info.Source
+			state.Encoder = info.Encoder
+		}
+		event.Type = models.SessionEventCaptureState
+		event.Capture = state
+
+	case encoder.PipelineEventQualityChanged:
+		if pe.Quality == nil {
+			return
+		}
+		quality := &models.QualityEvent{
+			Level:     pe.Quality.Level.String(),
+			Bitrate:   pe.Quality.Bitrate,
+			FrameRate: pe.Quality.FrameRate,
+			Width:     pe.Quality.Width,
+			Height:    pe.Quality.Height,
+		}
+		if pe.PreviousQuality != nil {
+			quality.PreviousLevel = pe.PreviousQuality.Level.String()
+		}
+		event.Type = models.SessionEventQualityChanged
+		event.Quality = quality
+
+	case encoder.PipelineEventDevice:
+		if pe.Device == nil || !deviceSessionEvent(pe.Device, event) {
+			return
+		}
+
+	default:
+		return
+	}
+
+	if event.Type != models.SessionEventClipboard {
+		logger.Info("session_event",
+			zap.String("session_id", session.ID),
+			zap.String("device_id", session.DeviceID),
+			zap.String("event", event.Type),
+		)
+	}
+
+	h.PublishSessionEvent(session.ID, event)
+}
+
+// deviceSessionEvent 把采集的设备事件填入服务端事件，返回是否需要推送
+func deviceSessionEvent(de *capture.DeviceEvent, event *models.SessionEvent) bool {
+	switch de.Type {
+	case capture.DeviceEventDisconnected:
+		event.Type = models.SessionEventDeviceDisconnected
+		event.Device = &models.DeviceEvent{Reconnecting: de.Reconnecting}
+	case capture.DeviceEventReconnectAttempt:
+		event.Type = models.SessionEventDeviceReconnecting
+		if de.Success {
+			event.Type = models.SessionEventDeviceReconnected
+		}
+		event.Device = &models.DeviceEvent{Attempt: de.Attempt, MaxAttempts: de.MaxAttempts}
+	case capture.DeviceEventClipboard:
+		event.Type = models.SessionEventClipboard
+		event.Clipboard = &models.ClipboardEvent{Text: de.Text}
+	default:
+		return false
+	}
+	return true
+}
+
+// sessionVideoCodec 会话视频轨道的编码
+func sessionVideoCodec(session *models.Session) webrtc.VideoCodecType {
+	if info := session.GetVideoPipelineInfo(); info != nil && info.Codec != "" {
+		return webrtc.VideoCodecType(info.Codec)
+	}
+	if session.VideoTrack != nil && session.VideoTrack.Codec().MimeType == pionWebRTC.MimeTypeH264 {
+		return webrtc.VideoCodecH264
+	}
+	return webrtc.VideoCodecVP8
+}
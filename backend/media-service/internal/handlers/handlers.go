@@ -4,15 +4,19 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"strings"
 
+	"github.com/cloudphone/media-service/internal/admission"
 	"github.com/cloudphone/media-service/internal/capture"
+	"github.com/cloudphone/media-service/internal/deviceaccess"
 	"github.com/cloudphone/media-service/internal/encoder"
 	"github.com/cloudphone/media-service/internal/logger"
+	"github.com/cloudphone/media-service/internal/middleware"
 	"github.com/cloudphone/media-service/internal/models"
 	"github.com/cloudphone/media-service/internal/webrtc"
 	"github.com/cloudphone/media-service/internal/websocket"
@@ -43,9 +47,14 @@ type Handler struct {
 	wsHub               *websocket.Hub
 	pipelineManager     *encoder.PipelineManager
 	adbPath             string
-	scrcpyServerPath    string                 // scrcpy-server.jar 路径
-	useScrcpy           bool                   // 是否优先使用 scrcpy（高性能 H.264）
-	combinedFrameWriter *CombinedFrameWriter   // 组合帧写入器（支持录像）
+	scrcpyServerPath    string                   // scrcpy-server.jar 路径
+	useScrcpy           bool                     // 是否优先使用 scrcpy（高性能 H.264）
+	h264Fallback        bool                     // 是否启用 H.264 回退链（scrcpy → screenrecord → screencap + H.264）
+	pipelineBuilder     *encoder.PipelineBuilder // 根据声明式规格构建采集、编码和写入器
+	combinedFrameWriter *CombinedFrameWriter     // 组合帧写入器（支持录像）
+	signaling           *signalingRegistry       // WebSocket 信令连接（推送 session_closed）
+	deviceAccess        *deviceaccess.Checker    // 通过 device-service 校验设备访问权限（nil 时不校验）
+	admission           *admission.Controller    // 会话配额和主机准入控制（nil 时不限制）
 	logger              *logrus.Logger
 }
 
@@ -67,6 +76,21 @@ func WithUseScrcpy(use bool) HandlerOption {
 	}
 }
 
+// WithH264Fallback 设置是否启用 H.264 回退链
+// 启用后所有会话使用 H.264 轨道，视频源依次尝试 scrcpy、screenrecord、screencap + 服务端 H.264 编码
+func WithH264Fallback(enabled bool) HandlerOption {
+	return func(h *Handler) {
+		h.h264Fallback = enabled
+	}
+}
+
+// WithPipelineBuilder 设置管道构建器（未设置时根据 scrcpy/回退链选项创建默认构建器）
+func WithPipelineBuilder(builder *encoder.PipelineBuilder) HandlerOption {
+	return func(h *Handler) {
+		h.pipelineBuilder = builder
+	}
+}
+
 // WithCombinedFrameWriter 设置组合帧写入器（支持录像功能）
 func WithCombinedFrameWriter(cfw *CombinedFrameWriter) HandlerOption {
 	return func(h *Handler) {
@@ -74,6 +98,20 @@ func WithCombinedFrameWriter(cfw *CombinedFrameWriter) HandlerOption {
 	}
 }
 
+// WithDeviceAccessChecker 设置设备访问检查器（创建会话前校验用户对设备的控制权限）
+func WithDeviceAccessChecker(checker *deviceaccess.Checker) HandlerOption {
+	return func(h *Handler) {
+		h.deviceAccess = checker
+	}
+}
+
+// WithAdmissionController 设置准入控制器（创建会话前检查配额和主机负载）
+func WithAdmissionController(controller *admission.Controller) HandlerOption {
+	return func(h *Handler) {
+		h.admission = controller
+	}
+}
+
 // New 创建新的处理器
 func New(webrtcMgr webrtc.WebRTCManager, hub *websocket.Hub, pipelineMgr *encoder.PipelineManager, adbPath string, opts ...HandlerOption) *Handler {
 	h := &Handler{
@@ -81,6 +119,7 @@ func New(webrtcMgr webrtc.WebRTCManager, hub *websocket.Hub, pipelineMgr *encode
 		wsHub:           hub,
 		pipelineManager: pipelineMgr,
 		adbPath:         adbPath,
+		signaling:       newSignalingRegistry(),
 		logger:          logrus.New(),
 	}
 
@@ -89,13 +128,33 @@ func New(webrtcMgr webrtc.WebRTCManager, hub *websocket.Hub, pipelineMgr *encode
 		opt(h)
 	}
 
+	if h.pipelineBuilder == nil {
+		h.pipelineBuilder = newDefaultPipelineBuilder(h.adbPath, h.scrcpyServerPath, h.useScrcpy, h.h264Fallback, h.logger)
+	}
+
+	// 会话关闭（ICE 失败、超时清理等）时停止管道并通知信令连接
+	h.webrtcManager.OnSessionClosed(h.onSessionClosed)
+	// ICE 中断后重新连接时请求关键帧
+	h.webrtcManager.OnSessionResumed(h.onSessionResumed)
+	// 控制权变化和请求推送给相关会话的客户端
+	h.webrtcManager.OnSessionRoleChanged(h.onSessionRoleChanged)
+	h.webrtcManager.OnControlRequested(h.onControlRequested)
+	// 采集状态、质量变化和设备事件推送给会话的客户端
+	if h.pipelineManager != nil {
+		h.pipelineManager.OnPipelineEvent(h.onPipelineEvent)
+	}
+
 	return h
 }
 
 // CreateSessionRequest 创建会话请求
 type CreateSessionRequest struct {
 	DeviceID string `json:"deviceId" binding:"required"`
-	UserID   string `json:"userId" binding:"required"`
+	// UserID 已废弃，会话归属以 JWT 用户为准（提供时必须一致）
+	UserID string `json:"userId"`
+	// Offer 客户端发起协商时的 SDP offer（Safari、原生 SDK 等需要自行控制 transceiver 和编码）
+	// 提供时服务端返回 answer 并立即启动视频管道，无需再调用 /sessions/answer
+	Offer *pionWebRTC.SessionDescription `json:"offer,omitempty"`
 }
 
 // ICEServerDTO ICE 服务器 DTO（用于 JSON 序列化）
@@ -108,8 +167,13 @@ type ICEServerDTO struct {
 // CreateSessionResponse 创建会话响应
 type CreateSessionResponse struct {
 	SessionID  string                        `json:"sessionId"`
-	Offer      *pionWebRTC.SessionDescription `json:"offer"`
+	Offer      *pionWebRTC.SessionDescription `json:"offer,omitempty"`
+	Answer     *pionWebRTC.SessionDescription `json:"answer,omitempty"` // 客户端发起协商时返回
 	ICEServers []ICEServerDTO                `json:"iceServers"` // 前端必须使用这些 ICE 服务器以确保 TURN 凭证匹配
+	// ResumeToken 会话恢复令牌，网络切换后通过 POST /sessions/:id/resume 恢复会话（每次恢复后轮换）
+	ResumeToken string `json:"resumeToken"`
+	// Role 会话角色：viewer 不能通过数据通道注入输入，可以请求控制权
+	Role models.SessionRole `json:"role"`
 }
 
 // HandleCreateSession 创建新的 WebRTC 会话
@@ -127,57 +191,115 @@ func (h *Handler) HandleCreateSession(c *gin.Context) {
 		return
 	}
 
-	// 根据配置选择视频编码类型
-	// scrcpy 模式使用 H.264（硬件加速，性能更好）
-	// screencap 模式使用 VP8（兼容性好）
-	videoCodec := webrtc.VideoCodecVP8
-	if h.useScrcpy {
-		videoCodec = webrtc.VideoCodecH264
+	userID, tenantID, ok := requestUser(c, req.UserID)
+	if !ok {
+		span.SetStatus(codes.Error, "unauthorized user")
+		return
+	}
+
+	// 有控制权限的用户创建 owner 会话，只有观看权限的用户创建 viewer 会话
+	level, ok := authorizeDeviceLevel(c, h.deviceAccess, req.DeviceID, deviceaccess.LevelView)
+	if !ok {
+		span.SetStatus(codes.Error, "device access denied")
+		return
 	}
+	role := sessionRoleFor(level)
 
 	// 添加业务相关 attributes
 	span.SetAttributes(
 		attribute.String("device.id", req.DeviceID),
-		attribute.String("user.id", req.UserID),
+		attribute.String("user.id", userID),
 		attribute.String("session.type", "webrtc"),
-		attribute.String("video.codec", string(videoCodec)),
-		attribute.Bool("use_scrcpy", h.useScrcpy),
+		attribute.String("session.role", string(role)),
 	)
 
 	// 创建会话（根据模式选择编码类型）
-	session, err := h.webrtcManager.CreateSessionWithOptions(req.DeviceID, req.UserID, webrtc.SessionOptions{
-		VideoCodec: videoCodec,
-	})
+	session, plan, err := h.createSessionForDevice(req.DeviceID, userID, tenantID, role, req.Offer)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "failed to create session")
 		logger.Error("failed_to_create_session",
 			zap.String("device_id", req.DeviceID),
-			zap.String("user_id", req.UserID),
+			zap.String("user_id", userID),
 			zap.Error(err),
 		)
+		if errors.Is(err, webrtc.ErrNoCommonCodec) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if writeAdmissionRejected(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
 		return
 	}
 
 	// 记录 session ID
-	span.SetAttributes(attribute.String("session.id", session.ID))
+	span.SetAttributes(
+		attribute.String("session.id", session.ID),
+		attribute.String("video.codec", string(trackCodecFor(plan.Codec))),
+		attribute.StringSlice("video.fallback_chain", plan.CaptureModes()),
+	)
 
-	// 创建 offer
-	offer, err := h.webrtcManager.CreateOffer(session.ID)
-	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, "failed to create offer")
-		logger.Error("failed_to_create_offer",
-			zap.String("session_id", session.ID),
-			zap.Error(err),
-		)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create offer"})
-		return
+	var offer, answer *pionWebRTC.SessionDescription
+	if req.Offer != nil {
+		// 客户端发起协商：返回 answer 后即可建立连接，直接启动视频管道
+		answer, err = h.webrtcManager.CreateAnswer(session.ID, *req.Offer, nil)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to create answer")
+			logger.Error("failed_to_create_answer",
+				zap.String("session_id", session.ID),
+				zap.Error(err),
+			)
+			h.closeSession(session.ID)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to process offer"})
+			return
+		}
+		if h.pipelineManager != nil {
+			go h.startVideoPipeline(context.Background(), session)
+		}
+	} else {
+		// 创建 offer
+		offer, err = h.webrtcManager.CreateOffer(session.ID)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to create offer")
+			logger.Error("failed_to_create_offer",
+				zap.String("session_id", session.ID),
+				zap.Error(err),
+			)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create offer"})
+			return
+		}
 	}
 
 	// 获取 ICE 服务器配置（包含 TURN 凭证）
 	// 前端必须使用这些服务器以确保 TURN 凭证与后端匹配
+	iceServerDTOs := h.iceServerDTOs()
+
+	span.SetStatus(codes.Ok, "session created successfully")
+	logger.Info("session_created",
+		zap.String("session_id", session.ID),
+		zap.String("device_id", req.DeviceID),
+		zap.String("user_id", userID),
+		zap.String("role", string(role)),
+		zap.Int("ice_servers", len(iceServerDTOs)),
+		zap.Bool("client_offer", req.Offer != nil),
+	)
+
+	c.JSON(http.StatusOK, CreateSessionResponse{
+		SessionID:   session.ID,
+		Offer:       offer,
+		Answer:      answer,
+		ICEServers:  iceServerDTOs,
+		ResumeToken: session.GetResumeToken(),
+		Role:        session.GetRole(),
+	})
+}
+
+// iceServerDTOs 返回 ICE 服务器配置（包含 TURN 凭证）
+func (h *Handler) iceServerDTOs() []ICEServerDTO {
 	iceServers := h.webrtcManager.GetICEServers()
 	iceServerDTOs := make([]ICEServerDTO, len(iceServers))
 	for i, server := range iceServers {
@@ -193,20 +315,67 @@ func (h *Handler) HandleCreateSession(c *gin.Context) {
 			Credential: credential,
 		}
 	}
+	return iceServerDTOs
+}
 
-	span.SetStatus(codes.Ok, "session created successfully")
-	logger.Info("session_created",
-		zap.String("session_id", session.ID),
-		zap.String("device_id", req.DeviceID),
-		zap.String("user_id", req.UserID),
-		zap.Int("ice_servers", len(iceServerDTOs)),
-	)
+// createSessionForDevice 根据租户/设备规格选择视频编码类型并创建会话
+// 轨道编码必须与回退链的输出一致：
+// scrcpy / screenrecord 回退链使用 H.264（设备端硬件编码或服务端 H.264 编码），
+// 仅 screencap 模式按编码器类型选择（默认 VP8，兼容性好）。
+// offer 非 nil 时（客户端发起协商）从 offer 与采集可输出编码的交集中选择，优先回退链的默认编码。
+// role 由用户对设备的访问级别决定（见 sessionRoleFor）。
+func (h *Handler) createSessionForDevice(deviceID, userID, tenantID string, role models.SessionRole, offer *pionWebRTC.SessionDescription) (*models.Session, *encoder.PipelinePlan, error) {
+	spec := h.pipelineBuilder.Resolve(tenantID, deviceID)
+	plan := h.pipelineBuilder.Plan(spec, "")
+	opts := webrtc.SessionOptions{
+		VideoCodec: trackCodecFor(plan.Codec),
+		TenantID:   tenantID,
+		Role:       role,
+	}
+
+	if offer != nil {
+		// screencap 服务端编码可输出任意编码，两种编码都可以作为备选
+		preferred := []webrtc.VideoCodecType{opts.VideoCodec}
+		for _, codec := range []webrtc.VideoCodecType{webrtc.VideoCodecH264, webrtc.VideoCodecVP8} {
+			if codec != opts.VideoCodec {
+				preferred = append(preferred, codec)
+			}
+		}
 
-	c.JSON(http.StatusOK, CreateSessionResponse{
-		SessionID:  session.ID,
-		Offer:      offer,
-		ICEServers: iceServerDTOs,
-	})
+		videoCodec, params, err := webrtc.NegotiateVideoCodec(*offer, preferred)
+		if err != nil {
+			return nil, plan, err
+		}
+		if videoCodec != opts.VideoCodec {
+			plan = h.pipelineBuilder.Plan(spec, streamCodecFor(videoCodec))
+		}
+		opts.VideoCodec = videoCodec
+		opts.OfferedVideoCodec = &params
+	}
+	opts.VideoBitrate = plan.MaxBitrate()
+
+	// 准入检查通过后预留名额，会话关闭时在 onSessionClosed 中释放
+	var reservation *admission.Reservation
+	if h.admission != nil {
+		var err error
+		reservation, err = h.admission.Admit(admission.Request{UserID: userID, TenantID: tenantID, DeviceID: deviceID})
+		if err != nil {
+			return nil, plan, err
+		}
+	}
+
+	session, err := h.webrtcManager.CreateSessionWithOptions(deviceID, userID, opts)
+	if err != nil {
+		if reservation != nil {
+			reservation.Cancel()
+		}
+		return nil, plan, err
+	}
+	if reservation != nil {
+		reservation.Commit(session.ID)
+	}
+
+	return session, plan, nil
 }
 
 // SetAnswerRequest 设置 Answer 请求
@@ -234,7 +403,13 @@ func (h *Handler) HandleSetAnswer(c *gin.Context) {
 		attribute.String("sdp.type", req.Answer.Type.String()),
 	)
 
-	if err := h.webrtcManager.HandleAnswer(req.SessionID, req.Answer); err != nil {
+	session, ok := h.authorizedSession(c, req.SessionID)
+	if !ok {
+		span.SetStatus(codes.Error, "session not accessible")
+		return
+	}
+
+	if err := h.webrtcManager.HandleAnswer(session.ID, req.Answer); err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "failed to handle answer")
 		logger.Error("failed_to_handle_answer",
@@ -245,15 +420,10 @@ func (h *Handler) HandleSetAnswer(c *gin.Context) {
 		return
 	}
 
-	// 获取 session 以启动视频管道
-	session, err := h.webrtcManager.GetSession(req.SessionID)
-	if err != nil {
-		logger.Warn("failed_to_get_session_for_pipeline",
-			zap.String("session_id", req.SessionID),
-			zap.Error(err),
-		)
-	} else if h.pipelineManager != nil {
+	// 启动视频管道
+	if h.pipelineManager != nil && !h.hasVideoPipeline(session.ID) {
 		// 在后台启动视频管道（不阻塞 HTTP 响应）
+		// 重新协商或 ICE restart 的 answer 不重复启动
 		// 重要：使用 context.Background() 而不是 HTTP 请求的 context
 		// 因为请求 context 在响应发送后会被取消，导致管道立即停止
 		pipelineCtx := context.Background()
@@ -269,61 +439,30 @@ func (h *Handler) HandleSetAnswer(c *gin.Context) {
 }
 
 // startVideoPipeline 启动视频管道（在后台运行）
+// 管道由 PipelineBuilder 根据会话的租户/设备规格构建，按回退链依次尝试视频源；
+// 选择结果记录在 session.VideoPipeline 中，可通过 HandleGetSession 查询
 func (h *Handler) startVideoPipeline(ctx context.Context, session *models.Session) {
 	sessionID := session.ID
 	deviceID := session.DeviceID
 
+	// 轨道编码在创建会话时已确定，回退链只保留能输出该编码的视频源
+	codec := encoder.StreamCodecVP8
+	if session.VideoTrack != nil && session.VideoTrack.Codec().MimeType == pionWebRTC.MimeTypeH264 {
+		codec = encoder.StreamCodecH264
+	}
+	spec := h.pipelineBuilder.Resolve(session.TenantID, deviceID)
+	plan := h.pipelineBuilder.Plan(spec, codec)
+
 	logger.Info("starting_video_pipeline",
 		zap.String("session_id", sessionID),
 		zap.String("device_id", deviceID),
-		zap.Bool("use_scrcpy", h.useScrcpy),
-		zap.String("scrcpy_server", h.scrcpyServerPath),
+		zap.String("tenant_id", session.TenantID),
+		zap.String("capture_mode", string(spec.CaptureMode)),
+		zap.String("encoder_type", string(spec.EncoderType)),
+		zap.Strings("fallback_chain", plan.CaptureModes()),
+		zap.String("video_codec", string(codec)),
 	)
 
-	// 创建屏幕捕获实例
-	var screenCapture capture.ScreenCapture
-
-	if h.useScrcpy && h.scrcpyServerPath != "" {
-		// 使用 scrcpy-server 进行高性能 H.264 捕获
-		// scrcpy 直接在设备上进行硬件 H.264 编码，通过 WiFi ADB 可达 30+ FPS
-		// 相比 screencap PNG 模式（~1 FPS），性能提升 30 倍以上
-		logger.Info("using_scrcpy_capture",
-			zap.String("session_id", sessionID),
-			zap.String("device_id", deviceID),
-			zap.String("scrcpy_server", h.scrcpyServerPath),
-		)
-		screenCapture = capture.NewScrcpyCapture(h.adbPath, h.scrcpyServerPath, h.logger)
-	} else {
-		// 回退到 AndroidScreenCapture（screencap PNG 模式）
-		// 注意: screenrecord --output-format=h264 在某些 Android 设备上不可用
-		// screencap 兼容性更好，虽然性能稍慢但更可靠
-		logger.Info("using_screencap_capture",
-			zap.String("session_id", sessionID),
-			zap.String("device_id", deviceID),
-		)
-		screenCapture = capture.NewAndroidScreenCapture(h.adbPath, h.logger)
-	}
-
-	// 创建视频管道
-	// WebRTCManager 实现了 FrameWriter 接口
-	// WiFi ADB 分辨率优化: 降低分辨率可显著提升帧率
-	// - 原始分辨率 1440x3040 通过 WiFi ADB 传输需要 1-2 秒/帧
-	// - 降低到 720 宽度（约 50%）可将帧率提升 2-3 倍
-	//
-	// 对于 scrcpy 模式:
-	// - scrcpy 直接输出 H.264 流，目标 FPS 和码率用于服务端配置
-	// - 分辨率由 scrcpy 的 max_size 参数控制
-	targetFPS := 30              // scrcpy 可以轻松达到 30 FPS
-	targetBitrate := 4000000     // 4 Mbps 适合 WiFi 传输
-	targetWidth := 720           // 720p 分辨率
-	targetHeight := 0            // 自动计算保持宽高比
-
-	if !h.useScrcpy {
-		// screencap 模式需要更保守的参数
-		targetFPS = 15
-		targetBitrate = 2000000
-	}
-
 	// 选择帧写入器：如果启用了录像支持，使用组合写入器
 	// 组合写入器会同时将帧发送到 WebRTC 和录像文件
 	var frameWriter encoder.FrameWriter
@@ -341,37 +480,23 @@ func (h *Handler) startVideoPipeline(ctx context.Context, session *models.Sessio
 		)
 	}
 
-	err := h.pipelineManager.CreateVideoPipeline(
-		ctx,
-		sessionID,
-		deviceID,
-		screenCapture,
-		frameWriter, // 使用选定的帧写入器
-		targetFPS,
-		targetBitrate,
-		targetWidth,
-		targetHeight,
-		encoder.CreateVideoPipelineOptions{
-			UseH264Passthrough: h.useScrcpy, // scrcpy 输出 H.264，使用直通模式
-		},
-	)
+	info, err := runVideoPlan(ctx, h.pipelineManager, h.pipelineBuilder, sessionID, deviceID, plan, frameWriter,
+		session.SetVideoPipelineInfo)
 	if err != nil {
 		logger.Error("failed_to_create_video_pipeline",
 			zap.String("session_id", sessionID),
 			zap.String("device_id", deviceID),
-			zap.Bool("use_scrcpy", h.useScrcpy),
+			zap.Strings("fallback_chain", plan.CaptureModes()),
+			zap.Int("attempts", len(info.Attempts)),
 			zap.Error(err),
 		)
-		return
 	}
+}
 
-	logger.Info("video_pipeline_started",
-		zap.String("session_id", sessionID),
-		zap.String("device_id", deviceID),
-		zap.Bool("use_scrcpy", h.useScrcpy),
-		zap.Int("target_fps", targetFPS),
-		zap.Int("target_bitrate", targetBitrate),
-	)
+// hasVideoPipeline 会话的视频管道是否在运行
+func (h *Handler) hasVideoPipeline(sessionID string) bool {
+	_, err := h.pipelineManager.GetVideoPipelineStats(sessionID)
+	return err == nil
 }
 
 // AddICECandidateRequest ICE 候选请求
@@ -405,6 +530,11 @@ func (h *Handler) HandleAddICECandidate(c *gin.Context) {
 		attribute.String("ice.candidate", req.Candidate.Candidate),
 	)
 
+	if _, ok := h.authorizedSession(c, req.SessionID); !ok {
+		span.SetStatus(codes.Error, "session not accessible")
+		return
+	}
+
 	if err := h.webrtcManager.AddICECandidate(req.SessionID, req.Candidate); err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "failed to add ice candidate")
@@ -443,6 +573,11 @@ func (h *Handler) HandleAddICECandidates(c *gin.Context) {
 		attribute.Int("candidates.count", len(req.Candidates)),
 	)
 
+	if _, ok := h.authorizedSession(c, req.SessionID); !ok {
+		span.SetStatus(codes.Error, "session not accessible")
+		return
+	}
+
 	// 批量添加所有 ICE 候选
 	addedCount := 0
 	var lastErr error
@@ -511,20 +646,11 @@ func (h *Handler) HandleAddICECandidates(c *gin.Context) {
 func (h *Handler) HandleCloseSession(c *gin.Context) {
 	sessionID := c.Param("id")
 
-	// 先停止视频管道
-	if h.pipelineManager != nil {
-		if err := h.pipelineManager.StopAllPipelines(sessionID); err != nil {
-			logger.Debug("no_pipelines_to_stop",
-				zap.String("session_id", sessionID),
-			)
-		} else {
-			logger.Info("pipelines_stopped",
-				zap.String("session_id", sessionID),
-			)
-		}
+	if _, ok := h.authorizedSession(c, sessionID); !ok {
+		return
 	}
 
-	if err := h.webrtcManager.CloseSession(sessionID); err != nil {
+	if err := h.closeSession(sessionID); err != nil {
 		logger.Warn("failed_to_close_session",
 			zap.String("session_id", sessionID),
 			zap.Error(err),
@@ -540,37 +666,74 @@ func (h *Handler) HandleCloseSession(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
+// authorizedSession 查找会话并校验当前用户可以访问（会话不存在返回 404，无权访问返回 403）
+func (h *Handler) authorizedSession(c *gin.Context, sessionID string) (*models.Session, bool) {
+	session, err := h.webrtcManager.GetSession(sessionID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return nil, false
+	}
+	if !authorizeResource(c, "session", session.ID, session.UserID, session.TenantID) {
+		return nil, false
+	}
+	return session, true
+}
+
+// closeSession 停止会话的所有管道并关闭会话
+func (h *Handler) closeSession(sessionID string) error {
+	// 先停止视频管道
+	if h.pipelineManager != nil {
+		if err := h.pipelineManager.StopAllPipelines(sessionID); err != nil {
+			logger.Debug("no_pipelines_to_stop",
+				zap.String("session_id", sessionID),
+			)
+		} else {
+			logger.Info("pipelines_stopped",
+				zap.String("session_id", sessionID),
+			)
+		}
+	}
+
+	return h.webrtcManager.CloseSession(sessionID)
+}
+
 // HandleGetSession 获取会话信息
 func (h *Handler) HandleGetSession(c *gin.Context) {
 	sessionID := c.Param("id")
 
-	session, err := h.webrtcManager.GetSession(sessionID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+	session, ok := h.authorizedSession(c, sessionID)
+	if !ok {
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"sessionId":  session.ID,
-		"deviceId":   session.DeviceID,
-		"userId":     session.UserID,
-		"state":      session.GetState(),
-		"createdAt":  session.CreatedAt,
-		"lastActive": session.LastActivityAt,
+		"sessionId":     session.ID,
+		"deviceId":      session.DeviceID,
+		"userId":        session.UserID,
+		"state":         session.GetState(),
+		"role":          session.GetRole(),
+		"createdAt":     session.CreatedAt,
+		"lastActive":    session.LastActivityAt,
+		"videoPipeline": session.GetVideoPipelineInfo(),
+		"transport":     session.GetTransportStats(),
 	})
 }
 
-// HandleListSessions 列出所有会话
+// HandleListSessions 列出当前用户可以访问的会话（管理员可以看到同租户所有会话）
 func (h *Handler) HandleListSessions(c *gin.Context) {
 	sessions := h.webrtcManager.GetAllSessions()
 
 	var result []map[string]interface{}
 	for _, session := range sessions {
+		if !canAccess(c, session.UserID, session.TenantID) {
+			continue
+		}
 		result = append(result, map[string]interface{}{
 			"sessionId":  session.ID,
 			"deviceId":   session.DeviceID,
 			"userId":     session.UserID,
 			"state":      session.GetState(),
+			"role":       session.GetRole(),
 			"createdAt":  session.CreatedAt,
 			"lastActive": session.LastActivityAt,
 		})
@@ -583,14 +746,16 @@ func (h *Handler) HandleListSessions(c *gin.Context) {
 }
 
 // HandleWebSocket 处理 WebSocket 连接
+// 连接承载信令协议（见 signaling.go）：创建会话、offer/answer、双向 trickle ICE、重新协商和关闭通知
 func (h *Handler) HandleWebSocket(c *gin.Context) {
-	userID := c.Query("userId")
 	deviceID := c.Query("deviceId")
 
-	if userID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "userId is required"})
+	// 连接上的所有会话归属 JWT 用户（?userId= 已废弃，提供时必须一致）
+	userID, tenantID, ok := requestUser(c, c.Query("userId"))
+	if !ok {
 		return
 	}
+	userCtx, _ := middleware.GetUserContext(c)
 
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
@@ -607,7 +772,21 @@ func (h *Handler) HandleWebSocket(c *gin.Context) {
 		zap.String("device_id", deviceID),
 	)
 
-	websocket.ServeWs(h.wsHub, conn, userID, deviceID)
+	sc := newSignalingConn(userID, tenantID, userCtx)
+	client := websocket.ServeWs(h.wsHub, conn, userID, deviceID,
+		websocket.WithMessageHandler(func(client *websocket.Client, message []byte) {
+			sc.attach(client)
+			h.handleSignalingMessage(sc, message)
+		}),
+		websocket.WithCloseHandler(func(*websocket.Client) {
+			h.signaling.detach(sc)
+			logger.Info("websocket_disconnected",
+				zap.String("user_id", userID),
+				zap.String("device_id", deviceID),
+			)
+		}),
+	)
+	sc.attach(client)
 }
 
 // HandleStats 获取统计信息
@@ -628,6 +807,7 @@ func (h *Handler) HandleStats(c *gin.Context) {
 
 	// Get frame pool statistics
 	framePoolStats := capture.DefaultFramePool.Stats()
+	encoderStats := h.pipelineManager.GetEncoderProcessStats()
 
 	c.JSON(http.StatusOK, gin.H{
 		"totalSessions":      len(sessions),
@@ -640,9 +820,25 @@ func (h *Handler) HandleStats(c *gin.Context) {
 			"reuses":      framePoolStats.Reuses,
 			"reuseRate":   fmt.Sprintf("%.1f%%", framePoolStats.ReuseRate()*100),
 		},
+		"encoderProcesses": gin.H{
+			"active":   encoderStats.ActiveProcesses,
+			"max":      encoderStats.MaxProcesses,
+			"rejected": encoderStats.Rejected,
+			"restarts": encoderStats.Restarts,
+		},
+		"admission": h.admissionStats(),
 	})
 }
 
+// admissionStats 准入控制利用率（未配置时为 nil）
+func (h *Handler) admissionStats() *admission.Stats {
+	if h.admission == nil {
+		return nil
+	}
+	stats := h.admission.Stats()
+	return &stats
+}
+
 // HandleHealth 健康检查
 func (h *Handler) HandleHealth(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
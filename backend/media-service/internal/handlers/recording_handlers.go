@@ -9,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/cloudphone/media-service/internal/models"
 	"github.com/cloudphone/media-service/internal/recording"
 	"github.com/cloudphone/media-service/internal/webrtc"
 	"github.com/gin-gonic/gin"
@@ -19,7 +20,8 @@ import (
 type RecordingHandler struct {
 	manager             *recording.Manager
 	webrtcManager       *webrtc.Manager
-	combinedFrameWriter *CombinedFrameWriter // 组合帧写入器，用于关联录像和 WebRTC
+	combinedFrameWriter *CombinedFrameWriter  // 组合帧写入器，用于关联录像和 WebRTC
+	events              SessionEventPublisher // 录像开始/停止事件推送（nil 时不推送）
 	logger              *zap.Logger
 }
 
@@ -40,6 +42,13 @@ func WithCombinedFrameWriterForRecording(cfw *CombinedFrameWriter) RecordingHand
 	}
 }
 
+// WithRecordingEventPublisher 设置服务端事件推送（通知会话客户端录像开始/停止）
+func WithRecordingEventPublisher(events SessionEventPublisher) RecordingHandlerOption {
+	return func(h *RecordingHandler) {
+		h.events = events
+	}
+}
+
 // NewRecordingHandler 创建录像处理器
 func NewRecordingHandler(
 	manager *recording.Manager,
@@ -71,6 +80,11 @@ func (h *RecordingHandler) HandleStartRecording(c *gin.Context) {
 		return
 	}
 
+	userCtx, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
 	// 获取会话信息以获取视频分辨率
 	session, err := h.webrtcManager.GetSession(req.SessionID)
 	if err != nil {
@@ -81,6 +95,13 @@ func (h *RecordingHandler) HandleStartRecording(c *gin.Context) {
 		return
 	}
 
+	// 只能录制自己的会话（管理员除外），录像归属发起录像的用户
+	if !authorizeResource(c, "session", session.ID, session.UserID, session.TenantID) {
+		return
+	}
+	req.UserID = userCtx.UserID
+	req.TenantID = userCtx.TenantID
+
 	// 使用默认分辨率（如果无法从会话获取）
 	width := 1280
 	height := 720
@@ -120,6 +141,13 @@ func (h *RecordingHandler) HandleStartRecording(c *gin.Context) {
 		zap.String("device_id", req.DeviceID),
 	)
 
+	if h.events != nil {
+		h.events.PublishSessionEvent(req.SessionID, &models.SessionEvent{
+			Type:      models.SessionEventRecordingStarted,
+			Recording: &models.RecordingEvent{RecordingID: rec.ID},
+		})
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"recording": rec.ToInfo(h.manager.GetBaseURL()),
 	})
@@ -137,6 +165,10 @@ func (h *RecordingHandler) HandleStopRecording(c *gin.Context) {
 		return
 	}
 
+	if !h.authorizeRecording(c, recordingID) {
+		return
+	}
+
 	// 先获取录像信息以获取 sessionID
 	recBefore, err := h.manager.GetRecording(recordingID)
 	if err == nil && h.combinedFrameWriter != nil {
@@ -167,6 +199,17 @@ func (h *RecordingHandler) HandleStopRecording(c *gin.Context) {
 		zap.Int64("file_size", rec.FileSize),
 	)
 
+	if h.events != nil {
+		h.events.PublishSessionEvent(rec.SessionID, &models.SessionEvent{
+			Type: models.SessionEventRecordingStopped,
+			Recording: &models.RecordingEvent{
+				RecordingID: rec.ID,
+				DurationMs:  rec.Duration.Milliseconds(),
+				FileSize:    rec.FileSize,
+			},
+		})
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"recording": rec.ToInfo(h.manager.GetBaseURL()),
 	})
@@ -184,6 +227,10 @@ func (h *RecordingHandler) HandleGetRecording(c *gin.Context) {
 		return
 	}
 
+	if !h.authorizeRecording(c, recordingID) {
+		return
+	}
+
 	rec, err := h.manager.GetRecording(recordingID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
@@ -198,13 +245,16 @@ func (h *RecordingHandler) HandleGetRecording(c *gin.Context) {
 	})
 }
 
-// HandleListRecordings 列出所有活跃录像
+// HandleListRecordings 列出当前用户可以访问的活跃录像
 // GET /api/media/recordings
 func (h *RecordingHandler) HandleListRecordings(c *gin.Context) {
 	recordings := h.manager.ListActiveRecordings()
 
 	var infos []recording.RecordingInfo
 	for _, rec := range recordings {
+		if !canAccess(c, rec.UserID, rec.TenantID) {
+			continue
+		}
 		infos = append(infos, rec.ToInfo(h.manager.GetBaseURL()))
 	}
 
@@ -226,6 +276,10 @@ func (h *RecordingHandler) HandleDownloadRecording(c *gin.Context) {
 		return
 	}
 
+	if !h.authorizeRecording(c, recordingID) {
+		return
+	}
+
 	filePath, err := h.manager.GetRecordingFilePath(recordingID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
@@ -267,6 +321,10 @@ func (h *RecordingHandler) HandleDeleteRecording(c *gin.Context) {
 		return
 	}
 
+	if !h.authorizeRecording(c, recordingID) {
+		return
+	}
+
 	// 先停止录像（如果还在进行中）
 	h.manager.StopRecording(recordingID)
 
@@ -293,6 +351,7 @@ func (h *RecordingHandler) HandleDeleteRecording(c *gin.Context) {
 		})
 		return
 	}
+	os.Remove(recording.MetadataPath(filePath))
 
 	h.logger.Info("recording_deleted",
 		zap.String("recording_id", recordingID),
@@ -344,6 +403,19 @@ func (h *RecordingHandler) HandleCleanupRecordings(c *gin.Context) {
 	})
 }
 
+// authorizeRecording 校验当前用户可以访问录像（不存在返回 404，无权访问返回 403）
+func (h *RecordingHandler) authorizeRecording(c *gin.Context, recordingID string) bool {
+	userID, tenantID, err := h.manager.GetRecordingOwner(recordingID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "recording_not_found",
+			"message": err.Error(),
+		})
+		return false
+	}
+	return authorizeResource(c, "recording", recordingID, userID, tenantID)
+}
+
 // HandleWriteFrame 内部接口：写入视频帧
 // 这个方法不作为 HTTP 端点暴露，而是被视频管道调用
 func (h *RecordingHandler) WriteFrame(recordingID string, frame []byte, timestamp time.Duration, keyframe bool) error {
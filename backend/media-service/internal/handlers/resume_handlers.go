@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/cloudphone/media-service/internal/logger"
+	"github.com/cloudphone/media-service/internal/webrtc"
+	"github.com/gin-gonic/gin"
+	pionWebRTC "github.com/pion/webrtc/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.uber.org/zap"
+)
+
+// =============================================================================
+// ICE restart 与会话恢复
+// =============================================================================
+//
+// 网络切换（Wi-Fi ↔ 4G）后 ICE 连接中断，会话进入 suspended 状态并保留
+// SESSION_RESUME_GRACE_SECONDS 秒：PeerConnection、视频管道和录像继续运行，
+// 只是不再向 WebRTC 轨道写入帧。客户端在宽限期内重启 ICE 即可恢复，
+// 重新连接后服务端请求关键帧，客户端无需等待下一个 GOP。
+//
+//	POST /sessions/:id/ice-restart  {"offer"?}                 信令仍然可用时
+//	POST /sessions/:id/resume       {"resumeToken","offer"?}   信令也已断开（如页面切后台后重新连接）
+//
+// 不带 offer 时返回服务端 ICE restart offer，客户端通过 POST /sessions/answer 回复；
+// 带客户端 ICE restart offer（createOffer({iceRestart: true})）时直接返回 answer。
+// 恢复令牌在创建会话时返回，每次恢复后轮换，旧令牌失效。
+
+// ICERestartRequest ICE restart 请求
+type ICERestartRequest struct {
+	// Offer 客户端 ICE restart offer（可选）
+	Offer *pionWebRTC.SessionDescription `json:"offer,omitempty"`
+}
+
+// ResumeSessionRequest 会话恢复请求
+type ResumeSessionRequest struct {
+	ResumeToken string                         `json:"resumeToken" binding:"required"`
+	Offer       *pionWebRTC.SessionDescription `json:"offer,omitempty"`
+}
+
+// ICERestartResponse ICE restart / 会话恢复响应
+type ICERestartResponse struct {
+	SessionID   string                         `json:"sessionId"`
+	Offer       *pionWebRTC.SessionDescription `json:"offer,omitempty"`       // 服务端 ICE restart offer
+	Answer      *pionWebRTC.SessionDescription `json:"answer,omitempty"`      // 客户端提供 offer 时返回
+	ResumeToken string                         `json:"resumeToken,omitempty"` // 仅会话恢复：轮换后的新令牌
+	ICEServers  []ICEServerDTO                 `json:"iceServers,omitempty"`  // 仅会话恢复：TURN 凭证可能已过期
+}
+
+// HandleICERestart 重启会话的 ICE
+func (h *Handler) HandleICERestart(c *gin.Context) {
+	ctx := c.Request.Context()
+	ctx, span := tracer.Start(ctx, "webrtc.ice_restart")
+	defer span.End()
+
+	sessionID := c.Param("id")
+	span.SetAttributes(attribute.String("session.id", sessionID))
+
+	// 请求体可以为空（服务端生成 offer）
+	var req ICERestartRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid request")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, ok := h.authorizedSession(c, sessionID); !ok {
+		span.SetStatus(codes.Error, "session not accessible")
+		return
+	}
+
+	resp, err := h.restartICE(sessionID, req.Offer)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to restart ice")
+		logger.Error("failed_to_restart_ice",
+			zap.String("session_id", sessionID),
+			zap.Error(err),
+		)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to restart ICE"})
+		return
+	}
+
+	span.SetStatus(codes.Ok, "ice restarted")
+	logger.Info("ice_restart_started",
+		zap.String("session_id", sessionID),
+		zap.Bool("client_offer", req.Offer != nil),
+	)
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// HandleResumeSession 凭恢复令牌恢复会话并重启 ICE
+func (h *Handler) HandleResumeSession(c *gin.Context) {
+	ctx := c.Request.Context()
+	ctx, span := tracer.Start(ctx, "webrtc.resume_session")
+	defer span.End()
+
+	sessionID := c.Param("id")
+	span.SetAttributes(attribute.String("session.id", sessionID))
+
+	var req ResumeSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid request")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 恢复令牌之外同样校验会话归属，令牌泄露也不能被其他用户使用
+	if _, ok := h.authorizedSession(c, sessionID); !ok {
+		span.SetStatus(codes.Error, "session not accessible")
+		return
+	}
+
+	resumeToken, err := h.webrtcManager.ResumeSession(sessionID, req.ResumeToken)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to resume session")
+		logger.Warn("failed_to_resume_session",
+			zap.String("session_id", sessionID),
+			zap.Error(err),
+		)
+		if errors.Is(err, webrtc.ErrInvalidResumeToken) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid resume token"})
+			return
+		}
+		// 宽限期已过，会话已关闭
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	resp, err := h.restartICE(sessionID, req.Offer)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to restart ice")
+		logger.Error("failed_to_restart_ice",
+			zap.String("session_id", sessionID),
+			zap.Error(err),
+		)
+		// 令牌已轮换，仍返回给客户端以便重试
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to restart ICE", "resumeToken": resumeToken})
+		return
+	}
+	resp.ResumeToken = resumeToken
+	resp.ICEServers = h.iceServerDTOs()
+
+	span.SetStatus(codes.Ok, "session resumed")
+	logger.Info("session_resumed",
+		zap.String("session_id", sessionID),
+		zap.Bool("client_offer", req.Offer != nil),
+	)
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// restartICE 重启 ICE（等待 gathering 完成，offer/answer 包含全部候选）
+func (h *Handler) restartICE(sessionID string, offer *pionWebRTC.SessionDescription) (*ICERestartResponse, error) {
+	if offer != nil && offer.Type != pionWebRTC.SDPTypeOffer {
+		return nil, errors.New("sdp must be an offer")
+	}
+
+	sdp, err := h.webrtcManager.RestartICE(sessionID, offer, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &ICERestartResponse{SessionID: sessionID}
+	if offer != nil {
+		resp.Answer = sdp
+	} else {
+		resp.Offer = sdp
+	}
+	return resp, nil
+}
+
+// onSessionResumed ICE 中断后重新连接，请求关键帧使客户端立即恢复画面
+func (h *Handler) onSessionResumed(sessionID string) {
+	logger.Info("session_ice_reconnected",
+		zap.String("session_id", sessionID),
+	)
+
+	if h.pipelineManager == nil {
+		return
+	}
+	if err := h.pipelineManager.RequestKeyframe(sessionID); err != nil {
+		logger.Debug("keyframe_request_failed",
+			zap.String("session_id", sessionID),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,515 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/cloudphone/media-service/internal/admission"
+	"github.com/cloudphone/media-service/internal/deviceaccess"
+	"github.com/cloudphone/media-service/internal/logger"
+	"github.com/cloudphone/media-service/internal/middleware"
+	"github.com/cloudphone/media-service/internal/sfu"
+	"github.com/gin-gonic/gin"
+	pionWebRTC "github.com/pion/webrtc/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.uber.org/zap"
+)
+
+// =============================================================================
+// SFU 房间
+// =============================================================================
+//
+// 房间让一个观看者连接同时接收多个设备（见 sfu.Room）：
+// 创建者增减设备（需要设备的观看权限，设备必须已有发布者），参与者加入后通过数据通道 "room"
+// 接收成员变化事件和重新协商的 offer。
+//
+// 房间创建者和管理员可以加入；配置了设备访问检查器时，同租户的用户对房间中的每个设备都有观看权限也可以加入
+// （空房间只有创建者和管理员可以加入）。添加设备时代表每个参与者校验观看权限，没有权限的参与者被移出房间。
+
+// CreateRoomRequest 创建房间请求
+type CreateRoomRequest struct {
+	Name string `json:"name"`
+}
+
+// AddRoomDeviceRequest 向房间添加设备请求
+type AddRoomDeviceRequest struct {
+	DeviceID string `json:"deviceId" binding:"required"`
+}
+
+// JoinRoomResponse 加入房间响应
+type JoinRoomResponse struct {
+	ParticipantID string                         `json:"participantId"`
+	RoomID        string                         `json:"roomId"`
+	Offer         *pionWebRTC.SessionDescription `json:"offer"`
+	ICEServers    []ICEServerDTO                 `json:"iceServers"`
+}
+
+// SetRoomAnswerRequest 设置房间参与者 Answer 请求
+type SetRoomAnswerRequest struct {
+	ParticipantID string                        `json:"participantId" binding:"required"`
+	Answer        pionWebRTC.SessionDescription `json:"answer" binding:"required"`
+}
+
+// AddRoomICECandidateRequest 添加房间参与者 ICE 候选请求
+type AddRoomICECandidateRequest struct {
+	ParticipantID string                      `json:"participantId" binding:"required"`
+	Candidate     pionWebRTC.ICECandidateInit `json:"candidate" binding:"required"`
+}
+
+// HandleCreateRoom 创建房间
+// POST /api/media/sfu/rooms
+func (h *SFUHandler) HandleCreateRoom(c *gin.Context) {
+	ctx := c.Request.Context()
+	ctx, span := tracer.Start(ctx, "sfu.create_room")
+	defer span.End()
+
+	var req CreateRoomRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid request")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, tenantID, ok := requestUser(c, "")
+	if !ok {
+		span.SetStatus(codes.Error, "unauthorized user")
+		return
+	}
+
+	room := h.sfuManager.CreateRoom(req.Name, userID, tenantID)
+
+	span.SetAttributes(
+		attribute.String("room.id", room.ID),
+		attribute.String("user.id", userID),
+	)
+	span.SetStatus(codes.Ok, "room created")
+	logger.Info("sfu_room_created",
+		zap.String("room_id", room.ID),
+		zap.String("user_id", userID),
+	)
+
+	c.JSON(http.StatusOK, room.ToInfo())
+}
+
+// HandleListRooms 列出当前用户可以访问的房间
+// GET /api/media/sfu/rooms
+func (h *SFUHandler) HandleListRooms(c *gin.Context) {
+	userCtx, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var result []sfu.RoomInfo
+	for _, room := range h.sfuManager.GetAllRooms() {
+		if !canAccess(c, room.UserID, room.TenantID) && !room.HasParticipantUser(userCtx.UserID) {
+			continue
+		}
+		result = append(result, room.ToInfo())
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rooms": result,
+		"total": len(result),
+	})
+}
+
+// HandleGetRoom 获取房间信息（房间创建者、管理员和参与者可以查看）
+// GET /api/media/sfu/rooms/:id
+func (h *SFUHandler) HandleGetRoom(c *gin.Context) {
+	room, ok := h.findRoom(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	userCtx, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if !room.HasParticipantUser(userCtx.UserID) &&
+		!authorizeResource(c, "room", room.ID, room.UserID, room.TenantID) {
+		return
+	}
+
+	c.JSON(http.StatusOK, room.ToInfo())
+}
+
+// HandleCloseRoom 关闭房间（所有参与者离开，设备的发布者不受影响）
+// DELETE /api/media/sfu/rooms/:id
+func (h *SFUHandler) HandleCloseRoom(c *gin.Context) {
+	roomID := c.Param("id")
+
+	if _, ok := h.authorizedRoom(c, roomID); !ok {
+		return
+	}
+
+	if err := h.sfuManager.CloseRoom(roomID); err != nil {
+		logger.Warn("failed_to_close_room",
+			zap.String("room_id", roomID),
+			zap.Error(err),
+		)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return
+	}
+
+	logger.Info("sfu_room_closed",
+		zap.String("room_id", roomID),
+	)
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// HandleAddRoomDevice 向房间添加设备，所有参与者通过重新协商开始接收
+// POST /api/media/sfu/rooms/:id/devices
+func (h *SFUHandler) HandleAddRoomDevice(c *gin.Context) {
+	ctx := c.Request.Context()
+	ctx, span := tracer.Start(ctx, "sfu.add_room_device")
+	defer span.End()
+
+	var req AddRoomDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid request")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	roomID := c.Param("id")
+	span.SetAttributes(
+		attribute.String("room.id", roomID),
+		attribute.String("device.id", req.DeviceID),
+	)
+
+	room, ok := h.authorizedRoom(c, roomID)
+	if !ok {
+		span.SetStatus(codes.Error, "room not accessible")
+		return
+	}
+
+	publisher, err := h.sfuManager.GetPublisherByDevice(req.DeviceID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "no publisher for device")
+		c.JSON(http.StatusNotFound, gin.H{"error": "No active publisher for this device"})
+		return
+	}
+	if !h.authorizeSubscribe(c, publisher) {
+		span.SetStatus(codes.Error, "publisher not accessible")
+		return
+	}
+
+	canView := func(p *sfu.RoomParticipant) bool {
+		return h.participantCanView(ctx, p, req.DeviceID)
+	}
+	if _, err := h.sfuManager.AddRoomDevice(room.ID, req.DeviceID, canView); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to add room device")
+		logger.Error("failed_to_add_room_device",
+			zap.String("room_id", room.ID),
+			zap.String("device_id", req.DeviceID),
+			zap.Error(err),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add device to room"})
+		return
+	}
+
+	span.SetStatus(codes.Ok, "room device added")
+	logger.Info("sfu_room_device_added",
+		zap.String("room_id", room.ID),
+		zap.String("device_id", req.DeviceID),
+		zap.String("publisher_id", publisher.ID),
+	)
+
+	c.JSON(http.StatusOK, room.ToInfo())
+}
+
+// HandleRemoveRoomDevice 把设备移出房间
+// DELETE /api/media/sfu/rooms/:id/devices/:deviceId
+func (h *SFUHandler) HandleRemoveRoomDevice(c *gin.Context) {
+	roomID := c.Param("id")
+	deviceID := c.Param("deviceId")
+
+	room, ok := h.authorizedRoom(c, roomID)
+	if !ok {
+		return
+	}
+
+	if err := h.sfuManager.RemoveRoomDevice(room.ID, deviceID); err != nil {
+		logger.Warn("failed_to_remove_room_device",
+			zap.String("room_id", roomID),
+			zap.String("device_id", deviceID),
+			zap.Error(err),
+		)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not in room"})
+		return
+	}
+
+	logger.Info("sfu_room_device_removed",
+		zap.String("room_id", roomID),
+		zap.String("device_id", deviceID),
+	)
+
+	c.JSON(http.StatusOK, room.ToInfo())
+}
+
+// HandleJoinRoom 加入房间，返回订阅房间中所有设备的 offer
+// POST /api/media/sfu/rooms/:id/join
+func (h *SFUHandler) HandleJoinRoom(c *gin.Context) {
+	ctx := c.Request.Context()
+	ctx, span := tracer.Start(ctx, "sfu.join_room")
+	defer span.End()
+
+	roomID := c.Param("id")
+	span.SetAttributes(attribute.String("room.id", roomID))
+
+	room, ok := h.findRoom(c, roomID)
+	if !ok {
+		span.SetStatus(codes.Error, "room not found")
+		return
+	}
+
+	userCtx, ok := currentUser(c)
+	if !ok {
+		span.SetStatus(codes.Error, "unauthorized user")
+		return
+	}
+	userID, tenantID := userCtx.UserID, userCtx.TenantID
+	span.SetAttributes(attribute.String("user.id", userID))
+
+	if !h.authorizeRoomJoin(c, room) {
+		span.SetStatus(codes.Error, "room not accessible")
+		return
+	}
+
+	// 参与者的连接承载房间中的多个设备，不计入设备配额
+	reservation, ok := admitSession(c, h.admission, admission.Request{UserID: userID, TenantID: tenantID})
+	if !ok {
+		span.SetStatus(codes.Error, "admission rejected")
+		return
+	}
+
+	participant, offer, err := h.sfuManager.JoinRoom(room.ID, userID, tenantID, userCtx.Permissions)
+	if err != nil {
+		reservation.Cancel()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to join room")
+		logger.Error("failed_to_join_sfu_room",
+			zap.String("room_id", room.ID),
+			zap.Error(err),
+		)
+		if errors.Is(err, sfu.ErrRoomNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to join room"})
+		return
+	}
+
+	reservation.Commit(participant.ID)
+	span.SetAttributes(attribute.String("participant.id", participant.ID))
+	span.SetStatus(codes.Ok, "room joined")
+	logger.Info("sfu_room_joined",
+		zap.String("room_id", room.ID),
+		zap.String("participant_id", participant.ID),
+		zap.String("user_id", userID),
+	)
+
+	c.JSON(http.StatusOK, JoinRoomResponse{
+		ParticipantID: participant.ID,
+		RoomID:        room.ID,
+		Offer:         offer,
+		ICEServers:    h.iceServerDTOs(),
+	})
+}
+
+// HandleSetRoomAnswer 处理房间参与者 Answer（初始 offer 或数据通道不可用时的重新协商）
+// POST /api/media/sfu/rooms/answer
+func (h *SFUHandler) HandleSetRoomAnswer(c *gin.Context) {
+	ctx := c.Request.Context()
+	ctx, span := tracer.Start(ctx, "sfu.set_room_answer")
+	defer span.End()
+
+	var req SetRoomAnswerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid request")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	span.SetAttributes(attribute.String("participant.id", req.ParticipantID))
+
+	if _, ok := h.authorizedParticipant(c, req.ParticipantID); !ok {
+		span.SetStatus(codes.Error, "participant not accessible")
+		return
+	}
+
+	if err := h.sfuManager.HandleRoomAnswer(req.ParticipantID, req.Answer); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to handle answer")
+		logger.Error("failed_to_handle_room_answer",
+			zap.String("participant_id", req.ParticipantID),
+			zap.Error(err),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to handle answer"})
+		return
+	}
+
+	span.SetStatus(codes.Ok, "answer handled")
+	logger.Info("sfu_room_answer_handled",
+		zap.String("participant_id", req.ParticipantID),
+	)
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// HandleAddRoomICECandidate 添加房间参与者 ICE 候选
+// POST /api/media/sfu/rooms/ice-candidate
+func (h *SFUHandler) HandleAddRoomICECandidate(c *gin.Context) {
+	ctx := c.Request.Context()
+	ctx, span := tracer.Start(ctx, "sfu.add_room_ice_candidate")
+	defer span.End()
+
+	var req AddRoomICECandidateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid request")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	span.SetAttributes(attribute.String("participant.id", req.ParticipantID))
+
+	if _, ok := h.authorizedParticipant(c, req.ParticipantID); !ok {
+		span.SetStatus(codes.Error, "participant not accessible")
+		return
+	}
+
+	if err := h.sfuManager.AddRoomICECandidate(req.ParticipantID, req.Candidate); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to add ice candidate")
+		logger.Warn("failed_to_add_room_ice_candidate",
+			zap.String("participant_id", req.ParticipantID),
+			zap.Error(err),
+		)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to add ICE candidate"})
+		return
+	}
+
+	span.SetStatus(codes.Ok, "ice candidate added")
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// HandleLeaveRoom 参与者离开房间
+// DELETE /api/media/sfu/rooms/participants/:id
+func (h *SFUHandler) HandleLeaveRoom(c *gin.Context) {
+	participantID := c.Param("id")
+
+	participant, ok := h.authorizedParticipant(c, participantID)
+	if !ok {
+		return
+	}
+
+	if err := h.sfuManager.LeaveRoom(participantID); err != nil {
+		logger.Warn("failed_to_leave_room",
+			zap.String("participant_id", participantID),
+			zap.Error(err),
+		)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Participant not found"})
+		return
+	}
+
+	logger.Info("sfu_room_left",
+		zap.String("room_id", participant.RoomID),
+		zap.String("participant_id", participantID),
+	)
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// ========== 房间归属校验 ==========
+
+// findRoom 查找房间（不存在返回 404）
+func (h *SFUHandler) findRoom(c *gin.Context, roomID string) (*sfu.Room, bool) {
+	room, err := h.sfuManager.GetRoom(roomID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return nil, false
+	}
+	return room, true
+}
+
+// authorizedRoom 查找房间并校验当前用户是房间创建者或管理员（不存在返回 404，无权访问返回 403）
+func (h *SFUHandler) authorizedRoom(c *gin.Context, roomID string) (*sfu.Room, bool) {
+	room, ok := h.findRoom(c, roomID)
+	if !ok {
+		return nil, false
+	}
+	if !authorizeResource(c, "room", room.ID, room.UserID, room.TenantID) {
+		return nil, false
+	}
+	return room, true
+}
+
+// authorizedParticipant 查找房间参与者并校验当前用户可以访问（不存在返回 404，无权访问返回 403）
+func (h *SFUHandler) authorizedParticipant(c *gin.Context, participantID string) (*sfu.RoomParticipant, bool) {
+	participant, err := h.sfuManager.GetRoomParticipant(participantID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Participant not found"})
+		return nil, false
+	}
+	if !authorizeResource(c, "room participant", participant.ID, participant.UserID, participant.TenantID) {
+		return nil, false
+	}
+	return participant, true
+}
+
+// authorizeRoomJoin 校验当前用户可以加入房间
+// 房间创建者和管理员可以加入；配置了设备访问检查器时，同租户且对房间中每个设备都有观看权限的用户也可以加入
+// 空房间没有可以校验的设备，只有创建者和管理员可以加入（之后添加的设备逐个校验参与者）
+func (h *SFUHandler) authorizeRoomJoin(c *gin.Context, room *sfu.Room) bool {
+	userCtx, ok := currentUser(c)
+	if !ok {
+		return false
+	}
+	if userCtx.CanAccess(room.UserID, room.TenantID) {
+		return true
+	}
+	deviceIDs := room.DeviceIDs()
+	if h.deviceAccess == nil || room.TenantID != userCtx.TenantID || len(deviceIDs) == 0 {
+		return authorizeResource(c, "room", room.ID, room.UserID, room.TenantID)
+	}
+
+	for _, deviceID := range deviceIDs {
+		if !authorizeDevice(c, h.deviceAccess, deviceID, deviceaccess.LevelView) {
+			return false
+		}
+	}
+	return true
+}
+
+// participantCanView 按房间参与者的身份校验设备的观看权限
+// 不使用参与者加入时的 JWT（可能已过期），以服务令牌查询设备归属；device-service 不可用时拒绝
+func (h *SFUHandler) participantCanView(ctx context.Context, p *sfu.RoomParticipant, deviceID string) bool {
+	if h.deviceAccess == nil {
+		return true
+	}
+	identity := &middleware.UserContext{
+		UserID:      p.UserID,
+		TenantID:    p.TenantID,
+		Permissions: p.Permissions,
+	}
+	level, err := h.deviceAccess.CheckIdentity(ctx, identity, deviceID)
+	if err != nil {
+		logger.Warn("room_participant_access_check_failed",
+			zap.String("participant_id", p.ID),
+			zap.String("user_id", p.UserID),
+			zap.String("device_id", deviceID),
+			zap.Error(err),
+		)
+		return false
+	}
+	return level.Allows(deviceaccess.LevelView)
+}
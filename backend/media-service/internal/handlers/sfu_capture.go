@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cloudphone/media-service/internal/logger"
+	"github.com/cloudphone/media-service/internal/sfu"
+	"go.uber.org/zap"
+)
+
+// =============================================================================
+// SFU 发布者设备采集
+// =============================================================================
+//
+// 设备采集管道按 sfu.Manager 的发布者生命周期事件启动和停止（见 sfu.PublisherEvent）：
+//
+//	capture_started / capture_resumed   启动采集管道（低帧率暂停时恢复原帧率）
+//	capture_paused                      停止采集管道，配置了暂停帧率时降到该帧率继续采集
+//	closed                              停止采集管道
+//
+// 同一发布者的事件在 Manager 的事件队列中按顺序异步处理，启动管道（含视频源回退探测）在后台进行，
+// 暂停和关闭取消尚未完成的启动。
+
+// sfuCapture 发布者的设备采集管道
+type sfuCapture struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{} // 启动结束（成功或失败）时关闭，未启动时为 nil
+	fps    atomic.Int32  // 运行中管道的目标帧率（低帧率暂停后恢复）
+}
+
+// onPublisherEvent 处理发布者生命周期事件
+func (h *SFUHandler) onPublisherEvent(event sfu.PublisherEvent) {
+	logger.Info("sfu_publisher_lifecycle_event",
+		zap.String("event", string(event.Type)),
+		zap.String("publisher_id", event.PublisherID),
+		zap.String("device_id", event.DeviceID),
+		zap.String("source", event.Source),
+		zap.Int("subscriber_count", event.SubscriberCount),
+		zap.String("reason", event.Reason),
+	)
+
+	if h.pipelineManager == nil || event.Source != sfu.PublisherSourceDevice {
+		return
+	}
+
+	switch event.Type {
+	case sfu.PublisherEventCaptureStarted:
+		h.startCapture(event.PublisherID)
+	case sfu.PublisherEventCaptureResumed:
+		h.resumeCapture(event.PublisherID)
+	case sfu.PublisherEventCapturePaused:
+		h.pauseCapture(event.PublisherID)
+	case sfu.PublisherEventClosed:
+		h.stopCapture(event.PublisherID)
+	}
+}
+
+// startCapture 启动发布者的采集管道（已启动时不变）
+func (h *SFUHandler) startCapture(publisherID string) {
+	publisher, err := h.sfuManager.GetPublisher(publisherID)
+	if err != nil {
+		return
+	}
+
+	h.capturesMu.Lock()
+	capture, ok := h.captures[publisherID]
+	if !ok {
+		capture = &sfuCapture{}
+		h.captures[publisherID] = capture
+	}
+	h.capturesMu.Unlock()
+
+	capture.mu.Lock()
+	defer capture.mu.Unlock()
+
+	if capture.done != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	capture.cancel, capture.done = cancel, done
+
+	go func() {
+		defer close(done)
+		capture.fps.Store(int32(h.startSFUVideoPipeline(ctx, publisher)))
+	}()
+}
+
+// pauseCapture 没有订阅者时停止采集管道，配置了暂停帧率时降低帧率继续采集
+func (h *SFUHandler) pauseCapture(publisherID string) {
+	capture := h.lookupCapture(publisherID)
+	if capture == nil {
+		return
+	}
+
+	capture.mu.Lock()
+	defer capture.mu.Unlock()
+
+	if h.pausedCaptureFPS > 0 && capture.started() {
+		if err := h.pipelineManager.AdjustVideoFPS(publisherID, h.pausedCaptureFPS); err == nil {
+			return
+		}
+	}
+	h.stopCaptureLocked(publisherID, capture)
+}
+
+// resumeCapture 有订阅者时恢复采集管道
+func (h *SFUHandler) resumeCapture(publisherID string) {
+	if capture := h.lookupCapture(publisherID); capture != nil {
+		capture.mu.Lock()
+		fps := int(capture.fps.Load())
+		if capture.started() && fps > 0 {
+			if err := h.pipelineManager.AdjustVideoFPS(publisherID, fps); err == nil {
+				capture.mu.Unlock()
+				return
+			}
+		}
+		// 采集已停止（或低帧率管道已退出），重新启动
+		h.stopCaptureLocked(publisherID, capture)
+		capture.mu.Unlock()
+	}
+	h.startCapture(publisherID)
+}
+
+// stopCapture 发布者关闭时停止采集管道
+func (h *SFUHandler) stopCapture(publisherID string) {
+	h.capturesMu.Lock()
+	capture, ok := h.captures[publisherID]
+	delete(h.captures, publisherID)
+	h.capturesMu.Unlock()
+
+	if !ok {
+		return
+	}
+	capture.mu.Lock()
+	defer capture.mu.Unlock()
+	h.stopCaptureLocked(publisherID, capture)
+}
+
+// stopCaptureLocked 取消尚未完成的启动并停止采集管道（调用方持有 capture.mu）
+func (h *SFUHandler) stopCaptureLocked(publisherID string, capture *sfuCapture) {
+	if capture.done == nil {
+		return
+	}
+	capture.cancel()
+	<-capture.done
+	capture.cancel, capture.done = nil, nil
+	capture.fps.Store(0)
+
+	if err := h.pipelineManager.StopAllPipelines(publisherID); err != nil {
+		logger.Debug("no_sfu_pipelines_to_stop",
+			zap.String("publisher_id", publisherID),
+		)
+	}
+}
+
+// lookupCapture 查找发布者的采集管道
+func (h *SFUHandler) lookupCapture(publisherID string) *sfuCapture {
+	h.capturesMu.Lock()
+	defer h.capturesMu.Unlock()
+	return h.captures[publisherID]
+}
+
+// started 采集管道已启动完成（调用方持有 mu）
+func (c *sfuCapture) started() bool {
+	if c.done == nil {
+		return false
+	}
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/cloudphone/media-service/internal/logger"
+	"github.com/cloudphone/media-service/internal/sfu"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.uber.org/zap"
+)
+
+// =============================================================================
+// SFU 订阅者控制权
+// =============================================================================
+//
+// 创建时请求了数据通道（"dataChannel": true）的订阅者可以由发布者所有者授予临时控制权，
+// 期间订阅者在数据通道 "control" 上发送的输入与发布者的输入一样注入设备（见 sfu.ControlInput）：
+//
+//	POST /sfu/subscribers/:id/control/grant   {"durationSeconds":300}   发布者所有者授予（默认 5 分钟，最长 30 分钟）
+//	POST /sfu/subscribers/:id/control/revoke                            发布者所有者收回
+//	GET  /sfu/publishers/:id/control/audit                              控制记录：谁、由谁授权、何时开始和结束
+//
+// 同一时间只有一个订阅者持有控制权，授予新的订阅者时原来的被收回。
+//
+// 控制记录接口只返回最近的记录（发布者关闭后丢失），每次授权和结束另外写入结构化日志
+// sfu_control_audit（启用 RabbitMQ 时同时发布 media.sfu.control.*）。
+
+// GrantSubscriberControlRequest 授予订阅者控制权请求
+type GrantSubscriberControlRequest struct {
+	DurationSeconds int `json:"durationSeconds"` // 为 0 时使用默认时长
+}
+
+// HandleGrantSubscriberControl 发布者所有者授予订阅者临时控制权
+// POST /api/media/sfu/subscribers/:id/control/grant
+func (h *SFUHandler) HandleGrantSubscriberControl(c *gin.Context) {
+	ctx := c.Request.Context()
+	_, span := tracer.Start(ctx, "sfu.grant_subscriber_control")
+	defer span.End()
+
+	subscriberID := c.Param("id")
+	span.SetAttributes(attribute.String("subscriber.id", subscriberID))
+
+	// 请求体可以省略
+	var req GrantSubscriberControlRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid request")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	subscriber, ok := h.subscriberForPublisherOwner(c, subscriberID)
+	if !ok {
+		span.SetStatus(codes.Error, "publisher not accessible")
+		return
+	}
+	userCtx, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	entry, err := h.sfuManager.GrantSubscriberControl(subscriber.ID, userCtx.UserID, time.Duration(req.DurationSeconds)*time.Second)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to grant control")
+		logger.Warn("failed_to_grant_sfu_subscriber_control",
+			zap.String("subscriber_id", subscriberID),
+			zap.Error(err),
+		)
+		writeSubscriberControlError(c, err)
+		return
+	}
+
+	span.SetStatus(codes.Ok, "control granted")
+	logger.Info("sfu_subscriber_control_granted",
+		zap.String("subscriber_id", subscriber.ID),
+		zap.String("publisher_id", subscriber.PublisherID),
+		zap.String("device_id", subscriber.DeviceID),
+		zap.String("user_id", subscriber.UserID),
+		zap.String("granted_by", userCtx.UserID),
+		zap.Time("expires_at", entry.ExpiresAt),
+	)
+
+	c.JSON(http.StatusOK, entry)
+}
+
+// HandleRevokeSubscriberControl 发布者所有者收回订阅者的控制权
+// POST /api/media/sfu/subscribers/:id/control/revoke
+func (h *SFUHandler) HandleRevokeSubscriberControl(c *gin.Context) {
+	subscriberID := c.Param("id")
+
+	subscriber, ok := h.subscriberForPublisherOwner(c, subscriberID)
+	if !ok {
+		return
+	}
+
+	if err := h.sfuManager.RevokeSubscriberControl(subscriber.ID); err != nil {
+		logger.Warn("failed_to_revoke_sfu_subscriber_control",
+			zap.String("subscriber_id", subscriberID),
+			zap.Error(err),
+		)
+		writeSubscriberControlError(c, err)
+		return
+	}
+
+	logger.Info("sfu_subscriber_control_revoked",
+		zap.String("subscriber_id", subscriber.ID),
+		zap.String("publisher_id", subscriber.PublisherID),
+		zap.String("device_id", subscriber.DeviceID),
+	)
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// HandleGetControlAudit 获取发布者的订阅者控制记录
+// GET /api/media/sfu/publishers/:id/control/audit
+func (h *SFUHandler) HandleGetControlAudit(c *gin.Context) {
+	publisher, ok := h.authorizedPublisher(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	entries, err := h.sfuManager.GetControlAudit(publisher.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Publisher not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"publisherId": publisher.ID,
+		"deviceId":    publisher.DeviceID,
+		"entries":     entries,
+		"total":       len(entries),
+	})
+}
+
+// subscriberForPublisherOwner 查找订阅者并校验当前用户是其发布者的所有者（或管理员）
+func (h *SFUHandler) subscriberForPublisherOwner(c *gin.Context, subscriberID string) (*sfu.SubscriberSession, bool) {
+	subscriber, err := h.sfuManager.GetSubscriber(subscriberID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscriber not found"})
+		return nil, false
+	}
+	if _, ok := h.authorizedPublisher(c, subscriber.PublisherID); !ok {
+		return nil, false
+	}
+	return subscriber, true
+}
+
+// writeSubscriberControlError 按错误类型返回授予/收回控制权失败的响应
+func writeSubscriberControlError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, sfu.ErrSubscriberNoDataChannel):
+		c.JSON(http.StatusConflict, gin.H{"error": "Subscriber was created without a data channel"})
+	case errors.Is(err, sfu.ErrControlNotGranted):
+		c.JSON(http.StatusConflict, gin.H{"error": "Subscriber has no control"})
+	case errors.Is(err, sfu.ErrNoControlInput):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Device input is not available"})
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscriber not found"})
+	}
+}
+
+// onControlAudit 把控制权的授权和结束写入结构化日志（审计留存）
+func (h *SFUHandler) onControlAudit(event sfu.ControlAuditEvent) {
+	fields := []zap.Field{
+		zap.String("event", event.Type),
+		zap.String("publisher_id", event.PublisherID),
+		zap.String("device_id", event.DeviceID),
+		zap.String("subscriber_id", event.SubscriberID),
+		zap.String("user_id", event.UserID),
+		zap.String("granted_by", event.GrantedBy),
+		zap.Time("granted_at", event.GrantedAt),
+		zap.Time("expires_at", event.ExpiresAt),
+	}
+	if event.EndedAt != nil {
+		fields = append(fields,
+			zap.Time("ended_at", *event.EndedAt),
+			zap.String("end_reason", event.EndReason),
+			zap.Int("input_events", event.InputEvents),
+		)
+	}
+	logger.Info("sfu_control_audit", fields...)
+}
@@ -2,10 +2,13 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"sync"
 	"time"
 
-	"github.com/cloudphone/media-service/internal/capture"
+	"github.com/cloudphone/media-service/internal/admission"
+	"github.com/cloudphone/media-service/internal/deviceaccess"
 	"github.com/cloudphone/media-service/internal/encoder"
 	"github.com/cloudphone/media-service/internal/logger"
 	"github.com/cloudphone/media-service/internal/sfu"
@@ -24,7 +27,15 @@ type SFUHandler struct {
 	adbPath          string
 	scrcpyServerPath string
 	useScrcpy        bool
+	pipelineBuilder  *encoder.PipelineBuilder // 与 1:1 会话共用的管道构建器
+	deviceAccess     *deviceaccess.Checker    // 通过 device-service 校验设备访问权限（nil 时不校验）
+	admission        *admission.Controller    // 主机准入控制（nil 时不检查）
+	pausedCaptureFPS int                      // 没有订阅者时保持采集的帧率（0 = 停止采集）
 	logger           *logrus.Logger
+
+	// captures 发布者的设备采集管道（按发布者生命周期事件启动和停止）
+	captures   map[string]*sfuCapture // publisherID -> capture
+	capturesMu sync.Mutex
 }
 
 // SFUHandlerOption 配置选项
@@ -45,6 +56,35 @@ func WithSFUUseScrcpy(use bool) SFUHandlerOption {
 	}
 }
 
+// WithSFUPipelineBuilder 设置管道构建器（未设置时根据 scrcpy 选项创建默认构建器）
+func WithSFUPipelineBuilder(builder *encoder.PipelineBuilder) SFUHandlerOption {
+	return func(h *SFUHandler) {
+		h.pipelineBuilder = builder
+	}
+}
+
+// WithSFUDeviceAccessChecker 设置设备访问检查器
+// 发布需要设备的控制权限，订阅只需要观看权限（未设置时只能订阅自己的发布者）
+func WithSFUDeviceAccessChecker(checker *deviceaccess.Checker) SFUHandlerOption {
+	return func(h *SFUHandler) {
+		h.deviceAccess = checker
+	}
+}
+
+// WithSFUAdmissionController 设置准入控制器（创建发布者 / 订阅者前检查主机负载）
+func WithSFUAdmissionController(controller *admission.Controller) SFUHandlerOption {
+	return func(h *SFUHandler) {
+		h.admission = controller
+	}
+}
+
+// WithSFUPausedCaptureFPS 设置没有订阅者时的采集帧率（0 = 停止采集，默认）
+func WithSFUPausedCaptureFPS(fps int) SFUHandlerOption {
+	return func(h *SFUHandler) {
+		h.pausedCaptureFPS = fps
+	}
+}
+
 // NewSFUHandler 创建 SFU 处理器
 func NewSFUHandler(sfuMgr *sfu.Manager, pipelineMgr *encoder.PipelineManager, adbPath string, opts ...SFUHandlerOption) *SFUHandler {
 	h := &SFUHandler{
@@ -52,12 +92,22 @@ func NewSFUHandler(sfuMgr *sfu.Manager, pipelineMgr *encoder.PipelineManager, ad
 		pipelineManager: pipelineMgr,
 		adbPath:         adbPath,
 		logger:          logrus.New(),
+		captures:        make(map[string]*sfuCapture),
 	}
 
 	for _, opt := range opts {
 		opt(h)
 	}
 
+	if h.pipelineBuilder == nil {
+		h.pipelineBuilder = newDefaultPipelineBuilder(h.adbPath, h.scrcpyServerPath, h.useScrcpy, false, h.logger)
+	}
+
+	// 设备采集跟随发布者生命周期（按需采集时由第一个订阅者启动）
+	sfuMgr.OnPublisherEvent(h.onPublisherEvent)
+	sfuMgr.OnConnectionClosed(h.onConnectionClosed)
+	sfuMgr.OnControlAudit(h.onControlAudit)
+
 	return h
 }
 
@@ -66,7 +116,7 @@ func NewSFUHandler(sfuMgr *sfu.Manager, pipelineMgr *encoder.PipelineManager, ad
 // CreatePublisherRequest 创建发布者请求
 type CreatePublisherRequest struct {
 	DeviceID   string `json:"deviceId" binding:"required"`
-	UserID     string `json:"userId" binding:"required"`
+	UserID     string `json:"userId"`     // 已废弃，以 JWT 用户为准（提供时必须一致）
 	VideoCodec string `json:"videoCodec"` // "VP8" 或 "H264"，默认 "VP8"
 }
 
@@ -93,37 +143,73 @@ func (h *SFUHandler) HandleCreatePublisher(c *gin.Context) {
 		return
 	}
 
-	// 根据配置选择编码类型
+	userID, tenantID, ok := requestUser(c, req.UserID)
+	if !ok {
+		span.SetStatus(codes.Error, "unauthorized user")
+		return
+	}
+
+	if !authorizeDevice(c, h.deviceAccess, req.DeviceID, deviceaccess.LevelControl) {
+		span.SetStatus(codes.Error, "device access denied")
+		return
+	}
+
+	// 设备已有发布者时复用，只有发布者所有者（或管理员）可以重新协商；新的发布者计入准入配额
+	var reservation *admission.Reservation
+	if existing, err := h.sfuManager.GetPublisherByDevice(req.DeviceID); err == nil {
+		if !authorizeResource(c, "publisher", existing.ID, existing.UserID, existing.TenantID) {
+			span.SetStatus(codes.Error, "publisher not accessible")
+			return
+		}
+	} else if reservation, ok = admitSession(c, h.admission, admission.Request{UserID: userID, TenantID: tenantID, DeviceID: req.DeviceID}); !ok {
+		span.SetStatus(codes.Error, "admission rejected")
+		return
+	}
+
+	// 未指定编码类型时根据租户/设备规格选择，必须与回退链的输出一致
 	videoCodec := req.VideoCodec
 	if videoCodec == "" {
-		if h.useScrcpy {
-			videoCodec = "H264"
-		} else {
-			videoCodec = "VP8"
-		}
+		plan := h.pipelineBuilder.Plan(h.pipelineBuilder.Resolve(tenantID, req.DeviceID), "")
+		videoCodec = string(trackCodecFor(plan.Codec))
 	}
 
 	span.SetAttributes(
 		attribute.String("device.id", req.DeviceID),
-		attribute.String("user.id", req.UserID),
+		attribute.String("user.id", userID),
 		attribute.String("video.codec", videoCodec),
 	)
 
 	// 创建发布者
-	publisher, err := h.sfuManager.CreatePublisher(req.DeviceID, req.UserID, videoCodec)
+	publisher, err := h.sfuManager.CreatePublisher(req.DeviceID, userID, videoCodec)
 	if err != nil {
+		reservation.Cancel()
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "failed to create publisher")
 		logger.Error("failed_to_create_sfu_publisher",
 			zap.String("device_id", req.DeviceID),
 			zap.Error(err),
 		)
+		if errors.Is(err, sfu.ErrPublisherIngest) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Device is being published via WHIP"})
+			return
+		}
+		if errors.Is(err, sfu.ErrPublisherRelay) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Device is published on another instance"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create publisher"})
 		return
 	}
 
+	// 名额在发布者关闭时释放（并发请求复用了同一发布者时 Commit 释放预留）
+	reservation.Commit(publisher.ID)
 	span.SetAttributes(attribute.String("publisher.id", publisher.ID))
 
+	// 复用已有发布者时保留其原始租户
+	if publisher.TenantID == "" {
+		publisher.TenantID = tenantID
+	}
+
 	// 创建 Offer
 	offer, err := h.sfuManager.CreatePublisherOffer(publisher.ID)
 	if err != nil {
@@ -192,7 +278,13 @@ func (h *SFUHandler) HandleSetPublisherAnswer(c *gin.Context) {
 
 	span.SetAttributes(attribute.String("publisher.id", req.PublisherID))
 
-	if err := h.sfuManager.HandlePublisherAnswer(req.PublisherID, req.Answer); err != nil {
+	publisher, ok := h.authorizedPublisher(c, req.PublisherID)
+	if !ok {
+		span.SetStatus(codes.Error, "publisher not accessible")
+		return
+	}
+
+	if err := h.sfuManager.HandlePublisherAnswer(publisher.ID, req.Answer); err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "failed to handle answer")
 		logger.Error("failed_to_handle_publisher_answer",
@@ -203,17 +295,12 @@ func (h *SFUHandler) HandleSetPublisherAnswer(c *gin.Context) {
 		return
 	}
 
-	// 获取发布者信息以启动视频管道
-	publisher, err := h.sfuManager.GetPublisher(req.PublisherID)
-	if err != nil {
-		logger.Warn("failed_to_get_publisher_for_pipeline",
-			zap.String("publisher_id", req.PublisherID),
+	// 协商完成，按订阅者数量启动设备采集（见 onPublisherEvent）
+	if err := h.sfuManager.ActivatePublisher(publisher.ID); err != nil {
+		logger.Warn("failed_to_activate_sfu_publisher",
+			zap.String("publisher_id", publisher.ID),
 			zap.Error(err),
 		)
-	} else if h.pipelineManager != nil {
-		// 启动视频管道
-		pipelineCtx := context.Background()
-		go h.startSFUVideoPipeline(pipelineCtx, publisher)
 	}
 
 	span.SetStatus(codes.Ok, "answer handled")
@@ -224,70 +311,61 @@ func (h *SFUHandler) HandleSetPublisherAnswer(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
-// startSFUVideoPipeline 启动 SFU 视频管道
-func (h *SFUHandler) startSFUVideoPipeline(ctx context.Context, publisher *sfu.PublisherSession) {
+// startSFUVideoPipeline 启动 SFU 视频管道，返回所用视频源的目标帧率（失败时为 0）
+// 与 1:1 会话使用同一个 PipelineBuilder，按发布者的租户/设备规格构建回退链
+func (h *SFUHandler) startSFUVideoPipeline(ctx context.Context, publisher *sfu.PublisherSession) int {
 	publisherID := publisher.ID
 	deviceID := publisher.DeviceID
 
+	// 回退链只保留能输出发布者轨道编码的视频源
+	codec := encoder.StreamCodecVP8
+	if publisher.VideoTrack != nil && publisher.VideoTrack.Codec().MimeType == pionWebRTC.MimeTypeH264 {
+		codec = encoder.StreamCodecH264
+	}
+	spec := h.pipelineBuilder.Resolve(publisher.TenantID, deviceID)
+	plan := h.pipelineBuilder.Plan(spec, codec)
+
 	logger.Info("starting_sfu_video_pipeline",
 		zap.String("publisher_id", publisherID),
 		zap.String("device_id", deviceID),
-		zap.Bool("use_scrcpy", h.useScrcpy),
+		zap.String("tenant_id", publisher.TenantID),
+		zap.String("capture_mode", string(spec.CaptureMode)),
+		zap.String("encoder_type", string(spec.EncoderType)),
+		zap.Strings("fallback_chain", plan.CaptureModes()),
+		zap.String("video_codec", string(codec)),
 	)
 
-	// 创建屏幕捕获
-	var screenCapture capture.ScreenCapture
-	if h.useScrcpy && h.scrcpyServerPath != "" {
-		screenCapture = capture.NewScrcpyCapture(h.adbPath, h.scrcpyServerPath, h.logger)
-	} else {
-		screenCapture = capture.NewAndroidScreenCapture(h.adbPath, h.logger)
-	}
-
-	// 配置参数
-	targetFPS := 30
-	targetBitrate := 4000000
-	targetWidth := 720
-	targetHeight := 0
-
-	if !h.useScrcpy {
-		targetFPS = 15
-		targetBitrate = 2000000
-	}
-
 	// 创建 SFU FrameWriter 适配器
 	frameWriter := &sfuFrameWriter{
 		manager:     h.sfuManager,
 		publisherID: publisherID,
 	}
 
-	err := h.pipelineManager.CreateVideoPipeline(
-		ctx,
-		publisherID, // 使用 publisherID 作为 sessionID
-		deviceID,
-		screenCapture,
-		frameWriter,
-		targetFPS,
-		targetBitrate,
-		targetWidth,
-		targetHeight,
-		encoder.CreateVideoPipelineOptions{
-			UseH264Passthrough: h.useScrcpy,
-		},
-	)
+	// 使用 publisherID 作为 sessionID
+	info, err := runVideoPlan(ctx, h.pipelineManager, h.pipelineBuilder, publisherID, deviceID, plan, frameWriter, nil)
 	if err != nil {
 		logger.Error("failed_to_create_sfu_video_pipeline",
 			zap.String("publisher_id", publisherID),
 			zap.String("device_id", deviceID),
+			zap.Int("attempts", len(info.Attempts)),
 			zap.Error(err),
 		)
-		return
+		return 0
 	}
 
 	logger.Info("sfu_video_pipeline_started",
 		zap.String("publisher_id", publisherID),
 		zap.String("device_id", deviceID),
-		zap.Int("target_fps", targetFPS),
+		zap.String("source", info.Source),
+		zap.String("encoder", info.Encoder),
 	)
+
+	for _, step := range plan.Steps {
+		if string(step.CaptureMode) == info.Source {
+			return step.FPS
+		}
+	}
+	return 0
 }
 
 // sfuFrameWriter 适配器：将帧写入 SFU Manager
@@ -324,6 +402,11 @@ func (h *SFUHandler) HandleAddPublisherICECandidate(c *gin.Context) {
 
 	span.SetAttributes(attribute.String("publisher.id", req.PublisherID))
 
+	if _, ok := h.authorizedPublisher(c, req.PublisherID); !ok {
+		span.SetStatus(codes.Error, "publisher not accessible")
+		return
+	}
+
 	if err := h.sfuManager.AddPublisherICECandidate(req.PublisherID, req.Candidate); err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "failed to add ice candidate")
@@ -344,9 +427,8 @@ func (h *SFUHandler) HandleAddPublisherICECandidate(c *gin.Context) {
 func (h *SFUHandler) HandleGetPublisher(c *gin.Context) {
 	publisherID := c.Param("id")
 
-	publisher, err := h.sfuManager.GetPublisher(publisherID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Publisher not found"})
+	publisher, ok := h.authorizedPublisher(c, publisherID)
+	if !ok {
 		return
 	}
 
@@ -358,15 +440,11 @@ func (h *SFUHandler) HandleGetPublisher(c *gin.Context) {
 func (h *SFUHandler) HandleClosePublisher(c *gin.Context) {
 	publisherID := c.Param("id")
 
-	// 停止视频管道
-	if h.pipelineManager != nil {
-		if err := h.pipelineManager.StopAllPipelines(publisherID); err != nil {
-			logger.Debug("no_sfu_pipelines_to_stop",
-				zap.String("publisher_id", publisherID),
-			)
-		}
+	if _, ok := h.authorizedPublisher(c, publisherID); !ok {
+		return
 	}
 
+	// 视频管道随 closed 事件停止（见 onPublisherEvent）
 	if err := h.sfuManager.ClosePublisher(publisherID); err != nil {
 		logger.Warn("failed_to_close_publisher",
 			zap.String("publisher_id", publisherID),
@@ -383,13 +461,16 @@ func (h *SFUHandler) HandleClosePublisher(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
-// HandleListPublishers 列出所有发布者
+// HandleListPublishers 列出当前用户可以访问的发布者
 // GET /api/media/sfu/publishers
 func (h *SFUHandler) HandleListPublishers(c *gin.Context) {
 	publishers := h.sfuManager.GetAllPublishers()
 
 	var result []sfu.PublisherInfo
 	for _, pub := range publishers {
+		if !canAccess(c, pub.UserID, pub.TenantID) {
+			continue
+		}
 		result = append(result, pub.ToInfo())
 	}
 
@@ -404,13 +485,15 @@ func (h *SFUHandler) HandleListPublishers(c *gin.Context) {
 // CreateSubscriberRequest 创建订阅者请求
 type CreateSubscriberRequest struct {
 	PublisherID string `json:"publisherId" binding:"required"`
-	UserID      string `json:"userId" binding:"required"`
+	UserID      string `json:"userId"`      // 已废弃，以 JWT 用户为准（提供时必须一致）
+	DataChannel bool   `json:"dataChannel"` // 创建控制数据通道（发布者所有者可以授予临时控制权）
 }
 
 // CreateSubscriberByDeviceRequest 通过设备创建订阅者请求
 type CreateSubscriberByDeviceRequest struct {
-	DeviceID string `json:"deviceId" binding:"required"`
-	UserID   string `json:"userId" binding:"required"`
+	DeviceID    string `json:"deviceId" binding:"required"`
+	UserID      string `json:"userId"`      // 已废弃，以 JWT 用户为准（提供时必须一致）
+	DataChannel bool   `json:"dataChannel"` // 创建控制数据通道（发布者所有者可以授予临时控制权）
 }
 
 // CreateSubscriberResponse 创建订阅者响应
@@ -437,14 +520,37 @@ func (h *SFUHandler) HandleCreateSubscriber(c *gin.Context) {
 		return
 	}
 
+	userID, tenantID, ok := requestUser(c, req.UserID)
+	if !ok {
+		span.SetStatus(codes.Error, "unauthorized user")
+		return
+	}
+
 	span.SetAttributes(
 		attribute.String("publisher.id", req.PublisherID),
-		attribute.String("user.id", req.UserID),
+		attribute.String("user.id", userID),
 	)
 
+	publisher, err := h.sfuManager.GetPublisher(req.PublisherID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Publisher not found"})
+		return
+	}
+	if !h.authorizeSubscribe(c, publisher) {
+		span.SetStatus(codes.Error, "publisher not accessible")
+		return
+	}
+
+	reservation, ok := admitSession(c, h.admission, admission.Request{UserID: userID, TenantID: tenantID, DeviceID: publisher.DeviceID})
+	if !ok {
+		span.SetStatus(codes.Error, "admission rejected")
+		return
+	}
+
 	// 创建订阅者
-	subscriber, err := h.sfuManager.CreateSubscriber(req.PublisherID, req.UserID)
+	subscriber, err := h.sfuManager.CreateSubscriber(req.PublisherID, userID, subscriberOptions(req.DataChannel)...)
 	if err != nil {
+		reservation.Cancel()
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "failed to create subscriber")
 		logger.Error("failed_to_create_sfu_subscriber",
@@ -455,7 +561,9 @@ func (h *SFUHandler) HandleCreateSubscriber(c *gin.Context) {
 		return
 	}
 
+	reservation.Commit(subscriber.ID)
 	span.SetAttributes(attribute.String("subscriber.id", subscriber.ID))
+	subscriber.TenantID = tenantID
 
 	// 创建 Offer
 	offer, err := h.sfuManager.CreateSubscriberOffer(subscriber.ID)
@@ -518,23 +626,42 @@ func (h *SFUHandler) HandleCreateSubscriberByDevice(c *gin.Context) {
 		return
 	}
 
+	userID, tenantID, ok := requestUser(c, req.UserID)
+	if !ok {
+		span.SetStatus(codes.Error, "unauthorized user")
+		return
+	}
+
 	span.SetAttributes(
 		attribute.String("device.id", req.DeviceID),
-		attribute.String("user.id", req.UserID),
+		attribute.String("user.id", userID),
 	)
 
-	// 获取设备的发布者
+	// 获取设备的发布者（发布者在其他实例上时在本实例创建中继）
 	publisher, err := h.sfuManager.GetPublisherByDevice(req.DeviceID)
 	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, "no publisher for device")
-		c.JSON(http.StatusNotFound, gin.H{"error": "No active publisher for this device"})
+		var ok bool
+		if publisher, ok = h.relayedPublisher(c, req.DeviceID); !ok {
+			span.SetStatus(codes.Error, "no publisher for device")
+			return
+		}
+		span.SetAttributes(attribute.String("relay.publisher.id", publisher.ID))
+	}
+	if !h.authorizeSubscribe(c, publisher) {
+		span.SetStatus(codes.Error, "publisher not accessible")
+		return
+	}
+
+	reservation, ok := admitSession(c, h.admission, admission.Request{UserID: userID, TenantID: tenantID, DeviceID: publisher.DeviceID})
+	if !ok {
+		span.SetStatus(codes.Error, "admission rejected")
 		return
 	}
 
 	// 创建订阅者
-	subscriber, err := h.sfuManager.CreateSubscriber(publisher.ID, req.UserID)
+	subscriber, err := h.sfuManager.CreateSubscriber(publisher.ID, userID, subscriberOptions(req.DataChannel)...)
 	if err != nil {
+		reservation.Cancel()
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "failed to create subscriber")
 		logger.Error("failed_to_create_sfu_subscriber",
@@ -545,7 +672,9 @@ func (h *SFUHandler) HandleCreateSubscriberByDevice(c *gin.Context) {
 		return
 	}
 
+	reservation.Commit(subscriber.ID)
 	span.SetAttributes(attribute.String("subscriber.id", subscriber.ID))
+	subscriber.TenantID = tenantID
 
 	// 创建 Offer
 	offer, err := h.sfuManager.CreateSubscriberOffer(subscriber.ID)
@@ -611,6 +740,11 @@ func (h *SFUHandler) HandleSetSubscriberAnswer(c *gin.Context) {
 
 	span.SetAttributes(attribute.String("subscriber.id", req.SubscriberID))
 
+	if _, ok := h.authorizedSubscriber(c, req.SubscriberID); !ok {
+		span.SetStatus(codes.Error, "subscriber not accessible")
+		return
+	}
+
 	if err := h.sfuManager.HandleSubscriberAnswer(req.SubscriberID, req.Answer); err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "failed to handle answer")
@@ -653,6 +787,11 @@ func (h *SFUHandler) HandleAddSubscriberICECandidate(c *gin.Context) {
 
 	span.SetAttributes(attribute.String("subscriber.id", req.SubscriberID))
 
+	if _, ok := h.authorizedSubscriber(c, req.SubscriberID); !ok {
+		span.SetStatus(codes.Error, "subscriber not accessible")
+		return
+	}
+
 	if err := h.sfuManager.AddSubscriberICECandidate(req.SubscriberID, req.Candidate); err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "failed to add ice candidate")
@@ -673,9 +812,8 @@ func (h *SFUHandler) HandleAddSubscriberICECandidate(c *gin.Context) {
 func (h *SFUHandler) HandleGetSubscriber(c *gin.Context) {
 	subscriberID := c.Param("id")
 
-	subscriber, err := h.sfuManager.GetSubscriber(subscriberID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Subscriber not found"})
+	subscriber, ok := h.authorizedSubscriber(c, subscriberID)
+	if !ok {
 		return
 	}
 
@@ -687,6 +825,10 @@ func (h *SFUHandler) HandleGetSubscriber(c *gin.Context) {
 func (h *SFUHandler) HandleCloseSubscriber(c *gin.Context) {
 	subscriberID := c.Param("id")
 
+	if _, ok := h.authorizedSubscriber(c, subscriberID); !ok {
+		return
+	}
+
 	if err := h.sfuManager.CloseSubscriber(subscriberID); err != nil {
 		logger.Warn("failed_to_close_subscriber",
 			zap.String("subscriber_id", subscriberID),
@@ -703,6 +845,171 @@ func (h *SFUHandler) HandleCloseSubscriber(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
+// HandlePauseSubscriber 暂停向订阅者转发视频
+// POST /api/media/sfu/subscribers/:id/pause
+func (h *SFUHandler) HandlePauseSubscriber(c *gin.Context) {
+	subscriberID := c.Param("id")
+
+	if _, ok := h.authorizedSubscriber(c, subscriberID); !ok {
+		return
+	}
+
+	if err := h.sfuManager.PauseSubscriber(subscriberID); err != nil {
+		logger.Warn("failed_to_pause_subscriber",
+			zap.String("subscriber_id", subscriberID),
+			zap.Error(err),
+		)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscriber not found"})
+		return
+	}
+
+	logger.Info("sfu_subscriber_paused",
+		zap.String("subscriber_id", subscriberID),
+	)
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// HandleResumeSubscriber 恢复向订阅者转发视频（从下一个关键帧开始）
+// POST /api/media/sfu/subscribers/:id/resume
+func (h *SFUHandler) HandleResumeSubscriber(c *gin.Context) {
+	subscriberID := c.Param("id")
+
+	if _, ok := h.authorizedSubscriber(c, subscriberID); !ok {
+		return
+	}
+
+	if err := h.sfuManager.ResumeSubscriber(subscriberID); err != nil {
+		logger.Warn("failed_to_resume_subscriber",
+			zap.String("subscriber_id", subscriberID),
+			zap.Error(err),
+		)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscriber not found"})
+		return
+	}
+
+	logger.Info("sfu_subscriber_resumed",
+		zap.String("subscriber_id", subscriberID),
+	)
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// subscriberOptions 订阅者创建选项
+func subscriberOptions(dataChannel bool) []sfu.SubscriberOption {
+	if dataChannel {
+		return []sfu.SubscriberOption{sfu.WithSubscriberDataChannel()}
+	}
+	return nil
+}
+
+// ========== 归属校验 ==========
+
+// authorizedPublisher 查找发布者并校验当前用户可以访问（不存在返回 404，无权访问返回 403）
+func (h *SFUHandler) authorizedPublisher(c *gin.Context, publisherID string) (*sfu.PublisherSession, bool) {
+	publisher, err := h.sfuManager.GetPublisher(publisherID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Publisher not found"})
+		return nil, false
+	}
+	if !authorizeResource(c, "publisher", publisher.ID, publisher.UserID, publisher.TenantID) {
+		return nil, false
+	}
+	return publisher, true
+}
+
+// authorizedSubscriber 查找订阅者并校验当前用户可以访问（不存在返回 404，无权访问返回 403）
+func (h *SFUHandler) authorizedSubscriber(c *gin.Context, subscriberID string) (*sfu.SubscriberSession, bool) {
+	subscriber, err := h.sfuManager.GetSubscriber(subscriberID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscriber not found"})
+		return nil, false
+	}
+	if !authorizeResource(c, "subscriber", subscriber.ID, subscriber.UserID, subscriber.TenantID) {
+		return nil, false
+	}
+	return subscriber, true
+}
+
+// authorizeSubscribe 校验当前用户可以订阅发布者
+// 配置了设备访问检查器时需要设备的观看权限，否则只能订阅自己的发布者（管理员除外）
+func (h *SFUHandler) authorizeSubscribe(c *gin.Context, publisher *sfu.PublisherSession) bool {
+	return h.authorizeSubscribeRecord(c, sfu.PublisherRecord{
+		DeviceID:    publisher.DeviceID,
+		PublisherID: publisher.ID,
+		UserID:      publisher.UserID,
+		TenantID:    publisher.TenantID,
+	})
+}
+
+// authorizeSubscribeRecord 按发布者的设备和归属校验订阅权限（其他实例上的发布者使用目录中的登记）
+func (h *SFUHandler) authorizeSubscribeRecord(c *gin.Context, record sfu.PublisherRecord) bool {
+	if h.deviceAccess != nil {
+		return authorizeDevice(c, h.deviceAccess, record.DeviceID, deviceaccess.LevelView)
+	}
+	return authorizeResource(c, "publisher", record.PublisherID, record.UserID, record.TenantID)
+}
+
+// relayedPublisher 设备的发布者在其他实例上时，在本实例创建中继发布者（级联 SFU）
+// 按源发布者校验请求用户的订阅权限（中继以服务令牌订阅源实例，源实例不再校验用户）；失败时已写入响应
+func (h *SFUHandler) relayedPublisher(c *gin.Context, deviceID string) (*sfu.PublisherSession, bool) {
+	origin, err := h.sfuManager.LookupRemotePublisher(deviceID)
+	if err != nil {
+		if !errors.Is(err, sfu.ErrNoRemotePublisher) {
+			logger.Warn("sfu_publisher_directory_lookup_failed",
+				zap.String("device_id", deviceID),
+				zap.Error(err),
+			)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Publisher directory unavailable"})
+			return nil, false
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "No active publisher for this device"})
+		return nil, false
+	}
+	if !h.authorizeSubscribeRecord(c, origin.PublisherRecord) {
+		return nil, false
+	}
+
+	publisher, err := h.sfuManager.CreateRelayPublisher(c.Request.Context(), origin)
+	if err != nil {
+		logger.Error("failed_to_create_sfu_relay_publisher",
+			zap.String("device_id", deviceID),
+			zap.String("origin_instance", origin.InstanceID),
+			zap.Error(err),
+		)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to relay publisher from another instance"})
+		return nil, false
+	}
+
+	logger.Info("sfu_relay_publisher_ready",
+		zap.String("publisher_id", publisher.ID),
+		zap.String("device_id", deviceID),
+		zap.String("origin_instance", origin.InstanceID),
+		zap.String("origin_publisher_id", origin.PublisherID),
+	)
+	return publisher, true
+}
+
+// iceServerDTOs 返回 ICE 服务器配置（包含 TURN 凭证）
+func (h *SFUHandler) iceServerDTOs() []ICEServerDTO {
+	iceServers := h.sfuManager.GetICEServers()
+	iceServerDTOs := make([]ICEServerDTO, len(iceServers))
+	for i, server := range iceServers {
+		var credential string
+		if server.Credential != nil {
+			if cred, ok := server.Credential.(string); ok {
+				credential = cred
+			}
+		}
+		iceServerDTOs[i] = ICEServerDTO{
+			URLs:       server.URLs,
+			Username:   server.Username,
+			Credential: credential,
+		}
+	}
+	return iceServerDTOs
+}
+
 // ========== 统计 API ==========
 
 // HandleSFUStats 获取 SFU 统计信息
@@ -724,9 +1031,28 @@ func (h *SFUHandler) HandleSFUStats(c *gin.Context) {
 		})
 	}
 
+	// 房间统计：订阅数为房间内所有参与者连接承载的设备轨道总数
+	rooms := h.sfuManager.GetAllRooms()
+	totalParticipants := 0
+	var roomStats []map[string]interface{}
+	for _, room := range rooms {
+		info := room.ToInfo()
+		totalParticipants += len(info.Participants)
+		roomStats = append(roomStats, map[string]interface{}{
+			"id":                info.ID,
+			"name":              info.Name,
+			"deviceCount":       len(info.Devices),
+			"participantCount":  len(info.Participants),
+			"subscriptionCount": info.SubscriptionCount,
+		})
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"totalPublishers":  len(publishers),
-		"totalSubscribers": totalSubscribers,
-		"publishers":       publisherStats,
+		"totalPublishers":       len(publishers),
+		"totalSubscribers":      totalSubscribers,
+		"publishers":            publisherStats,
+		"totalRooms":            len(rooms),
+		"totalRoomParticipants": totalParticipants,
+		"rooms":                 roomStats,
 	})
 }
@@ -0,0 +1,697 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/cloudphone/media-service/internal/admission"
+	"github.com/cloudphone/media-service/internal/deviceaccess"
+	"github.com/cloudphone/media-service/internal/logger"
+	"github.com/cloudphone/media-service/internal/middleware"
+	"github.com/cloudphone/media-service/internal/models"
+	"github.com/cloudphone/media-service/internal/webrtc"
+	"github.com/cloudphone/media-service/internal/websocket"
+	pionWebRTC "github.com/pion/webrtc/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.uber.org/zap"
+)
+
+// =============================================================================
+// WebSocket 信令协议 (/api/media/ws)
+// =============================================================================
+//
+// 替代 REST 轮询（POST /sessions、/sessions/answer、/sessions/ice-candidates），
+// 消息格式为 models.SignalingMessage，每个 WebSocket 帧一条 JSON 消息:
+//
+//	→ {"type":"create_session","id":"1","deviceId":"d1"}
+//	  (带 sdp 时为客户端发起协商，服务端回复 {"type":"answer",...} 并立即启动视频管道)
+//	← {"type":"offer","id":"1","sessionId":"s1","sdp":{...},"iceServers":[...],"resumeToken":"..."}
+//	← {"type":"ice_candidate","sessionId":"s1","candidate":{...}}    (trickle, 在 offer 之后推送)
+//	← {"type":"end_of_candidates","sessionId":"s1"}
+//	→ {"type":"answer","id":"2","sessionId":"s1","sdp":{...}}
+//	← {"type":"ack","id":"2","sessionId":"s1"}
+//	→ {"type":"ice_candidate","sessionId":"s1","candidate":{...}}   (带 id 时回复 ack)
+//	→ {"type":"renegotiate","id":"3","sessionId":"s1"}               (服务端生成新 offer)
+//	→ {"type":"offer","id":"3","sessionId":"s1","sdp":{...}}        (客户端重新协商，服务端回复 answer)
+//	→ {"type":"ice_restart","id":"5","sessionId":"s1"}               (网络切换后重启 ICE，带 sdp 时回复 answer)
+//	→ {"type":"resume","id":"6","sessionId":"s1","resumeToken":"..."} (新连接接管会话并重启 ICE)
+//	← {"type":"offer","id":"6","sessionId":"s1","sdp":{...},"iceServers":[...],"resumeToken":"..."}
+//	→ {"type":"close_session","id":"4","sessionId":"s1"}
+//	← {"type":"session_closed","sessionId":"s1","reason":"ice_failed"}
+//	← {"type":"error","id":"4","code":"not_found","error":"..."}
+//	  (create_session 被准入控制拒绝时 code 为准入错误码，如 user_session_limit、host_cpu_overloaded)
+//	→ {"type":"grant_control","id":"7","sessionId":"s1","targetSessionId":"s2"}  (owner 授予控制权，revoke_control 收回)
+//	→ {"type":"request_control","id":"8","sessionId":"s2"}                       (viewer 请求控制权)
+//	← {"type":"role_changed","sessionId":"s2","role":"controller"}
+//	← {"type":"control_requested","sessionId":"s1","userId":"u2","targetSessionId":"s2"}
+//	← {"type":"session_event","sessionId":"s1","event":{"type":"capture_state",...}}  (数据通道事件通道未打开时的回退，见 events.go)
+//
+// WebSocket 断开不会关闭已建立的会话（媒体流继续），只是不再推送信令消息。
+// 客户端重新连接后通过 resume 接管会话，恢复令牌每次 resume 后轮换。
+
+// signalingError 信令请求错误，包含返回给客户端的错误码
+type signalingError struct {
+	code    string
+	message string
+}
+
+func (e *signalingError) Error() string {
+	return e.message
+}
+
+func newSignalingError(code, message string) *signalingError {
+	return &signalingError{code: code, message: message}
+}
+
+// signalingConn 单个 WebSocket 信令连接
+type signalingConn struct {
+	userID   string
+	tenantID string
+	user     *middleware.UserContext // JWT 用户，用于校验会话归属
+
+	mu       sync.Mutex
+	client   *websocket.Client
+	sessions map[string]bool // sessionID -> 视频管道是否已启动
+}
+
+// attach 绑定 WebSocket 客户端（读取协程可能先于 ServeWs 返回收到消息）
+func (sc *signalingConn) attach(client *websocket.Client) {
+	sc.mu.Lock()
+	sc.client = client
+	sc.mu.Unlock()
+}
+
+// send 发送信令消息，连接已断开时忽略
+func (sc *signalingConn) send(msg *models.SignalingMessage) {
+	sc.mu.Lock()
+	client := sc.client
+	sc.mu.Unlock()
+
+	if client == nil {
+		return
+	}
+	if err := client.SendJSON(msg); err != nil {
+		logger.Debug("signaling_send_failed",
+			zap.String("user_id", sc.userID),
+			zap.String("type", msg.Type),
+			zap.String("session_id", msg.SessionID),
+			zap.Error(err),
+		)
+	}
+}
+
+// markPipelineStarted 标记会话的视频管道已启动，返回此前是否已启动
+func (sc *signalingConn) markPipelineStarted(sessionID string) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	started := sc.sessions[sessionID]
+	sc.sessions[sessionID] = true
+	return started
+}
+
+// signalingRegistry 会话到信令连接的映射，用于推送 session_closed
+type signalingRegistry struct {
+	mu    sync.Mutex
+	conns map[string]*signalingConn // sessionID -> conn
+}
+
+func newSignalingRegistry() *signalingRegistry {
+	return &signalingRegistry{conns: make(map[string]*signalingConn)}
+}
+
+// bind 将会话绑定到信令连接（后绑定的连接接管推送）
+func (r *signalingRegistry) bind(sessionID string, sc *signalingConn) {
+	r.mu.Lock()
+	r.conns[sessionID] = sc
+	r.mu.Unlock()
+
+	sc.mu.Lock()
+	if _, ok := sc.sessions[sessionID]; !ok {
+		sc.sessions[sessionID] = false
+	}
+	sc.mu.Unlock()
+}
+
+// lookup 返回会话绑定的信令连接（未绑定时为 nil）
+func (r *signalingRegistry) lookup(sessionID string) *signalingConn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.conns[sessionID]
+}
+
+// remove 解除会话绑定，返回绑定的连接
+func (r *signalingRegistry) remove(sessionID string) *signalingConn {
+	r.mu.Lock()
+	sc := r.conns[sessionID]
+	delete(r.conns, sessionID)
+	r.mu.Unlock()
+
+	if sc != nil {
+		sc.mu.Lock()
+		delete(sc.sessions, sessionID)
+		sc.mu.Unlock()
+	}
+	return sc
+}
+
+// detach 连接断开时解除其所有会话的绑定（会话本身保留）
+func (r *signalingRegistry) detach(sc *signalingConn) {
+	sc.mu.Lock()
+	sessionIDs := make([]string, 0, len(sc.sessions))
+	for sessionID := range sc.sessions {
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+	sc.mu.Unlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, sessionID := range sessionIDs {
+		if r.conns[sessionID] == sc {
+			delete(r.conns, sessionID)
+		}
+	}
+}
+
+// trickleSender 推送服务端 ICE 候选
+// 候选可能在 offer 发出之前就已生成，先缓存，offer 发出后再按顺序推送
+type trickleSender struct {
+	sc        *signalingConn
+	sessionID string
+
+	mu      sync.Mutex
+	ready   bool
+	pending []*pionWebRTC.ICECandidateInit
+	done    bool
+}
+
+func (t *trickleSender) onCandidate(candidate *pionWebRTC.ICECandidateInit) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.ready {
+		if candidate == nil {
+			t.done = true
+		} else {
+			t.pending = append(t.pending, candidate)
+		}
+		return
+	}
+	t.sendLocked(candidate)
+}
+
+// flush 在 offer 发出后调用，推送缓存的候选
+func (t *trickleSender) flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.ready = true
+	for _, candidate := range t.pending {
+		t.sendLocked(candidate)
+	}
+	t.pending = nil
+	if t.done {
+		t.sendLocked(nil)
+	}
+}
+
+func (t *trickleSender) sendLocked(candidate *pionWebRTC.ICECandidateInit) {
+	if candidate == nil {
+		t.sc.send(&models.SignalingMessage{Type: models.SignalingEndOfCandidates, SessionID: t.sessionID})
+		return
+	}
+	t.sc.send(&models.SignalingMessage{Type: models.SignalingICECandidate, SessionID: t.sessionID, Candidate: candidate})
+}
+
+// newSignalingConn 创建信令连接状态
+func newSignalingConn(userID, tenantID string, user *middleware.UserContext) *signalingConn {
+	return &signalingConn{
+		userID:   userID,
+		tenantID: tenantID,
+		user:     user,
+		sessions: make(map[string]bool),
+	}
+}
+
+// handleSignalingMessage 分发客户端信令消息（在 WebSocket 读取协程中顺序执行）
+func (h *Handler) handleSignalingMessage(sc *signalingConn, data []byte) {
+	var msg models.SignalingMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		sc.send(&models.SignalingMessage{
+			Type:  models.SignalingError,
+			Code:  models.SignalingErrBadRequest,
+			Error: "invalid signaling message: " + err.Error(),
+		})
+		return
+	}
+
+	var err error
+	switch msg.Type {
+	case models.SignalingCreateSession:
+		err = h.signalCreateSession(sc, &msg)
+	case models.SignalingAnswer:
+		err = h.signalAnswer(sc, &msg)
+	case models.SignalingICECandidate:
+		err = h.signalICECandidate(sc, &msg)
+	case models.SignalingRenegotiate:
+		err = h.signalRenegotiate(sc, &msg)
+	case models.SignalingOffer:
+		err = h.signalOffer(sc, &msg)
+	case models.SignalingICERestart:
+		err = h.signalICERestart(sc, &msg)
+	case models.SignalingResume:
+		err = h.signalResume(sc, &msg)
+	case models.SignalingCloseSession:
+		err = h.signalCloseSession(sc, &msg)
+	case models.SignalingGrantControl:
+		err = h.signalGrantControl(sc, &msg)
+	case models.SignalingRevokeControl:
+		err = h.signalRevokeControl(sc, &msg)
+	case models.SignalingRequestControl:
+		err = h.signalRequestControl(sc, &msg)
+	default:
+		err = newSignalingError(models.SignalingErrBadRequest, "unknown message type: "+msg.Type)
+	}
+
+	if err != nil {
+		code := models.SignalingErrInternal
+		if se, ok := err.(*signalingError); ok {
+			code = se.code
+		}
+		logger.Warn("signaling_request_failed",
+			zap.String("user_id", sc.userID),
+			zap.String("type", msg.Type),
+			zap.String("session_id", msg.SessionID),
+			zap.String("code", code),
+			zap.Error(err),
+		)
+		sc.send(&models.SignalingMessage{
+			Type:      models.SignalingError,
+			ID:        msg.ID,
+			SessionID: msg.SessionID,
+			Code:      code,
+			Error:     err.Error(),
+		})
+	}
+}
+
+// signalCreateSession 创建会话并返回 trickle offer
+func (h *Handler) signalCreateSession(sc *signalingConn, msg *models.SignalingMessage) error {
+	ctx, span := tracer.Start(context.Background(), "ws.create_session")
+	defer span.End()
+
+	if msg.DeviceID == "" {
+		return newSignalingError(models.SignalingErrBadRequest, "deviceId is required")
+	}
+
+	// 只有观看权限的用户创建 viewer 会话
+	level, err := checkDeviceAccess(ctx, h.deviceAccess, sc.user, msg.DeviceID, deviceaccess.LevelView)
+	if err != nil {
+		span.SetStatus(codes.Error, "device access denied")
+		switch {
+		case errors.Is(err, deviceaccess.ErrDeviceNotFound):
+			return newSignalingError(models.SignalingErrNotFound, "device not found")
+		case errors.Is(err, errDeviceAccessDenied):
+			return newSignalingError(models.SignalingErrForbidden, "no view access to device")
+		default:
+			return newSignalingError(models.SignalingErrInternal, "device access check unavailable")
+		}
+	}
+
+	if msg.SDP != nil && msg.SDP.Type != pionWebRTC.SDPTypeOffer {
+		return newSignalingError(models.SignalingErrBadRequest, "sdp must be an offer")
+	}
+
+	session, plan, err := h.createSessionForDevice(msg.DeviceID, sc.userID, sc.tenantID, sessionRoleFor(level), msg.SDP)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to create session")
+		if errors.Is(err, webrtc.ErrNoCommonCodec) {
+			return newSignalingError(models.SignalingErrBadRequest, err.Error())
+		}
+		// 准入拒绝使用准入错误码（如 user_session_limit、ice_ports_exhausted）
+		var rejected *admission.RejectedError
+		if errors.As(err, &rejected) {
+			return newSignalingError(rejected.Code, rejected.Message)
+		}
+		return newSignalingError(models.SignalingErrInternal, "failed to create session")
+	}
+
+	span.SetAttributes(
+		attribute.String("session.id", session.ID),
+		attribute.String("device.id", msg.DeviceID),
+		attribute.String("user.id", sc.userID),
+		attribute.String("video.codec", string(trackCodecFor(plan.Codec))),
+	)
+
+	trickle := &trickleSender{sc: sc, sessionID: session.ID}
+	reply := &models.SignalingMessage{
+		Type:        models.SignalingOffer,
+		ID:          msg.ID,
+		SessionID:   session.ID,
+		DeviceID:    session.DeviceID,
+		ICEServers:  h.webrtcManager.GetICEServers(),
+		ResumeToken: session.GetResumeToken(),
+		Role:        session.GetRole(),
+	}
+
+	if msg.SDP != nil {
+		// 客户端发起协商
+		reply.Type = models.SignalingAnswer
+		reply.SDP, err = h.webrtcManager.CreateAnswer(session.ID, *msg.SDP, trickle.onCandidate)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to create answer")
+			h.closeSession(session.ID)
+			return newSignalingError(models.SignalingErrBadRequest, "failed to process offer")
+		}
+	} else {
+		reply.SDP, err = h.webrtcManager.CreateTrickleOffer(session.ID, trickle.onCandidate)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "failed to create offer")
+			h.closeSession(session.ID)
+			return newSignalingError(models.SignalingErrInternal, "failed to create offer")
+		}
+	}
+
+	h.signaling.bind(session.ID, sc)
+	if msg.SDP != nil && !sc.markPipelineStarted(session.ID) && h.pipelineManager != nil {
+		go h.startVideoPipeline(context.Background(), session)
+	}
+
+	sc.send(reply)
+	trickle.flush()
+
+	span.SetStatus(codes.Ok, "session created")
+	logger.Info("session_created",
+		zap.String("session_id", session.ID),
+		zap.String("device_id", msg.DeviceID),
+		zap.String("user_id", sc.userID),
+		zap.String("role", string(session.GetRole())),
+		zap.String("signaling", "websocket"),
+	)
+
+	return nil
+}
+
+// signalAnswer 设置客户端 answer，首次 answer 后启动视频管道
+func (h *Handler) signalAnswer(sc *signalingConn, msg *models.SignalingMessage) error {
+	_, span := tracer.Start(context.Background(), "ws.set_answer")
+	defer span.End()
+	span.SetAttributes(attribute.String("session.id", msg.SessionID))
+
+	if msg.SDP == nil {
+		return newSignalingError(models.SignalingErrBadRequest, "sdp is required")
+	}
+	session, err := h.signalingSession(sc, msg.SessionID)
+	if err != nil {
+		return err
+	}
+
+	if err := h.webrtcManager.HandleAnswer(session.ID, *msg.SDP); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to handle answer")
+		return newSignalingError(models.SignalingErrBadRequest, "failed to handle answer")
+	}
+
+	// 重新协商的 answer 不重复启动管道
+	h.signaling.bind(session.ID, sc)
+	if !sc.markPipelineStarted(session.ID) && h.pipelineManager != nil {
+		go h.startVideoPipeline(context.Background(), session)
+	}
+
+	sc.send(&models.SignalingMessage{Type: models.SignalingAck, ID: msg.ID, SessionID: session.ID})
+
+	span.SetStatus(codes.Ok, "answer handled")
+	logger.Info("answer_handled",
+		zap.String("session_id", session.ID),
+		zap.String("signaling", "websocket"),
+	)
+
+	return nil
+}
+
+// signalICECandidate 添加客户端 ICE 候选
+func (h *Handler) signalICECandidate(sc *signalingConn, msg *models.SignalingMessage) error {
+	if msg.Candidate == nil {
+		return newSignalingError(models.SignalingErrBadRequest, "candidate is required")
+	}
+	session, err := h.signalingSession(sc, msg.SessionID)
+	if err != nil {
+		return err
+	}
+
+	if err := h.webrtcManager.AddICECandidate(session.ID, *msg.Candidate); err != nil {
+		return newSignalingError(models.SignalingErrBadRequest, "failed to add ICE candidate")
+	}
+
+	logger.Debug("browser_ice_candidate_received",
+		zap.String("session_id", session.ID),
+		zap.String("candidate", msg.Candidate.Candidate),
+	)
+
+	if msg.ID != "" {
+		sc.send(&models.SignalingMessage{Type: models.SignalingAck, ID: msg.ID, SessionID: session.ID})
+	}
+	return nil
+}
+
+// signalRenegotiate 服务端重新生成 offer（如增加音频轨道后）
+func (h *Handler) signalRenegotiate(sc *signalingConn, msg *models.SignalingMessage) error {
+	_, span := tracer.Start(context.Background(), "ws.renegotiate")
+	defer span.End()
+	span.SetAttributes(attribute.String("session.id", msg.SessionID))
+
+	session, err := h.signalingSession(sc, msg.SessionID)
+	if err != nil {
+		return err
+	}
+
+	trickle := &trickleSender{sc: sc, sessionID: session.ID}
+	offer, err := h.webrtcManager.CreateTrickleOffer(session.ID, trickle.onCandidate)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to create offer")
+		return newSignalingError(models.SignalingErrBadRequest, err.Error())
+	}
+
+	h.signaling.bind(session.ID, sc)
+	sc.send(&models.SignalingMessage{
+		Type:      models.SignalingOffer,
+		ID:        msg.ID,
+		SessionID: session.ID,
+		SDP:       offer,
+	})
+	trickle.flush()
+
+	span.SetStatus(codes.Ok, "renegotiation offer created")
+	logger.Info("session_renegotiation_started",
+		zap.String("session_id", session.ID),
+	)
+
+	return nil
+}
+
+// signalOffer 客户端发起的重新协商，回复 answer
+func (h *Handler) signalOffer(sc *signalingConn, msg *models.SignalingMessage) error {
+	_, span := tracer.Start(context.Background(), "ws.client_offer")
+	defer span.End()
+	span.SetAttributes(attribute.String("session.id", msg.SessionID))
+
+	if msg.SDP == nil || msg.SDP.Type != pionWebRTC.SDPTypeOffer {
+		return newSignalingError(models.SignalingErrBadRequest, "sdp offer is required")
+	}
+	session, err := h.signalingSession(sc, msg.SessionID)
+	if err != nil {
+		return err
+	}
+
+	trickle := &trickleSender{sc: sc, sessionID: session.ID}
+	answer, err := h.webrtcManager.CreateAnswer(session.ID, *msg.SDP, trickle.onCandidate)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to create answer")
+		return newSignalingError(models.SignalingErrBadRequest, err.Error())
+	}
+
+	h.signaling.bind(session.ID, sc)
+	sc.send(&models.SignalingMessage{
+		Type:      models.SignalingAnswer,
+		ID:        msg.ID,
+		SessionID: session.ID,
+		SDP:       answer,
+	})
+	trickle.flush()
+
+	span.SetStatus(codes.Ok, "client offer answered")
+	logger.Info("session_client_offer_answered",
+		zap.String("session_id", session.ID),
+	)
+
+	return nil
+}
+
+// signalICERestart 重启会话的 ICE（信令连接仍然可用）
+func (h *Handler) signalICERestart(sc *signalingConn, msg *models.SignalingMessage) error {
+	_, span := tracer.Start(context.Background(), "ws.ice_restart")
+	defer span.End()
+	span.SetAttributes(attribute.String("session.id", msg.SessionID))
+
+	session, err := h.signalingSession(sc, msg.SessionID)
+	if err != nil {
+		return err
+	}
+
+	reply, trickle, err := h.signalRestartICE(sc, session, msg)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to restart ice")
+		return err
+	}
+	sc.send(reply)
+	trickle.flush()
+
+	span.SetStatus(codes.Ok, "ice restarted")
+	logger.Info("ice_restart_started",
+		zap.String("session_id", session.ID),
+		zap.Bool("client_offer", msg.SDP != nil),
+		zap.String("signaling", "websocket"),
+	)
+
+	return nil
+}
+
+// signalResume 新的信令连接凭恢复令牌接管会话并重启 ICE
+// 会话的视频管道仍在运行，后续 answer 不会重复启动
+func (h *Handler) signalResume(sc *signalingConn, msg *models.SignalingMessage) error {
+	_, span := tracer.Start(context.Background(), "ws.resume")
+	defer span.End()
+	span.SetAttributes(attribute.String("session.id", msg.SessionID))
+
+	if msg.ResumeToken == "" {
+		return newSignalingError(models.SignalingErrBadRequest, "resumeToken is required")
+	}
+	session, err := h.signalingSession(sc, msg.SessionID)
+	if err != nil {
+		return err
+	}
+
+	resumeToken, err := h.webrtcManager.ResumeSession(session.ID, msg.ResumeToken)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to resume session")
+		if errors.Is(err, webrtc.ErrInvalidResumeToken) {
+			return newSignalingError(models.SignalingErrForbidden, err.Error())
+		}
+		return newSignalingError(models.SignalingErrNotFound, "session not found")
+	}
+
+	h.signaling.bind(session.ID, sc)
+	sc.markPipelineStarted(session.ID)
+
+	reply, trickle, err := h.signalRestartICE(sc, session, msg)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to restart ice")
+		return err
+	}
+	reply.ResumeToken = resumeToken
+	reply.ICEServers = h.webrtcManager.GetICEServers()
+	sc.send(reply)
+	trickle.flush()
+
+	span.SetStatus(codes.Ok, "session resumed")
+	logger.Info("session_resumed",
+		zap.String("session_id", session.ID),
+		zap.Bool("client_offer", msg.SDP != nil),
+		zap.String("signaling", "websocket"),
+	)
+
+	return nil
+}
+
+// signalRestartICE 重启 ICE 并构造回复：客户端提供 offer 时回复 answer，否则回复服务端 ICE restart offer
+// 调用方发送回复后再 flush 推送缓存的候选
+func (h *Handler) signalRestartICE(sc *signalingConn, session *models.Session, msg *models.SignalingMessage) (*models.SignalingMessage, *trickleSender, error) {
+	if msg.SDP != nil && msg.SDP.Type != pionWebRTC.SDPTypeOffer {
+		return nil, nil, newSignalingError(models.SignalingErrBadRequest, "sdp must be an offer")
+	}
+
+	trickle := &trickleSender{sc: sc, sessionID: session.ID}
+	sdp, err := h.webrtcManager.RestartICE(session.ID, msg.SDP, trickle.onCandidate)
+	if err != nil {
+		return nil, nil, newSignalingError(models.SignalingErrBadRequest, err.Error())
+	}
+
+	reply := &models.SignalingMessage{
+		Type:      models.SignalingOffer,
+		ID:        msg.ID,
+		SessionID: session.ID,
+		SDP:       sdp,
+	}
+	if msg.SDP != nil {
+		reply.Type = models.SignalingAnswer
+	}
+
+	h.signaling.bind(session.ID, sc)
+	return reply, trickle, nil
+}
+
+// signalCloseSession 关闭会话
+func (h *Handler) signalCloseSession(sc *signalingConn, msg *models.SignalingMessage) error {
+	session, err := h.signalingSession(sc, msg.SessionID)
+	if err != nil {
+		return err
+	}
+
+	// 主动关闭只回复 ack，不再推送 session_closed
+	h.signaling.remove(session.ID)
+	if err := h.closeSession(session.ID); err != nil {
+		return newSignalingError(models.SignalingErrNotFound, "session not found")
+	}
+
+	sc.send(&models.SignalingMessage{Type: models.SignalingAck, ID: msg.ID, SessionID: session.ID})
+	return nil
+}
+
+// signalingSession 查找会话并校验归属（只能操作本用户的会话，管理员除外）
+func (h *Handler) signalingSession(sc *signalingConn, sessionID string) (*models.Session, error) {
+	if sessionID == "" {
+		return nil, newSignalingError(models.SignalingErrBadRequest, "sessionId is required")
+	}
+
+	session, err := h.webrtcManager.GetSession(sessionID)
+	if err != nil {
+		return nil, newSignalingError(models.SignalingErrNotFound, "session not found")
+	}
+	if !sc.user.CanAccess(session.UserID, session.TenantID) {
+		return nil, newSignalingError(models.SignalingErrForbidden, "session belongs to another user")
+	}
+
+	return session, nil
+}
+
+// onSessionClosed 会话关闭时停止管道并通知绑定的信令连接
+func (h *Handler) onSessionClosed(sessionID, reason string) {
+	if h.pipelineManager != nil && reason != webrtc.SessionCloseReasonClosed {
+		// 主动关闭的会话已由 closeSession 停止管道
+		h.pipelineManager.StopAllPipelines(sessionID)
+	}
+	if h.admission != nil {
+		h.admission.Release(sessionID)
+	}
+
+	sc := h.signaling.remove(sessionID)
+	if sc == nil {
+		return
+	}
+
+	sc.send(&models.SignalingMessage{
+		Type:      models.SignalingSessionClosed,
+		SessionID: sessionID,
+		Reason:    reason,
+	})
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cloudphone/media-service/internal/encoder"
+	"github.com/cloudphone/media-service/internal/logger"
+	"github.com/cloudphone/media-service/internal/models"
+	"github.com/cloudphone/media-service/internal/webrtc"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/zap"
+)
+
+// sourceProbeTimeout 等待视频源输出首帧的时间，超时视为该视频源不可用
+// 部分模拟器镜像的 MediaCodec 可以启动但不输出任何帧，只能通过首帧探测发现
+const sourceProbeTimeout = 5 * time.Second
+
+// newDefaultPipelineBuilder 未注入 PipelineBuilder 时根据 scrcpy/回退链选项创建
+func newDefaultPipelineBuilder(adbPath, scrcpyServerPath string, useScrcpy, h264Fallback bool, log *logrus.Logger) *encoder.PipelineBuilder {
+	if !useScrcpy {
+		scrcpyServerPath = ""
+	}
+	return encoder.NewPipelineBuilder(adbPath, log,
+		encoder.WithBuilderScrcpyServer(scrcpyServerPath),
+		encoder.WithBuilderH264Fallback(h264Fallback),
+	)
+}
+
+// trackCodecFor 将管道输出编码映射为 WebRTC 轨道编码
+func trackCodecFor(codec encoder.StreamCodec) webrtc.VideoCodecType {
+	if codec == encoder.StreamCodecH264 {
+		return webrtc.VideoCodecH264
+	}
+	return webrtc.VideoCodecVP8
+}
+
+// streamCodecFor 将 WebRTC 轨道编码映射为管道输出编码
+func streamCodecFor(codec webrtc.VideoCodecType) encoder.StreamCodec {
+	if codec == webrtc.VideoCodecH264 {
+		return encoder.StreamCodecH264
+	}
+	return encoder.StreamCodecVP8
+}
+
+// runVideoPlan 按回退链依次尝试视频源，直到某个视频源成功输出首帧
+// 1:1 会话和 SFU 发布者共用，返回的 info 记录了每一次尝试
+func runVideoPlan(
+	ctx context.Context,
+	pipelineManager *encoder.PipelineManager,
+	builder *encoder.PipelineBuilder,
+	sessionID, deviceID string,
+	plan *encoder.PipelinePlan,
+	frameWriter encoder.FrameWriter,
+	onAttempt func(info *models.VideoPipelineInfo),
+) (*models.VideoPipelineInfo, error) {
+	info := &models.VideoPipelineInfo{Codec: string(trackCodecFor(plan.Codec))}
+
+	for i, step := range plan.Steps {
+		isLast := i == len(plan.Steps)-1
+
+		// 最后一个视频源不做首帧探测，没有更多可回退的选项
+		err := tryVideoSource(ctx, pipelineManager, builder, sessionID, step, deviceID, frameWriter, !isLast)
+
+		attempt := models.VideoSourceAttempt{Source: string(step.CaptureMode), At: time.Now()}
+		if err != nil {
+			attempt.Error = err.Error()
+			info.Attempts = append(info.Attempts, attempt)
+			if onAttempt != nil {
+				onAttempt(info)
+			}
+
+			logger.Warn("video_source_failed",
+				zap.String("session_id", sessionID),
+				zap.String("device_id", deviceID),
+				zap.String("source", string(step.CaptureMode)),
+				zap.Bool("will_fallback", !isLast),
+				zap.Error(err),
+			)
+			continue
+		}
+
+		info.Attempts = append(info.Attempts, attempt)
+		info.Source = string(step.CaptureMode)
+		info.Encoder = string(step.EncoderType)
+		if onAttempt != nil {
+			onAttempt(info)
+		}
+
+		logger.Info("video_pipeline_started",
+			zap.String("session_id", sessionID),
+			zap.String("device_id", deviceID),
+			zap.String("source", string(step.CaptureMode)),
+			zap.String("encoder", string(step.EncoderType)),
+			zap.String("video_codec", info.Codec),
+			zap.Int("failed_sources", len(info.Attempts)-1),
+			zap.Int("target_fps", step.FPS),
+			zap.Int("target_bitrate", step.Bitrate),
+			zap.Int("target_width", step.Width),
+			zap.Int("target_height", step.Height),
+		)
+		return info, nil
+	}
+
+	return info, fmt.Errorf("all video sources failed: %v", plan.CaptureModes())
+}
+
+// tryVideoSource 使用指定视频源启动管道
+// probe=true 时等待首帧，超时则拆除管道并返回错误，以便回退到下一个视频源
+func tryVideoSource(
+	ctx context.Context,
+	pipelineManager *encoder.PipelineManager,
+	builder *encoder.PipelineBuilder,
+	sessionID string,
+	step encoder.PipelineSpec,
+	deviceID string,
+	frameWriter encoder.FrameWriter,
+	probe bool,
+) error {
+	built, err := builder.Build(step, frameWriter)
+	if err != nil {
+		return err
+	}
+
+	if err := pipelineManager.CreateBuiltVideoPipeline(ctx, sessionID, deviceID, built); err != nil {
+		if built.Capture.IsRunning() {
+			built.Capture.Stop()
+		}
+		return err
+	}
+
+	if !probe {
+		return nil
+	}
+
+	if err := waitForFirstFrame(ctx, pipelineManager, sessionID, sourceProbeTimeout); err != nil {
+		if stopErr := pipelineManager.StopVideoPipeline(sessionID); stopErr != nil {
+			logger.Warn("failed_to_stop_probe_pipeline",
+				zap.String("session_id", sessionID),
+				zap.String("source", string(step.CaptureMode)),
+				zap.Error(stopErr),
+			)
+		}
+		if built.Capture.IsRunning() {
+			built.Capture.Stop()
+		}
+		return err
+	}
+
+	return nil
+}
+
+// waitForFirstFrame 等待管道处理第一帧
+func waitForFirstFrame(ctx context.Context, pipelineManager *encoder.PipelineManager, sessionID string, timeout time.Duration) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline.C:
+			return fmt.Errorf("no frames within %s", timeout)
+		case <-ticker.C:
+			stats, err := pipelineManager.GetVideoPipelineStats(sessionID)
+			if err != nil {
+				return err
+			}
+			if stats.FramesProcessed > 0 {
+				return nil
+			}
+		}
+	}
+}
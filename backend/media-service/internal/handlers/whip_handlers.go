@@ -0,0 +1,512 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/cloudphone/media-service/internal/admission"
+	"github.com/cloudphone/media-service/internal/deviceaccess"
+	"github.com/cloudphone/media-service/internal/logger"
+	"github.com/cloudphone/media-service/internal/middleware"
+	"github.com/cloudphone/media-service/internal/sfu"
+	"github.com/gin-gonic/gin"
+	pionWebRTC "github.com/pion/webrtc/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.uber.org/zap"
+)
+
+// ========== WHIP / WHEP API ==========
+//
+// 标准 HTTP 信令，供 OBS、GStreamer、ffmpeg 等工具和第三方播放器使用：
+//
+//	POST   /sfu/whip/:deviceId       推流（Content-Type: application/sdp），替代设备采集
+//	POST   /sfu/whep/:id             播放（:id 为设备 ID 或发布者 ID）
+//	PATCH  /sfu/whi{p,ep}/resources/:id  trickle ICE（Content-Type: application/trickle-ice-sdpfrag）
+//	DELETE /sfu/whi{p,ep}/resources/:id  结束推流/播放
+//
+// POST 成功返回 201 Created、SDP answer 和 Location 头（资源 URL），
+// ICE 服务器通过 Link 头下发（rel="ice-server"）。
+
+const (
+	contentTypeSDP      = "application/sdp"
+	contentTypeSDPFrag  = "application/trickle-ice-sdpfrag"
+	maxSDPBodySize      = 64 * 1024
+	whipResourceSegment = "resources"
+)
+
+// readSDPBody 读取指定 Content-Type 的请求体
+func readSDPBody(c *gin.Context, contentType string) (string, int, error) {
+	mediaType, _, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if err != nil || mediaType != contentType {
+		return "", http.StatusUnsupportedMediaType, fmt.Errorf("Content-Type must be %s", contentType)
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxSDPBodySize))
+	if err != nil {
+		return "", http.StatusRequestEntityTooLarge, fmt.Errorf("failed to read body: %w", err)
+	}
+	if len(body) == 0 {
+		return "", http.StatusBadRequest, errors.New("empty body")
+	}
+
+	return string(body), http.StatusOK, nil
+}
+
+// writeSDPAnswer 返回 201 Created 和 SDP answer
+func writeSDPAnswer(c *gin.Context, resourceID string, answer *pionWebRTC.SessionDescription, iceServers []pionWebRTC.ICEServer) {
+	// POST /sfu/whip/:deviceId -> /sfu/whip/resources/:id
+	location := path.Join(path.Dir(c.Request.URL.Path), whipResourceSegment, resourceID)
+
+	c.Header("Location", location)
+	for _, link := range iceServerLinks(iceServers) {
+		c.Writer.Header().Add("Link", link)
+	}
+	c.Data(http.StatusCreated, contentTypeSDP, []byte(answer.SDP))
+}
+
+// iceServerLinks 将 ICE 服务器转换为 Link 头（RFC 9725 第 4.6 节）
+func iceServerLinks(servers []pionWebRTC.ICEServer) []string {
+	var links []string
+	for _, server := range servers {
+		var credential string
+		if cred, ok := server.Credential.(string); ok {
+			credential = cred
+		}
+		for _, url := range server.URLs {
+			link := fmt.Sprintf("<%s>; rel=\"ice-server\"", url)
+			if server.Username != "" {
+				link += fmt.Sprintf("; username=%q; credential=%q; credential-type=\"password\"", server.Username, credential)
+			}
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// parseTrickleICEFragment 解析 trickle-ice-sdpfrag（RFC 8840），返回其中的候选
+func parseTrickleICEFragment(fragment string) ([]pionWebRTC.ICECandidateInit, error) {
+	var (
+		candidates []pionWebRTC.ICECandidateInit
+		mid        *string
+		mLineIndex = -1
+	)
+
+	for _, line := range strings.Split(fragment, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "m="):
+			mLineIndex++
+			mid = nil
+		case strings.HasPrefix(line, "a=mid:"):
+			value := strings.TrimPrefix(line, "a=mid:")
+			mid = &value
+		case strings.HasPrefix(line, "a=candidate:"):
+			candidate := pionWebRTC.ICECandidateInit{
+				Candidate: strings.TrimPrefix(line, "a="),
+				SDPMid:    mid,
+			}
+			if mLineIndex >= 0 {
+				index := uint16(mLineIndex)
+				candidate.SDPMLineIndex = &index
+			}
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	if len(candidates) == 0 && !strings.Contains(fragment, "a=end-of-candidates") {
+		return nil, errors.New("no candidates in fragment")
+	}
+	return candidates, nil
+}
+
+// patchICECandidates 处理 trickle ICE PATCH 请求
+func patchICECandidates(c *gin.Context, resourceID string, addCandidate func(string, pionWebRTC.ICECandidateInit) error) {
+	body, status, err := readSDPBody(c, contentTypeSDPFrag)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	candidates, err := parseTrickleICEFragment(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, candidate := range candidates {
+		if err := addCandidate(resourceID, candidate); err != nil {
+			logger.Warn("failed_to_add_trickle_ice_candidate",
+				zap.String("resource_id", resourceID),
+				zap.Error(err),
+			)
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Failed to add ICE candidate"})
+			return
+		}
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// HandleWHIPPublish 外部编码器推流到设备的 SFU 房间
+// POST /api/media/sfu/whip/:deviceId
+func (h *SFUHandler) HandleWHIPPublish(c *gin.Context) {
+	ctx := c.Request.Context()
+	_, span := tracer.Start(ctx, "sfu.whip_publish")
+	defer span.End()
+
+	deviceID := c.Param("deviceId")
+	offer, status, err := readSDPBody(c, contentTypeSDP)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid request")
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	userCtx, ok := currentUser(c)
+	if !ok {
+		span.SetStatus(codes.Error, "unauthorized user")
+		return
+	}
+	userID, tenantID := userCtx.UserID, userCtx.TenantID
+
+	// 推流替代设备采集，需要设备的控制权限
+	if !authorizeDevice(c, h.deviceAccess, deviceID, deviceaccess.LevelControl) {
+		span.SetStatus(codes.Error, "device access denied")
+		return
+	}
+
+	span.SetAttributes(
+		attribute.String("device.id", deviceID),
+		attribute.String("user.id", userID),
+	)
+
+	reservation, ok := admitSession(c, h.admission, admission.Request{UserID: userID, TenantID: tenantID, DeviceID: deviceID})
+	if !ok {
+		span.SetStatus(codes.Error, "admission rejected")
+		return
+	}
+
+	publisher, answer, err := h.sfuManager.CreateIngestPublisher(deviceID, userID, pionWebRTC.SessionDescription{
+		Type: pionWebRTC.SDPTypeOffer,
+		SDP:  offer,
+	})
+	if err != nil {
+		reservation.Cancel()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to create ingest publisher")
+		logger.Warn("failed_to_create_whip_publisher",
+			zap.String("device_id", deviceID),
+			zap.Error(err),
+		)
+		switch {
+		case errors.Is(err, sfu.ErrDeviceHasPublisher):
+			c.JSON(http.StatusConflict, gin.H{"error": "Device already has an active publisher"})
+		case errors.Is(err, sfu.ErrUnsupportedOffer):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to process offer"})
+		}
+		return
+	}
+	reservation.Commit(publisher.ID)
+	publisher.TenantID = tenantID
+
+	span.SetAttributes(attribute.String("publisher.id", publisher.ID))
+	span.SetStatus(codes.Ok, "ingest publisher created")
+	logger.Info("sfu_whip_publisher_created",
+		zap.String("publisher_id", publisher.ID),
+		zap.String("device_id", deviceID),
+		zap.String("video_codec", publisher.VideoTrack.Codec().MimeType),
+	)
+
+	writeSDPAnswer(c, publisher.ID, answer, h.sfuManager.GetICEServers())
+}
+
+// HandleWHIPPatch WHIP trickle ICE
+// PATCH /api/media/sfu/whip/resources/:id
+func (h *SFUHandler) HandleWHIPPatch(c *gin.Context) {
+	publisherID := c.Param("id")
+
+	publisher, err := h.sfuManager.GetPublisher(publisherID)
+	if err != nil || !publisher.IsIngest() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Publisher not found"})
+		return
+	}
+	if !authorizeResource(c, "publisher", publisher.ID, publisher.UserID, publisher.TenantID) {
+		return
+	}
+
+	patchICECandidates(c, publisherID, h.sfuManager.AddPublisherICECandidate)
+}
+
+// HandleWHIPDelete 结束 WHIP 推流（关闭发布者及其订阅者）
+// DELETE /api/media/sfu/whip/resources/:id
+func (h *SFUHandler) HandleWHIPDelete(c *gin.Context) {
+	publisherID := c.Param("id")
+
+	publisher, err := h.sfuManager.GetPublisher(publisherID)
+	if err != nil || !publisher.IsIngest() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Publisher not found"})
+		return
+	}
+	if !authorizeResource(c, "publisher", publisher.ID, publisher.UserID, publisher.TenantID) {
+		return
+	}
+
+	if err := h.sfuManager.ClosePublisher(publisherID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Publisher not found"})
+		return
+	}
+
+	logger.Info("sfu_whip_publisher_closed",
+		zap.String("publisher_id", publisherID),
+	)
+
+	c.Status(http.StatusOK)
+}
+
+// HandleWHEPSubscribe 通过单个 SDP offer 创建订阅者
+// POST /api/media/sfu/whep/:id（:id 为设备 ID 或发布者 ID）
+func (h *SFUHandler) HandleWHEPSubscribe(c *gin.Context) {
+	ctx := c.Request.Context()
+	_, span := tracer.Start(ctx, "sfu.whep_subscribe")
+	defer span.End()
+
+	target := c.Param("id")
+	offer, status, err := readSDPBody(c, contentTypeSDP)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid request")
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	userCtx, ok := currentUser(c)
+	if !ok {
+		span.SetStatus(codes.Error, "unauthorized user")
+		return
+	}
+	userID := userCtx.UserID
+
+	publisher, err := h.sfuManager.GetPublisherByDevice(target)
+	if err != nil {
+		publisher, err = h.sfuManager.GetPublisher(target)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "no publisher")
+		c.JSON(http.StatusNotFound, gin.H{"error": "No active publisher for this device"})
+		return
+	}
+	if !h.authorizeSubscribe(c, publisher) {
+		span.SetStatus(codes.Error, "publisher not accessible")
+		return
+	}
+	reservation, ok := admitSession(c, h.admission, admission.Request{UserID: userID, TenantID: userCtx.TenantID, DeviceID: publisher.DeviceID})
+	if !ok {
+		span.SetStatus(codes.Error, "admission rejected")
+		return
+	}
+
+	span.SetAttributes(
+		attribute.String("publisher.id", publisher.ID),
+		attribute.String("device.id", publisher.DeviceID),
+		attribute.String("user.id", userID),
+	)
+
+	subscriber, err := h.sfuManager.CreateSubscriber(publisher.ID, userID)
+	if err != nil {
+		reservation.Cancel()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to create subscriber")
+		logger.Error("failed_to_create_whep_subscriber",
+			zap.String("publisher_id", publisher.ID),
+			zap.Error(err),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create subscriber"})
+		return
+	}
+	reservation.Commit(subscriber.ID)
+	subscriber.TenantID = userCtx.TenantID
+
+	answer, err := h.sfuManager.HandleSubscriberOffer(subscriber.ID, pionWebRTC.SessionDescription{
+		Type: pionWebRTC.SDPTypeOffer,
+		SDP:  offer,
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to handle offer")
+		logger.Warn("failed_to_handle_whep_offer",
+			zap.String("subscriber_id", subscriber.ID),
+			zap.Error(err),
+		)
+		h.sfuManager.CloseSubscriber(subscriber.ID)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to process offer"})
+		return
+	}
+
+	span.SetAttributes(attribute.String("subscriber.id", subscriber.ID))
+	span.SetStatus(codes.Ok, "subscriber created")
+	logger.Info("sfu_whep_subscriber_created",
+		zap.String("subscriber_id", subscriber.ID),
+		zap.String("publisher_id", publisher.ID),
+		zap.String("device_id", publisher.DeviceID),
+	)
+
+	writeSDPAnswer(c, subscriber.ID, answer, h.sfuManager.GetICEServers())
+}
+
+// HandleWHEPPatch WHEP trickle ICE
+// PATCH /api/media/sfu/whep/resources/:id
+func (h *SFUHandler) HandleWHEPPatch(c *gin.Context) {
+	subscriberID := c.Param("id")
+
+	if _, ok := h.authorizedSubscriber(c, subscriberID); !ok {
+		return
+	}
+
+	patchICECandidates(c, subscriberID, h.sfuManager.AddSubscriberICECandidate)
+}
+
+// HandleWHEPDelete 结束 WHEP 播放
+// DELETE /api/media/sfu/whep/resources/:id
+func (h *SFUHandler) HandleWHEPDelete(c *gin.Context) {
+	subscriberID := c.Param("id")
+
+	if _, ok := h.authorizedSubscriber(c, subscriberID); !ok {
+		return
+	}
+
+	if err := h.sfuManager.CloseSubscriber(subscriberID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscriber not found"})
+		return
+	}
+
+	logger.Info("sfu_whep_subscriber_closed",
+		zap.String("subscriber_id", subscriberID),
+	)
+
+	c.Status(http.StatusOK)
+}
+
+// ========== 级联 SFU 中继（实例间 WHEP）==========
+//
+// 其他实例以服务令牌（middleware.ServiceAuthMiddleware）订阅本实例的设备发布者：
+//
+//	POST   /internal/media/sfu/relay/whep/:deviceId       创建中继订阅
+//	DELETE /internal/media/sfu/relay/whep/resources/:id   结束中继订阅
+//
+// 中继实例在本地逐个校验订阅者，这里只接受本实例的设备发布者（不再中继其他实例的中继）。
+// 中继订阅计入主机负载和设备配额，不计入用户配额。
+
+// relaySubscriberUserPrefix 中继订阅者的 UserID 前缀（后接调用方服务名）
+const relaySubscriberUserPrefix = "service:"
+
+// HandleRelayWHEPSubscribe 其他实例创建中继订阅
+// POST /internal/media/sfu/relay/whep/:deviceId
+func (h *SFUHandler) HandleRelayWHEPSubscribe(c *gin.Context) {
+	ctx := c.Request.Context()
+	_, span := tracer.Start(ctx, "sfu.relay_whep_subscribe")
+	defer span.End()
+
+	deviceID := c.Param("deviceId")
+	offer, status, err := readSDPBody(c, contentTypeSDP)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid request")
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	publisher, err := h.sfuManager.GetPublisherByDevice(deviceID)
+	if err != nil || publisher.IsRelay() {
+		span.SetStatus(codes.Error, "no publisher")
+		c.JSON(http.StatusNotFound, gin.H{"error": "No active publisher for this device"})
+		return
+	}
+	reservation, ok := admitSession(c, h.admission, admission.Request{DeviceID: publisher.DeviceID})
+	if !ok {
+		span.SetStatus(codes.Error, "admission rejected")
+		return
+	}
+
+	service := c.GetString(middleware.ServiceContextKey)
+	span.SetAttributes(
+		attribute.String("publisher.id", publisher.ID),
+		attribute.String("device.id", publisher.DeviceID),
+		attribute.String("service", service),
+	)
+
+	subscriber, err := h.sfuManager.CreateSubscriber(publisher.ID, relaySubscriberUserPrefix+service)
+	if err != nil {
+		reservation.Cancel()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to create subscriber")
+		logger.Error("failed_to_create_relay_subscriber",
+			zap.String("publisher_id", publisher.ID),
+			zap.Error(err),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create subscriber"})
+		return
+	}
+	reservation.Commit(subscriber.ID)
+	subscriber.TenantID = publisher.TenantID
+
+	answer, err := h.sfuManager.HandleSubscriberOffer(subscriber.ID, pionWebRTC.SessionDescription{
+		Type: pionWebRTC.SDPTypeOffer,
+		SDP:  offer,
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to handle offer")
+		logger.Warn("failed_to_handle_relay_offer",
+			zap.String("subscriber_id", subscriber.ID),
+			zap.Error(err),
+		)
+		h.sfuManager.CloseSubscriber(subscriber.ID)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to process offer"})
+		return
+	}
+
+	span.SetAttributes(attribute.String("subscriber.id", subscriber.ID))
+	span.SetStatus(codes.Ok, "relay subscriber created")
+	logger.Info("sfu_relay_subscriber_created",
+		zap.String("subscriber_id", subscriber.ID),
+		zap.String("publisher_id", publisher.ID),
+		zap.String("device_id", publisher.DeviceID),
+		zap.String("service", service),
+	)
+
+	writeSDPAnswer(c, subscriber.ID, answer, h.sfuManager.GetICEServers())
+}
+
+// HandleRelayWHEPDelete 其他实例结束中继订阅（只能结束中继订阅者）
+// DELETE /internal/media/sfu/relay/whep/resources/:id
+func (h *SFUHandler) HandleRelayWHEPDelete(c *gin.Context) {
+	subscriberID := c.Param("id")
+
+	subscriber, err := h.sfuManager.GetSubscriber(subscriberID)
+	if err != nil || !strings.HasPrefix(subscriber.UserID, relaySubscriberUserPrefix) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscriber not found"})
+		return
+	}
+
+	if err := h.sfuManager.CloseSubscriber(subscriberID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscriber not found"})
+		return
+	}
+
+	logger.Info("sfu_relay_subscriber_closed",
+		zap.String("subscriber_id", subscriberID),
+		zap.String("service", c.GetString(middleware.ServiceContextKey)),
+	)
+
+	c.Status(http.StatusOK)
+}
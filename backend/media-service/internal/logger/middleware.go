@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"net/url"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -18,7 +19,7 @@ func GinLogger() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
 		path := c.Request.URL.Path
-		query := c.Request.URL.RawQuery
+		query := redactQuery(c.Request.URL.RawQuery)
 
 		// 处理请求
 		c.Next()
@@ -68,6 +69,31 @@ func GinLogger() gin.HandlerFunc {
 	}
 }
 
+// redactedQueryParams 不记录值的查询参数（WebSocket 升级请求通过 ?token= 传递 JWT）
+var redactedQueryParams = []string{"token"}
+
+// redactQuery 替换查询字符串中的凭证，无法解析时整体省略
+func redactQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "[REDACTED]"
+	}
+	redacted := false
+	for _, key := range redactedQueryParams {
+		if _, ok := values[key]; ok {
+			values.Set(key, "[REDACTED]")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return rawQuery
+	}
+	return values.Encode()
+}
+
 // GinRecovery 返回一个 Gin 中间件，用于恢复 panic 并记录
 //
 // 类似于 Winston 的 AllExceptionsFilter
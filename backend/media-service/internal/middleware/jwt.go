@@ -31,6 +31,7 @@ type UserContext struct {
 	Roles       []string
 	Permissions []string
 	TenantID    string
+	Token       string // 原始 JWT（代表用户调用下游服务时转发）
 }
 
 const (
@@ -38,6 +39,17 @@ const (
 	UserContextKey = "user"
 )
 
+// 权限（与 user-service 的权限定义一致）
+const (
+	// PermissionAll 通配权限（super_admin）
+	PermissionAll = "*"
+	// PermissionMediaAdmin 媒体管理权限（admin / device_manager 角色）
+	// 可访问同租户其他用户的会话、发布者、订阅者和录像，并执行清理等管理操作
+	PermissionMediaAdmin = "media:stream-control"
+	// PermissionMediaStats 查看全局媒体统计
+	PermissionMediaStats = "media:stats"
+)
+
 // JWTMiddleware JWT 认证中间件
 // 验证请求头中的 JWT token，并将解析后的用户信息存储到 context 中
 func JWTMiddleware() gin.HandlerFunc {
@@ -52,6 +64,12 @@ func JWTMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 从请求头获取 Authorization token
 		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" && c.IsWebsocket() {
+			// 浏览器 WebSocket API 无法设置请求头，升级请求允许通过 ?token= 传递
+			if token := c.Query("token"); token != "" {
+				authHeader = "Bearer " + token
+			}
+		}
 		if authHeader == "" {
 			logger.Warn("jwt_missing_token",
 				zap.String("path", c.Request.URL.Path),
@@ -174,6 +192,7 @@ func JWTMiddleware() gin.HandlerFunc {
 			Roles:       claims.Roles,
 			Permissions: claims.Permissions,
 			TenantID:    claims.TenantID,
+			Token:       tokenString,
 		}
 		c.Set(UserContextKey, userCtx)
 
@@ -219,20 +238,7 @@ func RequirePermission(requiredPermissions ...string) gin.HandlerFunc {
 		}
 
 		// 检查用户是否拥有任一所需权限
-		hasPermission := false
-		userPermissionsMap := make(map[string]bool)
-		for _, perm := range userCtx.Permissions {
-			userPermissionsMap[perm] = true
-		}
-
-		for _, required := range requiredPermissions {
-			if userPermissionsMap[required] {
-				hasPermission = true
-				break
-			}
-		}
-
-		if !hasPermission {
+		if !userCtx.HasPermission(requiredPermissions...) {
 			logger.Warn("permission_denied",
 				zap.String("user_id", userCtx.UserID),
 				zap.String("username", userCtx.Username),
@@ -267,3 +273,36 @@ func GetUserContext(c *gin.Context) (*UserContext, bool) {
 	userCtx, ok := userCtxRaw.(*UserContext)
 	return userCtx, ok
 }
+
+// HasPermission 判断用户是否拥有任一指定权限（PermissionAll 拥有所有权限）
+func (u *UserContext) HasPermission(permissions ...string) bool {
+	for _, perm := range u.Permissions {
+		if perm == PermissionAll {
+			return true
+		}
+		for _, required := range permissions {
+			if perm == required {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CanAccess 判断用户能否访问属于 ownerUserID / ownerTenantID 的资源
+//   - 资源所有者可以访问（资源有租户时租户必须一致）
+//   - 拥有 PermissionMediaAdmin 的用户可以访问同租户的资源，没有租户的平台管理员可以访问所有资源
+//   - 没有租户的资源（旧会话、录像或调用方没有租户）只有平台管理员和 PermissionAll 可以代为访问
+//   - PermissionAll 可以访问所有资源
+func (u *UserContext) CanAccess(ownerUserID, ownerTenantID string) bool {
+	if ownerUserID != "" && ownerUserID == u.UserID && (ownerTenantID == "" || ownerTenantID == u.TenantID) {
+		return true
+	}
+	if !u.HasPermission(PermissionMediaAdmin) {
+		return false
+	}
+	if u.TenantID == "" || u.HasPermission(PermissionAll) {
+		return true
+	}
+	return ownerTenantID != "" && ownerTenantID == u.TenantID
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cloudphone/media-service/internal/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+// 服务间认证（与 @cloudphone/shared 的 ServiceTokenService / ServiceAuthGuard 一致）：
+// 服务令牌是用 JWT_SECRET 签名的 HS256 JWT，issuer 为 cloudphone-platform，audience 为 internal-services，
+// 通过 X-Service-Token 请求头传递。用户 JWT 的 audience 为 cloudphone-users，不能用于内部接口。
+
+const (
+	// ServiceTokenHeader 服务令牌请求头
+	ServiceTokenHeader = "X-Service-Token"
+	// ServiceContextKey 用于在 gin.Context 中存储调用方服务名的 key
+	ServiceContextKey = "service"
+
+	serviceTokenIssuer   = "cloudphone-platform"
+	serviceTokenAudience = "internal-services"
+)
+
+// ServiceClaims 服务令牌中的声明
+type ServiceClaims struct {
+	Service string `json:"service"`
+	jwt.RegisteredClaims
+}
+
+// GenerateServiceToken 生成服务令牌
+func GenerateServiceToken(service string, ttl time.Duration) (string, error) {
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		return "", fmt.Errorf("JWT_SECRET not configured")
+	}
+
+	now := time.Now()
+	claims := ServiceClaims{
+		Service: service,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    serviceTokenIssuer,
+			Audience:  jwt.ClaimStrings{serviceTokenAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(jwtSecret))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign service token: %w", err)
+	}
+	return token, nil
+}
+
+// ServiceAuthMiddleware 服务间认证中间件
+// 验证 X-Service-Token（或 Authorization: Bearer）中的服务令牌，并将调用方服务名存储到 context 中
+func ServiceAuthMiddleware() gin.HandlerFunc {
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		logger.Fatal("jwt_secret_not_configured",
+			zap.String("env_var", "JWT_SECRET"),
+			zap.String("message", "JWT_SECRET environment variable is required"),
+		)
+	}
+
+	return func(c *gin.Context) {
+		tokenString := c.GetHeader(ServiceTokenHeader)
+		if tokenString == "" {
+			tokenString = strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		}
+		if tokenString == "" {
+			logger.Warn("service_token_missing",
+				zap.String("path", c.Request.URL.Path),
+			)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error":   "Unauthorized",
+				"message": "Service token is required",
+			})
+			c.Abort()
+			return
+		}
+
+		claims := &ServiceClaims{}
+		_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return []byte(jwtSecret), nil
+		},
+			jwt.WithIssuer(serviceTokenIssuer),
+			jwt.WithAudience(serviceTokenAudience),
+			jwt.WithExpirationRequired(),
+		)
+		if err != nil || claims.Service == "" {
+			logger.Warn("service_token_invalid",
+				zap.String("path", c.Request.URL.Path),
+				zap.Error(err),
+			)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error":   "Unauthorized",
+				"message": "Invalid service token",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Set(ServiceContextKey, claims.Service)
+		c.Next()
+	}
+}
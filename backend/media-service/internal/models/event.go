@@ -0,0 +1,74 @@
+package models
+
+// SessionEventVersion 服务端事件 schema 版本（字段只增不改，不兼容变更时递增）
+const SessionEventVersion = 1
+
+// SessionEvent 服务端 → 客户端事件
+//
+// 通过数据通道 "events"（可靠有序，子协议 cloudphone-events.v1，JSON 文本）推送；
+// 事件通道未打开时通过 WebSocket 信令回退推送（type=session_event，事件在 event 字段）。
+// Type 决定哪个负载字段有值，其余负载字段省略。
+type SessionEvent struct {
+	Type      string `json:"type"`
+	Version   int    `json:"v"`
+	SessionID string `json:"sessionId"`
+	DeviceID  string `json:"deviceId"`
+	Timestamp int64  `json:"timestamp"` // Unix 毫秒
+
+	Capture   *CaptureStateEvent `json:"capture,omitempty"`   // capture_state
+	Quality   *QualityEvent      `json:"quality,omitempty"`   // quality_changed
+	Recording *RecordingEvent    `json:"recording,omitempty"` // recording_started / recording_stopped
+	Device    *DeviceEvent       `json:"device,omitempty"`    // device_disconnected / device_reconnecting / device_reconnected
+	Clipboard *ClipboardEvent    `json:"clipboard,omitempty"` // clipboard
+}
+
+// 服务端事件类型
+const (
+	SessionEventCaptureState       = "capture_state"       // 采集状态：首帧或分辨率/方向变化
+	SessionEventQualityChanged     = "quality_changed"     // 自适应质量级别变化
+	SessionEventRecordingStarted   = "recording_started"   // 会话开始录像
+	SessionEventRecordingStopped   = "recording_stopped"   // 会话录像停止
+	SessionEventDeviceDisconnected = "device_disconnected" // 设备视频流中断
+	SessionEventDeviceReconnecting = "device_reconnecting" // 一次重连尝试失败，继续重试
+	SessionEventDeviceReconnected  = "device_reconnected"  // 重连成功，视频流恢复
+	SessionEventClipboard          = "clipboard"           // 设备剪贴板更新
+)
+
+// CaptureStateEvent 采集状态
+type CaptureStateEvent struct {
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Rotation int    `json:"rotation"`          // 相对会话开始时方向的旋转：0 | 90
+	Codec    string `json:"codec"`             // WebRTC 轨道编码: H264 | VP8
+	Source   string `json:"source,omitempty"`  // 视频源: scrcpy | screenrecord | screencap
+	Encoder  string `json:"encoder,omitempty"` // passthrough | h264 | vp8
+}
+
+// QualityEvent 质量级别变化
+type QualityEvent struct {
+	Level         string `json:"level"` // Low | Medium | High | Ultra
+	PreviousLevel string `json:"previousLevel,omitempty"`
+	Bitrate       int    `json:"bitrate"` // bps
+	FrameRate     int    `json:"frameRate"`
+	Width         int    `json:"width"`
+	Height        int    `json:"height"`
+}
+
+// RecordingEvent 录像开始/停止
+type RecordingEvent struct {
+	RecordingID string `json:"recordingId"`
+	DurationMs  int64  `json:"durationMs,omitempty"` // 仅 recording_stopped
+	FileSize    int64  `json:"fileSize,omitempty"`   // 仅 recording_stopped
+}
+
+// DeviceEvent 设备连接状态
+type DeviceEvent struct {
+	Reconnecting bool   `json:"reconnecting"`          // device_disconnected：是否会自动重连
+	Attempt      uint32 `json:"attempt,omitempty"`     // device_reconnecting / device_reconnected
+	MaxAttempts  uint32 `json:"maxAttempts,omitempty"` // 0 表示不限
+}
+
+// ClipboardEvent 设备剪贴板内容
+type ClipboardEvent struct {
+	Text string `json:"text"`
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"sync"
 	"time"
@@ -18,6 +19,7 @@ type Session struct {
 	ID              string
 	DeviceID        string
 	UserID          string
+	TenantID        string // 租户 ID（来自 JWT，用于租户级配置）
 	PeerConnection  *webrtc.PeerConnection
 	DataChannel     *webrtc.DataChannel
 	VideoTrack      *webrtc.TrackLocalStaticSample
@@ -26,7 +28,46 @@ type Session struct {
 	LastActivityAt  time.Time
 	State           SessionState
 	ICECandidates   []webrtc.ICECandidateInit
+	VideoPipeline   *VideoPipelineInfo  // 视频管道选择结果（回退链决策）
+	resumeToken     string              // 会话恢复令牌（一次性，恢复后轮换）
+	role            SessionRole         // 会话角色（决定能否通过数据通道注入输入）
+	eventChannel    *webrtc.DataChannel // 服务端事件数据通道（未打开时为 nil）
 	mu              sync.RWMutex
+
+	// transportStats 视频发送端传输统计的来源（由 WebRTC 管理器设置，未设置时为 nil）
+	transportStats func() TransportStats
+}
+
+// VideoPipelineInfo 视频管道选择结果
+type VideoPipelineInfo struct {
+	Source   string               `json:"source"`  // 最终使用的视频源: scrcpy | screenrecord | screencap
+	Codec    string               `json:"codec"`   // WebRTC 轨道编码: H264 | VP8
+	Encoder  string               `json:"encoder"` // passthrough（设备编码）| h264 / vp8（服务端编码）
+	Attempts []VideoSourceAttempt `json:"attempts"`
+}
+
+// TransportStats 视频发送端传输统计（来自 PeerConnection 收到的 RTCP 反馈）
+type TransportStats struct {
+	PacketsSent          uint64  `json:"packetsSent"`          // 已发送的视频 RTP 包（含重传）
+	RetransmittedPackets uint64  `json:"retransmittedPackets"` // 响应 NACK 重传的包
+	NACKedPackets        uint64  `json:"nackedPackets"`        // 接收端请求重传的包
+	PLIs                 uint64  `json:"plis"`
+	FIRs                 uint64  `json:"firs"`
+	KeyframeRequests     uint64  `json:"keyframeRequests"` // 实际发给视频管道的关键帧请求（有冷却时间）
+	RTTMs                float64 `json:"rttMs"`
+	JitterMs             float64 `json:"jitterMs"`
+	PacketLoss           float64 `json:"packetLoss"`      // 最近一个统计周期的丢包率 (0-1)
+	Bandwidth            uint64  `json:"bandwidth"`       // 估计带宽 (bps)，0 表示接收端未提供
+	NACKHistorySize      int     `json:"nackHistorySize"` // 发送历史保留的包数（按会话码率调整）
+	FECEnabled           bool    `json:"fecEnabled"`      // 当前是否发送视频 FEC (ULPFEC)
+	FECPackets           uint64  `json:"fecPackets"`
+}
+
+// VideoSourceAttempt 回退链中一次视频源尝试
+type VideoSourceAttempt struct {
+	Source string    `json:"source"`
+	Error  string    `json:"error,omitempty"` // 为空表示成功
+	At     time.Time `json:"at"`
 }
 
 type SessionState string
@@ -37,9 +78,76 @@ const (
 	SessionStateConnected   SessionState = "connected"
 	SessionStateDisconnected SessionState = "disconnected"
 	SessionStateFailed      SessionState = "failed"
+	SessionStateSuspended   SessionState = "suspended" // ICE 失败，等待客户端在宽限期内恢复
 	SessionStateClosed      SessionState = "closed"
 )
 
+// SessionRole 会话角色
+//
+// 同一设备的多个会话中，只有 owner 和 controller 可以注入输入（触摸、按键、文本），
+// viewer 只能观看。owner 可以在运行时把控制权授予或收回其他会话。
+type SessionRole string
+
+const (
+	SessionRoleOwner      SessionRole = "owner"      // 创建者（有设备控制权限），可授予/收回控制权
+	SessionRoleController SessionRole = "controller" // 被授予控制权的参与者
+	SessionRoleViewer     SessionRole = "viewer"     // 只读观看
+)
+
+// SetRole 设置会话角色
+func (s *Session) SetRole(role SessionRole) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.role = role
+}
+
+// GetRole 获取会话角色
+func (s *Session) GetRole() SessionRole {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.role
+}
+
+// CanControl 会话是否可以注入输入
+func (s *Session) CanControl() bool {
+	role := s.GetRole()
+	return role == SessionRoleOwner || role == SessionRoleController
+}
+
+// SetEventChannel 设置服务端事件数据通道（通道关闭时传 nil）
+func (s *Session) SetEventChannel(dc *webrtc.DataChannel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.eventChannel = dc
+}
+
+// GetEventChannel 获取服务端事件数据通道（未打开时返回 nil）
+func (s *Session) GetEventChannel() *webrtc.DataChannel {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.eventChannel
+}
+
+// SetTransportStatsSource 设置传输统计的来源
+func (s *Session) SetTransportStatsSource(source func() TransportStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.transportStats = source
+}
+
+// GetTransportStats 获取传输统计（未设置来源时返回 nil）
+func (s *Session) GetTransportStats() *TransportStats {
+	s.mu.RLock()
+	source := s.transportStats
+	s.mu.RUnlock()
+
+	if source == nil {
+		return nil
+	}
+	stats := source()
+	return &stats
+}
+
 // UpdateState 更新会话状态
 func (s *Session) UpdateState(state SessionState) {
 	s.mu.Lock()
@@ -55,6 +163,45 @@ func (s *Session) GetState() SessionState {
 	return s.State
 }
 
+// SetResumeToken 设置会话恢复令牌
+func (s *Session) SetResumeToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resumeToken = token
+}
+
+// GetResumeToken 获取当前的会话恢复令牌
+func (s *Session) GetResumeToken() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.resumeToken
+}
+
+// RotateResumeToken 校验恢复令牌，匹配时替换为 next（旧令牌失效）
+func (s *Session) RotateResumeToken(token, next string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.resumeToken == "" || subtle.ConstantTimeCompare([]byte(s.resumeToken), []byte(token)) != 1 {
+		return false
+	}
+	s.resumeToken = next
+	return true
+}
+
+// SetVideoPipelineInfo 记录视频管道选择结果
+func (s *Session) SetVideoPipelineInfo(info *VideoPipelineInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.VideoPipeline = info
+}
+
+// GetVideoPipelineInfo 获取视频管道选择结果（未启动时返回 nil）
+func (s *Session) GetVideoPipelineInfo() *VideoPipelineInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.VideoPipeline
+}
+
 // AddICECandidate 添加 ICE 候选（带数量限制）
 func (s *Session) AddICECandidate(candidate webrtc.ICECandidateInit) error {
 	s.mu.Lock()
@@ -69,17 +216,63 @@ func (s *Session) AddICECandidate(candidate webrtc.ICECandidateInit) error {
 	return nil
 }
 
-// SignalingMessage 信令消息
+// SignalingMessage 信令消息（WebSocket /api/media/ws）
+//
+// 客户端请求携带 ID，服务端的响应（offer / ack / error）回传相同的 ID 用于关联；
+// 服务端主动推送的消息（ice_candidate / end_of_candidates / session_closed）不带 ID。
 type SignalingMessage struct {
-	Type      string                   `json:"type"`
-	SessionID string                   `json:"sessionId,omitempty"`
-	DeviceID  string                   `json:"deviceId,omitempty"`
-	UserID    string                   `json:"userId,omitempty"`
-	SDP       *webrtc.SessionDescription `json:"sdp,omitempty"`
-	Candidate *webrtc.ICECandidateInit `json:"candidate,omitempty"`
-	Error     string                   `json:"error,omitempty"`
+	Type            string                     `json:"type"`
+	ID              string                     `json:"id,omitempty"` // 请求/响应关联 ID
+	SessionID       string                     `json:"sessionId,omitempty"`
+	DeviceID        string                     `json:"deviceId,omitempty"`
+	UserID          string                     `json:"userId,omitempty"`
+	SDP             *webrtc.SessionDescription `json:"sdp,omitempty"`
+	Candidate       *webrtc.ICECandidateInit   `json:"candidate,omitempty"`
+	ICEServers      []webrtc.ICEServer         `json:"iceServers,omitempty"`      // 仅 offer 响应：客户端必须使用这些 ICE 服务器
+	ResumeToken     string                     `json:"resumeToken,omitempty"`     // 会话恢复令牌（create_session / resume 响应，resume 请求）
+	Reason          string                     `json:"reason,omitempty"`          // session_closed 的关闭原因
+	Role            SessionRole                `json:"role,omitempty"`            // 会话角色（create_session 响应、role_changed）
+	Event           *SessionEvent              `json:"event,omitempty"`           // session_event 的事件（数据通道事件通道不可用时的回退）
+	TargetSessionID string                     `json:"targetSessionId,omitempty"` // grant_control / revoke_control 的目标会话
+	Code            string                     `json:"code,omitempty"`            // error 的错误码
+	Error           string                     `json:"error,omitempty"`
 }
 
+// 信令消息类型
+const (
+	// 客户端 → 服务端
+	SignalingCreateSession  = "create_session"  // 创建会话，响应 offer（带 sdp 时响应 answer）
+	SignalingRenegotiate    = "renegotiate"     // 请求服务端重新协商，响应 offer
+	SignalingCloseSession   = "close_session"   // 关闭会话，响应 ack
+	SignalingICERestart     = "ice_restart"     // 网络切换后重启 ICE，响应 offer（带 sdp 时响应 answer）
+	SignalingResume         = "resume"          // 新的信令连接凭恢复令牌接管会话并重启 ICE，响应同 ice_restart
+	SignalingGrantControl   = "grant_control"   // owner 把控制权授予同设备的另一个会话，响应 ack
+	SignalingRevokeControl  = "revoke_control"  // owner 收回控制权，响应 ack
+	SignalingRequestControl = "request_control" // viewer 请求控制权（通知 owner 和当前 controller），响应 ack
+
+	// 双向（客户端发起协商时 offer/answer 方向相反）
+	SignalingICECandidate = "ice_candidate" // trickle ICE 候选
+	SignalingOffer        = "offer"         // SDP offer，客户端发送时响应 answer
+	SignalingAnswer       = "answer"        // SDP answer，客户端发送时响应 ack
+
+	// 服务端 → 客户端
+	SignalingEndOfCandidates  = "end_of_candidates" // 服务端 ICE gathering 完成
+	SignalingSessionClosed    = "session_closed"    // 会话被关闭（Reason 说明原因）
+	SignalingRoleChanged      = "role_changed"      // 会话角色变化（Role 为新角色）
+	SignalingControlRequested = "control_requested" // 同设备的 viewer 请求控制权（TargetSessionID 为请求者）
+	SignalingSessionEvent     = "session_event"     // 服务端事件（Event），数据通道事件通道未打开时使用
+	SignalingAck              = "ack"
+	SignalingError            = "error"
+)
+
+// 信令错误码
+const (
+	SignalingErrBadRequest = "bad_request"
+	SignalingErrNotFound   = "not_found"
+	SignalingErrForbidden  = "forbidden"
+	SignalingErrInternal   = "internal"
+)
+
 // ControlMessage 控制消息（触摸、按键等）
 type ControlMessage struct {
 	Type      string  `json:"type"`
@@ -90,6 +283,9 @@ type ControlMessage struct {
 	KeyCode   int     `json:"keyCode,omitempty"`
 	Text      string  `json:"text,omitempty"`
 	Timestamp int64   `json:"timestamp"`
+
+	// TargetSessionID type=control 时 grant / revoke 的目标会话
+	TargetSessionID string `json:"targetSessionId,omitempty"`
 }
 
 // StatsReport 会话统计
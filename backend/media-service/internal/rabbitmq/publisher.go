@@ -3,9 +3,11 @@ package rabbitmq
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/cloudphone/media-service/internal/logger"
+	"github.com/cloudphone/media-service/internal/sfu"
 	"github.com/streadway/amqp"
 	"go.uber.org/zap"
 )
@@ -152,6 +154,51 @@ func (p *Publisher) PublishRecordingStopped(sessionID, deviceID, recordingPath s
 	return p.publishEvent("media.recording.stopped", event)
 }
 
+// PublishSFUPublisherEvent publishes an SFU publisher lifecycle event
+// (capture_started, capture_paused, capture_resumed or closed)
+func (p *Publisher) PublishSFUPublisherEvent(eventType, publisherID, deviceID, userID, source, reason string, subscriberCount int) error {
+	event := map[string]interface{}{
+		"publisher_id":     publisherID,
+		"device_id":        deviceID,
+		"user_id":          userID,
+		"source":           source,
+		"subscriber_count": subscriberCount,
+		"timestamp":        time.Now().Format(time.RFC3339),
+		"service":          "media-service",
+		"event_type":       "sfu.publisher." + eventType,
+	}
+	if reason != "" {
+		event["reason"] = reason
+	}
+
+	return p.publishEvent("media.sfu.publisher."+eventType, event)
+}
+
+// PublishSFUControlAudit publishes an SFU subscriber control audit record
+// (control_granted, or control_ended with the end reason and injected input count)
+func (p *Publisher) PublishSFUControlAudit(eventType string, entry sfu.ControlAuditEntry) error {
+	event := map[string]interface{}{
+		"publisher_id":  entry.PublisherID,
+		"device_id":     entry.DeviceID,
+		"subscriber_id": entry.SubscriberID,
+		"user_id":       entry.UserID,
+		"granted_by":    entry.GrantedBy,
+		"granted_at":    entry.GrantedAt.Format(time.RFC3339),
+		"expires_at":    entry.ExpiresAt.Format(time.RFC3339),
+		"timestamp":     time.Now().Format(time.RFC3339),
+		"service":       "media-service",
+		"event_type":    "sfu." + eventType,
+	}
+	if entry.EndedAt != nil {
+		event["ended_at"] = entry.EndedAt.Format(time.RFC3339)
+		event["end_reason"] = entry.EndReason
+		event["input_events"] = entry.InputEvents
+	}
+
+	// control_granted -> media.sfu.control.granted
+	return p.publishEvent("media.sfu.control."+strings.TrimPrefix(eventType, "control_"), event)
+}
+
 // publishEvent is a helper to publish events to RabbitMQ
 func (p *Publisher) publishEvent(routingKey string, event map[string]interface{}) error {
 	body, err := json.Marshal(event)
@@ -2,10 +2,13 @@ package recording
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"hash/fnv"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -130,7 +133,8 @@ func (m *Manager) StartRecording(ctx context.Context, req StartRecordingRequest,
 		ID:        recordingID,
 		SessionID: req.SessionID,
 		DeviceID:  req.DeviceID,
-		UserID:    "", // 从 ctx 获取
+		UserID:    req.UserID,
+		TenantID:  req.TenantID,
 		State:     StateRecording,
 		Format:    format,
 		Width:     width,
@@ -170,6 +174,13 @@ func (m *Manager) StartRecording(ctx context.Context, req StartRecordingRequest,
 		return nil, fmt.Errorf("failed to write WebM header: %w", err)
 	}
 
+	// 写入归属元数据（录像完成后从内存移除，下载/删除时据此校验归属）
+	if err := writeMetadata(recording); err != nil {
+		writer.Close()
+		os.Remove(recording.FilePath)
+		return nil, err
+	}
+
 	// 创建取消上下文
 	recordCtx, cancel := context.WithCancel(ctx)
 
@@ -339,6 +350,36 @@ func (m *Manager) GetRecordingFilePath(recordingID string) (string, error) {
 	return matches[0], nil
 }
 
+// GetRecordingOwner 获取录像所属的用户和租户
+// 活跃录像从内存读取，已完成的录像从元数据文件读取；
+// 没有元数据文件的旧录像返回空的用户和租户（只有管理员可以访问）
+func (m *Manager) GetRecordingOwner(recordingID string) (userID, tenantID string, err error) {
+	if rec, err := m.GetRecording(recordingID); err == nil {
+		return rec.UserID, rec.TenantID, nil
+	}
+
+	if len(recordingID) < 8 {
+		return "", "", fmt.Errorf("recording not found: %s", recordingID)
+	}
+	filePath, err := m.GetRecordingFilePath(recordingID)
+	if err != nil {
+		return "", "", err
+	}
+
+	meta, err := readMetadata(filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", "", nil
+		}
+		return "", "", err
+	}
+	if meta.ID != recordingID {
+		return "", "", fmt.Errorf("recording not found: %s", recordingID)
+	}
+
+	return meta.UserID, meta.TenantID, nil
+}
+
 // ListActiveRecordings 列出所有活跃录像
 func (m *Manager) ListActiveRecordings() []*Recording {
 	var recordings []*Recording
@@ -405,6 +446,7 @@ func (m *Manager) Cleanup(maxAge time.Duration) (int, error) {
 				)
 				continue
 			}
+			os.Remove(MetadataPath(file))
 			deleted++
 			m.logger.Info("recording_deleted",
 				zap.String("file", file),
@@ -437,3 +479,51 @@ func (m *Manager) StopAll() {
 func (m *Manager) GetBaseURL() string {
 	return m.baseURL
 }
+
+// recordingMetadata 录像归属元数据，与录像文件同名（.json）
+type recordingMetadata struct {
+	ID        string    `json:"id"`
+	SessionID string    `json:"sessionId"`
+	DeviceID  string    `json:"deviceId"`
+	UserID    string    `json:"userId"`
+	TenantID  string    `json:"tenantId"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// MetadataPath 返回录像文件对应的元数据文件路径
+func MetadataPath(filePath string) string {
+	return strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".json"
+}
+
+// writeMetadata 写入录像归属元数据
+func writeMetadata(rec *Recording) error {
+	data, err := json.Marshal(recordingMetadata{
+		ID:        rec.ID,
+		SessionID: rec.SessionID,
+		DeviceID:  rec.DeviceID,
+		UserID:    rec.UserID,
+		TenantID:  rec.TenantID,
+		StartedAt: rec.StartedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode recording metadata: %w", err)
+	}
+	if err := os.WriteFile(MetadataPath(rec.FilePath), data, 0644); err != nil {
+		return fmt.Errorf("failed to write recording metadata: %w", err)
+	}
+	return nil
+}
+
+// readMetadata 读取录像归属元数据
+func readMetadata(filePath string) (*recordingMetadata, error) {
+	data, err := os.ReadFile(MetadataPath(filePath))
+	if err != nil {
+		return nil, err
+	}
+
+	var meta recordingMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("invalid recording metadata: %w", err)
+	}
+	return &meta, nil
+}
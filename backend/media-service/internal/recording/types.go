@@ -31,6 +31,7 @@ type Recording struct {
 	SessionID      string          `json:"sessionId"`      // 关联的 WebRTC 会话 ID
 	DeviceID       string          `json:"deviceId"`       // 设备 ID
 	UserID         string          `json:"userId"`         // 发起录像的用户
+	TenantID       string          `json:"tenantId"`       // 发起录像用户的租户
 	State          RecordingState  `json:"state"`          // 录像状态
 	Format         RecordingFormat `json:"format"`         // 录像格式
 	FilePath       string          `json:"filePath"`       // 本地文件路径
@@ -54,6 +55,7 @@ type RecordingInfo struct {
 	SessionID     string         `json:"sessionId"`
 	DeviceID      string         `json:"deviceId"`
 	UserID        string         `json:"userId"`
+	TenantID      string         `json:"tenantId,omitempty"`
 	State         string         `json:"state"`
 	Format        string         `json:"format"`
 	FileSize      int64          `json:"fileSize"`
@@ -86,6 +88,8 @@ type StartRecordingRequest struct {
 	MaxDuration int             `json:"maxDuration"` // 最大录像时长 (秒), 0 = 无限制
 	SPS         []byte          `json:"-"`           // H.264 SPS NAL unit (内部使用)
 	PPS         []byte          `json:"-"`           // H.264 PPS NAL unit (内部使用)
+	UserID      string          `json:"-"`           // 发起录像的用户 (来自 JWT)
+	TenantID    string          `json:"-"`           // 发起录像用户的租户 (来自 JWT)
 }
 
 // StopRecordingRequest 停止录像请求
@@ -141,6 +145,7 @@ func (r *Recording) ToInfo(downloadBaseURL string) RecordingInfo {
 		SessionID:     r.SessionID,
 		DeviceID:      r.DeviceID,
 		UserID:        r.UserID,
+		TenantID:      r.TenantID,
 		State:         string(r.State),
 		Format:        string(r.Format),
 		FileSize:      r.FileSize,
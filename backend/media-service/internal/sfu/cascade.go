@@ -0,0 +1,381 @@
+package sfu
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cloudphone/media-service/internal/httpclient"
+	"github.com/google/uuid"
+	"github.com/pion/webrtc/v3"
+)
+
+// 级联 SFU
+//
+// 单个实例的 ICE 端口范围限制了它能服务的订阅者数量。实例把自己拥有的设备发布者登记到
+// PublisherDirectory（Consul KV），其他实例的订阅者通过设备 ID 订阅时，本实例以 WHEP
+// 订阅源实例上的发布者并在本地重新发布（中继发布者），本地订阅者从中继接收：
+//
+//	设备 → 源实例发布者 → WHEP 订阅者 ⇢ 中继实例的中继发布者 → 本地订阅者
+//
+// 中继发布者与 WHIP 推流的发布者一样重组 RTP 帧后分发，本地订阅者的关键帧请求经 PLI 转发到源实例。
+// 最后一个本地订阅者离开或源实例的订阅结束时中继关闭。
+//
+// 实例之间的 WHEP 订阅使用服务令牌（WithRelayCredential）访问源实例的内部接口，不转发用户的 JWT：
+// 中继由多个用户的订阅者共用，每个本地订阅者在本实例单独授权。
+
+var (
+	// ErrNoRemotePublisher 其他实例上没有该设备的发布者（或拥有它的实例不健康）
+	ErrNoRemotePublisher = errors.New("no publisher for device on other instances")
+	// ErrPublisherRelay 发布者是从其他实例中继的，不能作为设备采集的发布者复用
+	ErrPublisherRelay = errors.New("device is relayed from another instance")
+)
+
+const (
+	// relayRequestTimeout 向源实例发起 WHEP 订阅和删除资源的超时
+	relayRequestTimeout = 15 * time.Second
+	// relayWHEPPath 源实例的内部 WHEP 订阅路径（按设备 ID 订阅，只接受服务令牌）
+	relayWHEPPath = "/internal/media/sfu/relay/whep/"
+	// relayTokenHeader 服务令牌请求头（middleware.ServiceTokenHeader）
+	relayTokenHeader = "X-Service-Token"
+)
+
+// RelayCredential 生成实例间 WHEP 请求使用的服务令牌
+type RelayCredential func() (string, error)
+
+// PublisherRecord 登记到发布者目录的设备发布者
+type PublisherRecord struct {
+	DeviceID    string `json:"deviceId"`
+	PublisherID string `json:"publisherId"`
+	UserID      string `json:"userId"`     // 发布者所有者（中继发布者沿用，用于订阅授权）
+	TenantID    string `json:"tenantId"`   // 发布者租户
+	VideoCodec  string `json:"videoCodec"` // 视频轨道的 MIME 类型（中继按该编码订阅，不重新编码）
+}
+
+// RemotePublisher 其他实例上的设备发布者
+type RemotePublisher struct {
+	PublisherRecord
+	InstanceID string `json:"instanceId"`
+	BaseURL    string `json:"baseUrl"` // 实例的 HTTP 地址，如 http://10.0.0.2:30006
+}
+
+// PublisherDirectory 跨实例的设备发布者目录
+type PublisherDirectory interface {
+	// RegisterPublisher 登记本实例拥有的设备发布者
+	RegisterPublisher(record PublisherRecord) error
+	// DeregisterPublisher 注销本实例的设备发布者（只删除同一发布者的登记）
+	DeregisterPublisher(record PublisherRecord) error
+	// LookupPublisher 查找其他实例上的设备发布者，没有时返回 ErrNoRemotePublisher
+	LookupPublisher(deviceID string) (*RemotePublisher, error)
+}
+
+// WithRelayCredential 设置实例间 WHEP 请求的服务令牌（未设置时不能创建中继）
+func WithRelayCredential(credential RelayCredential) ManagerOption {
+	return func(m *Manager) {
+		m.relayCredential = credential
+	}
+}
+
+// WithPublisherDirectory 启用级联 SFU
+// 本实例的发布者连接后登记到目录，本实例没有设备发布者时可以从其他实例中继
+func WithPublisherDirectory(directory PublisherDirectory) ManagerOption {
+	return func(m *Manager) {
+		m.directory = directory
+		m.relayClient = httpclient.New()
+	}
+}
+
+// publisherRelay 中继发布者的上游（源实例上的 WHEP 订阅）
+type publisherRelay struct {
+	origin   RemotePublisher
+	resource string // 源实例上 WHEP 资源的 URL
+}
+
+// LookupRemotePublisher 查找其他实例上的设备发布者
+func (m *Manager) LookupRemotePublisher(deviceID string) (*RemotePublisher, error) {
+	if m.directory == nil {
+		return nil, ErrNoRemotePublisher
+	}
+	return m.directory.LookupPublisher(deviceID)
+}
+
+// CreateRelayPublisher 以 WHEP 订阅源实例上的发布者并在本实例重新发布
+// 调用方负责校验请求用户的订阅权限（源实例只校验服务令牌）；本实例已有该设备的发布者
+// （包括并发请求刚创建的中继）时直接返回
+func (m *Manager) CreateRelayPublisher(ctx context.Context, origin *RemotePublisher) (*PublisherSession, error) {
+	// 同一设备同时只创建一个中继，其他请求等待后复用
+	for {
+		m.deviceMu.Lock()
+		if publisherID, exists := m.devicePublishers[origin.DeviceID]; exists {
+			m.deviceMu.Unlock()
+			return m.GetPublisher(publisherID)
+		}
+		pending, creating := m.relayPending[origin.DeviceID]
+		if !creating {
+			pending = make(chan struct{})
+			m.relayPending[origin.DeviceID] = pending
+			m.deviceMu.Unlock()
+			break
+		}
+		m.deviceMu.Unlock()
+
+		select {
+		case <-pending:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	defer func() {
+		m.deviceMu.Lock()
+		close(m.relayPending[origin.DeviceID])
+		delete(m.relayPending, origin.DeviceID)
+		m.deviceMu.Unlock()
+	}()
+
+	codec, err := relayVideoCodec(origin.VideoCodec)
+	if err != nil {
+		return nil, err
+	}
+
+	publisherID := uuid.New().String()
+	shard := m.getShard(publisherID)
+
+	// 上游连接与 WHIP 推流端相同：只接收视频，为源实例生成 NACK 和 TWCC 反馈
+	peerConnection, _, err := m.newPeerConnection("")
+	if err != nil {
+		return nil, err
+	}
+	transceiver, err := peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionRecvonly,
+	})
+	if err != nil {
+		peerConnection.Close()
+		return nil, fmt.Errorf("failed to add video transceiver: %w", err)
+	}
+	// 只协商源发布者的编码，源实例转发原始 RTP
+	if err := transceiver.SetCodecPreferences([]webrtc.RTPCodecParameters{codec}); err != nil {
+		peerConnection.Close()
+		return nil, fmt.Errorf("failed to set codec preferences: %w", err)
+	}
+
+	offer, err := createCompleteOffer(peerConnection)
+	if err != nil {
+		peerConnection.Close()
+		return nil, err
+	}
+
+	relay := &publisherRelay{origin: *origin}
+	answer, err := m.requestWHEP(ctx, relay, offer)
+	if err != nil {
+		peerConnection.Close()
+		return nil, err
+	}
+
+	publisher, err := m.newIngestPublisher(publisherID, origin.DeviceID, origin.UserID, peerConnection, codec, nil)
+	if err != nil {
+		peerConnection.Close()
+		m.deleteRelayResource(relay)
+		return nil, err
+	}
+	publisher.TenantID = origin.TenantID
+	publisher.relay = relay
+
+	m.setupPublisherHandlers(publisher)
+	peerConnection.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		m.readIngestTrack(publisher, track)
+		// 上游轨道结束，中继随之关闭（源实例关闭订阅或退出时由 ICE 失败关闭）
+		if _, err := m.GetPublisher(publisher.ID); err == nil {
+			log.Printf("Relay publisher %s lost its upstream from instance %s", publisher.ID, origin.InstanceID)
+			m.closePublisher(publisher.ID, PublisherCloseUpstreamClosed)
+		}
+	})
+
+	if err := peerConnection.SetRemoteDescription(*answer); err != nil {
+		peerConnection.Close()
+		m.deleteRelayResource(relay)
+		return nil, fmt.Errorf("failed to set remote description: %w", err)
+	}
+
+	// 等待 WHEP 请求期间本地可能创建了该设备的发布者
+	m.deviceMu.Lock()
+	if _, exists := m.devicePublishers[origin.DeviceID]; exists {
+		m.deviceMu.Unlock()
+		peerConnection.Close()
+		m.deleteRelayResource(relay)
+		return nil, ErrDeviceHasPublisher
+	}
+	m.devicePublishers[origin.DeviceID] = publisherID
+	m.deviceMu.Unlock()
+
+	shard.mu.Lock()
+	shard.publishers[publisherID] = publisher
+	shard.mu.Unlock()
+
+	publisher.UpdateState(StateConnecting)
+
+	log.Printf("Created SFU relay publisher: %s for device: %s from instance %s (publisher: %s, codec: %s)",
+		publisherID, origin.DeviceID, origin.InstanceID, origin.PublisherID, codec.MimeType)
+
+	return publisher, nil
+}
+
+// relayVideoCodec 源发布者视频编码对应的本地编码
+func relayVideoCodec(mimeType string) (webrtc.RTPCodecParameters, error) {
+	for _, codec := range videoCodecs {
+		if strings.EqualFold(codec.MimeType, mimeType) {
+			return codec, nil
+		}
+	}
+	return webrtc.RTPCodecParameters{}, fmt.Errorf("%w: %s", ErrUnsupportedOffer, mimeType)
+}
+
+// createCompleteOffer 创建包含全部候选的 offer（WHEP 不使用 trickle）
+func createCompleteOffer(pc *webrtc.PeerConnection) (*webrtc.SessionDescription, error) {
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create offer: %w", err)
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		return nil, fmt.Errorf("failed to set local description: %w", err)
+	}
+
+	select {
+	case <-gatherComplete:
+	case <-time.After(iceGatheringTimeout):
+		log.Printf("ICE gathering timeout while creating relay offer")
+	}
+
+	return pc.LocalDescription(), nil
+}
+
+// requestWHEP 向源实例发送 WHEP offer，返回 answer 并记录 WHEP 资源地址
+func (m *Manager) requestWHEP(ctx context.Context, relay *publisherRelay, offer *webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
+	base, err := url.Parse(relay.origin.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid origin instance URL: %w", err)
+	}
+	endpoint := base.JoinPath(relayWHEPPath, relay.origin.DeviceID)
+
+	ctx, cancel := context.WithTimeout(ctx, relayRequestTimeout)
+	defer cancel()
+
+	token, err := m.relayToken()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), bytes.NewBufferString(offer.SDP))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create WHEP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/sdp")
+	req.Header.Set(relayTokenHeader, token)
+
+	resp, err := m.relayClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("WHEP request to instance %s failed: %w", relay.origin.InstanceID, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WHEP answer: %w", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("instance %s rejected WHEP request: %d %s", relay.origin.InstanceID, resp.StatusCode, bytes.TrimSpace(body))
+	}
+
+	if location, err := resp.Location(); err == nil {
+		relay.resource = location.String()
+	}
+
+	return &webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: string(body)}, nil
+}
+
+// relayToken 生成实例间 WHEP 请求的服务令牌
+func (m *Manager) relayToken() (string, error) {
+	if m.relayCredential == nil {
+		return "", fmt.Errorf("relay credential not configured")
+	}
+	token, err := m.relayCredential()
+	if err != nil {
+		return "", fmt.Errorf("failed to create relay credential: %w", err)
+	}
+	return token, nil
+}
+
+// deleteRelayResource 删除源实例上的 WHEP 订阅（失败时源实例在 ICE 超时后清理）
+func (m *Manager) deleteRelayResource(relay *publisherRelay) {
+	if relay.resource == "" {
+		return
+	}
+
+	token, err := m.relayToken()
+	if err != nil {
+		log.Printf("Failed to delete WHEP resource %s: %v", relay.resource, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), relayRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, relay.resource, nil)
+	if err != nil {
+		log.Printf("Failed to create WHEP delete request: %v", err)
+		return
+	}
+	req.Header.Set(relayTokenHeader, token)
+
+	resp, err := m.relayClient.Do(req)
+	if err != nil {
+		log.Printf("Failed to delete WHEP resource %s: %v", relay.resource, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		log.Printf("Instance %s returned %d deleting WHEP resource %s", relay.origin.InstanceID, resp.StatusCode, relay.resource)
+	}
+}
+
+// advertisePublisher 本实例的发布者连接后登记到目录（中继发布者不登记）
+func (m *Manager) advertisePublisher(publisher *PublisherSession) {
+	if m.directory == nil || publisher.relay != nil {
+		return
+	}
+	if err := m.directory.RegisterPublisher(publisher.record()); err != nil {
+		log.Printf("Failed to register publisher %s for device %s in directory: %v", publisher.ID, publisher.DeviceID, err)
+	}
+}
+
+// releasePublisher 发布者关闭后注销目录登记，中继发布者删除源实例上的订阅
+func (m *Manager) releasePublisher(publisher *PublisherSession) {
+	if publisher.relay != nil {
+		go m.deleteRelayResource(publisher.relay)
+		return
+	}
+	if m.directory == nil {
+		return
+	}
+	if err := m.directory.DeregisterPublisher(publisher.record()); err != nil {
+		log.Printf("Failed to deregister publisher %s for device %s from directory: %v", publisher.ID, publisher.DeviceID, err)
+	}
+}
+
+// releaseRelay 中继发布者没有本地订阅者时关闭
+func (m *Manager) releaseRelay(publisher *PublisherSession) {
+	if publisher.relay == nil || publisher.GetState() == StateClosed || publisher.GetSubscriberCount() > 0 {
+		return
+	}
+	if err := m.closePublisher(publisher.ID, PublisherCloseNoSubscribers); err == nil {
+		log.Printf("Closed relay publisher %s: no local subscribers", publisher.ID)
+	}
+}
@@ -0,0 +1,491 @@
+package sfu
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/cloudphone/media-service/internal/models"
+	"github.com/pion/webrtc/v3"
+)
+
+// 订阅者控制权
+//
+// 订阅者默认只读。创建时请求数据通道（WithSubscriberDataChannel）的订阅者可以由发布者所有者
+// 授予临时控制权：期间订阅者数据通道上的触摸、按键和文本输入（models.ControlMessage）
+// 与发布者数据通道上的输入走同一条输入路径注入设备。同一时间只有一个订阅者持有控制权，
+// 发布者所有者始终保留控制权。
+//
+// 数据通道 "control" 上的消息：
+//
+//	← {"type":"control_granted","subscriberId":"s1","userId":"u2","expiresAt":"..."}   订阅者获得控制权
+//	← {"type":"control_revoked","subscriberId":"s1","reason":"expired"}                订阅者失去控制权
+//	→ {"type":"control","deviceId":"d1","action":"request"}                              订阅者请求控制权（转发给发布者）
+//	→ {"type":"control","deviceId":"d1","action":"release"}                              订阅者交还控制权
+//	← {"type":"control_requested","subscriberId":"s1","userId":"u2"}                   发布者收到控制权请求
+//
+// 每次授权记录在发布者的控制记录中（谁、由谁授权、开始和结束时间、结束原因、注入的输入数）。
+// 控制记录只是最近 controlAuditSize 条的缓存，随发布者关闭丢失；授权和结束同时作为控制记录事件
+// （OnControlAudit）发出，由事件处理器写入结构化日志和 RabbitMQ，结束事件带有该次授权注入的输入数。
+
+// ControlChannelLabel 发布者和订阅者的控制数据通道
+const ControlChannelLabel = "control"
+
+const (
+	// DefaultControlGrantDuration 未指定时订阅者控制权的时长
+	DefaultControlGrantDuration = 5 * time.Minute
+	// MaxControlGrantDuration 订阅者控制权的最长时长
+	MaxControlGrantDuration = 30 * time.Minute
+
+	// controlAuditSize 每个发布者保留的控制记录数
+	controlAuditSize = 100
+)
+
+// 控制权结束原因
+const (
+	ControlEndRevoked          = "revoked"
+	ControlEndExpired          = "expired"
+	ControlEndReleased         = "released"
+	ControlEndRegranted        = "regranted" // 授予了其他订阅者
+	ControlEndSubscriberClosed = "subscriber_closed"
+	ControlEndPublisherClosed  = "publisher_closed"
+)
+
+// 订阅者控制消息的动作
+const (
+	controlActionRequest = "request"
+	controlActionRelease = "release"
+)
+
+var (
+	// ErrNoControlInput 未配置设备输入
+	ErrNoControlInput = errors.New("control input not configured")
+	// ErrSubscriberNoDataChannel 订阅者创建时没有请求数据通道，不能获得控制权
+	ErrSubscriberNoDataChannel = errors.New("subscriber has no data channel")
+	// ErrControlNotGranted 订阅者没有控制权
+	ErrControlNotGranted = errors.New("subscriber has no control")
+)
+
+// ControlInput 设备输入（adb.Service 实现）
+type ControlInput interface {
+	SendTouchDown(deviceID string, x, y float64) error
+	SendTouchMove(deviceID string, x, y float64) error
+	SendTouchUp(deviceID string, x, y float64) error
+	SendTap(deviceID string, x, y float64) error
+	SendKeyEvent(deviceID string, keyCode int) error
+	SendLongPress(deviceID string, keyCode int) error
+	SendText(deviceID string, text string) error
+}
+
+// WithControlInput 设置设备输入
+// 发布者数据通道上的输入和获得控制权的订阅者的输入通过它注入设备（未设置时输入被丢弃）
+func WithControlInput(input ControlInput) ManagerOption {
+	return func(m *Manager) {
+		m.controlInput = input
+	}
+}
+
+// SubscriberOption 订阅者选项
+type SubscriberOption func(*subscriberOptions)
+
+type subscriberOptions struct {
+	dataChannel bool
+}
+
+// WithSubscriberDataChannel 为订阅者创建控制数据通道（可以被授予临时控制权）
+func WithSubscriberDataChannel() SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.dataChannel = true
+	}
+}
+
+// ControlAuditEntry 一次订阅者控制权的记录
+type ControlAuditEntry struct {
+	PublisherID  string     `json:"publisherId"`
+	DeviceID     string     `json:"deviceId"`
+	SubscriberID string     `json:"subscriberId"`
+	UserID       string     `json:"userId"`    // 获得控制权的用户
+	GrantedBy    string     `json:"grantedBy"` // 授权的用户
+	GrantedAt    time.Time  `json:"grantedAt"`
+	ExpiresAt    time.Time  `json:"expiresAt"`
+	EndedAt      *time.Time `json:"endedAt,omitempty"`
+	EndReason    string     `json:"endReason,omitempty"`
+	InputEvents  int        `json:"inputEvents"` // 注入设备的输入数
+}
+
+// 控制记录事件类型
+const (
+	ControlAuditGranted = "control_granted"
+	ControlAuditEnded   = "control_ended"
+)
+
+// ControlAuditEvent 控制记录事件（授权或结束时的记录快照）
+type ControlAuditEvent struct {
+	Type string
+	ControlAuditEntry
+}
+
+// ControlAuditHandler 控制记录事件处理器
+// 与发布者生命周期事件在同一事件队列中按顺序异步调用
+type ControlAuditHandler func(event ControlAuditEvent)
+
+// OnControlAudit 注册控制记录事件处理器
+func (m *Manager) OnControlAudit(handler ControlAuditHandler) {
+	m.eventMu.Lock()
+	defer m.eventMu.Unlock()
+	m.auditHandlers = append(m.auditHandlers, handler)
+}
+
+// recordControlAudit 把控制记录快照加入发布者的事件队列
+func (m *Manager) recordControlAudit(publisher *PublisherSession, eventType string, entry ControlAuditEntry) {
+	event := ControlAuditEvent{Type: eventType, ControlAuditEntry: entry}
+	publisher.events.push(func() {
+		m.eventMu.RLock()
+		handlers := make([]ControlAuditHandler, len(m.auditHandlers))
+		copy(handlers, m.auditHandlers)
+		m.eventMu.RUnlock()
+
+		for _, handler := range handlers {
+			handler(event)
+		}
+	})
+}
+
+// controlEvent 控制数据通道上服务端发送的消息
+type controlEvent struct {
+	Type         string     `json:"type"`
+	SubscriberID string     `json:"subscriberId"`
+	UserID       string     `json:"userId,omitempty"`
+	ExpiresAt    *time.Time `json:"expiresAt,omitempty"`
+	Reason       string     `json:"reason,omitempty"`
+}
+
+// publisherControl 发布者的订阅者控制权状态（零值可用）
+type publisherControl struct {
+	mu     sync.Mutex
+	active *ControlAuditEntry // 当前持有控制权的订阅者（没有时为 nil）
+	timer  *time.Timer
+	audit  []*ControlAuditEntry // 按授权时间排列，最多 controlAuditSize 条
+}
+
+// grant 授予控制权，返回被替换的记录（没有时为 nil）
+// 到期时结束该次授权并调用 onExpired（授权已被收回或替换时不调用）
+func (c *publisherControl) grant(entry *ControlAuditEntry, onExpired func(*ControlAuditEntry)) *ControlAuditEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	previous := c.endLocked(ControlEndRegranted)
+	c.active = entry
+	c.timer = time.AfterFunc(time.Until(entry.ExpiresAt), func() {
+		c.mu.Lock()
+		expired := c.active == entry
+		if expired {
+			c.endLocked(ControlEndExpired)
+		}
+		c.mu.Unlock()
+
+		if expired {
+			onExpired(entry)
+		}
+	})
+	c.audit = append(c.audit, entry)
+	if len(c.audit) > controlAuditSize {
+		c.audit = c.audit[len(c.audit)-controlAuditSize:]
+	}
+	return previous
+}
+
+// end 结束订阅者的控制权（subscriberID 为空时结束任何订阅者的控制权），返回结束的记录
+func (c *publisherControl) end(subscriberID, reason string) *ControlAuditEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.active == nil || (subscriberID != "" && c.active.SubscriberID != subscriberID) {
+		return nil
+	}
+	return c.endLocked(reason)
+}
+
+func (c *publisherControl) endLocked(reason string) *ControlAuditEntry {
+	entry := c.active
+	if entry == nil {
+		return nil
+	}
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	now := time.Now()
+	entry.EndedAt = &now
+	entry.EndReason = reason
+	c.active = nil
+	return entry
+}
+
+// recordInput 订阅者持有控制权时记录一次输入
+func (c *publisherControl) recordInput(subscriberID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.active == nil || c.active.SubscriberID != subscriberID {
+		return false
+	}
+	c.active.InputEvents++
+	return true
+}
+
+// controller 当前持有控制权的订阅者和到期时间
+func (c *publisherControl) controller() (string, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.active == nil {
+		return "", time.Time{}
+	}
+	return c.active.SubscriberID, c.active.ExpiresAt
+}
+
+// entries 控制记录快照
+func (c *publisherControl) entries() []ControlAuditEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := make([]ControlAuditEntry, len(c.audit))
+	for i, entry := range c.audit {
+		entries[i] = *entry
+	}
+	return entries
+}
+
+// GrantSubscriberControl 授予订阅者临时控制权（原来持有控制权的订阅者被收回）
+// duration 为 0 时使用 DefaultControlGrantDuration，最长 MaxControlGrantDuration
+func (m *Manager) GrantSubscriberControl(subscriberID, grantedBy string, duration time.Duration) (*ControlAuditEntry, error) {
+	subscriber, err := m.GetSubscriber(subscriberID)
+	if err != nil {
+		return nil, err
+	}
+	if subscriber.DataChannel == nil {
+		return nil, ErrSubscriberNoDataChannel
+	}
+	if m.controlInput == nil {
+		return nil, ErrNoControlInput
+	}
+
+	publisher, err := m.GetPublisher(subscriber.PublisherID)
+	if err != nil {
+		return nil, fmt.Errorf("publisher not found: %w", err)
+	}
+
+	if duration <= 0 {
+		duration = DefaultControlGrantDuration
+	}
+	duration = min(duration, MaxControlGrantDuration)
+
+	now := time.Now()
+	entry := &ControlAuditEntry{
+		PublisherID:  publisher.ID,
+		DeviceID:     publisher.DeviceID,
+		SubscriberID: subscriber.ID,
+		UserID:       subscriber.UserID,
+		GrantedBy:    grantedBy,
+		GrantedAt:    now,
+		ExpiresAt:    now.Add(duration),
+	}
+	granted := *entry
+	previous := publisher.control.grant(entry, func(expired *ControlAuditEntry) {
+		m.notifyControlEnded(publisher, expired)
+	})
+	if previous != nil {
+		m.notifyControlEnded(publisher, previous)
+	}
+	m.recordControlAudit(publisher, ControlAuditGranted, granted)
+
+	expiresAt := granted.ExpiresAt
+	sendControlEvent(subscriber.DataChannel, controlEvent{
+		Type:         "control_granted",
+		SubscriberID: subscriber.ID,
+		UserID:       subscriber.UserID,
+		ExpiresAt:    &expiresAt,
+	})
+
+	log.Printf("Control of device %s granted to subscriber %s (user %s) by %s until %s",
+		publisher.DeviceID, subscriber.ID, subscriber.UserID, grantedBy, expiresAt.Format(time.RFC3339))
+
+	return &granted, nil
+}
+
+// RevokeSubscriberControl 收回订阅者的控制权
+func (m *Manager) RevokeSubscriberControl(subscriberID string) error {
+	subscriber, err := m.GetSubscriber(subscriberID)
+	if err != nil {
+		return err
+	}
+
+	publisher, err := m.GetPublisher(subscriber.PublisherID)
+	if err != nil {
+		return fmt.Errorf("publisher not found: %w", err)
+	}
+
+	if !m.endSubscriberControl(publisher, subscriberID, ControlEndRevoked) {
+		return ErrControlNotGranted
+	}
+	return nil
+}
+
+// GetControlAudit 获取发布者的控制记录（按授权时间排列）
+func (m *Manager) GetControlAudit(publisherID string) ([]ControlAuditEntry, error) {
+	publisher, err := m.GetPublisher(publisherID)
+	if err != nil {
+		return nil, err
+	}
+	return publisher.control.entries(), nil
+}
+
+// endSubscriberControl 结束订阅者的控制权并通知订阅者（subscriberID 为空时结束任何订阅者的控制权）
+func (m *Manager) endSubscriberControl(publisher *PublisherSession, subscriberID, reason string) bool {
+	entry := publisher.control.end(subscriberID, reason)
+	if entry == nil {
+		return false
+	}
+	m.notifyControlEnded(publisher, entry)
+	return true
+}
+
+// notifyControlEnded 通知订阅者失去控制权并发出控制记录事件
+func (m *Manager) notifyControlEnded(publisher *PublisherSession, entry *ControlAuditEntry) {
+	m.recordControlAudit(publisher, ControlAuditEnded, *entry)
+
+	if subscriber, err := m.GetSubscriber(entry.SubscriberID); err == nil && subscriber.DataChannel != nil {
+		sendControlEvent(subscriber.DataChannel, controlEvent{
+			Type:         "control_revoked",
+			SubscriberID: entry.SubscriberID,
+			Reason:       entry.EndReason,
+		})
+	}
+
+	log.Printf("Control of device %s by subscriber %s (user %s) ended: %s (%d input events)",
+		entry.DeviceID, entry.SubscriberID, entry.UserID, entry.EndReason, entry.InputEvents)
+}
+
+// setupPublisherControlChannel 发布者数据通道上的输入注入设备
+func (m *Manager) setupPublisherControlChannel(publisher *PublisherSession) {
+	publisher.DataChannel.OnMessage(func(msg webrtc.DataChannelMessage) {
+		ctrlMsg, err := parseControlMessage(msg.Data, publisher.DeviceID)
+		if err == nil {
+			err = m.injectInput(publisher.DeviceID, ctrlMsg)
+		}
+		if err != nil {
+			log.Printf("Error handling publisher control message (publisher: %s): %v", publisher.ID, err)
+		}
+	})
+}
+
+// setupSubscriberControlChannel 持有控制权的订阅者的输入注入设备，其他订阅者只能请求或交还控制权
+func (m *Manager) setupSubscriberControlChannel(publisher *PublisherSession, subscriber *SubscriberSession) {
+	subscriber.DataChannel.OnMessage(func(msg webrtc.DataChannelMessage) {
+		if err := m.handleSubscriberControlMessage(publisher, subscriber, msg.Data); err != nil {
+			log.Printf("Error handling subscriber control message (subscriber: %s): %v", subscriber.ID, err)
+		}
+	})
+}
+
+// handleSubscriberControlMessage 处理订阅者数据通道上的控制消息
+func (m *Manager) handleSubscriberControlMessage(publisher *PublisherSession, subscriber *SubscriberSession, data []byte) error {
+	ctrlMsg, err := parseControlMessage(data, publisher.DeviceID)
+	if err != nil {
+		return err
+	}
+
+	if ctrlMsg.Type == "control" {
+		switch ctrlMsg.Action {
+		case controlActionRequest:
+			log.Printf("Control of device %s requested by subscriber %s", publisher.DeviceID, subscriber.ID)
+			if publisher.DataChannel != nil {
+				sendControlEvent(publisher.DataChannel, controlEvent{
+					Type:         "control_requested",
+					SubscriberID: subscriber.ID,
+					UserID:       subscriber.UserID,
+				})
+			}
+			return nil
+		case controlActionRelease:
+			if !m.endSubscriberControl(publisher, subscriber.ID, ControlEndReleased) {
+				return ErrControlNotGranted
+			}
+			return nil
+		default:
+			return fmt.Errorf("unknown control action: %s", ctrlMsg.Action)
+		}
+	}
+
+	if !publisher.control.recordInput(subscriber.ID) {
+		return fmt.Errorf("%w: %s input dropped", ErrControlNotGranted, ctrlMsg.Type)
+	}
+	return m.injectInput(publisher.DeviceID, ctrlMsg)
+}
+
+// parseControlMessage 解析控制消息并校验设备 ID
+func parseControlMessage(data []byte, deviceID string) (*models.ControlMessage, error) {
+	var ctrlMsg models.ControlMessage
+	if err := json.Unmarshal(data, &ctrlMsg); err != nil {
+		return nil, fmt.Errorf("failed to parse control message: %w", err)
+	}
+	if ctrlMsg.DeviceID != deviceID {
+		return nil, fmt.Errorf("device ID mismatch: expected %s, got %s", deviceID, ctrlMsg.DeviceID)
+	}
+	return &ctrlMsg, nil
+}
+
+// injectInput 把触摸、按键和文本输入注入设备（发布者和订阅者共用）
+func (m *Manager) injectInput(deviceID string, msg *models.ControlMessage) error {
+	if m.controlInput == nil {
+		return ErrNoControlInput
+	}
+
+	switch msg.Type {
+	case "touch":
+		switch msg.Action {
+		case "down":
+			return m.controlInput.SendTouchDown(deviceID, msg.X, msg.Y)
+		case "move":
+			return m.controlInput.SendTouchMove(deviceID, msg.X, msg.Y)
+		case "up":
+			return m.controlInput.SendTouchUp(deviceID, msg.X, msg.Y)
+		case "tap":
+			return m.controlInput.SendTap(deviceID, msg.X, msg.Y)
+		}
+		return fmt.Errorf("unknown touch action: %s", msg.Action)
+	case "key":
+		switch msg.Action {
+		case "press":
+			return m.controlInput.SendKeyEvent(deviceID, msg.KeyCode)
+		case "longpress":
+			return m.controlInput.SendLongPress(deviceID, msg.KeyCode)
+		}
+		return fmt.Errorf("unknown key action: %s", msg.Action)
+	case "text":
+		if msg.Text == "" {
+			return fmt.Errorf("text input is empty")
+		}
+		return m.controlInput.SendText(deviceID, msg.Text)
+	default:
+		return fmt.Errorf("unknown control message type: %s", msg.Type)
+	}
+}
+
+// sendControlEvent 通过控制数据通道发送消息（通道未打开时丢弃）
+func sendControlEvent(dc *webrtc.DataChannel, event controlEvent) {
+	if dc.ReadyState() != webrtc.DataChannelStateOpen {
+		return
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	if err := dc.SendText(string(data)); err != nil {
+		log.Printf("Failed to send %s on control channel: %v", event.Type, err)
+	}
+}
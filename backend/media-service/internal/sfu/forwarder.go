@@ -0,0 +1,173 @@
+package sfu
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// rtpOutboundMTU 打包时单个 RTP 包的最大长度（与 pion 的样本轨道一致）
+	rtpOutboundMTU = 1200
+	// videoClockRate 视频 RTP 时钟频率
+	videoClockRate = 90000
+
+	// subscriberKeyframeCooldown 同一层两次请求关键帧的最短间隔（所有订阅者共享）
+	subscriberKeyframeCooldown = time.Second
+)
+
+// layerFrame 某一层的一帧，打包后的 RTP 包由所有订阅者共享（只读）
+type layerFrame struct {
+	packets       []*rtp.Packet
+	keyframe      bool
+	temporalLayer int // H.264 时间层（见 encoder.H264TemporalLayer），其他编码为 0
+}
+
+// layerPacketizers 发布者各层的 RTP 打包器
+// 每层的帧只打包一次，订阅者的转发器在此基础上改写序号和时间戳
+type layerPacketizers struct {
+	mimeType string
+
+	mu          sync.Mutex
+	packetizers map[string]rtp.Packetizer
+}
+
+// newLayerPacketizers 创建打包器集合
+func newLayerPacketizers(mimeType string) *layerPacketizers {
+	return &layerPacketizers{
+		mimeType:    mimeType,
+		packetizers: make(map[string]rtp.Packetizer),
+	}
+}
+
+// packetize 把某一层的帧打包为 RTP 包
+func (l *layerPacketizers) packetize(rid string, frame []byte, duration time.Duration) []*rtp.Packet {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	packetizer, ok := l.packetizers[rid]
+	if !ok {
+		var payloader rtp.Payloader = &codecs.VP8Payloader{EnablePictureID: true}
+		if strings.EqualFold(l.mimeType, webrtc.MimeTypeH264) {
+			payloader = &codecs.H264Payloader{}
+		}
+		// SSRC 和 payload type 由订阅者轨道的绑定改写
+		packetizer = rtp.NewPacketizer(rtpOutboundMTU, 0, 0, payloader, rtp.NewRandomSequencer(), videoClockRate)
+		l.packetizers[rid] = packetizer
+	}
+	return packetizer.Packetize(frame, uint32(duration.Seconds()*videoClockRate))
+}
+
+// rtpWriter 转发器的输出（订阅者的 webrtc.TrackLocalStaticRTP）
+type rtpWriter interface {
+	WriteRTP(packet *rtp.Packet) error
+}
+
+// rtpForwarder 订阅者的 RTP 转发器
+// 转发的源（层）变化、暂停恢复后重新计算偏移，使订阅者收到的序号连续、时间戳按实际时间递增；
+// 同一个源内丢弃的帧只跳过序号（时间戳保持源的间隔）。SSRC 由轨道绑定改写为订阅者自己的 SSRC。
+// 调用方（layerSelector）负责加锁
+type rtpForwarder struct {
+	track rtpWriter
+
+	started   bool
+	rebase    bool // 下一个包开始新的源
+	seqOffset uint16
+	tsOffset  uint32
+	lastSeq   uint16
+	lastTS    uint32
+	lastWrite time.Time
+}
+
+// newRTPForwarder 创建转发器
+func newRTPForwarder(track rtpWriter) *rtpForwarder {
+	return &rtpForwarder{track: track}
+}
+
+// switchSource 下一个写入的包来自新的源
+func (f *rtpForwarder) switchSource() {
+	f.rebase = true
+}
+
+// skip 丢弃同一个源的 n 个包（后续包的序号前移，接收端不会当作丢包）
+func (f *rtpForwarder) skip(n int) {
+	f.seqOffset += uint16(n)
+}
+
+// write 改写并发送一帧的 RTP 包
+func (f *rtpForwarder) write(packets []*rtp.Packet) error {
+	for _, packet := range packets {
+		if !f.started || f.rebase {
+			f.rebaseOn(packet)
+		}
+
+		out := *packet
+		out.SequenceNumber = packet.SequenceNumber - f.seqOffset
+		out.Timestamp = packet.Timestamp - f.tsOffset
+
+		if err := f.track.WriteRTP(&out); err != nil && err != io.ErrClosedPipe {
+			return err
+		}
+		f.lastSeq = out.SequenceNumber
+		f.lastTS = out.Timestamp
+		f.lastWrite = time.Now()
+	}
+	return nil
+}
+
+// rebaseOn 以 packet 作为新源的第一个包计算偏移
+func (f *rtpForwarder) rebaseOn(packet *rtp.Packet) {
+	seq, ts := packet.SequenceNumber, packet.Timestamp
+	if f.started {
+		seq = f.lastSeq + 1
+		elapsed := uint32(time.Since(f.lastWrite).Seconds() * videoClockRate)
+		if elapsed == 0 {
+			elapsed = 1
+		}
+		ts = f.lastTS + elapsed
+	}
+
+	f.seqOffset = packet.SequenceNumber - seq
+	f.tsOffset = packet.Timestamp - ts
+	f.started = true
+	f.rebase = false
+}
+
+// keyframeGate 发布者的关键帧请求
+// 订阅者的 PLI/FIR、层切换和恢复都经过这里，同一层的请求在所有订阅者之间共享冷却时间，
+// 多个订阅者同时丢包时只请求一次
+type keyframeGate struct {
+	requester func(rid string) error
+	cooldown  time.Duration
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+// newKeyframeGate 创建关键帧请求
+func newKeyframeGate(requester func(rid string) error, cooldown time.Duration) *keyframeGate {
+	return &keyframeGate{
+		requester: requester,
+		cooldown:  cooldown,
+		last:      make(map[string]time.Time),
+	}
+}
+
+// request 请求某一层的关键帧，冷却期内直接忽略
+func (g *keyframeGate) request(rid string) error {
+	g.mu.Lock()
+	now := time.Now()
+	if now.Sub(g.last[rid]) < g.cooldown {
+		g.mu.Unlock()
+		return nil
+	}
+	g.last[rid] = now
+	g.mu.Unlock()
+
+	return g.requester(rid)
+}
@@ -0,0 +1,160 @@
+package sfu
+
+import (
+	"testing"
+
+	"github.com/pion/rtp"
+)
+
+// recordingWriter 记录转发器写出的包
+type recordingWriter struct {
+	packets []rtp.Packet
+}
+
+func (w *recordingWriter) WriteRTP(packet *rtp.Packet) error {
+	w.packets = append(w.packets, *packet)
+	return nil
+}
+
+// sourceFrame 源（层）的一帧：从 seq 开始的 packets 个包，时间戳相同
+type sourceFrame struct {
+	switchSource bool // 写入前切换源（层切换、恢复）
+	skip         int  // 写入前丢弃同一个源的包数
+	seq          uint16
+	ts           uint32
+	packets      int
+}
+
+func (f sourceFrame) rtpPackets() []*rtp.Packet {
+	packets := make([]*rtp.Packet, f.packets)
+	for i := range packets {
+		packets[i] = &rtp.Packet{Header: rtp.Header{
+			SequenceNumber: f.seq + uint16(i),
+			Timestamp:      f.ts,
+			Marker:         i == f.packets-1,
+		}}
+	}
+	return packets
+}
+
+func TestRTPForwarderContinuity(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames []sourceFrame
+	}{
+		{
+			name: "same source keeps source spacing",
+			frames: []sourceFrame{
+				{seq: 100, ts: 1000, packets: 3},
+				{seq: 103, ts: 4000, packets: 2},
+				{seq: 105, ts: 7000, packets: 1},
+			},
+		},
+		{
+			name: "layer switch rebases sequence and timestamp",
+			frames: []sourceFrame{
+				{seq: 100, ts: 1000, packets: 2},
+				{switchSource: true, seq: 40000, ts: 500000000, packets: 3},
+				{seq: 40003, ts: 500003000, packets: 1},
+				{switchSource: true, seq: 7, ts: 90, packets: 2},
+			},
+		},
+		{
+			name: "source sequence and timestamp wrap around",
+			frames: []sourceFrame{
+				{seq: 65533, ts: 4294965000, packets: 2},
+				{seq: 65535, ts: 704, packets: 3},
+				{seq: 2, ts: 3704, packets: 2},
+			},
+		},
+		{
+			name: "switch while output wraps around",
+			frames: []sourceFrame{
+				{seq: 65534, ts: 4294967000, packets: 3},
+				{switchSource: true, seq: 30000, ts: 123456, packets: 2},
+				{switchSource: true, seq: 65535, ts: 4294967295, packets: 2},
+				{seq: 1, ts: 2704, packets: 1},
+			},
+		},
+		{
+			name: "dropped frames only skip sequence numbers",
+			frames: []sourceFrame{
+				{seq: 65534, ts: 1000, packets: 1},
+				{skip: 3, seq: 2, ts: 7000, packets: 2},
+				{skip: 1, seq: 5, ts: 10000, packets: 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writer := &recordingWriter{}
+			forwarder := newRTPForwarder(writer)
+
+			var prev *rtp.Packet
+			var prevSourceTS uint32
+			for i, frame := range tt.frames {
+				if frame.switchSource {
+					forwarder.switchSource()
+				}
+				if frame.skip > 0 {
+					forwarder.skip(frame.skip)
+				}
+
+				written := len(writer.packets)
+				if err := forwarder.write(frame.rtpPackets()); err != nil {
+					t.Fatalf("frame %d: write failed: %v", i, err)
+				}
+				out := writer.packets[written:]
+				if len(out) != frame.packets {
+					t.Fatalf("frame %d: wrote %d packets, want %d", i, len(out), frame.packets)
+				}
+
+				for j := range out {
+					packet := &out[j]
+					if j > 0 && packet.Timestamp != out[0].Timestamp {
+						t.Errorf("frame %d: packet %d timestamp %d, want frame timestamp %d", i, j, packet.Timestamp, out[0].Timestamp)
+					}
+					if prev == nil {
+						prev = packet
+						continue
+					}
+					if want := prev.SequenceNumber + 1; packet.SequenceNumber != want {
+						t.Errorf("frame %d: packet %d sequence %d, want %d", i, j, packet.SequenceNumber, want)
+					}
+					prev = packet
+				}
+
+				if i == 0 {
+					prevSourceTS = frame.ts
+					continue
+				}
+				// 时间戳按 uint32 回绕比较：切换源后只要求递增，同一个源内保持源的间隔
+				delta := out[0].Timestamp - writer.packets[written-1].Timestamp
+				if frame.switchSource {
+					if delta == 0 || int32(delta) < 0 {
+						t.Errorf("frame %d: timestamp advanced by %d after switch, want > 0", i, int32(delta))
+					}
+				} else if want := frame.ts - prevSourceTS; delta != want {
+					t.Errorf("frame %d: timestamp advanced by %d, want source spacing %d", i, delta, want)
+				}
+				prevSourceTS = frame.ts
+			}
+		})
+	}
+}
+
+func TestRTPForwarderFirstPacketKeepsSourceNumbering(t *testing.T) {
+	writer := &recordingWriter{}
+	forwarder := newRTPForwarder(writer)
+
+	if err := forwarder.write(sourceFrame{seq: 65535, ts: 4294967295, packets: 2}.rtpPackets()); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if got := writer.packets[0].SequenceNumber; got != 65535 {
+		t.Errorf("first sequence = %d, want 65535", got)
+	}
+	if got := writer.packets[1].SequenceNumber; got != 0 {
+		t.Errorf("second sequence = %d, want 0 after wrap-around", got)
+	}
+}
@@ -0,0 +1,281 @@
+package sfu
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// 发布者生命周期
+//
+// 设备采集跟随订阅者数量（config.SFULazyCapture，默认开启）：发布者协商完成（ActivatePublisher）后，
+// 第一个订阅者连接时才开始采集，订阅者数量降为 0 时暂停采集，之后有订阅者时恢复。
+// 关闭按需采集时协商完成即开始采集，不暂停（发布者连接本身也在观看）。
+// 空闲关闭只看 config.SFUPublisherIdleSeconds：没有订阅者超过该时间后关闭发布者（0 = 不关闭）。
+//
+// Manager 只维护状态并发出事件（OnPublisherEvent），由事件处理器启动 / 暂停 / 停止设备采集管道。
+// 事件在状态变化时进入发布者的事件队列，由队列的 goroutine 按顺序调用处理器，
+// 订阅者连接和关闭的调用路径不等待处理器（停止采集管道、发布 RabbitMQ 消息）。
+// WHIP 推流和级联中继没有设备采集，只发出 closed 事件。
+
+// PublisherEventType 发布者生命周期事件类型
+type PublisherEventType string
+
+const (
+	// PublisherEventCaptureStarted 需要开始设备采集
+	PublisherEventCaptureStarted PublisherEventType = "capture_started"
+	// PublisherEventCapturePaused 订阅者数量降为 0，暂停设备采集
+	PublisherEventCapturePaused PublisherEventType = "capture_paused"
+	// PublisherEventCaptureResumed 暂停后又有订阅者，恢复设备采集
+	PublisherEventCaptureResumed PublisherEventType = "capture_resumed"
+	// PublisherEventClosed 发布者已关闭，停止设备采集
+	PublisherEventClosed PublisherEventType = "closed"
+)
+
+// 发布者关闭原因
+const (
+	PublisherCloseRequested      = "requested"
+	PublisherCloseIdle           = "idle"            // 没有订阅者超过空闲时间
+	PublisherCloseICEFailed      = "ice_failed"      // 发布者连接失败
+	PublisherCloseInactive       = "inactive"        // 不活跃会话清理
+	PublisherCloseNoSubscribers  = "no_subscribers"  // 中继没有本地订阅者
+	PublisherCloseUpstreamClosed = "upstream_closed" // 中继的源实例停止发送
+)
+
+// 发布者的视频来源
+const (
+	PublisherSourceDevice = "device"
+	PublisherSourceWHIP   = "whip"
+	PublisherSourceRelay  = "relay"
+)
+
+// PublisherEvent 发布者生命周期事件
+type PublisherEvent struct {
+	Type            PublisherEventType
+	PublisherID     string
+	DeviceID        string
+	UserID          string
+	TenantID        string
+	Source          string // 视频来源：device / whip / relay
+	SubscriberCount int
+	Reason          string // closed 事件的关闭原因
+	Timestamp       time.Time
+}
+
+// PublisherEventHandler 发布者生命周期事件处理器
+// 在发布者的事件队列 goroutine 中异步调用（同一发布者的事件按顺序，不持有 Manager 的锁）
+type PublisherEventHandler func(event PublisherEvent)
+
+// captureState 设备采集状态
+type captureState int
+
+const (
+	captureIdle captureState = iota
+	captureRunning
+	capturePaused
+)
+
+// String 采集状态名（API 响应）
+func (s captureState) String() string {
+	switch s {
+	case captureRunning:
+		return "running"
+	case capturePaused:
+		return "paused"
+	default:
+		return "idle"
+	}
+}
+
+// publisherLifecycle 发布者的设备采集状态和空闲计时
+type publisherLifecycle struct {
+	mu        sync.Mutex
+	ready     bool // 发布者已协商完成，可以开始采集
+	closing   bool
+	capture   captureState
+	idleTimer *time.Timer
+}
+
+// eventQueue 发布者的事件队列：回调由一个 goroutine 按入队顺序执行（零值可用）
+// 生命周期事件和控制记录事件共用，调用路径不等待事件处理器
+type eventQueue struct {
+	mu      sync.Mutex
+	pending []func()
+	running bool
+}
+
+// OnPublisherEvent 注册发布者生命周期事件处理器
+func (m *Manager) OnPublisherEvent(handler PublisherEventHandler) {
+	m.eventMu.Lock()
+	defer m.eventMu.Unlock()
+	m.eventHandlers = append(m.eventHandlers, handler)
+}
+
+// notifyPublisherEvent 把事件加入发布者的事件队列（不等待处理器）
+func (m *Manager) notifyPublisherEvent(publisher *PublisherSession, eventType PublisherEventType, reason string) {
+	event := PublisherEvent{
+		Type:            eventType,
+		PublisherID:     publisher.ID,
+		DeviceID:        publisher.DeviceID,
+		UserID:          publisher.UserID,
+		TenantID:        publisher.TenantID,
+		Source:          publisher.source(),
+		SubscriberCount: publisher.GetSubscriberCount(),
+		Reason:          reason,
+		Timestamp:       time.Now(),
+	}
+	publisher.events.push(func() { m.dispatchPublisherEvent(event) })
+}
+
+// dispatchPublisherEvent 通知所有事件处理器
+func (m *Manager) dispatchPublisherEvent(event PublisherEvent) {
+	m.eventMu.RLock()
+	handlers := make([]PublisherEventHandler, len(m.eventHandlers))
+	copy(handlers, m.eventHandlers)
+	m.eventMu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// ActivatePublisher 发布者协商完成，按订阅者数量开始设备采集
+// 重新协商时采集状态不变
+func (m *Manager) ActivatePublisher(publisherID string) error {
+	publisher, err := m.GetPublisher(publisherID)
+	if err != nil {
+		return err
+	}
+
+	publisher.lifecycle.mu.Lock()
+	publisher.lifecycle.ready = true
+	publisher.lifecycle.mu.Unlock()
+
+	m.updateCapture(publisher)
+	return nil
+}
+
+// updateCapture 订阅者数量变化后更新设备采集状态和空闲计时
+// 事件在 lc.mu 内入队以保持状态变化的顺序，处理器在队列 goroutine 中调用
+func (m *Manager) updateCapture(publisher *PublisherSession) {
+	if publisher.ingest != nil {
+		return
+	}
+
+	lc := &publisher.lifecycle
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	if lc.closing || !lc.ready {
+		return
+	}
+
+	lazy := m.config.SFULazyCapture
+	watched := publisher.GetSubscriberCount() > 0
+	switch {
+	case (watched || !lazy) && lc.capture == captureIdle:
+		lc.capture = captureRunning
+		m.notifyPublisherEvent(publisher, PublisherEventCaptureStarted, "")
+	case watched && lc.capture == capturePaused:
+		lc.capture = captureRunning
+		m.notifyPublisherEvent(publisher, PublisherEventCaptureResumed, "")
+	case !watched && lazy && lc.capture == captureRunning:
+		lc.capture = capturePaused
+		m.notifyPublisherEvent(publisher, PublisherEventCapturePaused, "")
+	}
+
+	if watched {
+		lc.stopIdleTimer()
+	} else if lc.idleTimer == nil && m.config.SFUPublisherIdleSeconds > 0 {
+		lc.startIdleTimer(time.Duration(m.config.SFUPublisherIdleSeconds)*time.Second, func() {
+			m.closeIdlePublisher(publisher)
+		})
+	}
+}
+
+// closeIdlePublisher 空闲计时结束时仍没有订阅者则关闭发布者
+func (m *Manager) closeIdlePublisher(publisher *PublisherSession) {
+	lc := &publisher.lifecycle
+	lc.mu.Lock()
+	idle := !lc.closing && publisher.GetSubscriberCount() == 0
+	lc.idleTimer = nil
+	lc.mu.Unlock()
+
+	if !idle {
+		return
+	}
+	if err := m.closePublisher(publisher.ID, PublisherCloseIdle); err == nil {
+		log.Printf("Closed SFU publisher %s for device %s: idle for %ds",
+			publisher.ID, publisher.DeviceID, m.config.SFUPublisherIdleSeconds)
+	}
+}
+
+// beginClose 标记发布者正在关闭（关闭订阅者时不再暂停采集）
+func (m *Manager) beginClose(publisher *PublisherSession) {
+	lc := &publisher.lifecycle
+	lc.mu.Lock()
+	lc.closing = true
+	lc.stopIdleTimer()
+	lc.mu.Unlock()
+}
+
+// captureState 当前设备采集状态
+func (lc *publisherLifecycle) captureState() captureState {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.capture
+}
+
+// push 回调入队，没有正在执行的 goroutine 时启动一个
+func (q *eventQueue) push(fn func()) {
+	q.mu.Lock()
+	q.pending = append(q.pending, fn)
+	if q.running {
+		q.mu.Unlock()
+		return
+	}
+	q.running = true
+	q.mu.Unlock()
+
+	go q.drain()
+}
+
+// drain 按顺序执行队列中的回调，队列为空时退出
+func (q *eventQueue) drain() {
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		fn := q.pending[0]
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+
+		fn()
+	}
+}
+
+// startIdleTimer 开始空闲计时（调用方持有 mu）
+func (lc *publisherLifecycle) startIdleTimer(timeout time.Duration, onIdle func()) {
+	var timer *time.Timer
+	timer = time.AfterFunc(timeout, func() {
+		// 计时已被取消或重新开始
+		lc.mu.Lock()
+		current := lc.idleTimer == timer
+		lc.mu.Unlock()
+		if current {
+			onIdle()
+		}
+	})
+	lc.idleTimer = timer
+}
+
+// stopIdleTimer 取消空闲计时（调用方持有 mu）
+func (lc *publisherLifecycle) stopIdleTimer() {
+	if lc.idleTimer != nil {
+		lc.idleTimer.Stop()
+		lc.idleTimer = nil
+	}
+}
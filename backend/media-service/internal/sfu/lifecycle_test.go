@@ -0,0 +1,266 @@
+package sfu
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudphone/media-service/internal/config"
+)
+
+// lifecycleStep 发布者生命周期测试的一步
+type lifecycleStep string
+
+const (
+	stepActivate lifecycleStep = "activate" // 协商完成
+	stepJoin     lifecycleStep = "join"     // 订阅者连接
+	stepLeave    lifecycleStep = "leave"    // 最后加入的订阅者离开
+)
+
+// lifecycleFixture 没有 PeerConnection 的设备发布者，记录发出的生命周期事件
+type lifecycleFixture struct {
+	manager   *Manager
+	publisher *PublisherSession
+	joined    int
+
+	mu     sync.Mutex
+	events []PublisherEventType
+}
+
+func newLifecycleFixture(t *testing.T, cfg *config.Config) *lifecycleFixture {
+	t.Helper()
+	f := &lifecycleFixture{
+		manager: NewManager(cfg),
+		publisher: &PublisherSession{
+			ID:          "pub-1",
+			DeviceID:    "device-1",
+			subscribers: make(map[string]*SubscriberSession),
+		},
+	}
+	f.manager.OnPublisherEvent(func(event PublisherEvent) {
+		f.mu.Lock()
+		f.events = append(f.events, event.Type)
+		f.mu.Unlock()
+	})
+	t.Cleanup(func() { f.manager.beginClose(f.publisher) })
+	return f
+}
+
+func (f *lifecycleFixture) run(t *testing.T, step lifecycleStep) {
+	t.Helper()
+	switch step {
+	case stepActivate:
+		f.publisher.lifecycle.mu.Lock()
+		f.publisher.lifecycle.ready = true
+		f.publisher.lifecycle.mu.Unlock()
+	case stepJoin:
+		f.joined++
+		id := fmt.Sprintf("sub-%d", f.joined)
+		f.publisher.mu.Lock()
+		f.publisher.subscribers[id] = &SubscriberSession{ID: id, PublisherID: f.publisher.ID}
+		f.publisher.mu.Unlock()
+	case stepLeave:
+		id := fmt.Sprintf("sub-%d", f.joined)
+		f.joined--
+		f.publisher.mu.Lock()
+		delete(f.publisher.subscribers, id)
+		f.publisher.mu.Unlock()
+	default:
+		t.Fatalf("unknown step %q", step)
+	}
+	f.manager.updateCapture(f.publisher)
+}
+
+// dispatched 等待事件队列处理完已入队的事件后返回所有事件
+func (f *lifecycleFixture) dispatched(t *testing.T) []PublisherEventType {
+	t.Helper()
+	done := make(chan struct{})
+	f.publisher.events.push(func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publisher events were not dispatched")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]PublisherEventType(nil), f.events...)
+}
+
+func (f *lifecycleFixture) idleTimerArmed() bool {
+	f.publisher.lifecycle.mu.Lock()
+	defer f.publisher.lifecycle.mu.Unlock()
+	return f.publisher.lifecycle.idleTimer != nil
+}
+
+func TestPublisherCaptureFollowsSubscribers(t *testing.T) {
+	tests := []struct {
+		name       string
+		lazy       bool
+		steps      []lifecycleStep
+		wantEvents []PublisherEventType
+		wantState  captureState
+	}{
+		{
+			name:      "lazy capture waits for the first subscriber",
+			lazy:      true,
+			steps:     []lifecycleStep{stepActivate},
+			wantState: captureIdle,
+		},
+		{
+			name:       "lazy capture starts on the first subscriber",
+			lazy:       true,
+			steps:      []lifecycleStep{stepActivate, stepJoin, stepJoin},
+			wantEvents: []PublisherEventType{PublisherEventCaptureStarted},
+			wantState:  captureRunning,
+		},
+		{
+			name:       "subscriber before negotiation starts capture on activate",
+			lazy:       true,
+			steps:      []lifecycleStep{stepJoin, stepActivate},
+			wantEvents: []PublisherEventType{PublisherEventCaptureStarted},
+			wantState:  captureRunning,
+		},
+		{
+			name:  "running to paused to running",
+			lazy:  true,
+			steps: []lifecycleStep{stepActivate, stepJoin, stepLeave, stepJoin},
+			wantEvents: []PublisherEventType{
+				PublisherEventCaptureStarted,
+				PublisherEventCapturePaused,
+				PublisherEventCaptureResumed,
+			},
+			wantState: captureRunning,
+		},
+		{
+			name:  "pauses only when the last subscriber leaves",
+			lazy:  true,
+			steps: []lifecycleStep{stepActivate, stepJoin, stepJoin, stepLeave, stepLeave},
+			wantEvents: []PublisherEventType{
+				PublisherEventCaptureStarted,
+				PublisherEventCapturePaused,
+			},
+			wantState: capturePaused,
+		},
+		{
+			name:       "without lazy capture starts on activate and never pauses",
+			lazy:       false,
+			steps:      []lifecycleStep{stepActivate, stepJoin, stepLeave},
+			wantEvents: []PublisherEventType{PublisherEventCaptureStarted},
+			wantState:  captureRunning,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newLifecycleFixture(t, &config.Config{SFULazyCapture: tt.lazy})
+			for _, step := range tt.steps {
+				f.run(t, step)
+			}
+
+			events := f.dispatched(t)
+			if fmt.Sprint(events) != fmt.Sprint(tt.wantEvents) {
+				t.Errorf("events = %v, want %v", events, tt.wantEvents)
+			}
+			if got := f.publisher.lifecycle.captureState(); got != tt.wantState {
+				t.Errorf("capture = %s, want %s", got, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestPublisherIdleTimer(t *testing.T) {
+	tests := []struct {
+		name        string
+		lazy        bool
+		idleSeconds int
+		steps       []lifecycleStep
+		wantArmed   bool
+	}{
+		{
+			name:        "armed when negotiated without subscribers",
+			lazy:        true,
+			idleSeconds: 300,
+			steps:       []lifecycleStep{stepActivate},
+			wantArmed:   true,
+		},
+		{
+			name:        "cancelled when a subscriber joins",
+			lazy:        true,
+			idleSeconds: 300,
+			steps:       []lifecycleStep{stepActivate, stepJoin},
+			wantArmed:   false,
+		},
+		{
+			name:        "armed again when the last subscriber leaves",
+			lazy:        true,
+			idleSeconds: 300,
+			steps:       []lifecycleStep{stepActivate, stepJoin, stepLeave},
+			wantArmed:   true,
+		},
+		{
+			name:        "armed without lazy capture",
+			lazy:        false,
+			idleSeconds: 300,
+			steps:       []lifecycleStep{stepActivate, stepJoin, stepLeave},
+			wantArmed:   true,
+		},
+		{
+			name:        "cancelled without lazy capture when a subscriber joins",
+			lazy:        false,
+			idleSeconds: 300,
+			steps:       []lifecycleStep{stepActivate, stepJoin},
+			wantArmed:   false,
+		},
+		{
+			name:        "never armed when idle close is disabled",
+			lazy:        true,
+			idleSeconds: 0,
+			steps:       []lifecycleStep{stepActivate, stepJoin, stepLeave},
+			wantArmed:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newLifecycleFixture(t, &config.Config{
+				SFULazyCapture:          tt.lazy,
+				SFUPublisherIdleSeconds: tt.idleSeconds,
+			})
+			for _, step := range tt.steps {
+				f.run(t, step)
+			}
+
+			if got := f.idleTimerArmed(); got != tt.wantArmed {
+				t.Errorf("idle timer armed = %v, want %v", got, tt.wantArmed)
+			}
+		})
+	}
+}
+
+func TestIdleTimerStoppedBeforeFiring(t *testing.T) {
+	var lc publisherLifecycle
+	fired := make(chan struct{}, 1)
+
+	lc.mu.Lock()
+	lc.startIdleTimer(20*time.Millisecond, func() { fired <- struct{}{} })
+	lc.stopIdleTimer()
+	lc.mu.Unlock()
+
+	select {
+	case <-fired:
+		t.Fatal("idle callback ran after the timer was stopped")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	lc.mu.Lock()
+	lc.startIdleTimer(20*time.Millisecond, func() { fired <- struct{}{} })
+	lc.mu.Unlock()
+
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatal("idle callback did not run")
+	}
+}
@@ -8,11 +8,17 @@ import (
 	"sync"
 	"time"
 
+	"github.com/cloudphone/media-service/internal/adaptive"
 	"github.com/cloudphone/media-service/internal/config"
+	"github.com/cloudphone/media-service/internal/encoder"
+	"github.com/cloudphone/media-service/internal/httpclient"
 	"github.com/cloudphone/media-service/internal/turn"
 	"github.com/google/uuid"
+	"github.com/pion/interceptor/pkg/nack"
+	"github.com/pion/interceptor/pkg/twcc"
 	"github.com/pion/webrtc/v3"
 	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/sirupsen/logrus"
 )
 
 const defaultNumShards = 16
@@ -33,10 +39,44 @@ type Manager struct {
 	turnService *turn.Service
 
 	// devicePublishers 设备ID到发布者的映射（用于快速查找某设备的发布者）
-	devicePublishers map[string]string // deviceID -> publisherID
+	devicePublishers map[string]string        // deviceID -> publisherID
+	relayPending     map[string]chan struct{} // deviceID -> 正在创建的中继（创建完成时关闭）
 	deviceMu         sync.RWMutex
+
+	// directory 跨实例的设备发布者目录（级联 SFU，nil 时不登记也不中继）
+	directory       PublisherDirectory
+	relayClient     *httpclient.Client
+	relayCredential RelayCredential // 实例间 WHEP 请求的服务令牌
+
+	// simulcastLayers 非空时 H.264 发布者转码为多个层，订阅者按 RTCP 反馈选择
+	simulcastLayers []encoder.SimulcastLayer
+	simulcastLogger *logrus.Logger
+
+	// feedback 接收 RTCP 反馈的发布者视频管道（码率自适应、关键帧请求）
+	feedback       adaptive.SessionFeedback
+	feedbackLogger *logrus.Logger
+
+	// controlInput 发布者和获得控制权的订阅者的输入注入设备
+	controlInput ControlInput
+
+	// rooms 房间（一个观看者连接订阅多个设备）
+	rooms            map[string]*Room            // roomID -> room
+	roomParticipants map[string]*RoomParticipant // participantID -> participant
+	roomsMu          sync.RWMutex
+
+	// eventHandlers 发布者生命周期事件处理器（启动 / 暂停 / 停止设备采集，发布事件）
+	eventHandlers []PublisherEventHandler
+	// closedHandlers 连接关闭监听器（释放准入名额）
+	closedHandlers []ConnectionClosedHandler
+	// auditHandlers 控制记录事件处理器（结构化日志、RabbitMQ）
+	auditHandlers []ControlAuditHandler
+	eventMu       sync.RWMutex
 }
 
+// ConnectionClosedHandler 连接关闭回调
+// connectionID 为发布者、独立连接的订阅者或房间参与者的 ID（房间订阅共用参与者的连接，不单独通知）
+type ConnectionClosedHandler func(connectionID string)
+
 // ManagerOption 配置选项
 type ManagerOption func(*Manager)
 
@@ -57,6 +97,25 @@ func WithTURNService(ts *turn.Service) ManagerOption {
 	}
 }
 
+// WithSimulcastTranscoding 启用服务端转码
+// 设备端 H.264 被转码为 layers 中的各层（与原始码流一起）供订阅者选择
+func WithSimulcastTranscoding(layers []encoder.SimulcastLayer, logger *logrus.Logger) ManagerOption {
+	return func(m *Manager) {
+		m.simulcastLayers = layers
+		m.simulcastLogger = logger
+	}
+}
+
+// WithPipelineFeedback 把发布者和订阅者 PeerConnection 收到的 RTCP 反馈接到发布者的视频管道
+// 订阅者的 PLI/FIR 请求设备关键帧，接收端报告/REMB 驱动设备码率（启用转码时码率不变，由订阅者选择层）
+// 订阅者切换到原始码流层时也通过它请求设备关键帧
+func WithPipelineFeedback(feedback adaptive.SessionFeedback, logger *logrus.Logger) ManagerOption {
+	return func(m *Manager) {
+		m.feedback = feedback
+		m.feedbackLogger = logger
+	}
+}
+
 // NewManager 创建 SFU 管理器
 func NewManager(cfg *config.Config, opts ...ManagerOption) *Manager {
 	m := &Manager{
@@ -64,6 +123,9 @@ func NewManager(cfg *config.Config, opts ...ManagerOption) *Manager {
 		numShards:        defaultNumShards,
 		turnService:      turn.NewService(),
 		devicePublishers: make(map[string]string),
+		relayPending:     make(map[string]chan struct{}),
+		rooms:            make(map[string]*Room),
+		roomParticipants: make(map[string]*RoomParticipant),
 	}
 
 	for _, opt := range opts {
@@ -82,43 +144,38 @@ func NewManager(cfg *config.Config, opts ...ManagerOption) *Manager {
 	return m
 }
 
-// getShard 获取 sessionID 对应的分片
-func (m *Manager) getShard(sessionID string) *shard {
-	h := fnv.New32a()
-	h.Write([]byte(sessionID))
-	return &m.shards[h.Sum32()%m.numShards]
+// OnConnectionClosed 注册连接关闭监听器
+// 回调在连接已从管理器移除后调用，不持有任何锁
+func (m *Manager) OnConnectionClosed(handler ConnectionClosedHandler) {
+	m.eventMu.Lock()
+	defer m.eventMu.Unlock()
+	m.closedHandlers = append(m.closedHandlers, handler)
 }
 
-// CreatePublisher 创建发布者会话
-// 发布者从设备捕获视频并广播给订阅者
-func (m *Manager) CreatePublisher(deviceID, userID string, videoCodec string) (*PublisherSession, error) {
-	// 检查该设备是否已有发布者
-	m.deviceMu.RLock()
-	existingPubID, exists := m.devicePublishers[deviceID]
-	m.deviceMu.RUnlock()
+// notifyConnectionClosed 通知所有连接关闭监听器
+func (m *Manager) notifyConnectionClosed(connectionID string) {
+	m.eventMu.RLock()
+	handlers := make([]ConnectionClosedHandler, len(m.closedHandlers))
+	copy(handlers, m.closedHandlers)
+	m.eventMu.RUnlock()
 
-	if exists {
-		// 获取现有发布者
-		pub, err := m.GetPublisher(existingPubID)
-		if err == nil {
-			log.Printf("Device %s already has publisher %s, reusing", deviceID, existingPubID)
-			return pub, nil
-		}
-		// 如果获取失败，清理映射继续创建
-		m.deviceMu.Lock()
-		delete(m.devicePublishers, deviceID)
-		m.deviceMu.Unlock()
+	for _, handler := range handlers {
+		handler(connectionID)
 	}
+}
 
-	publisherID := uuid.New().String()
-	shard := m.getShard(publisherID)
-
-	// 构建 ICE 服务器
-	iceServers := m.GetICEServers()
+// newPeerConnection 创建 PeerConnection（发布者、订阅者和 WHIP 推流共用的配置）
+// publisherID 非空时该 PeerConnection 的 RTCP 反馈送到发布者的视频管道，为空时为 WHIP 推流端
+// 返回的收集器需要通过 SetVideoSender 关联视频发送端（FEC 使用协商的 payload type）
+func (m *Manager) newPeerConnection(publisherID string) (*webrtc.PeerConnection, *adaptive.RTCPCollector, error) {
+	return m.createPeerConnection(m.newRTCPCollector(publisherID), publisherID == "")
+}
 
+// createPeerConnection 使用给定的 RTCP 收集器创建 PeerConnection（ingest 为 WHIP 推流端）
+func (m *Manager) createPeerConnection(collector *adaptive.RTCPCollector, ingest bool) (*webrtc.PeerConnection, *adaptive.RTCPCollector, error) {
 	// 创建 WebRTC 配置
 	webrtcConfig := webrtc.Configuration{
-		ICEServers:   iceServers,
+		ICEServers:   m.GetICEServers(),
 		SDPSemantics: webrtc.SDPSemanticsUnifiedPlan,
 	}
 
@@ -128,7 +185,7 @@ func (m *Manager) CreatePublisher(deviceID, userID string, videoCodec string) (*
 		m.config.ICEPortMin,
 		m.config.ICEPortMax,
 	); err != nil {
-		return nil, fmt.Errorf("failed to set ICE port range: %w", err)
+		return nil, nil, fmt.Errorf("failed to set ICE port range: %w", err)
 	}
 
 	if len(m.config.NAT1To1IPs) > 0 {
@@ -138,19 +195,133 @@ func (m *Manager) CreatePublisher(deviceID, userID string, videoCodec string) (*
 	// 创建 MediaEngine 并注册编解码器
 	mediaEngine := &webrtc.MediaEngine{}
 	if err := m.registerCodecs(mediaEngine); err != nil {
-		return nil, fmt.Errorf("failed to register codecs: %w", err)
+		return nil, nil, fmt.Errorf("failed to register codecs: %w", err)
+	}
+	if ingest {
+		// WHIP 推流端可以发送 simulcast，通过 MID/RID 头扩展区分各层
+		if err := webrtc.ConfigureSimulcastExtensionHeaders(mediaEngine); err != nil {
+			return nil, nil, fmt.Errorf("failed to register simulcast header extensions: %w", err)
+		}
+	}
+
+	// 每个 PeerConnection 独立的拦截器（收集器在 PeerConnection 关闭时停止）
+	// 设备码率在 SFU 中未知，NACK 重传历史按配置的最高码率调整
+	interceptorRegistry, err := adaptive.NewInterceptorRegistry(collector, m.config.MaxBitrate)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create interceptor registry: %w", err)
+	}
+
+	// WHIP 推流端是媒体发送方：为其生成 TWCC 反馈，推流编码器据此估计带宽
+	if ingest {
+		twccGenerator, err := twcc.NewSenderInterceptor()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create TWCC feedback generator: %w", err)
+		}
+		interceptorRegistry.Add(twccGenerator)
+
+		// 推流端丢包时请求重传（推流端需协商 nack）
+		nackGenerator, err := nack.NewGeneratorInterceptor()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create NACK generator: %w", err)
+		}
+		interceptorRegistry.Add(nackGenerator)
 	}
 
 	// 创建 API
 	api := webrtc.NewAPI(
 		webrtc.WithSettingEngine(settingEngine),
 		webrtc.WithMediaEngine(mediaEngine),
+		webrtc.WithInterceptorRegistry(interceptorRegistry),
 	)
 
-	// 创建 PeerConnection
 	peerConnection, err := api.NewPeerConnection(webrtcConfig)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create peer connection: %w", err)
+		return nil, nil, fmt.Errorf("failed to create peer connection: %w", err)
+	}
+	collector.Start()
+
+	return peerConnection, collector, nil
+}
+
+// newRTCPCollector 创建 RTCP 反馈收集器（publisherID 为空时只统计，用于 WHIP 推流端）
+// 同一发布者的多个 PeerConnection 各自上报，发布者管道的质量控制器按历史平均
+func (m *Manager) newRTCPCollector(publisherID string) *adaptive.RTCPCollector {
+	collector := adaptive.NewRTCPCollector(publisherID, m.feedbackLogger)
+	if publisherID == "" {
+		return collector
+	}
+
+	// 发布者 PeerConnection 发送原始码流；订阅者创建后改为请求其正在接收的层
+	collector.SetKeyframeRequester(func() error {
+		publisher, err := m.GetPublisher(publisherID)
+		if err != nil {
+			return err
+		}
+		return publisher.keyframes.request(SourceLayerRID)
+	})
+
+	collector.SetNetworkQualityHandler(func(quality adaptive.NetworkQuality) error {
+		publisher, err := m.GetPublisher(publisherID)
+		if err != nil {
+			return err
+		}
+		// 推流的发布者码率不受控制；转码的发布者保持设备码率，弱网订阅者切换到低码率层
+		if m.feedback == nil || publisher.ingest != nil || publisher.simulcast.transcoding() {
+			return nil
+		}
+		return m.feedback.UpdateNetworkQuality(publisherID, quality)
+	})
+
+	// 发布者管道的质量控制器按丢包率决定是否发送 FEC（推流和转码的发布者没有反馈，不发送）
+	collector.SetFECSource(func() bool {
+		return m.feedback != nil && m.feedback.FECEnabled(publisherID)
+	})
+	return collector
+}
+
+// getShard 获取 sessionID 对应的分片
+func (m *Manager) getShard(sessionID string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(sessionID))
+	return &m.shards[h.Sum32()%m.numShards]
+}
+
+// CreatePublisher 创建发布者会话
+// 发布者从设备捕获视频并广播给订阅者
+func (m *Manager) CreatePublisher(deviceID, userID string, videoCodec string) (*PublisherSession, error) {
+	// 检查该设备是否已有发布者
+	m.deviceMu.RLock()
+	existingPubID, exists := m.devicePublishers[deviceID]
+	m.deviceMu.RUnlock()
+
+	if exists {
+		// 获取现有发布者
+		pub, err := m.GetPublisher(existingPubID)
+		if err == nil && pub.relay != nil {
+			// 设备由其他实例采集，本实例只是中继
+			return nil, ErrPublisherRelay
+		}
+		if err == nil && pub.ingest != nil {
+			// WHIP 推流的发布者没有设备采集，不能作为采集发布者协商
+			return nil, ErrPublisherIngest
+		}
+		if err == nil {
+			log.Printf("Device %s already has publisher %s, reusing", deviceID, existingPubID)
+			return pub, nil
+		}
+		// 如果获取失败，清理映射继续创建
+		m.deviceMu.Lock()
+		delete(m.devicePublishers, deviceID)
+		m.deviceMu.Unlock()
+	}
+
+	publisherID := uuid.New().String()
+	shard := m.getShard(publisherID)
+
+	// 创建 PeerConnection
+	peerConnection, collector, err := m.newPeerConnection(publisherID)
+	if err != nil {
+		return nil, err
 	}
 
 	// 创建发布者会话
@@ -164,6 +335,7 @@ func (m *Manager) CreatePublisher(deviceID, userID string, videoCodec string) (*
 		State:          StateNew,
 		subscribers:    make(map[string]*SubscriberSession),
 	}
+	publisher.keyframes = newKeyframeGate(m.publisherKeyframeRequester(publisher), subscriberKeyframeCooldown)
 
 	// 设置事件处理器
 	m.setupPublisherHandlers(publisher)
@@ -184,20 +356,34 @@ func (m *Manager) CreatePublisher(deviceID, userID string, videoCodec string) (*
 		return nil, fmt.Errorf("failed to create video track: %w", err)
 	}
 	publisher.VideoTrack = videoTrack
+	publisher.packetizers = newLayerPacketizers(mimeType)
+
+	// 只有设备端 H.264 需要转码（服务端编码可直接按目标码率编码）
+	if mimeType == webrtc.MimeTypeH264 && len(m.simulcastLayers) > 0 {
+		publisher.simulcast = newPublisherSimulcast(m.simulcastLayers, m.simulcastLogger)
+	} else {
+		publisher.simulcast = newPublisherSimulcast(nil, nil)
+	}
 
 	// 添加视频轨道
-	if _, err = peerConnection.AddTrack(videoTrack); err != nil {
+	rtpSender, err := peerConnection.AddTrack(videoTrack)
+	if err != nil {
 		peerConnection.Close()
 		return nil, fmt.Errorf("failed to add video track: %w", err)
 	}
 
+	// 读取 RTCP 反馈（经过拦截器送到发布者视频管道）
+	collector.SetVideoSender(rtpSender)
+	go adaptive.ReadSenderRTCP(rtpSender)
+
 	// 创建数据通道（用于控制）
-	dataChannel, err := peerConnection.CreateDataChannel("control", nil)
+	dataChannel, err := peerConnection.CreateDataChannel(ControlChannelLabel, nil)
 	if err != nil {
 		peerConnection.Close()
 		return nil, fmt.Errorf("failed to create data channel: %w", err)
 	}
 	publisher.DataChannel = dataChannel
+	m.setupPublisherControlChannel(publisher)
 
 	// 存储发布者
 	shard.mu.Lock()
@@ -215,8 +401,13 @@ func (m *Manager) CreatePublisher(deviceID, userID string, videoCodec string) (*
 }
 
 // CreateSubscriber 创建订阅者会话
-// 订阅者从发布者接收视频流
-func (m *Manager) CreateSubscriber(publisherID, userID string) (*SubscriberSession, error) {
+// 订阅者从发布者接收视频流；请求数据通道时可以被授予临时控制权
+func (m *Manager) CreateSubscriber(publisherID, userID string, opts ...SubscriberOption) (*SubscriberSession, error) {
+	var options subscriberOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	// 获取发布者
 	publisher, err := m.GetPublisher(publisherID)
 	if err != nil {
@@ -228,46 +419,11 @@ func (m *Manager) CreateSubscriber(publisherID, userID string) (*SubscriberSessi
 	}
 
 	subscriberID := uuid.New().String()
-	shard := m.getShard(subscriberID)
-
-	// 构建 ICE 服务器
-	iceServers := m.GetICEServers()
-
-	// 创建 WebRTC 配置
-	webrtcConfig := webrtc.Configuration{
-		ICEServers:   iceServers,
-		SDPSemantics: webrtc.SDPSemanticsUnifiedPlan,
-	}
-
-	// 创建设置引擎
-	settingEngine := webrtc.SettingEngine{}
-	if err := settingEngine.SetEphemeralUDPPortRange(
-		m.config.ICEPortMin,
-		m.config.ICEPortMax,
-	); err != nil {
-		return nil, fmt.Errorf("failed to set ICE port range: %w", err)
-	}
-
-	if len(m.config.NAT1To1IPs) > 0 {
-		settingEngine.SetNAT1To1IPs(m.config.NAT1To1IPs, webrtc.ICECandidateTypeHost)
-	}
-
-	// 创建 MediaEngine
-	mediaEngine := &webrtc.MediaEngine{}
-	if err := m.registerCodecs(mediaEngine); err != nil {
-		return nil, fmt.Errorf("failed to register codecs: %w", err)
-	}
-
-	// 创建 API
-	api := webrtc.NewAPI(
-		webrtc.WithSettingEngine(settingEngine),
-		webrtc.WithMediaEngine(mediaEngine),
-	)
 
 	// 创建 PeerConnection
-	peerConnection, err := api.NewPeerConnection(webrtcConfig)
+	peerConnection, collector, err := m.newPeerConnection(publisherID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create peer connection: %w", err)
+		return nil, err
 	}
 
 	// 创建订阅者会话
@@ -285,37 +441,74 @@ func (m *Manager) CreateSubscriber(publisherID, userID string) (*SubscriberSessi
 	// 设置事件处理器
 	m.setupSubscriberHandlers(subscriber)
 
-	// 关键：将发布者的 Track 添加到订阅者的 PeerConnection
-	// 这样订阅者就能接收发布者的视频流，而不需要重新编码
-	rtpSender, err := peerConnection.AddTrack(publisher.VideoTrack)
-	if err != nil {
+	if options.dataChannel {
+		dataChannel, err := peerConnection.CreateDataChannel(ControlChannelLabel, nil)
+		if err != nil {
+			peerConnection.Close()
+			return nil, fmt.Errorf("failed to create data channel: %w", err)
+		}
+		subscriber.DataChannel = dataChannel
+		m.setupSubscriberControlChannel(publisher, subscriber)
+	}
+
+	if err := m.attachSubscriber(publisher, subscriber, "video"); err != nil {
 		peerConnection.Close()
-		return nil, fmt.Errorf("failed to add publisher track to subscriber: %w", err)
+		return nil, err
+	}
+	collector.SetVideoSender(subscriber.RTPSender)
+
+	// 订阅者的 PLI/FIR 请求其正在接收的层的关键帧，冷却时间在该发布者的所有订阅者之间共享
+	collector.SetKeyframeRequester(func() error {
+		return publisher.keyframes.request(subscriber.layer.keyframeLayer())
+	})
+
+	log.Printf("Created SFU subscriber: %s for publisher: %s (device: %s)",
+		subscriberID, publisherID, publisher.DeviceID)
+
+	return subscriber, nil
+}
+
+// attachSubscriber 为订阅者创建独立的视频轨道并加入其 PeerConnection，然后开始转发
+// 每个订阅者使用独立的 RTP 轨道（编码与发布者相同，不重新编码），由层选择器转发所选层的 RTP 包：
+// 各订阅者按自己的 RTCP 反馈选择转码层 / 推流 simulcast 层和时间层，可以单独暂停，慢的订阅者不影响其他人
+func (m *Manager) attachSubscriber(publisher *PublisherSession, subscriber *SubscriberSession, trackID string) error {
+	videoTrack, err := webrtc.NewTrackLocalStaticRTP(
+		webrtc.RTPCodecCapability{MimeType: publisher.VideoTrack.Codec().MimeType},
+		trackID,
+		fmt.Sprintf("cloudphone-sfu-%s", publisher.DeviceID),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create subscriber video track: %w", err)
+	}
+	subscriber.VideoTrack = videoTrack
+	subscriber.control = &publisher.control
+	initialLayer := publisher.simulcast.initialLayer()
+	subscriber.layer = newLayerSelector(newRTPForwarder(videoTrack), initialLayer)
+
+	rtpSender, err := subscriber.PeerConnection.AddTrack(videoTrack)
+	if err != nil {
+		return fmt.Errorf("failed to add publisher track to subscriber: %w", err)
 	}
 	subscriber.RTPSender = rtpSender
 
-	// 处理 RTCP 反馈（用于质量控制）
-	go func() {
-		rtcpBuf := make([]byte, 1500)
-		for {
-			if _, _, rtcpErr := rtpSender.Read(rtcpBuf); rtcpErr != nil {
-				return
-			}
-		}
-	}()
+	// 处理 RTCP 反馈（用于质量控制和层选择）
+	go m.readSubscriberRTCP(publisher, subscriber, rtpSender)
 
 	// 存储订阅者
+	shard := m.getShard(subscriber.ID)
 	shard.mu.Lock()
-	shard.subscribers[subscriberID] = subscriber
+	shard.subscribers[subscriber.ID] = subscriber
 	shard.mu.Unlock()
 
 	// 添加到发布者的订阅者列表
 	publisher.AddSubscriber(subscriber)
+	m.ensureTranscoder(publisher)
+	// 订阅者从所选层的关键帧开始接收，主动请求关键帧（推流编码器和设备的关键帧间隔较长）
+	m.requestLayerKeyframe(publisher, initialLayer)
+	// 第一个订阅者开始（或恢复）设备采集
+	m.updateCapture(publisher)
 
-	log.Printf("Created SFU subscriber: %s for publisher: %s (device: %s)",
-		subscriberID, publisherID, publisher.DeviceID)
-
-	return subscriber, nil
+	return nil
 }
 
 // GetPublisher 获取发布者
@@ -363,57 +556,82 @@ func (m *Manager) GetSubscriber(subscriberID string) (*SubscriberSession, error)
 
 // ClosePublisher 关闭发布者
 func (m *Manager) ClosePublisher(publisherID string) error {
-	shard := m.getShard(publisherID)
+	return m.closePublisher(publisherID, PublisherCloseRequested)
+}
 
-	shard.mu.Lock()
-	publisher, ok := shard.publishers[publisherID]
-	if !ok {
-		shard.mu.Unlock()
-		return fmt.Errorf("publisher not found: %s", publisherID)
+// closePublisher 关闭发布者，reason 随 closed 事件发出
+func (m *Manager) closePublisher(publisherID, reason string) error {
+	publisher, err := m.GetPublisher(publisherID)
+	if err != nil {
+		return err
 	}
 
-	// 关闭所有订阅者
+	m.beginClose(publisher)
+	m.endSubscriberControl(publisher, "", ControlEndPublisherClosed)
+	// 先标记为关闭，关闭最后一个订阅者时中继不会再次关闭
+	publisher.UpdateState(StateClosed)
+
+	// 关闭所有订阅者（CloseSubscriber 需要查找发布者，不能持有发布者分片的锁）
 	for _, sub := range publisher.GetSubscribers() {
 		m.CloseSubscriber(sub.ID)
 	}
 
+	shard := m.getShard(publisherID)
+	shard.mu.Lock()
+	if _, ok := shard.publishers[publisherID]; !ok {
+		shard.mu.Unlock()
+		return fmt.Errorf("publisher not found: %s", publisherID)
+	}
+
 	// 关闭发布者连接
 	if publisher.PeerConnection != nil {
 		publisher.PeerConnection.Close()
 	}
 
-	publisher.UpdateState(StateClosed)
 	delete(shard.publishers, publisherID)
 	shard.mu.Unlock()
 
+	publisher.simulcast.stopTranscoder()
+
 	// 清理设备映射
 	m.deviceMu.Lock()
 	delete(m.devicePublishers, publisher.DeviceID)
 	m.deviceMu.Unlock()
 
-	log.Printf("Closed SFU publisher: %s", publisherID)
+	m.removeDeviceFromRooms(publisher)
+	m.releasePublisher(publisher)
+	m.notifyPublisherEvent(publisher, PublisherEventClosed, reason)
+	m.notifyConnectionClosed(publisherID)
+
+	log.Printf("Closed SFU publisher: %s (%s)", publisherID, reason)
 
 	return nil
 }
 
 // CloseSubscriber 关闭订阅者
 func (m *Manager) CloseSubscriber(subscriberID string) error {
-	shard := m.getShard(subscriberID)
+	subscriber, err := m.GetSubscriber(subscriberID)
+	if err != nil {
+		return err
+	}
+
+	// 发布者可能与订阅者在同一分片，在持有分片锁之前查找
+	publisher, _ := m.GetPublisher(subscriber.PublisherID)
 
+	shard := m.getShard(subscriberID)
 	shard.mu.Lock()
-	subscriber, ok := shard.subscribers[subscriberID]
-	if !ok {
+	if _, ok := shard.subscribers[subscriberID]; !ok {
 		shard.mu.Unlock()
 		return fmt.Errorf("subscriber not found: %s", subscriberID)
 	}
 
 	// 从发布者移除
-	if publisher, err := m.GetPublisher(subscriber.PublisherID); err == nil {
+	if publisher != nil {
 		publisher.RemoveSubscriber(subscriberID)
 	}
 
-	// 关闭连接
-	if subscriber.PeerConnection != nil {
+	// 关闭连接（房间订阅共用观看者的连接，只移除轨道）
+	if subscriber.participant == nil && subscriber.PeerConnection != nil {
 		subscriber.PeerConnection.Close()
 	}
 
@@ -421,11 +639,60 @@ func (m *Manager) CloseSubscriber(subscriberID string) error {
 	delete(shard.subscribers, subscriberID)
 	shard.mu.Unlock()
 
+	if subscriber.participant != nil {
+		subscriber.participant.removeSubscription(subscriber)
+	} else {
+		m.notifyConnectionClosed(subscriberID)
+	}
+
+	// 停止转码进程可能需要等待，在分片锁之外进行
+	if publisher != nil {
+		if entry := publisher.control.end(subscriberID, ControlEndSubscriberClosed); entry != nil {
+			m.notifyControlEnded(publisher, entry)
+		}
+		m.releaseTranscoder(publisher)
+		m.releaseRelay(publisher)
+		// 最后一个订阅者离开时暂停设备采集
+		m.updateCapture(publisher)
+	}
+
 	log.Printf("Closed SFU subscriber: %s", subscriberID)
 
 	return nil
 }
 
+// PauseSubscriber 暂停向订阅者转发视频（连接保持，其他订阅者不受影响）
+func (m *Manager) PauseSubscriber(subscriberID string) error {
+	subscriber, err := m.GetSubscriber(subscriberID)
+	if err != nil {
+		return err
+	}
+
+	subscriber.layer.pause()
+	log.Printf("Paused SFU subscriber: %s", subscriberID)
+
+	return nil
+}
+
+// ResumeSubscriber 恢复向订阅者转发视频
+// 从所选层的下一个关键帧开始转发，并为该层请求关键帧
+func (m *Manager) ResumeSubscriber(subscriberID string) error {
+	subscriber, err := m.GetSubscriber(subscriberID)
+	if err != nil {
+		return err
+	}
+
+	publisher, err := m.GetPublisher(subscriber.PublisherID)
+	if err != nil {
+		return fmt.Errorf("publisher not found: %w", err)
+	}
+
+	m.requestLayerKeyframe(publisher, subscriber.layer.resume())
+	log.Printf("Resumed SFU subscriber: %s", subscriberID)
+
+	return nil
+}
+
 // CreatePublisherOffer 创建发布者 SDP Offer
 func (m *Manager) CreatePublisherOffer(publisherID string) (*webrtc.SessionDescription, error) {
 	publisher, err := m.GetPublisher(publisherID)
@@ -541,7 +808,7 @@ func (m *Manager) AddSubscriberICECandidate(subscriberID string, candidate webrt
 }
 
 // WriteVideoFrame 向发布者的视频轨道写入帧
-// 所有订阅者会自动收到这个帧
+// 订阅者的轨道由各自的层选择器写入（原始码流层），同时送入转码器
 func (m *Manager) WriteVideoFrame(publisherID string, frame []byte, duration time.Duration) error {
 	publisher, err := m.GetPublisher(publisherID)
 	if err != nil {
@@ -563,6 +830,9 @@ func (m *Manager) WriteVideoFrame(publisherID string, frame []byte, duration tim
 		}
 	}
 
+	// 订阅者不共享发布者轨道，原始码流和转码层由层选择器分发
+	m.writeSourceFrame(publisher, frame, duration)
+
 	return nil
 }
 
@@ -620,9 +890,10 @@ func (m *Manager) setupPublisherHandlers(pub *PublisherSession) {
 		switch state {
 		case webrtc.ICEConnectionStateConnected:
 			pub.UpdateState(StateConnected)
+			m.advertisePublisher(pub)
 		case webrtc.ICEConnectionStateFailed:
 			pub.UpdateState(StateFailed)
-			m.ClosePublisher(pub.ID)
+			m.closePublisher(pub.ID, PublisherCloseICEFailed)
 		case webrtc.ICEConnectionStateDisconnected:
 			pub.UpdateState(StateDisconnected)
 		case webrtc.ICEConnectionStateClosed:
@@ -660,29 +931,47 @@ func (m *Manager) setupSubscriberHandlers(sub *SubscriberSession) {
 	})
 }
 
-// registerCodecs 注册编解码器
-func (m *Manager) registerCodecs(mediaEngine *webrtc.MediaEngine) error {
-	// H.264
-	if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+// videoCodecs 支持的视频编码（按优先级排列）
+var videoCodecs = []webrtc.RTPCodecParameters{
+	{
 		RTPCodecCapability: webrtc.RTPCodecCapability{
-			MimeType:    webrtc.MimeTypeH264,
-			ClockRate:   90000,
-			SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
+			MimeType:     webrtc.MimeTypeH264,
+			ClockRate:    90000,
+			SDPFmtpLine:  "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
+			RTCPFeedback: videoRTCPFeedback,
 		},
 		PayloadType: 102,
-	}, webrtc.RTPCodecTypeVideo); err != nil {
-		return err
-	}
-
-	// VP8
-	if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+	},
+	{
 		RTPCodecCapability: webrtc.RTPCodecCapability{
-			MimeType:  webrtc.MimeTypeVP8,
-			ClockRate: 90000,
+			MimeType:     webrtc.MimeTypeVP8,
+			ClockRate:    90000,
+			RTCPFeedback: videoRTCPFeedback,
 		},
 		PayloadType: 96,
-	}, webrtc.RTPCodecTypeVideo); err != nil {
-		return err
+	},
+}
+
+// videoRTCPFeedback 视频编码协商的 RTCP 反馈
+//   - goog-remb: 接收端带宽估计，用于转码层选择和设备码率
+//   - transport-cc: 传输层拥塞反馈，驱动发送端带宽估计 (GCC)，优先于 REMB
+//   - nack: 订阅者丢包时请求重传（从 PeerConnection 的发送历史中重发）；WHIP 推流端丢包时服务端请求重传
+//   - ccm fir / nack pli: 订阅者解码失败时请求设备关键帧
+var videoRTCPFeedback = []webrtc.RTCPFeedback{
+	{Type: webrtc.TypeRTCPFBGoogREMB},
+	{Type: webrtc.TypeRTCPFBTransportCC},
+	{Type: webrtc.TypeRTCPFBNACK},
+	{Type: webrtc.TypeRTCPFBCCM, Parameter: "fir"},
+	{Type: webrtc.TypeRTCPFBNACK, Parameter: "pli"},
+}
+
+// registerCodecs 注册编解码器
+func (m *Manager) registerCodecs(mediaEngine *webrtc.MediaEngine) error {
+	// H.264 / VP8
+	for _, codec := range videoCodecs {
+		if err := mediaEngine.RegisterCodec(codec, webrtc.RTPCodecTypeVideo); err != nil {
+			return err
+		}
 	}
 
 	// Opus
@@ -698,36 +987,62 @@ func (m *Manager) registerCodecs(mediaEngine *webrtc.MediaEngine) error {
 		return err
 	}
 
+	// TWCC 头扩展：接收端据此发送传输层拥塞反馈，驱动发送端带宽估计 (GCC)
+	if err := adaptive.RegisterTransportCC(mediaEngine); err != nil {
+		return err
+	}
+
+	// 视频 FEC (RED/ULPFEC)：协商后由发布者视频管道的质量控制器按丢包率开启
+	if m.config.VideoFECEnabled {
+		if err := adaptive.RegisterFECCodecs(mediaEngine); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 // CleanupInactiveSessions 清理不活跃的会话
 func (m *Manager) CleanupInactiveSessions(timeout time.Duration) {
 	now := time.Now()
+	var (
+		closed            []*PublisherSession
+		roomSubscriptions []*SubscriberSession
+		inactive          []*SubscriberSession
+		closedSubscribers []string
+	)
 
 	for i := uint32(0); i < m.numShards; i++ {
 		shard := &m.shards[i]
 
 		shard.mu.Lock()
-		// 清理不活跃的订阅者
+		// 清理不活跃的订阅者（房间订阅随观看者离开房间关闭）
 		for subID, sub := range shard.subscribers {
-			if now.Sub(sub.LastActivityAt) > timeout {
+			if sub.participant == nil && now.Sub(sub.LastActivityAt) > timeout {
 				log.Printf("Cleaning up inactive subscriber: %s", subID)
 				if sub.PeerConnection != nil {
 					sub.PeerConnection.Close()
 				}
 				delete(shard.subscribers, subID)
+				inactive = append(inactive, sub)
 			}
 		}
 
-		// 清理不活跃的发布者
+		// 清理不活跃的发布者（以及没有本地订阅者的中继）
 		for pubID, pub := range shard.publishers {
-			if now.Sub(pub.LastActivityAt) > timeout {
+			idleRelay := pub.relay != nil && pub.GetSubscriberCount() == 0 && now.Sub(pub.CreatedAt) > timeout
+			if now.Sub(pub.LastActivityAt) > timeout || idleRelay {
 				log.Printf("Cleaning up inactive publisher: %s", pubID)
-				// 先关闭所有订阅者
+				m.beginClose(pub)
+				// 先关闭所有订阅者（房间订阅在分片锁之外从观看者的连接中移除）
 				for _, sub := range pub.GetSubscribers() {
-					if sub.PeerConnection != nil {
-						sub.PeerConnection.Close()
+					if sub.participant != nil {
+						roomSubscriptions = append(roomSubscriptions, sub)
+					} else {
+						if sub.PeerConnection != nil {
+							sub.PeerConnection.Close()
+						}
+						closedSubscribers = append(closedSubscribers, sub.ID)
 					}
 					delete(shard.subscribers, sub.ID)
 				}
@@ -735,6 +1050,7 @@ func (m *Manager) CleanupInactiveSessions(timeout time.Duration) {
 					pub.PeerConnection.Close()
 				}
 				delete(shard.publishers, pubID)
+				closed = append(closed, pub)
 
 				// 清理设备映射
 				m.deviceMu.Lock()
@@ -744,4 +1060,27 @@ func (m *Manager) CleanupInactiveSessions(timeout time.Duration) {
 		}
 		shard.mu.Unlock()
 	}
+
+	for _, sub := range roomSubscriptions {
+		sub.participant.removeSubscription(sub)
+	}
+	// 发布者查找需要分片锁，在分片锁之外从发布者移除不活跃的订阅者
+	for _, sub := range inactive {
+		if pub, err := m.GetPublisher(sub.PublisherID); err == nil {
+			pub.RemoveSubscriber(sub.ID)
+			m.releaseTranscoder(pub)
+			m.updateCapture(pub)
+		}
+		m.notifyConnectionClosed(sub.ID)
+	}
+	for _, subID := range closedSubscribers {
+		m.notifyConnectionClosed(subID)
+	}
+	for _, pub := range closed {
+		pub.simulcast.stopTranscoder()
+		m.removeDeviceFromRooms(pub)
+		m.releasePublisher(pub)
+		m.notifyPublisherEvent(pub, PublisherEventClosed, PublisherCloseInactive)
+		m.notifyConnectionClosed(pub.ID)
+	}
 }
@@ -0,0 +1,799 @@
+package sfu
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/cloudphone/media-service/internal/adaptive"
+	"github.com/google/uuid"
+	"github.com/pion/webrtc/v3"
+)
+
+// 房间：一个观看者的 PeerConnection 同时订阅多个设备的发布者（课堂、设备农场看板）
+//
+// 房间记录设备列表和参与者。参与者加入时服务端为房间中的每个设备创建订阅（SubscriberSession，
+// 共用参与者的 PeerConnection），通过 HTTP 返回初始 offer；之后设备的增减通过重新协商完成：
+// 服务端在数据通道 "room" 上发送 offer 事件，客户端在同一通道回复 answer 事件（也可以通过 HTTP 提交）。
+// 同一时间只有一个未完成的 offer，期间的变化在收到 answer 后合并为下一个 offer。
+//
+// 数据通道上的事件（RoomEvent，JSON）：
+//
+//	participant_joined  {"type":"participant_joined","roomId":"r1","participantId":"p2","userId":"u2"}
+//	participant_left    {"type":"participant_left","roomId":"r1","participantId":"p2","userId":"u2"}
+//	device_added        {"type":"device_added","roomId":"r1","deviceId":"d1","publisherId":"pub1","streamId":"cloudphone-sfu-d1"}
+//	device_removed      {"type":"device_removed","roomId":"r1","deviceId":"d1","publisherId":"pub1","streamId":"cloudphone-sfu-d1"}
+//	room_closed         {"type":"room_closed","roomId":"r1"}
+//	removed             {"type":"removed","roomId":"r1","participantId":"p2","deviceId":"d2"}（只发给被移出的参与者：没有新加入设备的观看权限）
+//	offer / answer      {"type":"offer","roomId":"r1","participantId":"p1","sdp":{"type":"offer","sdp":"..."}}
+//
+// 每个设备的视频轨道属于 MediaStream "cloudphone-sfu-<deviceId>"（streamId），客户端据此把轨道对应到设备。
+
+// RoomDataChannelLabel 房间事件和重新协商使用的数据通道
+const RoomDataChannelLabel = "room"
+
+// 房间事件类型
+const (
+	RoomEventParticipantJoined = "participant_joined"
+	RoomEventParticipantLeft   = "participant_left"
+	RoomEventDeviceAdded       = "device_added"
+	RoomEventDeviceRemoved     = "device_removed"
+	RoomEventRoomClosed        = "room_closed"
+	RoomEventRemoved           = "removed"
+	RoomEventOffer             = "offer"  // 服务端 -> 客户端
+	RoomEventAnswer            = "answer" // 客户端 -> 服务端
+)
+
+var (
+	// ErrRoomNotFound 房间不存在
+	ErrRoomNotFound = errors.New("room not found")
+	// ErrParticipantNotFound 房间参与者不存在
+	ErrParticipantNotFound = errors.New("room participant not found")
+)
+
+// RoomEvent 房间数据通道上的事件
+type RoomEvent struct {
+	Type          string                     `json:"type"`
+	RoomID        string                     `json:"roomId"`
+	ParticipantID string                     `json:"participantId,omitempty"`
+	UserID        string                     `json:"userId,omitempty"`
+	DeviceID      string                     `json:"deviceId,omitempty"`
+	PublisherID   string                     `json:"publisherId,omitempty"`
+	StreamID      string                     `json:"streamId,omitempty"` // 设备视频轨道的 MediaStream ID
+	SDP           *webrtc.SessionDescription `json:"sdp,omitempty"`
+	Timestamp     int64                      `json:"timestamp"` // Unix 毫秒
+}
+
+// Room 房间
+type Room struct {
+	ID        string
+	Name      string
+	UserID    string // 创建者（可以增减设备、关闭房间）
+	TenantID  string
+	CreatedAt time.Time
+
+	mu           sync.RWMutex
+	devices      map[string]string // deviceID -> publisherID
+	participants map[string]*RoomParticipant
+}
+
+// RoomParticipant 房间参与者（一个观看者 PeerConnection）
+type RoomParticipant struct {
+	ID             string
+	RoomID         string
+	UserID         string
+	TenantID       string
+	Permissions    []string // 参与者的权限（来自 JWT，添加设备时按身份校验观看权限）
+	PeerConnection *webrtc.PeerConnection
+	DataChannel    *webrtc.DataChannel
+	JoinedAt       time.Time
+
+	mu            sync.Mutex
+	state         SessionState
+	subscriptions map[string]*SubscriberSession // publisherID -> 订阅
+	negotiating   bool                          // 已发送 offer，等待 answer
+	pendingOffer  bool                          // 需要再次协商（轨道在协商期间或数据通道打开前变化）
+	closed        bool
+}
+
+// RoomInfo 房间信息（用于 API 响应）
+type RoomInfo struct {
+	ID                string                `json:"id"`
+	Name              string                `json:"name,omitempty"`
+	UserID            string                `json:"userId"`
+	Devices           []RoomDeviceInfo      `json:"devices"`
+	Participants      []RoomParticipantInfo `json:"participants"`
+	SubscriptionCount int                   `json:"subscriptionCount"`
+	CreatedAt         time.Time             `json:"createdAt"`
+}
+
+// RoomDeviceInfo 房间中的设备
+type RoomDeviceInfo struct {
+	DeviceID    string `json:"deviceId"`
+	PublisherID string `json:"publisherId"`
+	StreamID    string `json:"streamId"`
+}
+
+// RoomParticipantInfo 房间参与者信息
+type RoomParticipantInfo struct {
+	ID            string    `json:"id"`
+	UserID        string    `json:"userId"`
+	State         string    `json:"state"`
+	Subscriptions []string  `json:"subscriptions"` // 正在接收的订阅者 ID
+	JoinedAt      time.Time `json:"joinedAt"`
+}
+
+// roomStreamID 设备视频轨道的 MediaStream ID（与单独订阅时相同）
+func roomStreamID(deviceID string) string {
+	return fmt.Sprintf("cloudphone-sfu-%s", deviceID)
+}
+
+// GetParticipantCount 获取参与者数量
+func (r *Room) GetParticipantCount() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.participants)
+}
+
+// GetDeviceCount 获取设备数量
+func (r *Room) GetDeviceCount() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.devices)
+}
+
+// HasParticipantUser 用户是否为房间参与者
+func (r *Room) HasParticipantUser(userID string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, p := range r.participants {
+		if p.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// DeviceIDs 获取房间中的设备 ID
+func (r *Room) DeviceIDs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ids := make([]string, 0, len(r.devices))
+	for deviceID := range r.devices {
+		ids = append(ids, deviceID)
+	}
+	return ids
+}
+
+// getParticipants 参与者快照
+func (r *Room) getParticipants() []*RoomParticipant {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	participants := make([]*RoomParticipant, 0, len(r.participants))
+	for _, p := range r.participants {
+		participants = append(participants, p)
+	}
+	return participants
+}
+
+// ToInfo 转换为 API 响应格式
+func (r *Room) ToInfo() RoomInfo {
+	info := RoomInfo{
+		ID:           r.ID,
+		Name:         r.Name,
+		UserID:       r.UserID,
+		Devices:      []RoomDeviceInfo{},
+		Participants: []RoomParticipantInfo{},
+		CreatedAt:    r.CreatedAt,
+	}
+
+	r.mu.RLock()
+	for deviceID, publisherID := range r.devices {
+		info.Devices = append(info.Devices, RoomDeviceInfo{
+			DeviceID:    deviceID,
+			PublisherID: publisherID,
+			StreamID:    roomStreamID(deviceID),
+		})
+	}
+	r.mu.RUnlock()
+
+	for _, p := range r.getParticipants() {
+		participant := p.ToInfo()
+		info.SubscriptionCount += len(participant.Subscriptions)
+		info.Participants = append(info.Participants, participant)
+	}
+	return info
+}
+
+// ToInfo 转换为 API 响应格式
+func (p *RoomParticipant) ToInfo() RoomParticipantInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	info := RoomParticipantInfo{
+		ID:            p.ID,
+		UserID:        p.UserID,
+		State:         string(p.state),
+		Subscriptions: make([]string, 0, len(p.subscriptions)),
+		JoinedAt:      p.JoinedAt,
+	}
+	for _, sub := range p.subscriptions {
+		info.Subscriptions = append(info.Subscriptions, sub.ID)
+	}
+	return info
+}
+
+// GetState 获取参与者状态
+func (p *RoomParticipant) GetState() SessionState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
+}
+
+// subscriptionCount 订阅数量（共享连接带宽）
+func (p *RoomParticipant) subscriptionCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.subscriptions)
+}
+
+// updateState 更新参与者及其订阅的状态
+func (p *RoomParticipant) updateState(state SessionState) {
+	p.mu.Lock()
+	p.state = state
+	subs := make([]*SubscriberSession, 0, len(p.subscriptions))
+	for _, sub := range p.subscriptions {
+		subs = append(subs, sub)
+	}
+	p.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.UpdateState(state)
+	}
+}
+
+// sendEvent 通过数据通道发送事件（通道未打开时丢弃）
+func (p *RoomParticipant) sendEvent(event RoomEvent) {
+	if p.DataChannel == nil || p.DataChannel.ReadyState() != webrtc.DataChannelStateOpen {
+		return
+	}
+
+	event.RoomID = p.RoomID
+	if event.Timestamp == 0 {
+		event.Timestamp = time.Now().UnixMilli()
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	if err := p.DataChannel.SendText(string(data)); err != nil {
+		log.Printf("Failed to send room event %s to participant %s: %v", event.Type, p.ID, err)
+	}
+}
+
+// negotiate 发送重新协商的 offer
+// 上一个 offer 未得到 answer、信令状态不稳定或数据通道未打开时推迟到之后
+func (p *RoomParticipant) negotiate() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return
+	}
+	if p.negotiating ||
+		p.PeerConnection.SignalingState() != webrtc.SignalingStateStable ||
+		p.DataChannel.ReadyState() != webrtc.DataChannelStateOpen {
+		p.pendingOffer = true
+		return
+	}
+
+	offer, err := p.PeerConnection.CreateOffer(nil)
+	if err != nil {
+		log.Printf("Failed to create renegotiation offer for participant %s: %v", p.ID, err)
+		return
+	}
+	if err := p.PeerConnection.SetLocalDescription(offer); err != nil {
+		log.Printf("Failed to set renegotiation offer for participant %s: %v", p.ID, err)
+		return
+	}
+	p.negotiating = true
+	p.pendingOffer = false
+
+	// ICE 收集已在初始协商时完成，本地描述中包含候选
+	p.sendEvent(RoomEvent{
+		Type:          RoomEventOffer,
+		ParticipantID: p.ID,
+		SDP:           p.PeerConnection.LocalDescription(),
+	})
+}
+
+// handleAnswer 处理客户端对最近一个 offer 的 answer，期间有新的变化时继续协商
+func (p *RoomParticipant) handleAnswer(answer webrtc.SessionDescription) error {
+	p.mu.Lock()
+	if err := p.PeerConnection.SetRemoteDescription(answer); err != nil {
+		p.mu.Unlock()
+		return fmt.Errorf("failed to set room answer: %w", err)
+	}
+	p.negotiating = false
+	pending := p.pendingOffer
+	p.mu.Unlock()
+
+	if pending {
+		p.negotiate()
+	}
+	return nil
+}
+
+// subscription 获取参与者对某个发布者的订阅
+func (p *RoomParticipant) subscription(publisherID string) *SubscriberSession {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.subscriptions[publisherID]
+}
+
+// removeSubscription 从参与者的连接中移除订阅的轨道并重新协商（订阅已关闭）
+// 参与者离开房间时连接整体关闭，不再协商
+func (p *RoomParticipant) removeSubscription(sub *SubscriberSession) {
+	p.mu.Lock()
+	if p.subscriptions[sub.PublisherID] == sub {
+		delete(p.subscriptions, sub.PublisherID)
+	}
+	closed := p.closed
+	p.mu.Unlock()
+
+	if closed || sub.RTPSender == nil {
+		return
+	}
+	if err := p.PeerConnection.RemoveTrack(sub.RTPSender); err != nil {
+		log.Printf("Failed to remove track of subscriber %s from participant %s: %v", sub.ID, p.ID, err)
+		return
+	}
+	p.negotiate()
+}
+
+// ========== Manager ==========
+
+// CreateRoom 创建房间
+func (m *Manager) CreateRoom(name, userID, tenantID string) *Room {
+	room := &Room{
+		ID:           uuid.New().String(),
+		Name:         name,
+		UserID:       userID,
+		TenantID:     tenantID,
+		CreatedAt:    time.Now(),
+		devices:      make(map[string]string),
+		participants: make(map[string]*RoomParticipant),
+	}
+
+	m.roomsMu.Lock()
+	m.rooms[room.ID] = room
+	m.roomsMu.Unlock()
+
+	log.Printf("Created SFU room: %s (%s)", room.ID, name)
+
+	return room
+}
+
+// GetRoom 获取房间
+func (m *Manager) GetRoom(roomID string) (*Room, error) {
+	m.roomsMu.RLock()
+	room, ok := m.rooms[roomID]
+	m.roomsMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrRoomNotFound, roomID)
+	}
+	return room, nil
+}
+
+// GetAllRooms 获取所有房间
+func (m *Manager) GetAllRooms() []*Room {
+	m.roomsMu.RLock()
+	defer m.roomsMu.RUnlock()
+
+	rooms := make([]*Room, 0, len(m.rooms))
+	for _, room := range m.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// GetRoomParticipant 获取房间参与者
+func (m *Manager) GetRoomParticipant(participantID string) (*RoomParticipant, error) {
+	m.roomsMu.RLock()
+	participant, ok := m.roomParticipants[participantID]
+	m.roomsMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrParticipantNotFound, participantID)
+	}
+	return participant, nil
+}
+
+// CloseRoom 关闭房间：通知并移除所有参与者（设备的发布者不受影响）
+func (m *Manager) CloseRoom(roomID string) error {
+	m.roomsMu.Lock()
+	room, ok := m.rooms[roomID]
+	delete(m.rooms, roomID)
+	m.roomsMu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrRoomNotFound, roomID)
+	}
+
+	for _, p := range room.getParticipants() {
+		p.sendEvent(RoomEvent{Type: RoomEventRoomClosed})
+		m.LeaveRoom(p.ID)
+	}
+
+	log.Printf("Closed SFU room: %s", roomID)
+
+	return nil
+}
+
+// RoomParticipantFilter 判断参与者能否观看设备
+type RoomParticipantFilter func(p *RoomParticipant) bool
+
+// AddRoomDevice 把设备的发布者加入房间，所有参与者订阅该设备并重新协商
+// canView 不为 nil 时，不能观看该设备的参与者被移出房间（收到 removed 事件后关闭连接）
+func (m *Manager) AddRoomDevice(roomID, deviceID string, canView RoomParticipantFilter) (*PublisherSession, error) {
+	room, err := m.GetRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	publisher, err := m.GetPublisherByDevice(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if publisher.VideoTrack == nil {
+		return nil, fmt.Errorf("publisher has no video track")
+	}
+
+	room.mu.Lock()
+	if room.devices[deviceID] == publisher.ID {
+		room.mu.Unlock()
+		return publisher, nil
+	}
+	room.devices[deviceID] = publisher.ID
+	room.mu.Unlock()
+
+	for _, p := range room.getParticipants() {
+		if canView != nil && !canView(p) {
+			p.sendEvent(RoomEvent{Type: RoomEventRemoved, ParticipantID: p.ID, DeviceID: deviceID})
+			m.LeaveRoom(p.ID)
+			log.Printf("Removed participant %s from SFU room %s: no view access to device %s", p.ID, roomID, deviceID)
+			continue
+		}
+		if err := m.subscribeParticipant(p, publisher); err != nil {
+			log.Printf("Failed to subscribe room participant %s to device %s: %v", p.ID, deviceID, err)
+			continue
+		}
+		p.negotiate()
+	}
+
+	m.broadcastRoomEvent(room, RoomEvent{
+		Type:        RoomEventDeviceAdded,
+		DeviceID:    deviceID,
+		PublisherID: publisher.ID,
+		StreamID:    roomStreamID(deviceID),
+	})
+
+	log.Printf("Added device %s (publisher %s) to SFU room %s", deviceID, publisher.ID, roomID)
+
+	return publisher, nil
+}
+
+// RemoveRoomDevice 把设备移出房间，关闭所有参与者对它的订阅并重新协商
+func (m *Manager) RemoveRoomDevice(roomID, deviceID string) error {
+	room, err := m.GetRoom(roomID)
+	if err != nil {
+		return err
+	}
+
+	room.mu.Lock()
+	publisherID, ok := room.devices[deviceID]
+	delete(room.devices, deviceID)
+	room.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("device %s is not in room %s", deviceID, roomID)
+	}
+
+	for _, p := range room.getParticipants() {
+		if sub := p.subscription(publisherID); sub != nil {
+			m.CloseSubscriber(sub.ID)
+		}
+	}
+
+	m.broadcastRoomEvent(room, RoomEvent{
+		Type:        RoomEventDeviceRemoved,
+		DeviceID:    deviceID,
+		PublisherID: publisherID,
+		StreamID:    roomStreamID(deviceID),
+	})
+
+	log.Printf("Removed device %s from SFU room %s", deviceID, roomID)
+
+	return nil
+}
+
+// removeDeviceFromRooms 发布者关闭后把设备移出所有房间（订阅已随发布者关闭）
+func (m *Manager) removeDeviceFromRooms(publisher *PublisherSession) {
+	for _, room := range m.GetAllRooms() {
+		room.mu.Lock()
+		removed := room.devices[publisher.DeviceID] == publisher.ID
+		if removed {
+			delete(room.devices, publisher.DeviceID)
+		}
+		room.mu.Unlock()
+
+		if removed {
+			m.broadcastRoomEvent(room, RoomEvent{
+				Type:        RoomEventDeviceRemoved,
+				DeviceID:    publisher.DeviceID,
+				PublisherID: publisher.ID,
+				StreamID:    roomStreamID(publisher.DeviceID),
+			})
+		}
+	}
+}
+
+// JoinRoom 加入房间：创建参与者的 PeerConnection，订阅房间中的所有设备并返回初始 offer
+// 参与者的身份（用户、租户和权限）在加入房间前设置，添加设备时的观看权限校验只依赖身份
+func (m *Manager) JoinRoom(roomID, userID, tenantID string, permissions []string) (*RoomParticipant, *webrtc.SessionDescription, error) {
+	room, err := m.GetRoom(roomID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	participantID := uuid.New().String()
+
+	// 参与者的连接承载多个设备，RTCP 反馈只用于统计，关键帧请求和层选择由各订阅处理
+	collector := adaptive.NewRTCPCollector(participantID, m.feedbackLogger)
+	peerConnection, _, err := m.createPeerConnection(collector, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dataChannel, err := peerConnection.CreateDataChannel(RoomDataChannelLabel, nil)
+	if err != nil {
+		peerConnection.Close()
+		return nil, nil, fmt.Errorf("failed to create data channel: %w", err)
+	}
+
+	participant := &RoomParticipant{
+		ID:             participantID,
+		RoomID:         roomID,
+		UserID:         userID,
+		TenantID:       tenantID,
+		Permissions:    permissions,
+		PeerConnection: peerConnection,
+		DataChannel:    dataChannel,
+		JoinedAt:       time.Now(),
+		state:          StateNew,
+		subscriptions:  make(map[string]*SubscriberSession),
+	}
+	m.setupParticipantHandlers(participant)
+
+	m.roomsMu.Lock()
+	if _, ok := m.rooms[roomID]; !ok {
+		m.roomsMu.Unlock()
+		peerConnection.Close()
+		return nil, nil, fmt.Errorf("%w: %s", ErrRoomNotFound, roomID)
+	}
+	m.roomParticipants[participantID] = participant
+	room.mu.Lock()
+	room.participants[participantID] = participant
+	devices := make([]string, 0, len(room.devices))
+	for _, publisherID := range room.devices {
+		devices = append(devices, publisherID)
+	}
+	room.mu.Unlock()
+	m.roomsMu.Unlock()
+
+	for _, publisherID := range devices {
+		publisher, err := m.GetPublisher(publisherID)
+		if err != nil {
+			continue
+		}
+		if err := m.subscribeParticipant(participant, publisher); err != nil {
+			log.Printf("Failed to subscribe room participant %s to device %s: %v", participantID, publisher.DeviceID, err)
+		}
+	}
+
+	offer, err := m.createParticipantOffer(participant)
+	if err != nil {
+		m.LeaveRoom(participantID)
+		return nil, nil, err
+	}
+
+	m.broadcastRoomEvent(room, RoomEvent{
+		Type:          RoomEventParticipantJoined,
+		ParticipantID: participantID,
+		UserID:        userID,
+	})
+
+	log.Printf("Participant %s (user %s) joined SFU room %s with %d devices",
+		participantID, userID, roomID, len(devices))
+
+	return participant, offer, nil
+}
+
+// createParticipantOffer 创建参与者的初始 offer（等待 ICE 收集完成，通过 HTTP 返回）
+func (m *Manager) createParticipantOffer(p *RoomParticipant) (*webrtc.SessionDescription, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	offer, err := p.PeerConnection.CreateOffer(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create offer: %w", err)
+	}
+
+	// 等待 ICE gathering 完成
+	gatherComplete := webrtc.GatheringCompletePromise(p.PeerConnection)
+
+	if err = p.PeerConnection.SetLocalDescription(offer); err != nil {
+		return nil, fmt.Errorf("failed to set local description: %w", err)
+	}
+
+	select {
+	case <-gatherComplete:
+	case <-time.After(10 * time.Second):
+		log.Printf("ICE gathering timeout for room participant: %s", p.ID)
+	}
+
+	p.negotiating = true
+	p.state = StateConnecting
+
+	return p.PeerConnection.LocalDescription(), nil
+}
+
+// HandleRoomAnswer 处理参与者的 answer（初始 offer 或重新协商）
+func (m *Manager) HandleRoomAnswer(participantID string, answer webrtc.SessionDescription) error {
+	participant, err := m.GetRoomParticipant(participantID)
+	if err != nil {
+		return err
+	}
+	return participant.handleAnswer(answer)
+}
+
+// AddRoomICECandidate 添加参与者 ICE 候选
+func (m *Manager) AddRoomICECandidate(participantID string, candidate webrtc.ICECandidateInit) error {
+	participant, err := m.GetRoomParticipant(participantID)
+	if err != nil {
+		return err
+	}
+	return participant.PeerConnection.AddICECandidate(candidate)
+}
+
+// LeaveRoom 参与者离开房间：关闭所有订阅和连接
+func (m *Manager) LeaveRoom(participantID string) error {
+	m.roomsMu.Lock()
+	participant, ok := m.roomParticipants[participantID]
+	if !ok {
+		m.roomsMu.Unlock()
+		return fmt.Errorf("%w: %s", ErrParticipantNotFound, participantID)
+	}
+	delete(m.roomParticipants, participantID)
+	room := m.rooms[participant.RoomID]
+	m.roomsMu.Unlock()
+
+	participant.mu.Lock()
+	participant.closed = true
+	subs := make([]*SubscriberSession, 0, len(participant.subscriptions))
+	for _, sub := range participant.subscriptions {
+		subs = append(subs, sub)
+	}
+	participant.mu.Unlock()
+
+	for _, sub := range subs {
+		m.CloseSubscriber(sub.ID)
+	}
+	participant.PeerConnection.Close()
+	participant.updateState(StateClosed)
+	m.notifyConnectionClosed(participantID)
+
+	if room != nil {
+		room.mu.Lock()
+		delete(room.participants, participantID)
+		room.mu.Unlock()
+
+		m.broadcastRoomEvent(room, RoomEvent{
+			Type:          RoomEventParticipantLeft,
+			ParticipantID: participantID,
+			UserID:        participant.UserID,
+		})
+	}
+
+	log.Printf("Participant %s left SFU room %s", participantID, participant.RoomID)
+
+	return nil
+}
+
+// subscribeParticipant 为参与者创建对发布者的订阅，轨道加入参与者的连接（由调用方重新协商）
+func (m *Manager) subscribeParticipant(p *RoomParticipant, publisher *PublisherSession) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return fmt.Errorf("participant %s has left the room", p.ID)
+	}
+	if _, ok := p.subscriptions[publisher.ID]; ok {
+		return nil
+	}
+
+	subscriber := &SubscriberSession{
+		ID:             uuid.New().String(),
+		PublisherID:    publisher.ID,
+		DeviceID:       publisher.DeviceID,
+		UserID:         p.UserID,
+		TenantID:       p.TenantID,
+		PeerConnection: p.PeerConnection,
+		CreatedAt:      time.Now(),
+		LastActivityAt: time.Now(),
+		State:          p.state,
+		participant:    p,
+	}
+	if err := m.attachSubscriber(publisher, subscriber, fmt.Sprintf("video-%s", publisher.DeviceID)); err != nil {
+		return err
+	}
+	p.subscriptions[publisher.ID] = subscriber
+
+	log.Printf("Created SFU room subscription: %s for publisher: %s (participant: %s)",
+		subscriber.ID, publisher.ID, p.ID)
+
+	return nil
+}
+
+// broadcastRoomEvent 向房间的所有参与者发送事件
+func (m *Manager) broadcastRoomEvent(room *Room, event RoomEvent) {
+	for _, p := range room.getParticipants() {
+		p.sendEvent(event)
+	}
+}
+
+// setupParticipantHandlers 设置参与者事件处理器
+func (m *Manager) setupParticipantHandlers(p *RoomParticipant) {
+	p.PeerConnection.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		log.Printf("Room participant %s ICE state: %s", p.ID, state.String())
+
+		switch state {
+		case webrtc.ICEConnectionStateConnected:
+			p.updateState(StateConnected)
+		case webrtc.ICEConnectionStateFailed:
+			p.updateState(StateFailed)
+			go m.LeaveRoom(p.ID)
+		case webrtc.ICEConnectionStateDisconnected:
+			p.updateState(StateDisconnected)
+		}
+	})
+
+	// 数据通道打开后发送期间推迟的 offer
+	p.DataChannel.OnOpen(func() {
+		p.mu.Lock()
+		pending := p.pendingOffer
+		p.mu.Unlock()
+		if pending {
+			p.negotiate()
+		}
+	})
+
+	// 客户端在数据通道上回复重新协商的 answer
+	p.DataChannel.OnMessage(func(msg webrtc.DataChannelMessage) {
+		var event RoomEvent
+		if err := json.Unmarshal(msg.Data, &event); err != nil {
+			log.Printf("Invalid room event from participant %s: %v", p.ID, err)
+			return
+		}
+		if event.Type != RoomEventAnswer || event.SDP == nil {
+			return
+		}
+		if err := p.handleAnswer(*event.SDP); err != nil {
+			log.Printf("Failed to handle room answer from participant %s: %v", p.ID, err)
+		}
+	})
+}
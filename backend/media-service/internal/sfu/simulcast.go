@@ -0,0 +1,647 @@
+package sfu
+
+import (
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudphone/media-service/internal/encoder"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// SourceLayerRID 发布者的原始码流（设备采集或未使用 simulcast 的推流，不经转码），作为最高层
+const SourceLayerRID = "src"
+
+// 层选择参数
+const (
+	// layerDowngradeLoss 丢包率（EWMA）超过该值时降一层
+	layerDowngradeLoss = 0.10
+	// layerUpgradeLoss 丢包率低于该值且保持足够时间才尝试升一层
+	layerUpgradeLoss = 0.02
+	// layerBandwidthHeadroom 层码率不超过 REMB 估计带宽的该比例
+	layerBandwidthHeadroom = 0.85
+	// layerLossSmoothing 丢包率 EWMA 系数
+	layerLossSmoothing = 0.3
+
+	// layerDowngradeHold / layerUpgradeHold 两次切换之间的最短间隔（防止抖动）
+	layerDowngradeHold = 2 * time.Second
+	layerUpgradeHold   = 10 * time.Second
+
+	// sourceBitrateWindow 码流码率统计窗口
+	sourceBitrateWindow = time.Second
+)
+
+// layerOption 可供订阅者选择的层（按码率从高到低排列）
+type layerOption struct {
+	RID     string
+	Bitrate int
+	// BaseOnly 只转发该层的时间基础层（丢弃不被参考的帧），码率按一半估算
+	BaseOnly bool
+}
+
+// label 层的显示名称（用于日志）
+func (o layerOption) label() string {
+	if o.BaseOnly {
+		return o.RID + "/T0"
+	}
+	return o.RID
+}
+
+// bitrateMeter 按 sourceBitrateWindow 窗口统计码流码率（EWMA 平滑）
+type bitrateMeter struct {
+	windowStart time.Time
+	windowBytes int
+	bitrate     int
+}
+
+// record 统计 n 字节
+func (b *bitrateMeter) record(n int, now time.Time) {
+	if b.windowStart.IsZero() {
+		b.windowStart = now
+	}
+	b.windowBytes += n
+
+	if elapsed := now.Sub(b.windowStart); elapsed >= sourceBitrateWindow {
+		bitrate := int(float64(b.windowBytes*8) / elapsed.Seconds())
+		if b.bitrate == 0 {
+			b.bitrate = bitrate
+		} else {
+			b.bitrate = (b.bitrate*3 + bitrate) / 4
+		}
+		b.windowStart = now
+		b.windowBytes = 0
+	}
+}
+
+// publisherSimulcast 发布者的多层编码状态
+// 层来自服务端转码（设备 H.264 转码为 layers）或 WHIP 推流端的 simulcast（ingestRIDs）；
+// 两者都没有时只有原始码流一层，订阅者仍可丢弃 H.264 的时间增强层。
+// 转码器在第一个订阅者加入时启动，最后一个订阅者离开时停止
+type publisherSimulcast struct {
+	layers     []encoder.SimulcastLayer
+	ingestRIDs []string // 推流端 offer 中声明的 RID（按 offer 顺序）
+	logger     *logrus.Logger
+
+	mu         sync.Mutex
+	transcoder *encoder.SimulcastTranscoder
+	failed     bool // 转码器无法运行时所有订阅者回退到原始码流
+
+	// 原始码流和推流各 RID 的码率统计
+	source bitrateMeter
+	ingest map[string]*bitrateMeter
+}
+
+// newPublisherSimulcast 创建发布者转码状态（layers 为空时不转码）
+func newPublisherSimulcast(layers []encoder.SimulcastLayer, logger *logrus.Logger) *publisherSimulcast {
+	return &publisherSimulcast{
+		layers: layers,
+		logger: logger,
+	}
+}
+
+// newIngestSimulcast 创建推流端 simulcast 的发布者状态，各 RID 作为层（不转码）
+func newIngestSimulcast(rids []string) *publisherSimulcast {
+	return &publisherSimulcast{
+		ingestRIDs: rids,
+		ingest:     make(map[string]*bitrateMeter, len(rids)),
+	}
+}
+
+// transcoding 是否转码
+func (p *publisherSimulcast) transcoding() bool {
+	return len(p.layers) > 0
+}
+
+// recordSource 统计原始码流码率
+func (p *publisherSimulcast) recordSource(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.source.record(n, time.Now())
+}
+
+// recordIngest 统计推流某个 RID 的码率
+func (p *publisherSimulcast) recordIngest(rid string, n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	meter, ok := p.ingest[rid]
+	if !ok {
+		meter = &bitrateMeter{}
+		p.ingest[rid] = meter
+	}
+	meter.record(n, time.Now())
+}
+
+// options 返回当前可选的层，原始码流在最前
+// 推流端 simulcast 时返回已收到的 RID，按统计码率从高到低排列（尚未收到任何层时为空）
+func (p *publisherSimulcast) options() []layerOption {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.ingest != nil {
+		return p.ingestOptionsLocked()
+	}
+
+	sourceBitrate := p.source.bitrate
+	if sourceBitrate == 0 && len(p.layers) > 0 {
+		// 尚未统计到码率时假定原始码流高于最高转码层
+		sourceBitrate = p.layers[0].Bitrate * 2
+	}
+
+	opts := []layerOption{{RID: SourceLayerRID, Bitrate: sourceBitrate}}
+	if p.failed {
+		return opts
+	}
+	for _, layer := range p.layers {
+		opts = append(opts, layerOption{RID: layer.RID, Bitrate: layer.Bitrate})
+	}
+	return opts
+}
+
+// ingestOptionsLocked 推流端 simulcast 的可选层（调用方持有 p.mu）
+// 未声明的 RID 排在声明的 RID 之后；码率相同时保持 offer 顺序
+func (p *publisherSimulcast) ingestOptionsLocked() []layerOption {
+	rids := append([]string(nil), p.ingestRIDs...)
+	for rid := range p.ingest {
+		if !slices.Contains(rids, rid) {
+			rids = append(rids, rid)
+		}
+	}
+
+	opts := make([]layerOption, 0, len(p.ingest))
+	for _, rid := range rids {
+		if meter, ok := p.ingest[rid]; ok {
+			opts = append(opts, layerOption{RID: rid, Bitrate: meter.bitrate})
+		}
+	}
+	sort.SliceStable(opts, func(i, j int) bool {
+		return opts[i].Bitrate > opts[j].Bitrate
+	})
+	return opts
+}
+
+// rids 返回所有层标识（用于 API 响应），只有原始码流一层时为空
+func (p *publisherSimulcast) rids() []string {
+	if p.ingest != nil {
+		return append([]string(nil), p.ingestRIDs...)
+	}
+	if !p.transcoding() {
+		return nil
+	}
+
+	rids := []string{SourceLayerRID}
+	for _, layer := range p.layers {
+		rids = append(rids, layer.RID)
+	}
+	return rids
+}
+
+// initialLayer 新订阅者的初始层：最高的转码层或码率最高的推流层，再根据反馈升降
+// 推流层尚未统计到码率时返回空，订阅者从第一个到达的关键帧所在层开始
+func (p *publisherSimulcast) initialLayer() string {
+	if p.ingest != nil {
+		p.mu.Lock()
+		opts := p.ingestOptionsLocked()
+		p.mu.Unlock()
+
+		if len(opts) == 0 || opts[0].Bitrate == 0 {
+			return ""
+		}
+		return opts[0].RID
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.failed || len(p.layers) == 0 {
+		return SourceLayerRID
+	}
+	return p.layers[0].RID
+}
+
+// activeTranscoder 返回正在运行的转码器
+func (p *publisherSimulcast) activeTranscoder() *encoder.SimulcastTranscoder {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failed {
+		return nil
+	}
+	return p.transcoder
+}
+
+// markFailed 标记转码失败，返回是否为首次标记
+func (p *publisherSimulcast) markFailed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failed {
+		return false
+	}
+	p.failed = true
+	return true
+}
+
+// stopTranscoder 停止转码器，下一个订阅者加入时重新尝试转码
+func (p *publisherSimulcast) stopTranscoder() {
+	p.mu.Lock()
+	transcoder := p.transcoder
+	p.transcoder = nil
+	p.failed = false
+	p.mu.Unlock()
+
+	if transcoder != nil {
+		transcoder.Close()
+	}
+}
+
+// layerSelector 订阅者的层选择状态
+// 订阅者拥有独立的视频轨道，当前层的 RTP 包经转发器改写后写入该轨道；
+// 切换目标层后在目标层的下一个关键帧处生效，RTP 序号和时间戳保持连续。
+// 还可以只转发时间基础层：丢弃的帧只跳过序号，恢复在下一个基础层帧处生效。
+// 暂停时不转发任何包，恢复后从目标层的下一个关键帧开始。
+type layerSelector struct {
+	mu         sync.Mutex
+	forwarder  *rtpForwarder
+	current    string // 正在转发的层（切换完成前和暂停时为空）
+	target     string // 期望的层（为空时采用第一个到达关键帧的层）
+	baseOnly   bool   // 期望只转发时间基础层
+	dropping   bool   // 正在丢弃时间增强层
+	temporal   bool   // 当前层出现过时间增强层的帧
+	paused     bool
+	loss       float64
+	estimate   uint64 // REMB 估计带宽 (bps)，0 表示未收到
+	lastSwitch time.Time
+	switches   uint64
+	dropped    uint64
+}
+
+// newLayerSelector 创建层选择器
+func newLayerSelector(forwarder *rtpForwarder, initial string) *layerSelector {
+	return &layerSelector{
+		forwarder:  forwarder,
+		target:     initial,
+		lastSwitch: time.Now(),
+	}
+}
+
+// forward 转发某一层的帧，只有当前层的帧会写入订阅者轨道
+func (s *layerSelector) forward(rid string, frame layerFrame) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.paused {
+		return nil
+	}
+	if s.target == "" && frame.keyframe {
+		s.target = rid
+	}
+	if rid == s.target && rid != s.current && frame.keyframe {
+		s.current = rid
+		s.lastSwitch = time.Now()
+		s.loss = 0
+		s.switches++
+		s.temporal = false
+		s.forwarder.switchSource()
+	}
+	if rid != s.current {
+		return nil
+	}
+
+	if frame.temporalLayer > 0 {
+		s.temporal = true
+	}
+	switch {
+	case s.baseOnly:
+		s.dropping = true
+	case s.dropping && frame.temporalLayer == 0:
+		s.dropping = false
+	}
+	if s.dropping && frame.temporalLayer > 0 {
+		s.forwarder.skip(len(frame.packets))
+		s.dropped++
+		return nil
+	}
+
+	return s.forwarder.write(frame.packets)
+}
+
+// pause 暂停转发
+func (s *layerSelector) pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = true
+	s.current = ""
+}
+
+// resume 恢复转发，返回需要关键帧的层（目标层尚未确定时为空）
+func (s *layerSelector) resume() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = false
+	return s.target
+}
+
+// isPaused 是否已暂停
+func (s *layerSelector) isPaused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+// keyframeLayer 订阅者请求关键帧时对应的层：切换中为目标层，否则为当前层
+func (s *layerSelector) keyframeLayer() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.target != "" {
+		return s.target
+	}
+	return s.current
+}
+
+// setTarget 设置目标层（转发该层的全部时间层）
+func (s *layerSelector) setTarget(rid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.target = rid
+	s.baseOnly = false
+}
+
+// currentLayer 返回正在转发的层，以及是否只转发时间基础层
+func (s *layerSelector) currentLayer() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current, s.dropping
+}
+
+// onFeedback 根据 RTCP 反馈更新统计并选择目标层
+// 当前层出现过时间增强层时，最低层之下还有一个只转发时间基础层的选项。
+// 返回新的目标层；不需要切换时 ok 为 false
+func (s *layerSelector) onFeedback(packets []rtcp.Packet, options []layerOption, scope feedbackScope) (target layerOption, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, packet := range packets {
+		switch p := packet.(type) {
+		case *rtcp.ReceiverReport:
+			for _, report := range p.Reports {
+				if scope.ssrc != 0 && report.SSRC != scope.ssrc {
+					continue
+				}
+				fraction := float64(report.FractionLost) / 256.0
+				s.loss = s.loss*(1-layerLossSmoothing) + fraction*layerLossSmoothing
+			}
+		case *rtcp.ReceiverEstimatedMaximumBitrate:
+			s.estimate = uint64(p.Bitrate) / uint64(max(scope.share, 1))
+		}
+	}
+
+	if s.temporal {
+		lowest := options[len(options)-1]
+		options = append(options[:len(options):len(options)], layerOption{
+			RID:      lowest.RID,
+			Bitrate:  lowest.Bitrate / 2,
+			BaseOnly: true,
+		})
+	}
+
+	// 目标层不可用（如转码失败）时直接回退到可用的最高层
+	index := -1
+	for i, opt := range options {
+		if opt.RID == s.target && opt.BaseOnly == s.baseOnly {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		s.target = options[0].RID
+		s.baseOnly = false
+		return options[0], true
+	}
+
+	now := time.Now()
+	next := index
+
+	switch {
+	case s.loss > layerDowngradeLoss:
+		next = index + 1
+	case s.estimate > 0 && float64(options[index].Bitrate) > float64(s.estimate)*layerBandwidthHeadroom:
+		// 直接降到带宽能承受的最高层
+		next = len(options) - 1
+		for i := index + 1; i < len(options); i++ {
+			if float64(options[i].Bitrate) <= float64(s.estimate)*layerBandwidthHeadroom {
+				next = i
+				break
+			}
+		}
+	case s.loss < layerUpgradeLoss && index > 0:
+		if s.estimate == 0 || float64(options[index-1].Bitrate) <= float64(s.estimate)*layerBandwidthHeadroom {
+			next = index - 1
+		}
+	}
+
+	if next >= len(options) {
+		next = len(options) - 1
+	}
+	if next == index {
+		return layerOption{}, false
+	}
+
+	hold := layerDowngradeHold
+	if next < index {
+		hold = layerUpgradeHold
+	}
+	if now.Sub(s.lastSwitch) < hold {
+		return layerOption{}, false
+	}
+
+	s.target = options[next].RID
+	s.baseOnly = options[next].BaseOnly
+	// 切换完成前不再重复判断
+	s.lastSwitch = now
+	return options[next], true
+}
+
+// readSubscriberRTCP 读取订阅者的 RTCP 反馈，据此为该订阅者选择层
+// 切换到其他层时请求该层的关键帧（切换在关键帧处生效）
+func (m *Manager) readSubscriberRTCP(publisher *PublisherSession, sub *SubscriberSession, rtpSender *webrtc.RTPSender) {
+	var ssrc uint32
+	if encodings := rtpSender.GetParameters().Encodings; len(encodings) > 0 {
+		ssrc = uint32(encodings[0].SSRC)
+	}
+
+	for {
+		packets, _, err := rtpSender.ReadRTCP()
+		if err != nil {
+			return
+		}
+
+		scope := feedbackScope{ssrc: ssrc, share: 1}
+		if sub.participant != nil {
+			// 房间的 PeerConnection 共用一个收集器，关键帧请求按 SSRC 在这里分发到各自的发布者
+			scope.share = sub.participant.subscriptionCount()
+			if requestsKeyframe(packets, ssrc) {
+				m.requestLayerKeyframe(publisher, sub.layer.keyframeLayer())
+			}
+		}
+
+		options := publisher.simulcast.options()
+		if len(options) == 0 {
+			// 推流层尚未到达
+			continue
+		}
+
+		target, ok := sub.layer.onFeedback(packets, options, scope)
+		if !ok {
+			continue
+		}
+		log.Printf("Subscriber %s switching to layer %s", sub.ID, target.label())
+		if current, _ := sub.layer.currentLayer(); target.RID != current && !sub.layer.isPaused() {
+			m.requestLayerKeyframe(publisher, target.RID)
+		}
+	}
+}
+
+// feedbackScope 订阅者 RTCP 反馈的范围
+// 房间的观看者在一个 PeerConnection 上接收多个设备，复合 RTCP 包会送到每个发送端：
+// 接收端报告只统计该订阅者的 SSRC，REMB 估计的是整个连接的带宽，按订阅数量平分
+type feedbackScope struct {
+	ssrc  uint32 // 为 0 时统计所有报告
+	share int    // 共享连接带宽的订阅数量
+}
+
+// requestsKeyframe RTCP 包中是否有针对 ssrc 的 PLI/FIR
+func requestsKeyframe(packets []rtcp.Packet, ssrc uint32) bool {
+	for _, packet := range packets {
+		switch p := packet.(type) {
+		case *rtcp.PictureLossIndication:
+			if p.MediaSSRC == ssrc {
+				return true
+			}
+		case *rtcp.FullIntraRequest:
+			for _, entry := range p.FIR {
+				if entry.SSRC == ssrc {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// requestLayerKeyframe 请求某一层的关键帧（经过发布者共享的冷却时间）
+func (m *Manager) requestLayerKeyframe(publisher *PublisherSession, rid string) {
+	if err := publisher.keyframes.request(rid); err != nil {
+		log.Printf("Failed to request keyframe for publisher %s: %v", publisher.ID, err)
+	}
+}
+
+// publisherKeyframeRequester 发布者的关键帧请求函数
+// 推流时向推流端发送该层的 PLI（层未知时请求所有层）；原始码流请求设备关键帧；转码层按转码器的关键帧间隔输出
+func (m *Manager) publisherKeyframeRequester(publisher *PublisherSession) func(rid string) error {
+	return func(rid string) error {
+		switch {
+		case publisher.ingest != nil:
+			publisher.ingest.requestKeyframe(rid)
+		case (rid == SourceLayerRID || rid == "") && m.feedback != nil:
+			return m.feedback.RequestKeyframe(publisher.ID)
+		}
+		return nil
+	}
+}
+
+// ensureTranscoder 第一个订阅者加入时启动转码器
+func (m *Manager) ensureTranscoder(publisher *PublisherSession) {
+	sim := publisher.simulcast
+	if !sim.transcoding() {
+		return
+	}
+
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	if sim.transcoder != nil || sim.failed {
+		return
+	}
+
+	transcoder, err := encoder.NewSimulcastTranscoder(encoder.SimulcastTranscoderOptions{
+		Layers: sim.layers,
+		OnSample: func(layer encoder.SimulcastLayer, au []byte, duration time.Duration, keyframe bool) {
+			m.forwardLayer(publisher, layer.RID, au, duration, keyframe)
+		},
+		Logger: sim.logger,
+	})
+	if err == nil {
+		err = transcoder.Start()
+	}
+	if err != nil {
+		// 进程数达到上限等情况下不转码，订阅者使用原始码流
+		log.Printf("Simulcast transcoding unavailable for publisher %s: %v", publisher.ID, err)
+		sim.failed = true
+		return
+	}
+
+	sim.transcoder = transcoder
+	log.Printf("Simulcast transcoder started for publisher %s", publisher.ID)
+}
+
+// releaseTranscoder 最后一个订阅者离开时停止转码器
+func (m *Manager) releaseTranscoder(publisher *PublisherSession) {
+	if !publisher.simulcast.transcoding() || publisher.GetSubscriberCount() > 0 {
+		return
+	}
+	publisher.simulcast.stopTranscoder()
+}
+
+// forwardLayer 将某一层的帧打包后分发给订阅者
+func (m *Manager) forwardLayer(publisher *PublisherSession, rid string, data []byte, duration time.Duration, keyframe bool) {
+	subscribers := publisher.GetSubscribers()
+	if len(subscribers) == 0 {
+		return
+	}
+
+	frame := layerFrame{
+		packets:  publisher.packetizers.packetize(rid, data, duration),
+		keyframe: keyframe,
+	}
+	if strings.EqualFold(publisher.packetizers.mimeType, webrtc.MimeTypeH264) {
+		frame.temporalLayer = encoder.H264TemporalLayer(data)
+	}
+
+	for _, sub := range subscribers {
+		if err := sub.layer.forward(rid, frame); err != nil {
+			log.Printf("Failed to forward layer %s to subscriber %s: %v", rid, sub.ID, err)
+		}
+	}
+}
+
+// writeSourceFrame 分发原始码流并送入转码器
+func (m *Manager) writeSourceFrame(publisher *PublisherSession, frame []byte, duration time.Duration) {
+	sim := publisher.simulcast
+	sim.recordSource(len(frame))
+
+	m.forwardLayer(publisher, SourceLayerRID, frame, duration, isKeyframe(publisher.packetizers.mimeType, frame))
+
+	transcoder := sim.activeTranscoder()
+	if transcoder == nil {
+		return
+	}
+	if err := transcoder.Write(frame); err != nil && sim.markFailed() {
+		log.Printf("Simulcast transcoding failed for publisher %s, falling back to source: %v", publisher.ID, err)
+		for _, sub := range publisher.GetSubscribers() {
+			sub.layer.setTarget(SourceLayerRID)
+		}
+	}
+}
+
+// isKeyframe 判断帧是否为关键帧（H.264 Annex-B 访问单元或 VP8 帧）
+func isKeyframe(mimeType string, frame []byte) bool {
+	if strings.EqualFold(mimeType, webrtc.MimeTypeH264) {
+		return encoder.IsH264Keyframe(frame)
+	}
+	// VP8 帧标签第 1 位为 0 表示关键帧
+	return len(frame) > 0 && frame[0]&0x01 == 0
+}
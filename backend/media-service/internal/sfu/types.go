@@ -23,6 +23,7 @@ type PublisherSession struct {
 	ID             string
 	DeviceID       string
 	UserID         string
+	TenantID       string // 租户 ID（来自 JWT，用于租户级配置）
 	PeerConnection *webrtc.PeerConnection
 	VideoTrack     *webrtc.TrackLocalStaticSample
 	AudioTrack     *webrtc.TrackLocalStaticSample
@@ -31,22 +32,36 @@ type PublisherSession struct {
 	LastActivityAt time.Time
 	State          SessionState
 	subscribers    map[string]*SubscriberSession
+	simulcast      *publisherSimulcast // 可供订阅者选择的层（转码层、推流 simulcast 或只有原始码流）
+	ingest         *publisherIngest    // WHIP 推流或级联中继时非 nil（外部编码器替代设备采集）
+	relay          *publisherRelay     // 从其他实例中继时非 nil
+	packetizers    *layerPacketizers   // 各层的 RTP 打包器（订阅者共享打包结果）
+	keyframes      *keyframeGate       // 关键帧请求（订阅者之间共享冷却时间）
+	control        publisherControl    // 订阅者的临时控制权和控制记录
+	lifecycle      publisherLifecycle  // 设备采集状态和空闲计时
+	events         eventQueue          // 生命周期和控制记录事件（按顺序异步分发）
 	mu             sync.RWMutex
 }
 
 // SubscriberSession 订阅者会话
 // 订阅某个 Publisher 的视频流，只读不写
 type SubscriberSession struct {
-	ID              string
-	PublisherID     string // 关联的发布者 ID
-	DeviceID        string // 观看的设备 ID
-	UserID          string // 观看者用户 ID
-	PeerConnection  *webrtc.PeerConnection
-	RTPSender       *webrtc.RTPSender // 用于发送视频
-	CreatedAt       time.Time
-	LastActivityAt  time.Time
-	State           SessionState
-	mu              sync.RWMutex
+	ID             string
+	PublisherID    string // 关联的发布者 ID
+	DeviceID       string // 观看的设备 ID
+	UserID         string // 观看者用户 ID
+	TenantID       string // 观看者租户 ID（来自 JWT，用于归属校验）
+	PeerConnection *webrtc.PeerConnection
+	RTPSender      *webrtc.RTPSender           // 用于发送视频
+	VideoTrack     *webrtc.TrackLocalStaticRTP // 订阅者独立的视频轨道（转发所选层的 RTP 包）
+	DataChannel    *webrtc.DataChannel         // 控制数据通道（创建时请求才有，获得控制权后可以注入输入）
+	CreatedAt      time.Time
+	LastActivityAt time.Time
+	State          SessionState
+	layer          *layerSelector    // 按订阅者的 RTCP 反馈选择层
+	participant    *RoomParticipant  // 房间订阅时非 nil（共用参与者的 PeerConnection）
+	control        *publisherControl // 发布者的控制权状态
+	mu             sync.RWMutex
 }
 
 // SessionState 会话状态
@@ -68,17 +83,28 @@ type PublisherInfo struct {
 	UserID          string    `json:"userId"`
 	State           string    `json:"state"`
 	SubscriberCount int       `json:"subscriberCount"`
+	SimulcastLayers []string  `json:"simulcastLayers,omitempty"` // 转码或推流 simulcast 时可选的层
+	Ingest          bool      `json:"ingest,omitempty"`          // 是否为 WHIP 推流
+	Controller      string    `json:"controller,omitempty"`      // 持有临时控制权的订阅者
+	RelayedFrom     string    `json:"relayedFrom,omitempty"`     // 级联中继时源实例 ID
+	Capture         string    `json:"capture,omitempty"`         // 设备采集状态：idle / running / paused
 	CreatedAt       time.Time `json:"createdAt"`
 }
 
 // SubscriberInfo 订阅者信息（用于 API 响应）
 type SubscriberInfo struct {
-	ID          string    `json:"id"`
-	PublisherID string    `json:"publisherId"`
-	DeviceID    string    `json:"deviceId"`
-	UserID      string    `json:"userId"`
-	State       string    `json:"state"`
-	CreatedAt   time.Time `json:"createdAt"`
+	ID               string     `json:"id"`
+	PublisherID      string     `json:"publisherId"`
+	DeviceID         string     `json:"deviceId"`
+	UserID           string     `json:"userId"`
+	State            string     `json:"state"`
+	Layer            string     `json:"layer,omitempty"`            // 当前接收的层
+	TemporalBaseOnly bool       `json:"temporalBaseOnly,omitempty"` // 只接收时间基础层（丢弃不被参考的帧）
+	Paused           bool       `json:"paused,omitempty"`           // 视频转发已暂停
+	RoomID           string     `json:"roomId,omitempty"`           // 房间订阅所在的房间
+	DataChannel      bool       `json:"dataChannel,omitempty"`      // 有控制数据通道
+	ControlExpiresAt *time.Time `json:"controlExpiresAt,omitempty"` // 持有临时控制权时的到期时间
+	CreatedAt        time.Time  `json:"createdAt"`
 }
 
 // AddSubscriber 添加订阅者
@@ -109,6 +135,39 @@ func (p *PublisherSession) GetSubscribers() []*SubscriberSession {
 	return subs
 }
 
+// IsIngest 是否为 WHIP 推流的发布者
+func (p *PublisherSession) IsIngest() bool {
+	return p.ingest != nil
+}
+
+// IsRelay 是否为从其他实例中继的发布者
+func (p *PublisherSession) IsRelay() bool {
+	return p.relay != nil
+}
+
+// source 视频来源
+func (p *PublisherSession) source() string {
+	switch {
+	case p.relay != nil:
+		return PublisherSourceRelay
+	case p.ingest != nil:
+		return PublisherSourceWHIP
+	default:
+		return PublisherSourceDevice
+	}
+}
+
+// record 登记到发布者目录的信息
+func (p *PublisherSession) record() PublisherRecord {
+	return PublisherRecord{
+		DeviceID:    p.DeviceID,
+		PublisherID: p.ID,
+		UserID:      p.UserID,
+		TenantID:    p.TenantID,
+		VideoCodec:  p.VideoTrack.Codec().MimeType,
+	}
+}
+
 // GetSubscriberCount 获取订阅者数量
 func (p *PublisherSession) GetSubscriberCount() int {
 	p.mu.RLock()
@@ -148,7 +207,7 @@ func (s *SubscriberSession) GetState() SessionState {
 
 // ToInfo 转换为 API 响应格式
 func (p *PublisherSession) ToInfo() PublisherInfo {
-	return PublisherInfo{
+	info := PublisherInfo{
 		ID:              p.ID,
 		DeviceID:        p.DeviceID,
 		UserID:          p.UserID,
@@ -156,11 +215,21 @@ func (p *PublisherSession) ToInfo() PublisherInfo {
 		SubscriberCount: p.GetSubscriberCount(),
 		CreatedAt:       p.CreatedAt,
 	}
+	info.SimulcastLayers = p.simulcast.rids()
+	info.Ingest = p.IsIngest()
+	info.Controller, _ = p.control.controller()
+	if p.relay != nil {
+		info.RelayedFrom = p.relay.origin.InstanceID
+	}
+	if p.ingest == nil {
+		info.Capture = p.lifecycle.captureState().String()
+	}
+	return info
 }
 
 // ToInfo 转换为 API 响应格式
 func (s *SubscriberSession) ToInfo() SubscriberInfo {
-	return SubscriberInfo{
+	info := SubscriberInfo{
 		ID:          s.ID,
 		PublisherID: s.PublisherID,
 		DeviceID:    s.DeviceID,
@@ -168,4 +237,14 @@ func (s *SubscriberSession) ToInfo() SubscriberInfo {
 		State:       string(s.GetState()),
 		CreatedAt:   s.CreatedAt,
 	}
+	info.Layer, info.TemporalBaseOnly = s.layer.currentLayer()
+	info.Paused = s.layer.isPaused()
+	if s.participant != nil {
+		info.RoomID = s.participant.RoomID
+	}
+	info.DataChannel = s.DataChannel != nil
+	if controller, expiresAt := s.control.controller(); controller == s.ID {
+		info.ControlExpiresAt = &expiresAt
+	}
+	return info
 }
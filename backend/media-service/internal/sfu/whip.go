@@ -0,0 +1,405 @@
+package sfu
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media/samplebuilder"
+)
+
+var (
+	// ErrDeviceHasPublisher 设备已有发布者（WHIP 推流不能与设备采集或另一个推流共存）
+	ErrDeviceHasPublisher = errors.New("device already has a publisher")
+	// ErrPublisherIngest 发布者由 WHIP 推流，不能作为设备采集的发布者复用
+	ErrPublisherIngest = errors.New("device is published via WHIP ingest")
+	// ErrUnsupportedOffer offer 中没有支持的视频编码
+	ErrUnsupportedOffer = errors.New("offer has no supported video codec")
+)
+
+const (
+	// iceGatheringTimeout 等待 ICE gathering 完成的最长时间（与 CreatePublisherOffer 一致）
+	iceGatheringTimeout = 10 * time.Second
+
+	// ingestMaxLate 重组帧时最多缓存的 RTP 包数
+	ingestMaxLate = 512
+	// ingestKeyframeInterval 两次向编码器请求关键帧的最短间隔
+	ingestKeyframeInterval = 500 * time.Millisecond
+	// ingestActivityInterval 推流期间刷新发布者活跃时间的间隔（避免被不活跃清理）
+	ingestActivityInterval = 10 * time.Second
+)
+
+// publisherIngest WHIP 推流状态
+// 外部编码器通过 PeerConnection 推送 RTP，重组为帧后经 WriteVideoFrame 分发给订阅者；
+// 推流端使用 simulcast 时每个 RID 是一个层，由订阅者的层选择器直接分发
+type publisherIngest struct {
+	pc *webrtc.PeerConnection
+
+	mu      sync.Mutex
+	ssrcs   map[string]uint32 // RID -> 视频轨道 SSRC（不使用 simulcast 时 RID 为空）
+	lastPLI map[uint32]time.Time
+}
+
+// newPublisherIngest 创建推流状态
+func newPublisherIngest(pc *webrtc.PeerConnection) *publisherIngest {
+	return &publisherIngest{
+		pc:      pc,
+		ssrcs:   make(map[string]uint32),
+		lastPLI: make(map[uint32]time.Time),
+	}
+}
+
+// setSSRC 记录视频轨道 SSRC
+func (i *publisherIngest) setSSRC(rid string, ssrc uint32) {
+	i.mu.Lock()
+	i.ssrcs[rid] = ssrc
+	i.mu.Unlock()
+}
+
+// requestKeyframe 向编码器发送 PLI（新订阅者加入、转码器启动或订阅者切换层时需要关键帧）
+// rid 为推流的某一层时只请求该层，否则请求所有层
+func (i *publisherIngest) requestKeyframe(rid string) {
+	i.mu.Lock()
+	var ssrcs []uint32
+	if ssrc, ok := i.ssrcs[rid]; ok {
+		ssrcs = append(ssrcs, ssrc)
+	} else {
+		for _, ssrc := range i.ssrcs {
+			ssrcs = append(ssrcs, ssrc)
+		}
+	}
+
+	now := time.Now()
+	var packets []rtcp.Packet
+	for _, ssrc := range ssrcs {
+		if now.Sub(i.lastPLI[ssrc]) < ingestKeyframeInterval {
+			continue
+		}
+		i.lastPLI[ssrc] = now
+		packets = append(packets, &rtcp.PictureLossIndication{MediaSSRC: ssrc})
+	}
+	i.mu.Unlock()
+
+	if len(packets) == 0 {
+		return
+	}
+	if err := i.pc.WriteRTCP(packets); err != nil {
+		log.Printf("Failed to send PLI to ingest encoder: %v", err)
+	}
+}
+
+// offerSimulcastRIDs 返回 offer 视频段中推流端发送的 RID（a=rid:<id> send），不使用 simulcast 时为空
+func offerSimulcastRIDs(offer webrtc.SessionDescription) []string {
+	parsed, err := offer.Unmarshal()
+	if err != nil {
+		return nil
+	}
+
+	var rids []string
+	for _, media := range parsed.MediaDescriptions {
+		if media.MediaName.Media != "video" {
+			continue
+		}
+		for _, attr := range media.Attributes {
+			if attr.Key != "rid" {
+				continue
+			}
+			if fields := strings.Fields(attr.Value); len(fields) >= 2 && fields[1] == "send" {
+				rids = append(rids, fields[0])
+			}
+		}
+		// 推流只使用第一个视频段
+		break
+	}
+	return rids
+}
+
+// ingestVideoCodec 按服务端优先级选择 offer 中第一个支持的视频编码
+// 返回的编码使用 offer 中的 payload type，answer 必须沿用对端的 payload type
+func ingestVideoCodec(offer webrtc.SessionDescription) (webrtc.RTPCodecParameters, error) {
+	parsed, err := offer.Unmarshal()
+	if err != nil {
+		return webrtc.RTPCodecParameters{}, fmt.Errorf("invalid offer: %w", err)
+	}
+
+	for _, codec := range videoCodecs {
+		for _, media := range parsed.MediaDescriptions {
+			if media.MediaName.Media != "video" {
+				continue
+			}
+			for _, format := range media.MediaName.Formats {
+				pt, err := strconv.ParseUint(format, 10, 8)
+				if err != nil {
+					continue
+				}
+				rtpCodec, err := parsed.GetCodecForPayloadType(uint8(pt))
+				if err != nil || !strings.EqualFold("video/"+rtpCodec.Name, codec.MimeType) {
+					continue
+				}
+				if codec.MimeType == webrtc.MimeTypeH264 && !h264FmtpCompatible(rtpCodec.Fmtp, codec.SDPFmtpLine) {
+					continue
+				}
+				codec.PayloadType = webrtc.PayloadType(pt)
+				return codec, nil
+			}
+		}
+	}
+	return webrtc.RTPCodecParameters{}, ErrUnsupportedOffer
+}
+
+// h264FmtpCompatible 判断 H.264 fmtp 是否兼容：packetization-mode 相同，
+// profile-level-id 的 profile_idc 和 profile-iop 相同（level 不影响解码）
+func h264FmtpCompatible(offered, local string) bool {
+	parse := func(fmtp string) map[string]string {
+		params := make(map[string]string)
+		for _, param := range strings.Split(fmtp, ";") {
+			if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 {
+				params[strings.ToLower(kv[0])] = strings.ToLower(kv[1])
+			}
+		}
+		return params
+	}
+	a, b := parse(offered), parse(local)
+
+	modeA, modeB := a["packetization-mode"], b["packetization-mode"]
+	if modeA == "" {
+		modeA = "0"
+	}
+	if modeB == "" {
+		modeB = "0"
+	}
+	if modeA != modeB {
+		return false
+	}
+
+	profileA, profileB := a["profile-level-id"], b["profile-level-id"]
+	return len(profileA) == 6 && len(profileB) == 6 && profileA[:4] == profileB[:4]
+}
+
+// answerOffer 设置远端 offer 并返回包含全部候选的 answer
+// WHIP/WHEP 客户端通常不支持服务端 trickle，因此等待 gathering 完成
+func answerOffer(pc *webrtc.PeerConnection, offer webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
+	if err := pc.SetRemoteDescription(offer); err != nil {
+		return nil, fmt.Errorf("failed to set remote description: %w", err)
+	}
+
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create answer: %w", err)
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return nil, fmt.Errorf("failed to set local description: %w", err)
+	}
+
+	select {
+	case <-gatherComplete:
+	case <-time.After(iceGatheringTimeout):
+		log.Printf("ICE gathering timeout while answering offer")
+	}
+
+	return pc.LocalDescription(), nil
+}
+
+// HandleSubscriberOffer 处理订阅者发起的 offer（WHEP）
+// 与 CreateSubscriberOffer 相反，由客户端提供 offer，服务端返回 answer
+func (m *Manager) HandleSubscriberOffer(subscriberID string, offer webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
+	subscriber, err := m.GetSubscriber(subscriberID)
+	if err != nil {
+		return nil, err
+	}
+
+	answer, err := answerOffer(subscriber.PeerConnection, offer)
+	if err != nil {
+		return nil, err
+	}
+
+	subscriber.UpdateState(StateConnecting)
+
+	return answer, nil
+}
+
+// CreateIngestPublisher 创建由外部编码器推流的发布者（WHIP）
+// 推流替代设备采集：订阅者通过设备 ID 或发布者 ID 订阅，与采集发布者没有区别
+func (m *Manager) CreateIngestPublisher(deviceID, userID string, offer webrtc.SessionDescription) (*PublisherSession, *webrtc.SessionDescription, error) {
+	if _, err := m.GetPublisherByDevice(deviceID); err == nil {
+		return nil, nil, ErrDeviceHasPublisher
+	}
+
+	codec, err := ingestVideoCodec(offer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	publisherID := uuid.New().String()
+	shard := m.getShard(publisherID)
+
+	peerConnection, _, err := m.newPeerConnection("")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 只接收视频，编码固定为选定的编码以便与发布者轨道一致
+	transceiver, err := peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionRecvonly,
+	})
+	if err != nil {
+		peerConnection.Close()
+		return nil, nil, fmt.Errorf("failed to add video transceiver: %w", err)
+	}
+	if err := transceiver.SetCodecPreferences([]webrtc.RTPCodecParameters{codec}); err != nil {
+		peerConnection.Close()
+		return nil, nil, fmt.Errorf("failed to set codec preferences: %w", err)
+	}
+
+	publisher, err := m.newIngestPublisher(publisherID, deviceID, userID, peerConnection, codec, offerSimulcastRIDs(offer))
+	if err != nil {
+		peerConnection.Close()
+		return nil, nil, err
+	}
+
+	m.setupPublisherHandlers(publisher)
+	peerConnection.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		m.readIngestTrack(publisher, track)
+	})
+
+	answer, err := answerOffer(peerConnection, offer)
+	if err != nil {
+		peerConnection.Close()
+		return nil, nil, err
+	}
+
+	// 等待 gathering 期间可能有其他发布者占用了该设备
+	m.deviceMu.Lock()
+	if _, exists := m.devicePublishers[deviceID]; exists {
+		m.deviceMu.Unlock()
+		peerConnection.Close()
+		return nil, nil, ErrDeviceHasPublisher
+	}
+	m.devicePublishers[deviceID] = publisherID
+	m.deviceMu.Unlock()
+
+	shard.mu.Lock()
+	shard.publishers[publisherID] = publisher
+	shard.mu.Unlock()
+
+	publisher.UpdateState(StateConnecting)
+
+	log.Printf("Created SFU ingest publisher: %s for device: %s (codec: %s)", publisherID, deviceID, codec.MimeType)
+
+	return publisher, answer, nil
+}
+
+// newIngestPublisher 创建接收 RTP 的发布者会话（WHIP 推流和级联中继）
+// rids 为推流端发送的 simulcast 层，为空时按配置转码
+func (m *Manager) newIngestPublisher(publisherID, deviceID, userID string, pc *webrtc.PeerConnection, codec webrtc.RTPCodecParameters, rids []string) (*PublisherSession, error) {
+	videoTrack, err := webrtc.NewTrackLocalStaticSample(
+		webrtc.RTPCodecCapability{MimeType: codec.MimeType},
+		"video",
+		fmt.Sprintf("cloudphone-sfu-%s", deviceID),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create video track: %w", err)
+	}
+
+	publisher := &PublisherSession{
+		ID:             publisherID,
+		DeviceID:       deviceID,
+		UserID:         userID,
+		PeerConnection: pc,
+		VideoTrack:     videoTrack,
+		CreatedAt:      time.Now(),
+		LastActivityAt: time.Now(),
+		State:          StateNew,
+		subscribers:    make(map[string]*SubscriberSession),
+		ingest:         newPublisherIngest(pc),
+		packetizers:    newLayerPacketizers(codec.MimeType),
+	}
+	publisher.keyframes = newKeyframeGate(m.publisherKeyframeRequester(publisher), subscriberKeyframeCooldown)
+	switch {
+	case len(rids) > 0:
+		// 推流端已经发送多个层，不再转码
+		publisher.simulcast = newIngestSimulcast(rids)
+	case codec.MimeType == webrtc.MimeTypeH264 && len(m.simulcastLayers) > 0:
+		publisher.simulcast = newPublisherSimulcast(m.simulcastLayers, m.simulcastLogger)
+	default:
+		publisher.simulcast = newPublisherSimulcast(nil, nil)
+	}
+	return publisher, nil
+}
+
+// readIngestTrack 读取推流的 RTP 包，重组为完整帧后分发（simulcast 时每个 RID 一个轨道）
+func (m *Manager) readIngestTrack(publisher *PublisherSession, track *webrtc.TrackRemote) {
+	if track.Kind() != webrtc.RTPCodecTypeVideo {
+		// 推流音频暂不转发
+		log.Printf("Ignoring %s track from ingest publisher %s", track.Kind(), publisher.ID)
+		return
+	}
+
+	mimeType := track.Codec().MimeType
+	if !strings.EqualFold(mimeType, publisher.VideoTrack.Codec().MimeType) {
+		log.Printf("Ingest publisher %s sent %s, expected %s", publisher.ID, mimeType, publisher.VideoTrack.Codec().MimeType)
+		return
+	}
+
+	var depacketizer rtp.Depacketizer = &codecs.VP8Packet{}
+	if strings.EqualFold(mimeType, webrtc.MimeTypeH264) {
+		// 输出 Annex-B 格式，与设备采集的帧一致
+		depacketizer = &codecs.H264Packet{}
+	}
+	builder := samplebuilder.New(ingestMaxLate, depacketizer, track.Codec().ClockRate)
+
+	rid := track.RID()
+	publisher.ingest.setSSRC(rid, uint32(track.SSRC()))
+	publisher.ingest.requestKeyframe(rid)
+	if rid != "" {
+		log.Printf("Ingest publisher %s receiving %s (rid: %s)", publisher.ID, mimeType, rid)
+	} else {
+		log.Printf("Ingest publisher %s receiving %s", publisher.ID, mimeType)
+	}
+
+	lastActivity := time.Now()
+	for {
+		packet, _, err := track.ReadRTP()
+		if err != nil {
+			return
+		}
+
+		builder.Push(packet)
+		for sample := builder.Pop(); sample != nil; sample = builder.Pop() {
+			if err := m.writeIngestFrame(publisher, rid, sample.Data, sample.Duration); err != nil {
+				// 发布者已关闭
+				return
+			}
+		}
+
+		if time.Since(lastActivity) >= ingestActivityInterval {
+			publisher.UpdateState(StateConnected)
+			lastActivity = time.Now()
+		}
+	}
+}
+
+// writeIngestFrame 分发推流的帧：simulcast 层直接交给订阅者的层选择器，否则作为原始码流写入
+func (m *Manager) writeIngestFrame(publisher *PublisherSession, rid string, frame []byte, duration time.Duration) error {
+	if rid == "" {
+		return m.WriteVideoFrame(publisher.ID, frame, duration)
+	}
+
+	if _, err := m.GetPublisher(publisher.ID); err != nil {
+		return err
+	}
+	publisher.simulcast.recordIngest(rid, len(frame))
+	m.forwardLayer(publisher, rid, frame, duration, isKeyframe(publisher.packetizers.mimeType, frame))
+	return nil
+}
@@ -0,0 +1,238 @@
+package webrtc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cloudphone/media-service/internal/models"
+	"github.com/pion/webrtc/v3"
+)
+
+// =============================================================================
+// 二进制控制协议
+// =============================================================================
+//
+// JSON 控制通道（label "control"，无子协议）之外的紧凑二进制协议，两者可以同时使用。
+// 数据通道的子协议为 "cloudphone-control.v<版本>" 时按二进制协议解析，
+// 服务端不支持的版本直接关闭该通道（客户端可回退到 JSON）。
+//
+// 推荐使用两个通道：
+//   - "pointer"（unordered, maxRetransmits=0）：触摸移动，丢包或乱序时只用最新位置
+//   - "input"  （可靠有序）：触摸按下/抬起/点击、按键、文本、控制权消息
+//
+// 服务端发起协商时创建这两个通道；客户端发起协商时由客户端在 offer 中创建。
+//
+// v1 消息格式（大端序），第一个字节为消息类型：
+//
+//	touch    0x01 | action u8 | seq u16 | x f32 | y f32           (12 字节)
+//	key      0x02 | action u8 | keyCode u16                      (4 字节)
+//	text     0x03 | len u16 | UTF-8 文本
+//	control  0x04 | action u8 | len u8 | targetSessionId
+//
+// touch action: 0=down 1=move 2=up 3=tap；key action: 0=press 1=longpress；
+// control action: 0=grant 1=revoke 2=request。
+// seq 为每个通道递增的序号（16 位回绕），乱序到达的旧 move 被丢弃。
+
+const (
+	// ControlProtocolPrefix 二进制控制协议的数据通道子协议前缀
+	ControlProtocolPrefix = "cloudphone-control.v"
+	// ControlProtocolVersion 当前支持的二进制协议版本
+	ControlProtocolVersion = 1
+
+	// 服务端发起协商时创建的二进制通道
+	pointerChannelLabel = "pointer"
+	inputChannelLabel   = "input"
+	// jsonChannelLabel JSON 控制通道
+	jsonChannelLabel = "control"
+)
+
+// ControlProtocol 当前版本的子协议名
+var ControlProtocol = ControlProtocolPrefix + strconv.Itoa(ControlProtocolVersion)
+
+// 二进制消息类型
+const (
+	binaryMsgTouch   byte = 0x01
+	binaryMsgKey     byte = 0x02
+	binaryMsgText    byte = 0x03
+	binaryMsgControl byte = 0x04
+)
+
+var (
+	binaryTouchActions   = []string{"down", "move", "up", "tap"}
+	binaryKeyActions     = []string{"press", "longpress"}
+	binaryControlActions = []string{ControlActionGrant, ControlActionRevoke, ControlActionRequest}
+)
+
+// errUnsupportedControlProtocol 数据通道子协议不是本服务支持的二进制控制协议版本
+var errUnsupportedControlProtocol = errors.New("unsupported control protocol")
+
+// parseControlProtocol 解析数据通道子协议，返回是否为二进制控制协议
+// 子协议带有前缀但版本不受支持时返回 errUnsupportedControlProtocol
+func parseControlProtocol(protocol string) (bool, error) {
+	if !strings.HasPrefix(protocol, ControlProtocolPrefix) {
+		return false, nil
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(protocol, ControlProtocolPrefix))
+	if err != nil || version != ControlProtocolVersion {
+		return true, fmt.Errorf("%w: %s", errUnsupportedControlProtocol, protocol)
+	}
+	return true, nil
+}
+
+// binaryControlChannel 单个二进制控制通道的解码状态
+// pion 在每个数据通道的读取协程中顺序回调 OnMessage，无需加锁
+type binaryControlChannel struct {
+	hasSeq  bool
+	lastSeq uint16
+}
+
+// decode 解码一条 v1 消息
+// 返回 nil 消息表示应丢弃（乱序到达的旧 move）
+func (ch *binaryControlChannel) decode(data []byte, deviceID string) (*models.ControlMessage, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty binary control message")
+	}
+
+	msg := &models.ControlMessage{DeviceID: deviceID}
+	switch data[0] {
+	case binaryMsgTouch:
+		if len(data) != 12 {
+			return nil, fmt.Errorf("invalid touch message length: %d", len(data))
+		}
+		action, err := binaryAction(binaryTouchActions, data[1])
+		if err != nil {
+			return nil, err
+		}
+		seq := binary.BigEndian.Uint16(data[2:4])
+		if action == "move" {
+			if ch.hasSeq && int16(seq-ch.lastSeq) <= 0 {
+				return nil, nil
+			}
+			ch.hasSeq, ch.lastSeq = true, seq
+		}
+		msg.Type = "touch"
+		msg.Action = action
+		msg.X = float64(math.Float32frombits(binary.BigEndian.Uint32(data[4:8])))
+		msg.Y = float64(math.Float32frombits(binary.BigEndian.Uint32(data[8:12])))
+
+	case binaryMsgKey:
+		if len(data) != 4 {
+			return nil, fmt.Errorf("invalid key message length: %d", len(data))
+		}
+		action, err := binaryAction(binaryKeyActions, data[1])
+		if err != nil {
+			return nil, err
+		}
+		msg.Type = "key"
+		msg.Action = action
+		msg.KeyCode = int(binary.BigEndian.Uint16(data[2:4]))
+
+	case binaryMsgText:
+		if len(data) < 3 {
+			return nil, fmt.Errorf("invalid text message length: %d", len(data))
+		}
+		n := int(binary.BigEndian.Uint16(data[1:3]))
+		if len(data) != 3+n {
+			return nil, fmt.Errorf("text length mismatch: header %d, payload %d", n, len(data)-3)
+		}
+		text := data[3:]
+		if !utf8.Valid(text) {
+			return nil, fmt.Errorf("text is not valid UTF-8")
+		}
+		msg.Type = "text"
+		msg.Text = string(text)
+
+	case binaryMsgControl:
+		if len(data) < 3 {
+			return nil, fmt.Errorf("invalid control message length: %d", len(data))
+		}
+		action, err := binaryAction(binaryControlActions, data[1])
+		if err != nil {
+			return nil, err
+		}
+		n := int(data[2])
+		if len(data) != 3+n {
+			return nil, fmt.Errorf("target session length mismatch: header %d, payload %d", n, len(data)-3)
+		}
+		msg.Type = "control"
+		msg.Action = action
+		msg.TargetSessionID = string(data[3:])
+
+	default:
+		return nil, fmt.Errorf("unknown binary message type: 0x%02x", data[0])
+	}
+
+	return msg, nil
+}
+
+func binaryAction(actions []string, code byte) (string, error) {
+	if int(code) >= len(actions) {
+		return "", fmt.Errorf("unknown action code: %d", code)
+	}
+	return actions[code], nil
+}
+
+// createBinaryControlChannels 服务端发起协商时创建二进制控制通道
+func (m *Manager) createBinaryControlChannels(session *models.Session, pc *webrtc.PeerConnection) error {
+	ordered := false
+	maxRetransmits := uint16(0)
+	protocol := ControlProtocol
+
+	pointer, err := pc.CreateDataChannel(pointerChannelLabel, &webrtc.DataChannelInit{
+		Ordered:        &ordered,
+		MaxRetransmits: &maxRetransmits,
+		Protocol:       &protocol,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create pointer channel: %w", err)
+	}
+	m.setupBinaryControlHandlers(session, pointer)
+
+	input, err := pc.CreateDataChannel(inputChannelLabel, &webrtc.DataChannelInit{
+		Protocol: &protocol,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create input channel: %w", err)
+	}
+	m.setupBinaryControlHandlers(session, input)
+
+	return nil
+}
+
+// setupBinaryControlHandlers 设置二进制控制通道处理器
+// 高频路径：不逐条记录日志，只记录解码和执行错误
+func (m *Manager) setupBinaryControlHandlers(session *models.Session, dc *webrtc.DataChannel) {
+	ch := &binaryControlChannel{}
+
+	dc.OnOpen(func() {
+		log.Printf("Binary control channel %q opened (session: %s, protocol: %s)", dc.Label(), session.ID, dc.Protocol())
+	})
+
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		if msg.IsString {
+			log.Printf("Ignoring text message on binary control channel %q (session: %s)", dc.Label(), session.ID)
+			return
+		}
+		ctrlMsg, err := ch.decode(msg.Data, session.DeviceID)
+		if err != nil {
+			log.Printf("Invalid binary control message (session: %s): %v", session.ID, err)
+			return
+		}
+		if ctrlMsg == nil {
+			return
+		}
+		if err := m.dispatchControlMessage(session, ctrlMsg); err != nil {
+			log.Printf("Error handling control message (session: %s): %v", session.ID, err)
+		}
+	})
+
+	dc.OnError(func(err error) {
+		log.Printf("Binary control channel %q error (session: %s): %v", dc.Label(), session.ID, err)
+	})
+}
@@ -0,0 +1,76 @@
+package webrtc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/cloudphone/media-service/internal/models"
+	"github.com/pion/webrtc/v3"
+)
+
+// 服务端事件通道：服务端 → 客户端的 JSON 事件（models.SessionEvent），可靠有序
+// 服务端发起协商时由服务端创建；客户端发起协商时由客户端在 offer 中创建同名通道
+const (
+	eventChannelLabel = "events"
+	// EventProtocol 事件通道子协议（版本与 models.SessionEventVersion 一致）
+	EventProtocol = "cloudphone-events.v1"
+)
+
+// ErrEventChannelUnavailable 会话的事件通道未打开（调用方应回退到 WebSocket）
+var ErrEventChannelUnavailable = errors.New("event channel unavailable")
+
+// createEventChannel 服务端发起协商时创建事件通道
+func (m *Manager) createEventChannel(session *models.Session, pc *webrtc.PeerConnection) error {
+	protocol := EventProtocol
+	dc, err := pc.CreateDataChannel(eventChannelLabel, &webrtc.DataChannelInit{
+		Protocol: &protocol,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create event channel: %w", err)
+	}
+	m.setupEventChannel(session, dc)
+	return nil
+}
+
+// setupEventChannel 事件通道打开后才用于推送，关闭后回退到 WebSocket
+func (m *Manager) setupEventChannel(session *models.Session, dc *webrtc.DataChannel) {
+	dc.OnOpen(func() {
+		log.Printf("Event channel opened (session: %s)", session.ID)
+		session.SetEventChannel(dc)
+	})
+
+	dc.OnClose(func() {
+		log.Printf("Event channel closed (session: %s)", session.ID)
+		if session.GetEventChannel() == dc {
+			session.SetEventChannel(nil)
+		}
+	})
+
+	// 事件通道是单向的，客户端发送的消息被忽略
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {})
+}
+
+// SendSessionEvent 通过会话的事件通道推送服务端事件
+// 事件通道未打开时返回 ErrEventChannelUnavailable
+func (m *Manager) SendSessionEvent(sessionID string, event *models.SessionEvent) error {
+	session, err := m.GetSession(sessionID)
+	if err != nil {
+		return err
+	}
+
+	dc := session.GetEventChannel()
+	if dc == nil || dc.ReadyState() != webrtc.DataChannelStateOpen {
+		return ErrEventChannelUnavailable
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session event: %w", err)
+	}
+	if err := dc.SendText(string(data)); err != nil {
+		return fmt.Errorf("failed to send session event: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package webrtc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cloudphone/media-service/internal/adaptive"
+	"github.com/cloudphone/media-service/internal/models"
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// videoRTCPFeedback 视频编码协商的 RTCP 反馈（浏览器只发送协商过的反馈类型）
+//   - goog-remb: 接收端估计带宽，作为自适应码率的可用带宽
+//   - transport-cc: 传输层拥塞反馈，驱动发送端带宽估计 (GCC)，优先于 REMB
+//   - nack: 丢包时请求重传，服务端从发送历史中重发（见 adaptive.NACKHistorySize）
+//   - ccm fir / nack pli: 解码失败时请求关键帧
+var videoRTCPFeedback = []webrtc.RTCPFeedback{
+	{Type: webrtc.TypeRTCPFBGoogREMB},
+	{Type: webrtc.TypeRTCPFBTransportCC},
+	{Type: webrtc.TypeRTCPFBNACK},
+	{Type: webrtc.TypeRTCPFBCCM, Parameter: "fir"},
+	{Type: webrtc.TypeRTCPFBNACK, Parameter: "pli"},
+}
+
+// offeredRTCPFeedback 客户端 offer 中服务端支持的 RTCP 反馈（answer 只能包含 offer 中的反馈）
+func offeredRTCPFeedback(offered []string) []webrtc.RTCPFeedback {
+	var feedback []webrtc.RTCPFeedback
+	for _, fb := range videoRTCPFeedback {
+		value := fb.Type
+		if fb.Parameter != "" {
+			value += " " + fb.Parameter
+		}
+		for _, o := range offered {
+			if o == value {
+				feedback = append(feedback, fb)
+				break
+			}
+		}
+	}
+	return feedback
+}
+
+// WithPipelineFeedback 把每个 PeerConnection 收到的 RTCP 反馈接到会话的视频管道（按会话 ID 查找）
+// 未设置时仍然注册 RTCP 报告拦截器，但反馈不会驱动码率和关键帧
+func WithPipelineFeedback(feedback adaptive.SessionFeedback, logger *logrus.Logger) ManagerOption {
+	return func(m *Manager) {
+		m.feedback = feedback
+		m.feedbackLogger = logger
+	}
+}
+
+// newInterceptorRegistry 为单个 PeerConnection 创建 RTCP 反馈收集器和拦截器注册表
+// 收集器在 PeerConnection 关闭时（拦截器 Close）自动停止；NACK 重传历史按 videoBitrate 调整
+func (m *Manager) newInterceptorRegistry(sessionID string, videoBitrate int) (*interceptor.Registry, *adaptive.RTCPCollector, error) {
+	collector := adaptive.NewSessionCollector(sessionID, m.feedback, m.feedbackLogger)
+
+	registry, err := adaptive.NewInterceptorRegistry(collector, videoBitrate)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create interceptor registry: %w", err)
+	}
+	return registry, collector, nil
+}
+
+// transportStats 把收集器的累计计数和最近一个周期的网络质量转换为会话传输统计
+func transportStats(collector *adaptive.RTCPCollector, videoBitrate int) models.TransportStats {
+	stats := collector.GetStats()
+	rtt, loss, jitter := collector.GetCurrentStats()
+	return models.TransportStats{
+		PacketsSent:          stats.PacketsSent,
+		RetransmittedPackets: stats.RetransmittedPackets,
+		NACKedPackets:        stats.NACKedPackets,
+		PLIs:                 stats.PLIs,
+		FIRs:                 stats.FIRs,
+		KeyframeRequests:     stats.KeyframeRequests,
+		RTTMs:                float64(rtt) / float64(time.Millisecond),
+		JitterMs:             float64(jitter) / float64(time.Millisecond),
+		PacketLoss:           loss,
+		Bandwidth:            collector.GetEstimatedBandwidth(),
+		NACKHistorySize:      int(adaptive.NACKHistorySize(videoBitrate)),
+		FECEnabled:           stats.FECEnabled,
+		FECPackets:           stats.FECPackets,
+	}
+}
@@ -19,7 +19,17 @@ const (
 
 // SessionOptions 创建会话的选项
 type SessionOptions struct {
-	VideoCodec VideoCodecType // 视频编码类型，默认 VP8
+	VideoCodec VideoCodecType     // 视频编码类型，默认 VP8
+	TenantID   string             // 租户 ID（可选）
+	Role       models.SessionRole // 会话角色，默认 owner（只有设备观看权限的用户为 viewer）
+
+	// VideoBitrate 视频管道的最高码率 (bps)，用于确定 NACK 重传历史的大小，0 表示未知
+	VideoBitrate int
+
+	// OfferedVideoCodec 客户端发起协商时由 NegotiateVideoCodec 从 offer 中选出的编码参数
+	// 非 nil 时会话只注册该视频编码（沿用 offer 的 payload type 和 fmtp），
+	// 数据通道由客户端创建，随后通过 CreateAnswer 完成协商
+	OfferedVideoCodec *webrtc.RTPCodecParameters
 }
 
 // WebRTCManager 定义 WebRTC 管理器接口
@@ -37,14 +47,39 @@ type WebRTCManager interface {
 
 	// SDP 处理
 	CreateOffer(sessionID string) (*webrtc.SessionDescription, error)
+	// CreateTrickleOffer 立即返回 offer，服务端 ICE 候选通过 onCandidate 逐个推送
+	CreateTrickleOffer(sessionID string, onCandidate ICECandidateHandler) (*webrtc.SessionDescription, error)
 	HandleAnswer(sessionID string, answer webrtc.SessionDescription) error
+	// CreateAnswer 处理客户端 offer（会话需使用 SessionOptions.OfferedVideoCodec 创建）
+	CreateAnswer(sessionID string, offer webrtc.SessionDescription, onCandidate ICECandidateHandler) (*webrtc.SessionDescription, error)
 
 	// ICE 处理
 	AddICECandidate(sessionID string, candidate webrtc.ICECandidateInit) error
+	// RestartICE 网络切换后重启 ICE（媒体轨道和视频管道不变）
+	// offer 为客户端 ICE restart offer 时返回 answer，为 nil 时返回服务端 ICE restart offer
+	RestartICE(sessionID string, offer *webrtc.SessionDescription, onCandidate ICECandidateHandler) (*webrtc.SessionDescription, error)
+
+	// 会话恢复：校验恢复令牌并返回轮换后的新令牌
+	ResumeSession(sessionID, token string) (string, error)
 
 	// ICE 服务器配置 (用于前端同步 TURN 凭证)
 	GetICEServers() []webrtc.ICEServer
 
 	// 视频帧写入
 	WriteVideoFrame(sessionID string, frame []byte, duration time.Duration) error
+
+	// 服务端事件推送（事件通道未打开时返回 ErrEventChannelUnavailable）
+	SendSessionEvent(sessionID string, event *models.SessionEvent) error
+
+	// 会话事件
+	OnSessionClosed(handler SessionClosedHandler)
+	OnSessionResumed(handler SessionResumedHandler)
+	OnSessionRoleChanged(handler SessionRoleChangedHandler)
+	OnControlRequested(handler ControlRequestedHandler)
+
+	// 会话角色：同一设备的 owner 可以把控制权授予或收回其他会话，viewer 可以请求控制权
+	GetDeviceSessions(deviceID string) []*models.Session
+	GrantControl(ownerSessionID, targetSessionID string) error
+	RevokeControl(ownerSessionID, targetSessionID string) error
+	RequestControl(sessionID string) error
 }
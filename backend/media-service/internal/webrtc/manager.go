@@ -9,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/cloudphone/media-service/internal/adaptive"
 	"github.com/cloudphone/media-service/internal/adb"
 	"github.com/cloudphone/media-service/internal/config"
 	"github.com/cloudphone/media-service/internal/metrics"
@@ -17,6 +18,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/pion/webrtc/v3"
 	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/sirupsen/logrus"
 )
 
 const defaultNumShards = 32 // 默认分片数量
@@ -37,6 +39,27 @@ type Manager struct {
 	numShards   uint32
 	adbService  *adb.Service
 	turnService *turn.Service
+
+	// closedHandlers 会话关闭监听器（信令层据此通知客户端）
+	closedHandlers []SessionClosedHandler
+	// resumedHandlers 会话恢复监听器（ICE 中断后重新连接，需要关键帧）
+	resumedHandlers []SessionResumedHandler
+	// roleChangedHandlers 会话角色变化监听器（通知被授予/收回控制权的客户端）
+	roleChangedHandlers []SessionRoleChangedHandler
+	// controlRequestedHandlers 控制权请求监听器（通知设备当前的 owner 和 controller）
+	controlRequestedHandlers []ControlRequestedHandler
+	handlersMu               sync.RWMutex
+
+	// roleMu 串行化同一管理器内的控制权变化（保证每个设备最多一个 controller）
+	roleMu sync.Mutex
+
+	// feedback 接收 RTCP 反馈的视频管道（码率自适应、关键帧请求）
+	feedback       adaptive.SessionFeedback
+	feedbackLogger *logrus.Logger
+
+	// interrupted ICE 中断的会话：断开时值为 nil，失败后为宽限期计时器
+	interrupted map[string]*time.Timer
+	suspendMu   sync.Mutex
 }
 
 // ManagerOption 配置选项
@@ -67,6 +90,7 @@ func NewManager(cfg *config.Config, opts ...ManagerOption) *Manager {
 		numShards:   defaultNumShards,
 		adbService:  adb.NewService(""),
 		turnService: turn.NewService(),
+		interrupted: make(map[string]*time.Timer),
 	}
 
 	// 应用配置选项
@@ -116,11 +140,22 @@ func (m *Manager) CreateSessionWithOptions(deviceID, userID string, opts Session
 	// 生成 session ID
 	sessionID := uuid.New().String()
 
+	// 生成会话恢复令牌（网络切换后客户端凭此恢复会话）
+	resumeToken, err := newResumeToken()
+	if err != nil {
+		return nil, err
+	}
+
 	// 默认 VP8
 	if opts.VideoCodec == "" {
 		opts.VideoCodec = VideoCodecVP8
 	}
 
+	// 默认为 owner（创建者拥有控制权）
+	if opts.Role == "" {
+		opts.Role = models.SessionRoleOwner
+	}
+
 	// 获取对应的分片
 	shard := m.getShard(sessionID)
 
@@ -154,15 +189,26 @@ func (m *Manager) CreateSessionWithOptions(deviceID, userID string, opts Session
 	// 创建 MediaEngine
 	mediaEngine := &webrtc.MediaEngine{}
 
-	// 注册编解码器
-	if err := m.registerCodecs(mediaEngine); err != nil {
+	// 注册编解码器（客户端发起协商时只注册从 offer 中选定的视频编码）
+	videoCodecs := defaultVideoCodecs
+	if opts.OfferedVideoCodec != nil {
+		videoCodecs = []webrtc.RTPCodecParameters{*opts.OfferedVideoCodec}
+	}
+	if err := m.registerCodecs(mediaEngine, videoCodecs); err != nil {
 		return nil, fmt.Errorf("failed to register codecs: %w", err)
 	}
 
+	// 每个 PeerConnection 独立的拦截器：接收端报告/REMB/TWCC 驱动码率，PLI/FIR 请求关键帧
+	interceptorRegistry, rtcpCollector, err := m.newInterceptorRegistry(sessionID, opts.VideoBitrate)
+	if err != nil {
+		return nil, err
+	}
+
 	// 创建 API
 	api := webrtc.NewAPI(
 		webrtc.WithSettingEngine(settingEngine),
 		webrtc.WithMediaEngine(mediaEngine),
+		webrtc.WithInterceptorRegistry(interceptorRegistry),
 	)
 
 	// 创建 PeerConnection
@@ -170,18 +216,25 @@ func (m *Manager) CreateSessionWithOptions(deviceID, userID string, opts Session
 	if err != nil {
 		return nil, fmt.Errorf("failed to create peer connection: %w", err)
 	}
+	rtcpCollector.Start()
 
 	// 创建会话
 	session := &models.Session{
 		ID:             sessionID,
 		DeviceID:       deviceID,
 		UserID:         userID,
+		TenantID:       opts.TenantID,
 		PeerConnection: peerConnection,
 		CreatedAt:      time.Now(),
 		LastActivityAt: time.Now(),
 		State:          models.SessionStateNew,
 		ICECandidates:  []webrtc.ICECandidateInit{},
 	}
+	session.SetResumeToken(resumeToken)
+	session.SetRole(opts.Role)
+	session.SetTransportStatsSource(func() models.TransportStats {
+		return transportStats(rtcpCollector, opts.VideoBitrate)
+	})
 
 	// 设置事件处理器
 	m.setupPeerConnectionHandlers(session)
@@ -211,20 +264,64 @@ func (m *Manager) CreateSessionWithOptions(deviceID, userID string, opts Session
 	log.Printf("Created video track with codec: %s for session: %s", opts.VideoCodec, sessionID)
 
 	// 添加视频轨道到 PeerConnection
-	if _, err = peerConnection.AddTrack(videoTrack); err != nil {
+	rtpSender, err := peerConnection.AddTrack(videoTrack)
+	if err != nil {
 		peerConnection.Close()
 		return nil, fmt.Errorf("failed to add video track: %w", err)
 	}
 
-	// 创建数据通道（用于控制消息）
-	dataChannel, err := peerConnection.CreateDataChannel("control", nil)
-	if err != nil {
-		peerConnection.Close()
-		return nil, fmt.Errorf("failed to create data channel: %w", err)
-	}
-	session.DataChannel = dataChannel
+	// 读取视频轨道的 RTCP（经过拦截器送到 RTCP 反馈收集器），FEC 使用该发送端协商的 payload type
+	rtcpCollector.SetVideoSender(rtpSender)
+	go adaptive.ReadSenderRTCP(rtpSender)
+
+	if opts.OfferedVideoCodec != nil {
+		// 客户端发起协商：answer 不能新增 m-line，数据通道由客户端在 offer 中创建
+		// 子协议为二进制控制协议的通道按二进制解析，其余只接受 JSON 控制通道和事件通道
+		peerConnection.OnDataChannel(func(dc *webrtc.DataChannel) {
+			binaryProtocol, err := parseControlProtocol(dc.Protocol())
+			switch {
+			case err != nil:
+				log.Printf("Closing data channel %q (session: %s): %v", dc.Label(), sessionID, err)
+				dc.Close()
+				return
+			case binaryProtocol:
+				m.setupBinaryControlHandlers(session, dc)
+				return
+			case dc.Label() == eventChannelLabel:
+				m.setupEventChannel(session, dc)
+				return
+			case dc.Label() != jsonChannelLabel:
+				log.Printf("Ignoring data channel %q (session: %s)", dc.Label(), sessionID)
+				return
+			}
+			shard.mu.Lock()
+			session.DataChannel = dc
+			shard.mu.Unlock()
+			m.setupDataChannelHandlers(session, dc)
+		})
+	} else {
+		// 创建数据通道（用于控制消息）
+		dataChannel, err := peerConnection.CreateDataChannel(jsonChannelLabel, nil)
+		if err != nil {
+			peerConnection.Close()
+			return nil, fmt.Errorf("failed to create data channel: %w", err)
+		}
+		session.DataChannel = dataChannel
 
-	m.setupDataChannelHandlers(session, dataChannel)
+		m.setupDataChannelHandlers(session, dataChannel)
+
+		// 二进制控制通道（与 JSON 通道并存，客户端按需使用）
+		if err := m.createBinaryControlChannels(session, peerConnection); err != nil {
+			peerConnection.Close()
+			return nil, err
+		}
+
+		// 服务端事件通道（采集状态、质量变化、录像、设备连接、剪贴板）
+		if err := m.createEventChannel(session, peerConnection); err != nil {
+			peerConnection.Close()
+			return nil, err
+		}
+	}
 
 	// 只锁定对应的分片
 	shard.mu.Lock()
@@ -257,6 +354,11 @@ func (m *Manager) GetSession(sessionID string) (*models.Session, error) {
 
 // CloseSession 关闭会话
 func (m *Manager) CloseSession(sessionID string) error {
+	return m.closeSession(sessionID, SessionCloseReasonClosed)
+}
+
+// closeSession 关闭会话并通知监听器
+func (m *Manager) closeSession(sessionID, reason string) error {
 	shard := m.getShard(sessionID)
 
 	shard.mu.Lock()
@@ -280,7 +382,9 @@ func (m *Manager) CloseSession(sessionID string) error {
 	delete(shard.sessions, sessionID)
 	shard.mu.Unlock()
 
-	log.Printf("Closed session: %s", sessionID)
+	m.clearInterrupted(sessionID)
+	log.Printf("Closed session: %s (reason: %s)", sessionID, reason)
+	m.notifySessionClosed(sessionID, reason)
 
 	return nil
 }
@@ -290,10 +394,9 @@ func (m *Manager) DeleteSession(sessionID string) {
 	shard := m.getShard(sessionID)
 
 	shard.mu.Lock()
-	defer shard.mu.Unlock()
-
 	session, ok := shard.sessions[sessionID]
 	if !ok {
+		shard.mu.Unlock()
 		return
 	}
 
@@ -309,8 +412,11 @@ func (m *Manager) DeleteSession(sessionID string) {
 
 	session.UpdateState(models.SessionStateClosed)
 	delete(shard.sessions, sessionID)
+	shard.mu.Unlock()
 
+	m.clearInterrupted(sessionID)
 	log.Printf("Deleted session during error cleanup: %s", sessionID)
+	m.notifySessionClosed(sessionID, SessionCloseReasonError)
 }
 
 // CreateOffer 创建 SDP offer（等待 ICE gathering 完成以包含所有候选）
@@ -451,6 +557,8 @@ func (m *Manager) CleanupInactiveSessions(timeout time.Duration) {
 
 	// 并发清理每个分片
 	var wg sync.WaitGroup
+	var closedMu sync.Mutex
+	var closed []string
 
 	for i := uint32(0); i < m.numShards; i++ {
 		wg.Add(1)
@@ -472,12 +580,21 @@ func (m *Manager) CleanupInactiveSessions(timeout time.Duration) {
 
 					session.UpdateState(models.SessionStateClosed)
 					delete(shard.sessions, sessionID)
+
+					closedMu.Lock()
+					closed = append(closed, sessionID)
+					closedMu.Unlock()
 				}
 			}
 		}(&m.shards[i])
 	}
 
 	wg.Wait()
+
+	for _, sessionID := range closed {
+		m.clearInterrupted(sessionID)
+		m.notifySessionClosed(sessionID, SessionCloseReasonInactive)
+	}
 }
 
 // WriteVideoFrame 向视频轨道写入帧
@@ -491,6 +608,12 @@ func (m *Manager) WriteVideoFrame(sessionID string, frame []byte, duration time.
 		return fmt.Errorf("video track not available")
 	}
 
+	// ICE 中断期间丢弃帧（采集和录像继续），恢复连接后请求关键帧
+	switch session.GetState() {
+	case models.SessionStateDisconnected, models.SessionStateSuspended:
+		return nil
+	}
+
 	sample := &media.Sample{
 		Data:     frame,
 		Duration: duration,
@@ -569,15 +692,16 @@ func (m *Manager) setupPeerConnectionHandlers(session *models.Session) {
 		case webrtc.ICEConnectionStateConnected:
 			stateValue = 2
 			session.UpdateState(models.SessionStateConnected)
+			m.resumeIfInterrupted(session)
 		case webrtc.ICEConnectionStateCompleted:
 			stateValue = 3
 		case webrtc.ICEConnectionStateFailed:
 			stateValue = 4
-			session.UpdateState(models.SessionStateFailed)
-			m.CloseSession(session.ID)
+			// 网络切换（Wi-Fi ↔ 4G）时保留会话，等待客户端 ICE restart
+			m.suspendSession(session)
 		case webrtc.ICEConnectionStateDisconnected:
 			stateValue = 5
-			session.UpdateState(models.SessionStateDisconnected)
+			m.markInterrupted(session)
 		case webrtc.ICEConnectionStateClosed:
 			stateValue = 6
 			session.UpdateState(models.SessionStateClosed)
@@ -635,22 +759,40 @@ func (m *Manager) handleControlMessage(session *models.Session, data []byte) err
 		return fmt.Errorf("failed to parse control message: %w", err)
 	}
 
-	log.Printf("Control message received (session: %s, type: %s, action: %s)",
-		session.ID, ctrlMsg.Type, ctrlMsg.Action)
+	// 触摸移动频率高（可达 120 Hz），不逐条记录
+	if ctrlMsg.Action != "move" {
+		log.Printf("Control message received (session: %s, type: %s, action: %s)",
+			session.ID, ctrlMsg.Type, ctrlMsg.Action)
+	}
+
+	return m.dispatchControlMessage(session, &ctrlMsg)
+}
 
+// dispatchControlMessage 校验并执行控制消息（JSON 和二进制协议共用）
+func (m *Manager) dispatchControlMessage(session *models.Session, ctrlMsg *models.ControlMessage) error {
 	// 验证设备ID匹配
 	if ctrlMsg.DeviceID != session.DeviceID {
 		return fmt.Errorf("device ID mismatch: expected %s, got %s",
 			session.DeviceID, ctrlMsg.DeviceID)
 	}
 
+	// 只有 owner 和 controller 可以注入输入，viewer 只能请求控制权
+	switch ctrlMsg.Type {
+	case "control":
+		return m.handleRoleMessage(session, ctrlMsg)
+	case "touch", "key", "text":
+		if !session.CanControl() {
+			return fmt.Errorf("%w: %s input from %s session", ErrControlNotPermitted, ctrlMsg.Type, session.GetRole())
+		}
+	}
+
 	switch ctrlMsg.Type {
 	case "touch":
-		return m.handleTouchEvent(session, &ctrlMsg)
+		return m.handleTouchEvent(session, ctrlMsg)
 	case "key":
-		return m.handleKeyEvent(session, &ctrlMsg)
+		return m.handleKeyEvent(session, ctrlMsg)
 	case "text":
-		return m.handleTextInput(session, &ctrlMsg)
+		return m.handleTextInput(session, ctrlMsg)
 	default:
 		return fmt.Errorf("unknown control message type: %s", ctrlMsg.Type)
 	}
@@ -665,7 +807,6 @@ func (m *Manager) handleTouchEvent(session *models.Session, msg *models.ControlM
 			return fmt.Errorf("failed to send touch down: %w", err)
 		}
 	case "move":
-		log.Printf("Touch move to (%.0f, %.0f) on device %s", msg.X, msg.Y, session.DeviceID)
 		if err := m.adbService.SendTouchMove(session.DeviceID, msg.X, msg.Y); err != nil {
 			return fmt.Errorf("failed to send touch move: %w", err)
 		}
@@ -717,34 +858,38 @@ func (m *Manager) handleTextInput(session *models.Session, msg *models.ControlMe
 	return nil
 }
 
-// registerCodecs 注册编解码器
-func (m *Manager) registerCodecs(mediaEngine *webrtc.MediaEngine) error {
+// defaultVideoCodecs 服务端发起协商时注册的视频编码
+var defaultVideoCodecs = []webrtc.RTPCodecParameters{
 	// H.264 视频编解码器 (硬件加速, 浏览器原生支持)
-	if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+	{
 		RTPCodecCapability: webrtc.RTPCodecCapability{
 			MimeType:     webrtc.MimeTypeH264,
 			ClockRate:    90000,
 			Channels:     0,
 			SDPFmtpLine:  "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
-			RTCPFeedback: nil,
+			RTCPFeedback: videoRTCPFeedback,
 		},
 		PayloadType: 102,
-	}, webrtc.RTPCodecTypeVideo); err != nil {
-		return err
-	}
-
+	},
 	// VP8 视频编解码器 (降级选项)
-	if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+	{
 		RTPCodecCapability: webrtc.RTPCodecCapability{
 			MimeType:     webrtc.MimeTypeVP8,
 			ClockRate:    90000,
 			Channels:     0,
 			SDPFmtpLine:  "",
-			RTCPFeedback: nil,
+			RTCPFeedback: videoRTCPFeedback,
 		},
 		PayloadType: 96,
-	}, webrtc.RTPCodecTypeVideo); err != nil {
-		return err
+	},
+}
+
+// registerCodecs 注册编解码器
+func (m *Manager) registerCodecs(mediaEngine *webrtc.MediaEngine, videoCodecs []webrtc.RTPCodecParameters) error {
+	for _, codec := range videoCodecs {
+		if err := mediaEngine.RegisterCodec(codec, webrtc.RTPCodecTypeVideo); err != nil {
+			return err
+		}
 	}
 
 	// Opus 音频编解码器
@@ -760,5 +905,17 @@ func (m *Manager) registerCodecs(mediaEngine *webrtc.MediaEngine) error {
 		return err
 	}
 
+	// TWCC 头扩展：接收端据此发送传输层拥塞反馈，驱动发送端带宽估计 (GCC)
+	if err := adaptive.RegisterTransportCC(mediaEngine); err != nil {
+		return err
+	}
+
+	// 视频 FEC (RED/ULPFEC)：协商后由质量控制器按丢包率开启
+	if m.config.VideoFECEnabled {
+		if err := adaptive.RegisterFECCodecs(mediaEngine); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
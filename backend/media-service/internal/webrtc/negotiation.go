@@ -0,0 +1,196 @@
+package webrtc
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudphone/media-service/internal/models"
+	"github.com/pion/webrtc/v3"
+)
+
+// ErrNoCommonCodec 客户端 offer 中没有采集可以输出的视频编码
+var ErrNoCommonCodec = errors.New("no common video codec in offer")
+
+// H.264 profile（profile-level-id 前两个字节），按服务端码流兼容程度排列
+// 服务端编码和设备端采集均输出 Constrained Baseline，可被以下 profile 的解码器解码；
+// answer 必须沿用 offer 中的 profile（RFC 6184 8.2.2），level 可以不同
+const (
+	h264RankConstrainedBaseline = iota
+	h264RankBaseline
+	h264RankMain
+	h264RankHigh
+	h264RankUnsupported
+)
+
+// h264ProfileRank 返回 profile-level-id 的兼容程度
+func h264ProfileRank(profileLevelID string) int {
+	b, err := hex.DecodeString(profileLevelID)
+	if err != nil || len(b) != 3 {
+		return h264RankUnsupported
+	}
+
+	profileIdc, profileIop := b[0], b[1]
+	switch profileIdc {
+	case 0x42:
+		// constraint_set1_flag 表示 Constrained Baseline
+		if profileIop&0x40 != 0 {
+			return h264RankConstrainedBaseline
+		}
+		return h264RankBaseline
+	case 0x4d:
+		return h264RankMain
+	case 0x64:
+		return h264RankHigh
+	default:
+		return h264RankUnsupported
+	}
+}
+
+// parseFmtp 解析 fmtp 参数（键小写）
+func parseFmtp(fmtp string) map[string]string {
+	params := make(map[string]string)
+	for _, param := range strings.Split(fmtp, ";") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) == 2 {
+			params[strings.ToLower(kv[0])] = kv[1]
+		}
+	}
+	return params
+}
+
+// NegotiateVideoCodec 从客户端 offer 中选择视频编码
+// preferred 为采集可以输出的编码（按优先级），依次在 offer 的第一个视频 m-line 中查找：
+//   - VP8: 任意 VP8 payload type
+//   - H.264: 要求 packetization-mode=1（RTP 打包使用 FU-A），profile 兼容 Constrained Baseline，
+//     多个候选时优先 Constrained Baseline，其次按 offer 中的顺序
+//
+// 返回的参数沿用 offer 中的 payload type、fmtp 和服务端支持的 RTCP 反馈，注册到会话的 MediaEngine 后即可生成对称的 answer
+func NegotiateVideoCodec(offer webrtc.SessionDescription, preferred []VideoCodecType) (VideoCodecType, webrtc.RTPCodecParameters, error) {
+	parsed, err := offer.Unmarshal()
+	if err != nil {
+		return "", webrtc.RTPCodecParameters{}, fmt.Errorf("invalid offer: %w", err)
+	}
+
+	var formats []string
+	for _, media := range parsed.MediaDescriptions {
+		if media.MediaName.Media == "video" {
+			formats = media.MediaName.Formats
+			break
+		}
+	}
+	if len(formats) == 0 {
+		return "", webrtc.RTPCodecParameters{}, fmt.Errorf("%w: offer has no video media section", ErrNoCommonCodec)
+	}
+
+	for _, codecType := range preferred {
+		var (
+			best     webrtc.RTPCodecParameters
+			bestRank = h264RankUnsupported
+		)
+
+		for _, format := range formats {
+			pt, err := strconv.ParseUint(format, 10, 8)
+			if err != nil {
+				continue
+			}
+			codec, err := parsed.GetCodecForPayloadType(uint8(pt))
+			if err != nil {
+				continue
+			}
+
+			params := webrtc.RTPCodecParameters{
+				RTPCodecCapability: webrtc.RTPCodecCapability{
+					ClockRate:    codec.ClockRate,
+					SDPFmtpLine:  codec.Fmtp,
+					RTCPFeedback: offeredRTCPFeedback(codec.RTCPFeedback),
+				},
+				PayloadType: webrtc.PayloadType(pt),
+			}
+
+			switch {
+			case codecType == VideoCodecVP8 && strings.EqualFold(codec.Name, "VP8"):
+				params.MimeType = webrtc.MimeTypeVP8
+				return codecType, params, nil
+
+			case codecType == VideoCodecH264 && strings.EqualFold(codec.Name, "H264"):
+				fmtp := parseFmtp(codec.Fmtp)
+				if fmtp["packetization-mode"] != "1" {
+					continue
+				}
+				if rank := h264ProfileRank(fmtp["profile-level-id"]); rank < bestRank {
+					params.MimeType = webrtc.MimeTypeH264
+					best, bestRank = params, rank
+				}
+			}
+		}
+
+		if bestRank != h264RankUnsupported {
+			return codecType, best, nil
+		}
+	}
+
+	return "", webrtc.RTPCodecParameters{}, ErrNoCommonCodec
+}
+
+// CreateAnswer 处理客户端 offer 并创建 SDP answer（客户端发起协商）
+// onCandidate 非 nil 时立即返回 answer，服务端候选通过 onCandidate 推送（trickle ICE）；
+// 否则等待 ICE gathering 完成，answer 中包含所有候选。
+// 失败时不删除会话，由调用方决定是否关闭。
+func (m *Manager) CreateAnswer(sessionID string, offer webrtc.SessionDescription, onCandidate ICECandidateHandler) (*webrtc.SessionDescription, error) {
+	session, err := m.GetSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	pc := session.PeerConnection
+
+	if offer.Type != webrtc.SDPTypeOffer {
+		return nil, fmt.Errorf("expected offer, got %s", offer.Type)
+	}
+
+	var gatherComplete <-chan struct{}
+	if onCandidate != nil {
+		pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+			if candidate == nil {
+				onCandidate(nil)
+				return
+			}
+			init := candidate.ToJSON()
+			onCandidate(&init)
+		})
+	} else {
+		gatherComplete = webrtc.GatheringCompletePromise(pc)
+	}
+
+	if err := pc.SetRemoteDescription(offer); err != nil {
+		return nil, fmt.Errorf("failed to set remote description: %w", err)
+	}
+
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create answer: %w", err)
+	}
+
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return nil, fmt.Errorf("failed to set local description: %w", err)
+	}
+
+	if gatherComplete != nil {
+		select {
+		case <-gatherComplete:
+			log.Printf("ICE gathering complete for session: %s", sessionID)
+		case <-time.After(10 * time.Second):
+			log.Printf("ICE gathering timeout for session: %s (proceeding anyway)", sessionID)
+		}
+	}
+
+	if session.GetState() == models.SessionStateNew {
+		session.UpdateState(models.SessionStateConnecting)
+	}
+
+	return pc.LocalDescription(), nil
+}
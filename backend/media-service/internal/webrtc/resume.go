@@ -0,0 +1,218 @@
+package webrtc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cloudphone/media-service/internal/models"
+	"github.com/pion/webrtc/v3"
+)
+
+// ErrInvalidResumeToken 会话恢复令牌不匹配（或已被使用）
+var ErrInvalidResumeToken = errors.New("invalid resume token")
+
+// resumeTokenBytes 会话恢复令牌的随机字节数
+const resumeTokenBytes = 32
+
+// SessionResumedHandler 会话恢复回调（ICE 中断后重新连接）
+type SessionResumedHandler func(sessionID string)
+
+// newResumeToken 生成会话恢复令牌
+func newResumeToken() (string, error) {
+	b := make([]byte, resumeTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate resume token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// OnSessionResumed 注册会话恢复监听器
+// ICE 断开或失败后重新连接时调用（此期间视频帧被丢弃，监听器应请求关键帧）
+func (m *Manager) OnSessionResumed(handler SessionResumedHandler) {
+	m.handlersMu.Lock()
+	defer m.handlersMu.Unlock()
+	m.resumedHandlers = append(m.resumedHandlers, handler)
+}
+
+// notifySessionResumed 通知所有会话恢复监听器
+func (m *Manager) notifySessionResumed(sessionID string) {
+	m.handlersMu.RLock()
+	handlers := make([]SessionResumedHandler, len(m.resumedHandlers))
+	copy(handlers, m.resumedHandlers)
+	m.handlersMu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(sessionID)
+	}
+}
+
+// resumeGracePeriod ICE 失败后保留会话的时间
+func (m *Manager) resumeGracePeriod() time.Duration {
+	return time.Duration(m.config.SessionResumeGraceSeconds) * time.Second
+}
+
+// markInterrupted ICE 断开时记录会话中断（尚未启动宽限期计时）
+// 断开通常会在几秒内自行恢复或进入失败状态
+func (m *Manager) markInterrupted(session *models.Session) {
+	m.markRestarting(session.ID)
+	session.UpdateState(models.SessionStateDisconnected)
+}
+
+// markRestarting ICE restart 后记录会话中断（服务端可能尚未检测到断开），重新连接时请求关键帧
+func (m *Manager) markRestarting(sessionID string) {
+	m.suspendMu.Lock()
+	if _, ok := m.interrupted[sessionID]; !ok {
+		m.interrupted[sessionID] = nil
+	}
+	m.suspendMu.Unlock()
+}
+
+// suspendSession ICE 失败后挂起会话，等待客户端在宽限期内 ICE restart
+// 挂起期间 PeerConnection、视频管道和录像保持运行，超时未恢复才关闭会话
+func (m *Manager) suspendSession(session *models.Session) {
+	grace := m.resumeGracePeriod()
+	if grace <= 0 {
+		session.UpdateState(models.SessionStateFailed)
+		m.closeSession(session.ID, SessionCloseReasonICEFailed)
+		return
+	}
+
+	sessionID := session.ID
+	m.suspendMu.Lock()
+	if timer := m.interrupted[sessionID]; timer != nil {
+		// 已在宽限期内
+		m.suspendMu.Unlock()
+		return
+	}
+	m.interrupted[sessionID] = time.AfterFunc(grace, func() {
+		m.suspendMu.Lock()
+		delete(m.interrupted, sessionID)
+		m.suspendMu.Unlock()
+
+		log.Printf("Session %s not resumed within %s, closing", sessionID, grace)
+		session.UpdateState(models.SessionStateFailed)
+		m.closeSession(sessionID, SessionCloseReasonICEFailed)
+	})
+	m.suspendMu.Unlock()
+
+	session.UpdateState(models.SessionStateSuspended)
+	log.Printf("Session %s suspended after ICE failure (grace period: %s)", sessionID, grace)
+}
+
+// clearInterrupted 清除会话的中断记录并停止宽限期计时，返回会话此前是否处于中断状态
+func (m *Manager) clearInterrupted(sessionID string) bool {
+	m.suspendMu.Lock()
+	defer m.suspendMu.Unlock()
+
+	timer, ok := m.interrupted[sessionID]
+	if !ok {
+		return false
+	}
+	if timer != nil {
+		timer.Stop()
+	}
+	delete(m.interrupted, sessionID)
+	return true
+}
+
+// resumeIfInterrupted ICE 重新连接后恢复会话
+func (m *Manager) resumeIfInterrupted(session *models.Session) {
+	if !m.clearInterrupted(session.ID) {
+		return
+	}
+	log.Printf("Session %s resumed after ICE interruption", session.ID)
+	m.notifySessionResumed(session.ID)
+}
+
+// ResumeSession 校验会话恢复令牌并轮换，返回新令牌
+// 客户端重新连接信令（如网络切换后）时使用，随后通过 RestartICE 恢复媒体
+func (m *Manager) ResumeSession(sessionID, token string) (string, error) {
+	session, err := m.GetSession(sessionID)
+	if err != nil {
+		return "", err
+	}
+
+	next, err := newResumeToken()
+	if err != nil {
+		return "", err
+	}
+	if !session.RotateResumeToken(token, next) {
+		return "", ErrInvalidResumeToken
+	}
+
+	log.Printf("Resume token accepted for session: %s (state: %s)", sessionID, session.GetState())
+	return next, nil
+}
+
+// RestartICE 重启会话的 ICE（新的 ufrag/pwd，DTLS、媒体轨道和视频管道保持不变）
+// offer 非 nil 时为客户端发起的 ICE restart，返回 answer；否则创建服务端 ICE restart offer，
+// 上一个 offer 尚未收到 answer 时先回滚。
+// onCandidate 非 nil 时立即返回，候选通过 onCandidate 推送；否则等待 ICE gathering 完成。
+// 重新连接后通知会话恢复监听器（请求关键帧）。失败时不删除会话。
+func (m *Manager) RestartICE(sessionID string, offer *webrtc.SessionDescription, onCandidate ICECandidateHandler) (*webrtc.SessionDescription, error) {
+	session, err := m.GetSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if offer != nil {
+		answer, err := m.CreateAnswer(sessionID, *offer, onCandidate)
+		if err != nil {
+			return nil, err
+		}
+		m.markRestarting(sessionID)
+		log.Printf("ICE restart answered for session: %s", sessionID)
+		return answer, nil
+	}
+
+	pc := session.PeerConnection
+	switch state := pc.SignalingState(); state {
+	case webrtc.SignalingStateStable:
+	case webrtc.SignalingStateHaveLocalOffer:
+		if err := pc.SetLocalDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeRollback}); err != nil {
+			return nil, fmt.Errorf("failed to rollback pending offer: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("cannot restart ICE in signaling state %s", state)
+	}
+
+	var gatherComplete <-chan struct{}
+	if onCandidate != nil {
+		pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+			if candidate == nil {
+				onCandidate(nil)
+				return
+			}
+			init := candidate.ToJSON()
+			onCandidate(&init)
+		})
+	} else {
+		gatherComplete = webrtc.GatheringCompletePromise(pc)
+	}
+
+	restartOffer, err := pc.CreateOffer(&webrtc.OfferOptions{ICERestart: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ICE restart offer: %w", err)
+	}
+
+	if err := pc.SetLocalDescription(restartOffer); err != nil {
+		return nil, fmt.Errorf("failed to set local description: %w", err)
+	}
+
+	if gatherComplete != nil {
+		select {
+		case <-gatherComplete:
+			log.Printf("ICE gathering complete for session: %s", sessionID)
+		case <-time.After(10 * time.Second):
+			log.Printf("ICE gathering timeout for session: %s (proceeding anyway)", sessionID)
+		}
+	}
+
+	m.markRestarting(sessionID)
+	log.Printf("ICE restart offer created for session: %s", sessionID)
+	return pc.LocalDescription(), nil
+}
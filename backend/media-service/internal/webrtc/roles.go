@@ -0,0 +1,198 @@
+package webrtc
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/cloudphone/media-service/internal/models"
+)
+
+// 会话角色错误
+var (
+	// ErrControlNotPermitted viewer 会话尝试注入输入
+	ErrControlNotPermitted = errors.New("session has no control permission")
+	// ErrNotSessionOwner 只有 owner 会话可以授予或收回控制权
+	ErrNotSessionOwner = errors.New("only the session owner can change control")
+	// ErrDifferentDevice 目标会话不属于同一设备
+	ErrDifferentDevice = errors.New("target session is on a different device")
+	// ErrInvalidRoleChange 目标会话的当前角色不允许该操作（如收回 owner 的控制权）
+	ErrInvalidRoleChange = errors.New("invalid role change")
+)
+
+// 数据通道控制消息（type=control）的动作
+const (
+	ControlActionGrant   = "grant"   // owner 授予控制权（targetSessionId）
+	ControlActionRevoke  = "revoke"  // owner 收回控制权（targetSessionId）
+	ControlActionRequest = "request" // viewer 请求控制权
+)
+
+// SessionRoleChangedHandler 会话角色变化回调
+type SessionRoleChangedHandler func(sessionID string, role models.SessionRole)
+
+// ControlRequestedHandler viewer 请求控制权回调
+type ControlRequestedHandler func(requester *models.Session)
+
+// OnSessionRoleChanged 注册会话角色变化监听器
+func (m *Manager) OnSessionRoleChanged(handler SessionRoleChangedHandler) {
+	m.handlersMu.Lock()
+	defer m.handlersMu.Unlock()
+	m.roleChangedHandlers = append(m.roleChangedHandlers, handler)
+}
+
+// OnControlRequested 注册控制权请求监听器（信令层据此通知设备的 owner 和 controller）
+func (m *Manager) OnControlRequested(handler ControlRequestedHandler) {
+	m.handlersMu.Lock()
+	defer m.handlersMu.Unlock()
+	m.controlRequestedHandlers = append(m.controlRequestedHandlers, handler)
+}
+
+// notifySessionRoleChanged 通知所有会话角色变化监听器
+func (m *Manager) notifySessionRoleChanged(sessionID string, role models.SessionRole) {
+	m.handlersMu.RLock()
+	handlers := make([]SessionRoleChangedHandler, len(m.roleChangedHandlers))
+	copy(handlers, m.roleChangedHandlers)
+	m.handlersMu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(sessionID, role)
+	}
+}
+
+// notifyControlRequested 通知所有控制权请求监听器
+func (m *Manager) notifyControlRequested(requester *models.Session) {
+	m.handlersMu.RLock()
+	handlers := make([]ControlRequestedHandler, len(m.controlRequestedHandlers))
+	copy(handlers, m.controlRequestedHandlers)
+	m.handlersMu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(requester)
+	}
+}
+
+// GetDeviceSessions 获取设备上的所有会话
+func (m *Manager) GetDeviceSessions(deviceID string) []*models.Session {
+	var sessions []*models.Session
+	for i := range m.shards {
+		shard := &m.shards[i]
+		shard.mu.RLock()
+		for _, session := range shard.sessions {
+			if session.DeviceID == deviceID {
+				sessions = append(sessions, session)
+			}
+		}
+		shard.mu.RUnlock()
+	}
+	return sessions
+}
+
+// GrantControl owner 会话把控制权授予同设备的另一个会话
+// 同一时间只有一个 controller：设备上原有的 controller 降为 viewer（owner 始终保留控制权）
+func (m *Manager) GrantControl(ownerSessionID, targetSessionID string) error {
+	changed, err := m.changeControl(ownerSessionID, targetSessionID, func(target *models.Session) (map[*models.Session]models.SessionRole, error) {
+		switch target.GetRole() {
+		case models.SessionRoleOwner:
+			return nil, fmt.Errorf("%w: target session is an owner", ErrInvalidRoleChange)
+		case models.SessionRoleController:
+			return nil, nil
+		}
+
+		changes := map[*models.Session]models.SessionRole{target: models.SessionRoleController}
+		for _, session := range m.GetDeviceSessions(target.DeviceID) {
+			if session != target && session.GetRole() == models.SessionRoleController {
+				changes[session] = models.SessionRoleViewer
+			}
+		}
+		return changes, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Control granted to session %s by %s (%d role changes)", targetSessionID, ownerSessionID, changed)
+	return nil
+}
+
+// RevokeControl owner 会话收回另一个会话的控制权（controller 降为 viewer）
+func (m *Manager) RevokeControl(ownerSessionID, targetSessionID string) error {
+	changed, err := m.changeControl(ownerSessionID, targetSessionID, func(target *models.Session) (map[*models.Session]models.SessionRole, error) {
+		switch target.GetRole() {
+		case models.SessionRoleOwner:
+			return nil, fmt.Errorf("%w: cannot revoke control from an owner", ErrInvalidRoleChange)
+		case models.SessionRoleViewer:
+			return nil, nil
+		}
+		return map[*models.Session]models.SessionRole{target: models.SessionRoleViewer}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Control revoked from session %s by %s (%d role changes)", targetSessionID, ownerSessionID, changed)
+	return nil
+}
+
+// changeControl 校验 owner 和目标会话后应用 plan 计算出的角色变化，返回变化数量
+// 角色变化串行执行，变化应用后再通知监听器
+func (m *Manager) changeControl(ownerSessionID, targetSessionID string, plan func(target *models.Session) (map[*models.Session]models.SessionRole, error)) (int, error) {
+	owner, err := m.GetSession(ownerSessionID)
+	if err != nil {
+		return 0, err
+	}
+	target, err := m.GetSession(targetSessionID)
+	if err != nil {
+		return 0, err
+	}
+	if owner.GetRole() != models.SessionRoleOwner {
+		return 0, ErrNotSessionOwner
+	}
+	if owner.DeviceID != target.DeviceID {
+		return 0, ErrDifferentDevice
+	}
+
+	m.roleMu.Lock()
+	changes, err := plan(target)
+	if err != nil {
+		m.roleMu.Unlock()
+		return 0, err
+	}
+	for session, role := range changes {
+		session.SetRole(role)
+	}
+	m.roleMu.Unlock()
+
+	for session, role := range changes {
+		m.notifySessionRoleChanged(session.ID, role)
+	}
+	return len(changes), nil
+}
+
+// RequestControl viewer 会话请求控制权，通知监听器（由 owner 决定是否授予）
+func (m *Manager) RequestControl(sessionID string) error {
+	session, err := m.GetSession(sessionID)
+	if err != nil {
+		return err
+	}
+	if session.CanControl() {
+		return fmt.Errorf("%w: session already has control", ErrInvalidRoleChange)
+	}
+
+	log.Printf("Control requested by session %s on device %s", sessionID, session.DeviceID)
+	m.notifyControlRequested(session)
+	return nil
+}
+
+// handleRoleMessage 处理数据通道上的控制权消息（type=control）
+func (m *Manager) handleRoleMessage(session *models.Session, msg *models.ControlMessage) error {
+	switch msg.Action {
+	case ControlActionGrant:
+		return m.GrantControl(session.ID, msg.TargetSessionID)
+	case ControlActionRevoke:
+		return m.RevokeControl(session.ID, msg.TargetSessionID)
+	case ControlActionRequest:
+		return m.RequestControl(session.ID)
+	default:
+		return fmt.Errorf("unknown control action: %s", msg.Action)
+	}
+}
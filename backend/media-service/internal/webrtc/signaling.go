@@ -0,0 +1,84 @@
+package webrtc
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/cloudphone/media-service/internal/models"
+	"github.com/pion/webrtc/v3"
+)
+
+// 会话关闭原因
+const (
+	SessionCloseReasonClosed    = "closed"     // 客户端或服务端主动关闭
+	SessionCloseReasonICEFailed = "ice_failed" // ICE 连接失败
+	SessionCloseReasonInactive  = "inactive"   // 长时间不活跃被清理
+	SessionCloseReasonError     = "error"      // 创建/协商过程中出错
+)
+
+// ICECandidateHandler 接收服务端 ICE 候选，candidate 为 nil 表示 gathering 完成
+type ICECandidateHandler func(candidate *webrtc.ICECandidateInit)
+
+// SessionClosedHandler 会话关闭回调
+type SessionClosedHandler func(sessionID, reason string)
+
+// OnSessionClosed 注册会话关闭监听器
+// 回调在会话已从管理器移除后调用，不持有任何分片锁
+func (m *Manager) OnSessionClosed(handler SessionClosedHandler) {
+	m.handlersMu.Lock()
+	defer m.handlersMu.Unlock()
+	m.closedHandlers = append(m.closedHandlers, handler)
+}
+
+// notifySessionClosed 通知所有会话关闭监听器
+func (m *Manager) notifySessionClosed(sessionID, reason string) {
+	m.handlersMu.RLock()
+	handlers := make([]SessionClosedHandler, len(m.closedHandlers))
+	copy(handlers, m.closedHandlers)
+	m.handlersMu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(sessionID, reason)
+	}
+}
+
+// CreateTrickleOffer 创建 SDP offer 并立即返回（trickle ICE）
+// 与 CreateOffer 不同，不等待 ICE gathering 完成，候选通过 onCandidate 推送给客户端，
+// 可显著缩短建连时间（TURN relay 候选通常需要数秒）。
+// 也用于重新协商：失败时不删除会话，由调用方决定是否关闭。
+func (m *Manager) CreateTrickleOffer(sessionID string, onCandidate ICECandidateHandler) (*webrtc.SessionDescription, error) {
+	session, err := m.GetSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if state := session.PeerConnection.SignalingState(); state != webrtc.SignalingStateStable {
+		return nil, fmt.Errorf("cannot create offer in signaling state %s", state)
+	}
+
+	// 先注册候选回调，再设置本地描述触发 gathering
+	session.PeerConnection.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate == nil {
+			log.Printf("ICE gathering complete for session: %s", sessionID)
+			onCandidate(nil)
+			return
+		}
+		init := candidate.ToJSON()
+		onCandidate(&init)
+	})
+
+	offer, err := session.PeerConnection.CreateOffer(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create offer: %w", err)
+	}
+
+	if err := session.PeerConnection.SetLocalDescription(offer); err != nil {
+		return nil, fmt.Errorf("failed to set local description: %w", err)
+	}
+
+	if session.GetState() == models.SessionStateNew {
+		session.UpdateState(models.SessionStateConnecting)
+	}
+
+	return session.PeerConnection.LocalDescription(), nil
+}
@@ -31,6 +31,12 @@ const (
 	safeSendTimeout = 1 * time.Second
 )
 
+// MessageHandler 处理客户端发来的消息（在读取协程中按顺序调用）
+type MessageHandler func(client *Client, message []byte)
+
+// CloseHandler 客户端连接断开时调用
+type CloseHandler func(client *Client)
+
 // Client 表示一个 WebSocket 客户端
 type Client struct {
 	Hub      *Hub
@@ -38,6 +44,30 @@ type Client struct {
 	Send     chan []byte
 	UserID   string
 	DeviceID string
+
+	onMessage MessageHandler
+	onClose   CloseHandler
+
+	// sendMu 保护 Send 通道的关闭，避免向已关闭的通道发送
+	sendMu sync.RWMutex
+	closed bool
+}
+
+// ClientOption 配置选项
+type ClientOption func(*Client)
+
+// WithMessageHandler 设置消息处理器（未设置时仅记录日志）
+func WithMessageHandler(handler MessageHandler) ClientOption {
+	return func(c *Client) {
+		c.onMessage = handler
+	}
+}
+
+// WithCloseHandler 设置连接断开回调
+func WithCloseHandler(handler CloseHandler) ClientOption {
+	return func(c *Client) {
+		c.onClose = handler
+	}
 }
 
 // Hub 管理所有 WebSocket 连接
@@ -78,7 +108,7 @@ func (h *Hub) Run() {
 			h.mu.Lock()
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
-				close(client.Send)
+				client.closeSend()
 
 				// 记录 WebSocket 连接断开指标
 				metrics.RecordWebSocketConnection(-1)
@@ -88,16 +118,16 @@ func (h *Hub) Run() {
 				client.UserID, client.DeviceID, len(h.clients))
 
 		case message := <-h.broadcast:
-			h.mu.RLock()
+			h.mu.Lock()
 			for client := range h.clients {
 				select {
 				case client.Send <- message:
 				default:
-					close(client.Send)
+					client.closeSend()
 					delete(h.clients, client)
 				}
 			}
-			h.mu.RUnlock()
+			h.mu.Unlock()
 		}
 	}
 }
@@ -134,6 +164,13 @@ func (h *Hub) GetClients() int {
 
 // SafeSend 安全发送消息到客户端（带超时）
 func (c *Client) SafeSend(message []byte) error {
+	c.sendMu.RLock()
+	defer c.sendMu.RUnlock()
+
+	if c.closed {
+		return fmt.Errorf("client connection closed")
+	}
+
 	select {
 	case c.Send <- message:
 		return nil
@@ -142,11 +179,34 @@ func (c *Client) SafeSend(message []byte) error {
 	}
 }
 
+// SendJSON 序列化并发送消息到客户端
+func (c *Client) SendJSON(message interface{}) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return c.SafeSend(data)
+}
+
+// closeSend 关闭发送通道（只关闭一次）
+func (c *Client) closeSend() {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if !c.closed {
+		c.closed = true
+		close(c.Send)
+	}
+}
+
 // readPump 从 WebSocket 连接读取消息
 func (c *Client) readPump() {
 	defer func() {
 		c.Hub.unregister <- c
 		c.Conn.Close()
+		if c.onClose != nil {
+			c.onClose(c)
+		}
 	}()
 
 	c.Conn.SetReadLimit(maxMessageSize)
@@ -169,6 +229,10 @@ func (c *Client) readPump() {
 		metrics.RecordWebSocketMessage("control", "inbound", len(message))
 
 		// 处理接收到的消息
+		if c.onMessage != nil {
+			c.onMessage(c, message)
+			continue
+		}
 		log.Printf("Received message from client %s: %s", c.UserID, string(message))
 
 		// 广播消息到其他客户端（如果需要）
@@ -194,28 +258,14 @@ func (c *Client) writePump() {
 				return
 			}
 
-			w, err := c.Conn.NextWriter(websocket.TextMessage)
-			if err != nil {
+			// 每条消息单独一帧，客户端可以直接按 JSON 解析
+			if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
 				return
 			}
-			w.Write(message)
 
 			// 记录发送的消息指标
 			metrics.RecordWebSocketMessage("control", "outbound", len(message))
 
-			// 将队列中的其他消息一起发送
-			n := len(c.Send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				additionalMsg := <-c.Send
-				w.Write(additionalMsg)
-				metrics.RecordWebSocketMessage("control", "outbound", len(additionalMsg))
-			}
-
-			if err := w.Close(); err != nil {
-				return
-			}
-
 		case <-ticker.C:
 			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
@@ -226,7 +276,7 @@ func (c *Client) writePump() {
 }
 
 // ServeWs 处理 WebSocket 连接
-func ServeWs(hub *Hub, conn *websocket.Conn, userID, deviceID string) {
+func ServeWs(hub *Hub, conn *websocket.Conn, userID, deviceID string, opts ...ClientOption) *Client {
 	client := &Client{
 		Hub:      hub,
 		Conn:     conn,
@@ -235,9 +285,15 @@ func ServeWs(hub *Hub, conn *websocket.Conn, userID, deviceID string) {
 		DeviceID: deviceID,
 	}
 
+	for _, opt := range opts {
+		opt(client)
+	}
+
 	client.Hub.register <- client
 
 	// 在新的 goroutine 中启动读写泵
 	go client.writePump()
 	go client.readPump()
+
+	return client
 }
@@ -10,8 +10,11 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/cloudphone/media-service/internal/adb"
+	"github.com/cloudphone/media-service/internal/admission"
 	"github.com/cloudphone/media-service/internal/config"
 	"github.com/cloudphone/media-service/internal/consul"
+	"github.com/cloudphone/media-service/internal/deviceaccess"
 	"github.com/cloudphone/media-service/internal/encoder"
 	"github.com/cloudphone/media-service/internal/handlers"
 	"github.com/cloudphone/media-service/internal/logger"
@@ -82,21 +85,28 @@ func main() {
 		)
 	}
 
+	// 创建视频管道管理器
+	pipelineLogger := logrus.New()
+	pipelineLogger.SetLevel(logrus.InfoLevel)
+	// 单机编码进程上限：screencap 模式下每个会话占用一个常驻 FFmpeg 进程
+	encoder.DefaultEncoderProcessPool().SetMaxProcesses(cfg.MaxEncoderProcesses)
+	// ENCODER_WORKERS > 0 时 screencap 会话并行解码/缩放/转换，编码仍共享同一个编码上下文
+	pipelineManager := encoder.NewPipelineManager(pipelineLogger,
+		encoder.WithEncoderWorkers(cfg.EncoderWorkers, cfg.EncoderMaxWorkers),
+	)
+
 	// 创建 WebRTC 管理器 (统一实现，支持分片锁和 TURN)
+	// 每个会话的 RTCP 反馈（接收端报告、REMB、PLI/FIR）驱动对应视频管道的码率和关键帧
 	webrtcManager := webrtc.NewManager(cfg,
 		webrtc.WithTURNService(turnService),
 		webrtc.WithNumShards(32), // 32 shards for high concurrency
+		webrtc.WithPipelineFeedback(pipelineManager, pipelineLogger),
 	)
 
 	// 创建 WebSocket Hub
 	wsHub := websocket.NewHub()
 	go wsHub.Run()
 
-	// 创建视频管道管理器
-	pipelineLogger := logrus.New()
-	pipelineLogger.SetLevel(logrus.InfoLevel)
-	pipelineManager := encoder.NewPipelineManager(pipelineLogger)
-
 	// 获取 ADB 路径
 	adbPath := os.Getenv("ADB_PATH")
 	if adbPath == "" {
@@ -108,10 +118,50 @@ func main() {
 	scrcpyServerPath := os.Getenv("SCRCPY_SERVER_PATH")
 	useScrcpy := scrcpyServerPath != "" && os.Getenv("USE_SCRCPY") != "false"
 
+	// H.264 回退链：scrcpy 不可用时依次回退到 screenrecord 和 screencap + 服务端 H.264 编码
+	h264Fallback := os.Getenv("H264_FALLBACK") != "false"
+
 	logger.Info("video_pipeline_manager_created",
 		zap.String("adb_path", adbPath),
 		zap.String("scrcpy_server_path", scrcpyServerPath),
 		zap.Bool("use_scrcpy", useScrcpy),
+		zap.Bool("h264_fallback", h264Fallback),
+	)
+
+	// 创建管道构建器：CAPTURE_MODE / VIDEO_ENCODER_TYPE 为全局规格，可按租户/设备覆盖
+	// 1:1 会话和 SFU 发布者共用同一个构建器
+	baseSpec := encoder.PipelineSpec{
+		CaptureMode: encoder.ParseCaptureMode(cfg.CaptureMode),
+		EncoderType: encoder.ParseEncoderType(cfg.VideoEncoderType),
+	}
+	if err := baseSpec.Validate(); err != nil {
+		logger.Fatal("invalid_pipeline_spec", zap.Error(err))
+	}
+	var pipelineOverrides *encoder.PipelineOverrides
+	if cfg.PipelineOverridesFile != "" {
+		pipelineOverrides, err = encoder.LoadPipelineOverrides(cfg.PipelineOverridesFile)
+		if err != nil {
+			logger.Fatal("failed_to_load_pipeline_overrides",
+				zap.String("path", cfg.PipelineOverridesFile),
+				zap.Error(err),
+			)
+		}
+		logger.Info("pipeline_overrides_loaded",
+			zap.String("path", cfg.PipelineOverridesFile),
+			zap.Int("tenants", len(pipelineOverrides.Tenants)),
+			zap.Int("devices", len(pipelineOverrides.Devices)),
+		)
+	}
+	builderScrcpyPath := ""
+	if useScrcpy {
+		builderScrcpyPath = scrcpyServerPath
+	}
+	pipelineBuilder := encoder.NewPipelineBuilder(adbPath, pipelineLogger,
+		encoder.WithBaseSpec(baseSpec),
+		encoder.WithPipelineOverrides(pipelineOverrides),
+		encoder.WithBuilderScrcpyServer(builderScrcpyPath),
+		encoder.WithBuilderH264Fallback(h264Fallback),
+		encoder.WithBuilderEncoderFactory(encoder.NewEncoderFactory(pipelineLogger)),
 	)
 
 	// 启动会话清理定时器
@@ -155,10 +205,95 @@ func main() {
 		zap.Bool("recording_support", true),
 	)
 
+	// 服务间请求（device-service 内部接口、实例间 WHEP 中继）使用服务令牌，不转发用户的 JWT
+	serviceCredential := func() (string, error) {
+		return middleware.GenerateServiceToken(cfg.ServiceName, serviceTokenTTL)
+	}
+
+	// 设备访问校验：创建会话、发布者和订阅者前向 device-service 确认用户对设备的权限
+	// 向房间添加设备时按其他参与者的身份校验（服务令牌查询设备归属）
+	var deviceAccessChecker *deviceaccess.Checker
+	if cfg.DeviceServiceURL != "" {
+		deviceAccessChecker = deviceaccess.NewChecker(cfg.DeviceServiceURL,
+			deviceaccess.WithCacheTTL(time.Duration(cfg.DeviceAccessCacheTTLSeconds)*time.Second),
+			deviceaccess.WithServiceCredential(serviceCredential),
+		)
+		logger.Info("device_access_checker_created",
+			zap.String("device_service_url", cfg.DeviceServiceURL),
+			zap.Int("cache_ttl_seconds", cfg.DeviceAccessCacheTTLSeconds),
+		)
+	} else {
+		logger.Warn("device_access_check_disabled",
+			zap.String("reason", "DEVICE_SERVICE_URL not configured"),
+		)
+	}
+
+	// 创建 SFU Manager（支持多人同屏观看）
+	sfuOpts := []sfu.ManagerOption{
+		sfu.WithTURNService(turnService),
+		sfu.WithNumShards(16),
+		sfu.WithPipelineFeedback(pipelineManager, pipelineLogger),
+		// 发布者和获得控制权的订阅者的数据通道输入通过 ADB 注入设备
+		sfu.WithControlInput(adb.NewService(adbPath)),
+	}
+	// 可选：将设备端 H.264 转码为多个层，弱网观看端自动切换到低码率层
+	if cfg.SFUSimulcastTranscode {
+		layers, err := encoder.ParseSimulcastLayers(cfg.SFUSimulcastLayers)
+		if err != nil {
+			logger.Fatal("invalid_sfu_simulcast_layers", zap.Error(err))
+		}
+		sfuOpts = append(sfuOpts, sfu.WithSimulcastTranscoding(layers, pipelineLogger))
+	}
+	// 可选：级联 SFU，本实例的设备发布者登记到 Consul，订阅其他实例上的设备时在本实例中继
+	var publisherDirectory *consul.PublisherDirectory
+	if cfg.SFUCascadeEnabled && cfg.ConsulEnabled {
+		directory, err := newPublisherDirectory(cfg)
+		if err != nil {
+			logger.Warn("sfu_cascade_disabled", zap.Error(err))
+		} else {
+			publisherDirectory = directory
+			sfuOpts = append(sfuOpts,
+				sfu.WithPublisherDirectory(publisherDirectory),
+				// 实例间 WHEP 订阅使用服务令牌，不转发用户的 JWT
+				sfu.WithRelayCredential(serviceCredential),
+			)
+		}
+	}
+	sfuManager := sfu.NewManager(cfg, sfuOpts...)
+
+	// 准入控制：限制用户 / 租户 / 设备的并发会话数，主机 CPU、视频管道或 ICE 端口不足时拒绝新连接
+	admissionController := admission.NewController(
+		admission.WithLimits(admission.Limits{
+			MaxSessionsPerUser:   cfg.MaxSessionsPerUser,
+			MaxSessionsPerTenant: cfg.MaxSessionsPerTenant,
+			MaxSessionsPerDevice: cfg.MaxSessionsPerDevice,
+			MaxCPUPercent:        cfg.AdmissionMaxCPUPercent,
+			MaxActivePipelines:   cfg.AdmissionMaxPipelines,
+			MinFreeICEPorts:      cfg.AdmissionMinFreeICEPorts,
+		}),
+		admission.WithICEPortRange(cfg.ICEPortMin, cfg.ICEPortMax),
+		admission.WithPipelineCounter(func() int {
+			videoPipelines, _ := pipelineManager.GetActivePipelineCount()
+			return videoPipelines
+		}),
+		admission.WithPeerConnectionCounter(func() int {
+			count := len(webrtcManager.GetAllSessions())
+			for _, publisher := range sfuManager.GetAllPublishers() {
+				count += 1 + len(publisher.GetSubscribers())
+			}
+			return count
+		}),
+	)
+	admissionController.Start(context.Background())
+
 	// 创建 HTTP 处理器
 	// 通过 HandlerOption 配置 scrcpy 高性能捕获模式和录像支持
 	handlerOpts := []handlers.HandlerOption{
 		handlers.WithCombinedFrameWriter(combinedFrameWriter), // 启用录像支持
+		handlers.WithH264Fallback(h264Fallback),
+		handlers.WithPipelineBuilder(pipelineBuilder),
+		handlers.WithDeviceAccessChecker(deviceAccessChecker),
+		handlers.WithAdmissionController(admissionController),
 	}
 	if useScrcpy {
 		handlerOpts = append(handlerOpts,
@@ -168,14 +303,13 @@ func main() {
 	}
 	handler := handlers.New(webrtcManager, wsHub, pipelineManager, adbPath, handlerOpts...)
 
-	// 创建 SFU Manager（支持多人同屏观看）
-	sfuManager := sfu.NewManager(cfg,
-		sfu.WithTURNService(turnService),
-		sfu.WithNumShards(16),
-	)
-
 	// 创建 SFU 处理器
-	sfuHandlerOpts := []handlers.SFUHandlerOption{}
+	sfuHandlerOpts := []handlers.SFUHandlerOption{
+		handlers.WithSFUPipelineBuilder(pipelineBuilder),
+		handlers.WithSFUDeviceAccessChecker(deviceAccessChecker),
+		handlers.WithSFUAdmissionController(admissionController),
+		handlers.WithSFUPausedCaptureFPS(cfg.SFUPausedCaptureFPS),
+	}
 	if useScrcpy {
 		sfuHandlerOpts = append(sfuHandlerOpts,
 			handlers.WithSFUScrcpyServer(scrcpyServerPath),
@@ -186,6 +320,7 @@ func main() {
 
 	logger.Info("sfu_manager_created",
 		zap.Bool("use_scrcpy", useScrcpy),
+		zap.Bool("simulcast_transcode", cfg.SFUSimulcastTranscode),
 	)
 
 	// 创建录像处理器（传入 CombinedFrameWriter 以支持边看边录）
@@ -194,6 +329,7 @@ func main() {
 		webrtcManager,
 		handlers.WithRecordingLogger(logger.Log),
 		handlers.WithCombinedFrameWriterForRecording(combinedFrameWriter),
+		handlers.WithRecordingEventPublisher(handler), // 录像开始/停止推送给会话客户端
 	)
 
 	// 启动 SFU 会话清理定时器
@@ -226,7 +362,7 @@ func main() {
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		ExposeHeaders:    []string{"Content-Length", "Location", "Link"}, // WHIP/WHEP 资源 URL 和 ICE 服务器
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
@@ -276,6 +412,17 @@ func main() {
 			logger.Info("rabbitmq_publisher_initialized",
 				zap.String("url_masked", "amqp://***:***@***"),
 			)
+			// SFU 发布者生命周期事件（采集开始 / 暂停 / 恢复、发布者关闭）
+			sfuManager.OnPublisherEvent(func(event sfu.PublisherEvent) {
+				eventPublisher.PublishSFUPublisherEvent(
+					string(event.Type), event.PublisherID, event.DeviceID, event.UserID,
+					event.Source, event.Reason, event.SubscriberCount,
+				)
+			})
+			// SFU 订阅者控制权的授权和结束（审计记录）
+			sfuManager.OnControlAudit(func(event sfu.ControlAuditEvent) {
+				eventPublisher.PublishSFUControlAudit(event.Type, event.ControlAuditEntry)
+			})
 		}
 	}
 
@@ -314,13 +461,19 @@ func main() {
 		api.POST("/sessions/ice-candidates", handler.HandleAddICECandidates) // 批量 ICE candidates（避免 429 错误）
 		api.GET("/sessions/:id", handler.HandleGetSession)
 		api.DELETE("/sessions/:id", handler.HandleCloseSession)
+		api.POST("/sessions/:id/ice-restart", handler.HandleICERestart)         // 网络切换后重启 ICE
+		api.POST("/sessions/:id/resume", handler.HandleResumeSession)           // 凭恢复令牌恢复会话（宽限期内）
+		api.GET("/sessions/:id/participants", handler.HandleListParticipants)   // 同设备的会话及角色
+		api.POST("/sessions/:id/control/grant", handler.HandleGrantControl)     // owner 授予控制权
+		api.POST("/sessions/:id/control/revoke", handler.HandleRevokeControl)   // owner 收回控制权
+		api.POST("/sessions/:id/control/request", handler.HandleRequestControl) // viewer 请求控制权
 		api.GET("/sessions", handler.HandleListSessions)
 
 		// WebSocket 连接
 		api.GET("/ws", handler.HandleWebSocket)
 
-		// 统计信息
-		api.GET("/stats", handler.HandleStats)
+		// 统计信息（全局数据，需要统计权限）
+		api.GET("/stats", middleware.RequirePermission(middleware.PermissionMediaStats), handler.HandleStats)
 
 		// Cloudflare TURN 凭证
 		api.GET("/turn-credentials", handler.HandleGetTurnCredentials)
@@ -343,9 +496,34 @@ func main() {
 			sfuGroup.POST("/subscribers/ice-candidate", sfuHandler.HandleAddSubscriberICECandidate)
 			sfuGroup.GET("/subscribers/:id", sfuHandler.HandleGetSubscriber)
 			sfuGroup.DELETE("/subscribers/:id", sfuHandler.HandleCloseSubscriber)
+			sfuGroup.POST("/subscribers/:id/pause", sfuHandler.HandlePauseSubscriber)
+			sfuGroup.POST("/subscribers/:id/resume", sfuHandler.HandleResumeSubscriber)
+			sfuGroup.POST("/subscribers/:id/control/grant", sfuHandler.HandleGrantSubscriberControl)
+			sfuGroup.POST("/subscribers/:id/control/revoke", sfuHandler.HandleRevokeSubscriberControl)
+			sfuGroup.GET("/publishers/:id/control/audit", sfuHandler.HandleGetControlAudit)
+
+			// 房间（一个观看者连接订阅多个设备，重新协商走数据通道 "room"）
+			sfuGroup.POST("/rooms", sfuHandler.HandleCreateRoom)
+			sfuGroup.GET("/rooms", sfuHandler.HandleListRooms)
+			sfuGroup.GET("/rooms/:id", sfuHandler.HandleGetRoom)
+			sfuGroup.DELETE("/rooms/:id", sfuHandler.HandleCloseRoom)
+			sfuGroup.POST("/rooms/:id/devices", sfuHandler.HandleAddRoomDevice)
+			sfuGroup.DELETE("/rooms/:id/devices/:deviceId", sfuHandler.HandleRemoveRoomDevice)
+			sfuGroup.POST("/rooms/:id/join", sfuHandler.HandleJoinRoom)
+			sfuGroup.POST("/rooms/answer", sfuHandler.HandleSetRoomAnswer)
+			sfuGroup.POST("/rooms/ice-candidate", sfuHandler.HandleAddRoomICECandidate)
+			sfuGroup.DELETE("/rooms/participants/:id", sfuHandler.HandleLeaveRoom)
+
+			// WHIP 推流 / WHEP 播放（标准 HTTP 信令）
+			sfuGroup.POST("/whip/:deviceId", sfuHandler.HandleWHIPPublish)
+			sfuGroup.PATCH("/whip/resources/:id", sfuHandler.HandleWHIPPatch)
+			sfuGroup.DELETE("/whip/resources/:id", sfuHandler.HandleWHIPDelete)
+			sfuGroup.POST("/whep/:id", sfuHandler.HandleWHEPSubscribe)
+			sfuGroup.PATCH("/whep/resources/:id", sfuHandler.HandleWHEPPatch)
+			sfuGroup.DELETE("/whep/resources/:id", sfuHandler.HandleWHEPDelete)
 
 			// SFU 统计
-			sfuGroup.GET("/stats", sfuHandler.HandleSFUStats)
+			sfuGroup.GET("/stats", middleware.RequirePermission(middleware.PermissionMediaStats), sfuHandler.HandleSFUStats)
 		}
 
 		// ========== 录像路由 (Recording) ==========
@@ -359,12 +537,20 @@ func main() {
 			recordingGroup.GET("/:id/download", recordingHandler.HandleDownloadRecording)
 			recordingGroup.DELETE("/:id", recordingHandler.HandleDeleteRecording)
 
-			// 录像统计和清理
-			recordingGroup.GET("/stats", recordingHandler.HandleRecordingStats)
-			recordingGroup.POST("/cleanup", recordingHandler.HandleCleanupRecordings)
+			// 录像统计和清理（全局操作，需要统计 / 管理权限）
+			recordingGroup.GET("/stats", middleware.RequirePermission(middleware.PermissionMediaStats), recordingHandler.HandleRecordingStats)
+			recordingGroup.POST("/cleanup", middleware.RequirePermission(middleware.PermissionMediaAdmin), recordingHandler.HandleCleanupRecordings)
 		}
 	}
 
+	// 内部路由（服务令牌认证，级联 SFU 的实例间 WHEP 中继）
+	internalGroup := router.Group("/internal/media")
+	internalGroup.Use(middleware.ServiceAuthMiddleware())
+	{
+		internalGroup.POST("/sfu/relay/whep/:deviceId", sfuHandler.HandleRelayWHEPSubscribe)
+		internalGroup.DELETE("/sfu/relay/whep/resources/:id", sfuHandler.HandleRelayWHEPDelete)
+	}
+
 	// 创建 HTTP 服务器
 	srv := &http.Server{
 		Addr:    ":" + cfg.Port,
@@ -401,6 +587,10 @@ func main() {
 	defer cancel()
 
 	// ========== 从 Consul 注销服务 ==========
+	if publisherDirectory != nil {
+		// 删除本实例的设备发布者登记，其他实例不再向本实例中继
+		publisherDirectory.Stop()
+	}
 	if consulClient != nil {
 		if err := consulClient.DeregisterService(); err != nil {
 			logger.Error("consul_deregistration_failed", zap.Error(err))
@@ -448,6 +638,25 @@ func main() {
 	)
 }
 
+// serviceTokenTTL 服务间请求使用的服务令牌有效期
+const serviceTokenTTL = 5 * time.Minute
+
+// newPublisherDirectory 创建并启动级联 SFU 的 Consul 发布者目录
+func newPublisherDirectory(cfg *config.Config) (*consul.PublisherDirectory, error) {
+	client, err := consul.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	directory, err := consul.NewPublisherDirectory(client)
+	if err != nil {
+		return nil, err
+	}
+	if err := directory.Start(); err != nil {
+		return nil, err
+	}
+	return directory, nil
+}
+
 // monitorGoroutines monitors Goroutine count for leak detection
 func monitorGoroutines() {
 	ticker := time.NewTicker(30 * time.Second)